@@ -0,0 +1,60 @@
+// Command migrate-uploads copies the files of the local uploads directory into
+// the blob store configured by STORAGE_BACKEND and points the stored URLs at
+// their new location. Run it from the backend directory:
+//
+//	STORAGE_BACKEND=s3 S3_ENDPOINT=... S3_BUCKET=... go run -tags sqlite_fts5 ./cmd/migrate-uploads
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"ktabnet/db/sqlite"
+	"ktabnet/repositories"
+	"ktabnet/storage"
+	"ktabnet/utils"
+)
+
+func main() {
+	from := flag.String("from", utils.GetUploadPath(""), "local uploads directory to copy from")
+	rewrite := flag.Bool("rewrite-urls", true, "update upload URLs stored in the database")
+	flag.Parse()
+
+	src := storage.NewLocalStore(*from, "/uploads/")
+	dst, err := storage.NewFromConfig()
+	if err != nil {
+		fmt.Printf("❌ Failed to set up upload storage: %v\n", err)
+		os.Exit(1)
+	}
+	if local, ok := dst.(*storage.LocalStore); ok && local.Dir == src.Dir {
+		fmt.Println("❌ Destination is the local uploads directory; set STORAGE_BACKEND to another store")
+		os.Exit(1)
+	}
+
+	copied, err := storage.Copy(src, dst, "")
+	if err != nil {
+		fmt.Printf("❌ Copy failed after %d files: %v\n", copied, err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Copied %d files\n", copied)
+
+	if !*rewrite {
+		return
+	}
+
+	sqlite.InitDB()
+	media := repositories.NewMediaRepository(sqlite.GetDB())
+	changed, err := media.RewriteURLs(func(url string) (string, bool) {
+		key, ok := src.KeyFromURL(url)
+		if !ok {
+			return "", false
+		}
+		return dst.URL(key), true
+	})
+	if err != nil {
+		fmt.Printf("❌ Failed to rewrite upload URLs: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Updated %d stored URLs\n", changed)
+}
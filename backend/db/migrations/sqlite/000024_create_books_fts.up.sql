@@ -0,0 +1,32 @@
+-- Full-text index over books, kept in sync with the books table through triggers.
+-- Requires SQLite built with FTS5 (go build -tags sqlite_fts5).
+CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(
+    title,
+    author,
+    description,
+    genre,
+    isbn,
+    content='books',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ai AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, new.title, new.author, new.description, new.genre, new.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ad AFTER DELETE ON books BEGIN
+    INSERT INTO books_fts(books_fts, rowid, title, author, description, genre, isbn)
+    VALUES ('delete', old.id, old.title, old.author, old.description, old.genre, old.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_au AFTER UPDATE ON books BEGIN
+    INSERT INTO books_fts(books_fts, rowid, title, author, description, genre, isbn)
+    VALUES ('delete', old.id, old.title, old.author, old.description, old.genre, old.isbn);
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, new.title, new.author, new.description, new.genre, new.isbn);
+END;
+
+-- Index books that already exist
+INSERT INTO books_fts(books_fts) VALUES ('rebuild');
@@ -0,0 +1,34 @@
+DROP TRIGGER IF EXISTS books_fts_au;
+DROP TRIGGER IF EXISTS books_fts_ad;
+DROP TRIGGER IF EXISTS books_fts_ai;
+DROP TABLE IF EXISTS books_fts;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(
+    title,
+    author,
+    description,
+    genre,
+    isbn,
+    content='books',
+    content_rowid='id',
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ai AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, new.title, new.author, new.description, new.genre, new.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ad AFTER DELETE ON books BEGIN
+    INSERT INTO books_fts(books_fts, rowid, title, author, description, genre, isbn)
+    VALUES ('delete', old.id, old.title, old.author, old.description, old.genre, old.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_au AFTER UPDATE ON books BEGIN
+    INSERT INTO books_fts(books_fts, rowid, title, author, description, genre, isbn)
+    VALUES ('delete', old.id, old.title, old.author, old.description, old.genre, old.isbn);
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, new.title, new.author, new.description, new.genre, new.isbn);
+END;
+
+INSERT INTO books_fts(books_fts) VALUES ('rebuild');
@@ -0,0 +1,39 @@
+-- Rebuild the book search index over normalized text (accents folded, Arabic
+-- diacritics stripped and letter variants unified). normalize_text() is registered
+-- by the application's sqlite driver, so this migration must run through the app.
+DROP TRIGGER IF EXISTS books_fts_au;
+DROP TRIGGER IF EXISTS books_fts_ad;
+DROP TRIGGER IF EXISTS books_fts_ai;
+DROP TABLE IF EXISTS books_fts;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(
+    title,
+    author,
+    description,
+    genre,
+    isbn,
+    tokenize='unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ai AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, normalize_text(new.title), normalize_text(new.author), normalize_text(new.description), normalize_text(new.genre), new.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ad AFTER DELETE ON books BEGIN
+    DELETE FROM books_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_au AFTER UPDATE ON books BEGIN
+    UPDATE books_fts
+    SET title = normalize_text(new.title),
+        author = normalize_text(new.author),
+        description = normalize_text(new.description),
+        genre = normalize_text(new.genre),
+        isbn = new.isbn
+    WHERE rowid = old.id;
+END;
+
+INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+SELECT id, normalize_text(title), normalize_text(author), normalize_text(description), normalize_text(genre), isbn
+FROM books;
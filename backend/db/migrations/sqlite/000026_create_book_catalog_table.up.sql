@@ -0,0 +1,13 @@
+-- Local bibliographic catalog used to prefill listings from an ISBN.
+-- Filled from a JSON file at startup and from remote lookups.
+CREATE TABLE IF NOT EXISTS book_catalog (
+    isbn TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    author TEXT,
+    description TEXT,
+    genre TEXT,
+    language TEXT,
+    cover_url TEXT,
+    source TEXT NOT NULL DEFAULT 'local',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
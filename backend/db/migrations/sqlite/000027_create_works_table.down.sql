@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_books_isbn;
+DROP INDEX IF EXISTS idx_books_work_id;
+ALTER TABLE books DROP COLUMN work_id;
+DROP TABLE IF EXISTS works;
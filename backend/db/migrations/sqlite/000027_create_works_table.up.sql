@@ -0,0 +1,26 @@
+-- A work groups every listing of the same book, whatever the edition.
+-- work_key is the normalized "title|author" pair (see BookRepository.resolveWorkID).
+CREATE TABLE IF NOT EXISTS works (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    work_key TEXT NOT NULL UNIQUE,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE books ADD COLUMN work_id INTEGER REFERENCES works(id);
+CREATE INDEX IF NOT EXISTS idx_books_work_id ON books(work_id);
+CREATE INDEX IF NOT EXISTS idx_books_isbn ON books(isbn);
+
+-- Store existing ISBNs in canonical ISBN-13 form; values that fail the checksum are left as they are
+UPDATE books SET isbn = COALESCE(isbn13(isbn), isbn) WHERE isbn IS NOT NULL AND isbn != '';
+
+-- Group existing listings. normalize_text() and isbn13() are registered by the app's sqlite driver.
+INSERT OR IGNORE INTO works (work_key, title, author)
+SELECT normalize_text(trim(title)) || '|' || normalize_text(trim(author)), title, author
+FROM books;
+
+UPDATE books SET work_id = (
+    SELECT id FROM works
+    WHERE work_key = normalize_text(trim(books.title)) || '|' || normalize_text(trim(books.author))
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS wishlist_cities;
+DROP INDEX IF EXISTS idx_wishlists_match_key;
+DROP INDEX IF EXISTS idx_wishlists_isbn;
+DROP INDEX IF EXISTS idx_wishlists_user;
+DROP TABLE IF EXISTS wishlists;
@@ -0,0 +1,25 @@
+-- Books users are looking for. A wish names an ISBN, a title and author, or both;
+-- match_key is the normalized "title|author" key also used by works.
+CREATE TABLE IF NOT EXISTS wishlists (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    isbn TEXT NOT NULL DEFAULT '',
+    title TEXT NOT NULL DEFAULT '',
+    author TEXT NOT NULL DEFAULT '',
+    match_key TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_wishlists_user ON wishlists(user_id);
+CREATE INDEX IF NOT EXISTS idx_wishlists_isbn ON wishlists(isbn) WHERE isbn != '';
+CREATE INDEX IF NOT EXISTS idx_wishlists_match_key ON wishlists(match_key) WHERE match_key != '';
+
+-- Cities a wish is limited to; a wish without rows here matches every city
+CREATE TABLE IF NOT EXISTS wishlist_cities (
+    wishlist_id INTEGER NOT NULL,
+    city TEXT NOT NULL,
+    PRIMARY KEY (wishlist_id, city),
+    FOREIGN KEY (wishlist_id) REFERENCES wishlists(id) ON DELETE CASCADE
+);
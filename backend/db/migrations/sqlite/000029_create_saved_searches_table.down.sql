@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_saved_searches_due;
+DROP INDEX IF EXISTS idx_saved_searches_user;
+DROP TABLE IF EXISTS saved_searches;
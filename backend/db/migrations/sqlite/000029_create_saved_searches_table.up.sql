@@ -0,0 +1,18 @@
+-- Saved feed searches delivered as periodic digests. query holds the feed filter
+-- parameters URL-encoded (e.g. "city=Rabat&genre=Fantasy&min_condition=good").
+-- last_book_id is the highest listing ID already covered by a digest.
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    query TEXT NOT NULL DEFAULT '',
+    frequency TEXT NOT NULL DEFAULT 'daily' CHECK(frequency IN ('hourly', 'daily')),
+    last_book_id INTEGER NOT NULL DEFAULT 0,
+    last_run_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_user ON saved_searches(user_id);
+CREATE INDEX IF NOT EXISTS idx_saved_searches_due ON saved_searches(frequency, last_run_at);
@@ -0,0 +1,53 @@
+-- Restore the original city CHECK; listings in other cities fall back to the default
+CREATE TABLE books_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    isbn TEXT,
+    description TEXT,
+    genre TEXT CHECK(genre IN ('Fiction', 'Romance', 'Fantasy', 'Science Fiction', 'Mystery', 'Biography', 'History', 'Self-Help')),
+    condition TEXT CHECK(condition IN ('new', 'like-new', 'good', 'fair', 'poor')),
+    city TEXT CHECK(city IN ('Marrakesh', 'Beni Mellal', 'Casablanca', 'Rabat')) DEFAULT 'Casablanca',
+    available BOOLEAN DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    work_id INTEGER REFERENCES works(id),
+    FOREIGN KEY (owner_id) REFERENCES users(id)
+);
+
+INSERT INTO books_old (id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at, work_id)
+SELECT id, owner_id, title, author, isbn, description, genre, condition,
+    CASE WHEN city IN ('Marrakesh', 'Beni Mellal', 'Casablanca', 'Rabat') THEN city ELSE 'Casablanca' END,
+    available, created_at, updated_at, work_id
+FROM books;
+
+DROP TABLE books;
+ALTER TABLE books_old RENAME TO books;
+
+CREATE INDEX IF NOT EXISTS idx_books_work_id ON books(work_id);
+CREATE INDEX IF NOT EXISTS idx_books_isbn ON books(isbn);
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ai AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, normalize_text(new.title), normalize_text(new.author), normalize_text(new.description), normalize_text(new.genre), new.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ad AFTER DELETE ON books BEGIN
+    DELETE FROM books_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_au AFTER UPDATE ON books BEGIN
+    UPDATE books_fts
+    SET title = normalize_text(new.title),
+        author = normalize_text(new.author),
+        description = normalize_text(new.description),
+        genre = normalize_text(new.genre),
+        isbn = new.isbn
+    WHERE rowid = old.id;
+END;
+
+ALTER TABLE users DROP COLUMN longitude;
+ALTER TABLE users DROP COLUMN latitude;
+
+DROP TABLE IF EXISTS cities;
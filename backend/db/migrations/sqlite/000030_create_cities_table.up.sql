@@ -0,0 +1,95 @@
+-- Cities with their coordinates, used for distance-based discovery.
+-- name_key is normalize_text(name) so lookups ignore case and accents ("sale" finds "Salé").
+CREATE TABLE IF NOT EXISTS cities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    name_key TEXT NOT NULL UNIQUE,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL
+);
+
+INSERT OR IGNORE INTO cities (name, name_key, latitude, longitude) VALUES
+    ('Rabat', normalize_text('Rabat'), 34.0209, -6.8416),
+    ('Salé', normalize_text('Salé'), 34.0531, -6.7985),
+    ('Témara', normalize_text('Témara'), 33.9287, -6.9063),
+    ('Kenitra', normalize_text('Kenitra'), 34.2610, -6.5802),
+    ('Casablanca', normalize_text('Casablanca'), 33.5731, -7.5898),
+    ('Mohammedia', normalize_text('Mohammedia'), 33.6866, -7.3830),
+    ('El Jadida', normalize_text('El Jadida'), 33.2316, -8.5007),
+    ('Settat', normalize_text('Settat'), 33.0013, -7.6166),
+    ('Khouribga', normalize_text('Khouribga'), 32.8811, -6.9063),
+    ('Beni Mellal', normalize_text('Beni Mellal'), 32.3373, -6.3498),
+    ('Marrakesh', normalize_text('Marrakesh'), 31.6295, -7.9811),
+    ('Safi', normalize_text('Safi'), 32.2994, -9.2372),
+    ('Essaouira', normalize_text('Essaouira'), 31.5085, -9.7595),
+    ('Agadir', normalize_text('Agadir'), 30.4278, -9.5981),
+    ('Tiznit', normalize_text('Tiznit'), 29.6974, -9.7316),
+    ('Ouarzazate', normalize_text('Ouarzazate'), 30.9335, -6.9370),
+    ('Errachidia', normalize_text('Errachidia'), 31.9314, -4.4247),
+    ('Fes', normalize_text('Fes'), 34.0181, -5.0078),
+    ('Meknes', normalize_text('Meknes'), 33.8935, -5.5473),
+    ('Ifrane', normalize_text('Ifrane'), 33.5228, -5.1106),
+    ('Khemisset', normalize_text('Khemisset'), 33.8240, -6.0660),
+    ('Taza', normalize_text('Taza'), 34.2100, -4.0100),
+    ('Tangier', normalize_text('Tangier'), 35.7595, -5.8340),
+    ('Tetouan', normalize_text('Tetouan'), 35.5889, -5.3626),
+    ('Larache', normalize_text('Larache'), 35.1932, -6.1557),
+    ('Al Hoceima', normalize_text('Al Hoceima'), 35.2517, -3.9372),
+    ('Nador', normalize_text('Nador'), 35.1681, -2.9335),
+    ('Oujda', normalize_text('Oujda'), 34.6814, -1.9086),
+    ('Laayoune', normalize_text('Laayoune'), 27.1253, -13.1625),
+    ('Dakhla', normalize_text('Dakhla'), 23.6848, -15.9580);
+
+-- Approximate user location (rounded to about 1 km); when unset the profile city is used
+ALTER TABLE users ADD COLUMN latitude REAL;
+ALTER TABLE users ADD COLUMN longitude REAL;
+
+-- Books may now be listed in any known city: rebuild the table without the city CHECK.
+-- The application validates cities against the cities table instead.
+CREATE TABLE books_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    isbn TEXT,
+    description TEXT,
+    genre TEXT CHECK(genre IN ('Fiction', 'Romance', 'Fantasy', 'Science Fiction', 'Mystery', 'Biography', 'History', 'Self-Help')),
+    condition TEXT CHECK(condition IN ('new', 'like-new', 'good', 'fair', 'poor')),
+    city TEXT DEFAULT 'Casablanca',
+    available BOOLEAN DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    work_id INTEGER REFERENCES works(id),
+    FOREIGN KEY (owner_id) REFERENCES users(id)
+);
+
+INSERT INTO books_new (id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at, work_id)
+SELECT id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at, work_id
+FROM books;
+
+-- Dropping books also drops its search triggers and indexes; books_fts keeps its rows
+DROP TABLE books;
+ALTER TABLE books_new RENAME TO books;
+
+CREATE INDEX IF NOT EXISTS idx_books_work_id ON books(work_id);
+CREATE INDEX IF NOT EXISTS idx_books_isbn ON books(isbn);
+CREATE INDEX IF NOT EXISTS idx_books_city ON books(city);
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ai AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, normalize_text(new.title), normalize_text(new.author), normalize_text(new.description), normalize_text(new.genre), new.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ad AFTER DELETE ON books BEGIN
+    DELETE FROM books_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_au AFTER UPDATE ON books BEGIN
+    UPDATE books_fts
+    SET title = normalize_text(new.title),
+        author = normalize_text(new.author),
+        description = normalize_text(new.description),
+        genre = normalize_text(new.genre),
+        isbn = new.isbn
+    WHERE rowid = old.id;
+END;
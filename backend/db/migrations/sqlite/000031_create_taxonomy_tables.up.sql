@@ -0,0 +1,124 @@
+-- Genres, conditions and cities become admin-managed reference tables with
+-- localized labels. name is the value stored on books and never changes;
+-- sort_order orders pickers, and for conditions it ranks them from best to worst.
+CREATE TABLE IF NOT EXISTS genres (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    label_ar TEXT NOT NULL DEFAULT '',
+    label_fr TEXT NOT NULL DEFAULT '',
+    label_en TEXT NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS conditions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    label_ar TEXT NOT NULL DEFAULT '',
+    label_fr TEXT NOT NULL DEFAULT '',
+    label_en TEXT NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR IGNORE INTO genres (name, label_ar, label_fr, label_en, sort_order) VALUES
+    ('Fiction', 'رواية', 'Roman', 'Fiction', 0),
+    ('Romance', 'رومانسية', 'Romance', 'Romance', 1),
+    ('Fantasy', 'فانتازيا', 'Fantasy', 'Fantasy', 2),
+    ('Science Fiction', 'خيال علمي', 'Science-fiction', 'Science Fiction', 3),
+    ('Mystery', 'غموض وتشويق', 'Policier', 'Mystery', 4),
+    ('Biography', 'سيرة ذاتية', 'Biographie', 'Biography', 5),
+    ('History', 'تاريخ', 'Histoire', 'History', 6),
+    ('Self-Help', 'تنمية ذاتية', 'Développement personnel', 'Self-Help', 7);
+
+INSERT OR IGNORE INTO conditions (name, label_ar, label_fr, label_en, sort_order) VALUES
+    ('new', 'جديد', 'Neuf', 'New', 0),
+    ('like-new', 'شبه جديد', 'Comme neuf', 'Like new', 1),
+    ('good', 'حالة جيدة', 'Bon état', 'Good', 2),
+    ('fair', 'حالة مقبولة', 'État correct', 'Fair', 3),
+    ('poor', 'حالة سيئة', 'Abîmé', 'Poor', 4);
+
+ALTER TABLE cities ADD COLUMN label_ar TEXT NOT NULL DEFAULT '';
+ALTER TABLE cities ADD COLUMN label_fr TEXT NOT NULL DEFAULT '';
+ALTER TABLE cities ADD COLUMN label_en TEXT NOT NULL DEFAULT '';
+ALTER TABLE cities ADD COLUMN sort_order INTEGER NOT NULL DEFAULT 0;
+
+UPDATE cities SET label_ar = 'الرباط', label_fr = 'Rabat', label_en = name WHERE name = 'Rabat';
+UPDATE cities SET label_ar = 'سلا', label_fr = 'Salé', label_en = name WHERE name = 'Salé';
+UPDATE cities SET label_ar = 'تمارة', label_fr = 'Témara', label_en = name WHERE name = 'Témara';
+UPDATE cities SET label_ar = 'القنيطرة', label_fr = 'Kénitra', label_en = name WHERE name = 'Kenitra';
+UPDATE cities SET label_ar = 'الدار البيضاء', label_fr = 'Casablanca', label_en = name WHERE name = 'Casablanca';
+UPDATE cities SET label_ar = 'المحمدية', label_fr = 'Mohammédia', label_en = name WHERE name = 'Mohammedia';
+UPDATE cities SET label_ar = 'الجديدة', label_fr = 'El Jadida', label_en = name WHERE name = 'El Jadida';
+UPDATE cities SET label_ar = 'سطات', label_fr = 'Settat', label_en = name WHERE name = 'Settat';
+UPDATE cities SET label_ar = 'خريبكة', label_fr = 'Khouribga', label_en = name WHERE name = 'Khouribga';
+UPDATE cities SET label_ar = 'بني ملال', label_fr = 'Béni Mellal', label_en = name WHERE name = 'Beni Mellal';
+UPDATE cities SET label_ar = 'مراكش', label_fr = 'Marrakech', label_en = name WHERE name = 'Marrakesh';
+UPDATE cities SET label_ar = 'آسفي', label_fr = 'Safi', label_en = name WHERE name = 'Safi';
+UPDATE cities SET label_ar = 'الصويرة', label_fr = 'Essaouira', label_en = name WHERE name = 'Essaouira';
+UPDATE cities SET label_ar = 'أكادير', label_fr = 'Agadir', label_en = name WHERE name = 'Agadir';
+UPDATE cities SET label_ar = 'تيزنيت', label_fr = 'Tiznit', label_en = name WHERE name = 'Tiznit';
+UPDATE cities SET label_ar = 'ورزازات', label_fr = 'Ouarzazate', label_en = name WHERE name = 'Ouarzazate';
+UPDATE cities SET label_ar = 'الرشيدية', label_fr = 'Errachidia', label_en = name WHERE name = 'Errachidia';
+UPDATE cities SET label_ar = 'فاس', label_fr = 'Fès', label_en = name WHERE name = 'Fes';
+UPDATE cities SET label_ar = 'مكناس', label_fr = 'Meknès', label_en = name WHERE name = 'Meknes';
+UPDATE cities SET label_ar = 'إفران', label_fr = 'Ifrane', label_en = name WHERE name = 'Ifrane';
+UPDATE cities SET label_ar = 'الخميسات', label_fr = 'Khémisset', label_en = name WHERE name = 'Khemisset';
+UPDATE cities SET label_ar = 'تازة', label_fr = 'Taza', label_en = name WHERE name = 'Taza';
+UPDATE cities SET label_ar = 'طنجة', label_fr = 'Tanger', label_en = name WHERE name = 'Tangier';
+UPDATE cities SET label_ar = 'تطوان', label_fr = 'Tétouan', label_en = name WHERE name = 'Tetouan';
+UPDATE cities SET label_ar = 'العرائش', label_fr = 'Larache', label_en = name WHERE name = 'Larache';
+UPDATE cities SET label_ar = 'الحسيمة', label_fr = 'Al Hoceïma', label_en = name WHERE name = 'Al Hoceima';
+UPDATE cities SET label_ar = 'الناظور', label_fr = 'Nador', label_en = name WHERE name = 'Nador';
+UPDATE cities SET label_ar = 'وجدة', label_fr = 'Oujda', label_en = name WHERE name = 'Oujda';
+UPDATE cities SET label_ar = 'العيون', label_fr = 'Laâyoune', label_en = name WHERE name = 'Laayoune';
+UPDATE cities SET label_ar = 'الداخلة', label_fr = 'Dakhla', label_en = name WHERE name = 'Dakhla';
+
+-- Drop the genre and condition CHECKs; the application validates against the tables
+CREATE TABLE books_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    isbn TEXT,
+    description TEXT,
+    genre TEXT,
+    condition TEXT,
+    city TEXT DEFAULT 'Casablanca',
+    available BOOLEAN DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    work_id INTEGER REFERENCES works(id),
+    FOREIGN KEY (owner_id) REFERENCES users(id)
+);
+
+INSERT INTO books_new (id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at, work_id)
+SELECT id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at, work_id
+FROM books;
+
+-- Dropping books also drops its search triggers and indexes; books_fts keeps its rows
+DROP TABLE books;
+ALTER TABLE books_new RENAME TO books;
+
+CREATE INDEX IF NOT EXISTS idx_books_work_id ON books(work_id);
+CREATE INDEX IF NOT EXISTS idx_books_isbn ON books(isbn);
+CREATE INDEX IF NOT EXISTS idx_books_city ON books(city);
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ai AFTER INSERT ON books BEGIN
+    INSERT INTO books_fts(rowid, title, author, description, genre, isbn)
+    VALUES (new.id, normalize_text(new.title), normalize_text(new.author), normalize_text(new.description), normalize_text(new.genre), new.isbn);
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_ad AFTER DELETE ON books BEGIN
+    DELETE FROM books_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS books_fts_au AFTER UPDATE ON books BEGIN
+    UPDATE books_fts
+    SET title = normalize_text(new.title),
+        author = normalize_text(new.author),
+        description = normalize_text(new.description),
+        genre = normalize_text(new.genre),
+        isbn = new.isbn
+    WHERE rowid = old.id;
+END;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_book_status_history_book;
+DROP TABLE IF EXISTS book_status_history;
+
+UPDATE books SET available = (status = 'listed');
+DROP INDEX IF EXISTS idx_books_status;
+ALTER TABLE books DROP COLUMN status;
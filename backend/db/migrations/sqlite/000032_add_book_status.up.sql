@@ -0,0 +1,25 @@
+-- A listing's lifecycle status replaces the available flag, which is kept in sync
+-- (available = status is 'listed') for older clients
+ALTER TABLE books ADD COLUMN status TEXT NOT NULL DEFAULT 'listed'
+    CHECK (status IN ('listed', 'reserved', 'in_exchange', 'lent_out', 'exchanged', 'archived'));
+
+UPDATE books SET status = CASE WHEN available = 1 THEN 'listed' ELSE 'archived' END;
+
+CREATE INDEX IF NOT EXISTS idx_books_status ON books(status);
+
+-- Every status change with who made it (changed_by 0 is the system) and why
+CREATE TABLE IF NOT EXISTS book_status_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    from_status TEXT NOT NULL DEFAULT '',
+    to_status TEXT NOT NULL,
+    changed_by INTEGER NOT NULL DEFAULT 0,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_status_history_book ON book_status_history(book_id, id);
+
+INSERT INTO book_status_history (book_id, to_status, changed_by, reason, created_at)
+SELECT id, status, 0, 'status introduced', created_at FROM books;
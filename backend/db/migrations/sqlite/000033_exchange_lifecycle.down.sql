@@ -0,0 +1,34 @@
+DROP INDEX IF EXISTS idx_book_exchange_events_exchange;
+DROP TABLE IF EXISTS book_exchange_events;
+
+-- States the original table does not know are folded into the closest one
+CREATE TABLE book_exchanges_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    offered_book_id INTEGER NOT NULL,
+    requester_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled')),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (offered_book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_exchanges_old (id, book_id, offered_book_id, requester_id, status, created_at)
+SELECT id, book_id, offered_book_id, requester_id,
+       CASE WHEN status IN ('meetup_scheduled', 'completed', 'disputed') THEN 'accepted' ELSE status END,
+       created_at
+FROM book_exchanges;
+
+DROP TABLE book_exchanges;
+
+-- The original unique index covers the status too, so only the latest of repeated requests is kept
+DELETE FROM book_exchanges_old WHERE id NOT IN (
+    SELECT MAX(id) FROM book_exchanges_old GROUP BY book_id, offered_book_id, requester_id, status
+);
+
+ALTER TABLE book_exchanges_old RENAME TO book_exchanges;
+
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_book_id ON book_exchanges(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_requester_id ON book_exchanges(requester_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_book_exchanges_pending_unique ON book_exchanges(book_id, offered_book_id, requester_id, status);
@@ -0,0 +1,52 @@
+-- Exchanges follow a state machine: pending -> accepted -> meetup_scheduled -> completed,
+-- with disputed, declined and cancelled as the other end states. owner_id keeps the
+-- owner of the requested book at request time, since completion swaps book owners.
+CREATE TABLE book_exchanges_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    offered_book_id INTEGER NOT NULL,
+    requester_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'meetup_scheduled', 'completed', 'disputed', 'declined', 'cancelled')),
+    owner_confirmed_at DATETIME,
+    requester_confirmed_at DATETIME,
+    dispute_reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    completed_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (offered_book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_exchanges_new (id, book_id, offered_book_id, requester_id, owner_id, status, created_at, updated_at)
+SELECT e.id, e.book_id, e.offered_book_id, e.requester_id,
+       COALESCE((SELECT owner_id FROM books WHERE id = e.book_id), 0), e.status, e.created_at, e.created_at
+FROM book_exchanges e;
+
+DROP TABLE book_exchanges;
+ALTER TABLE book_exchanges_new RENAME TO book_exchanges;
+
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_book_id ON book_exchanges(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_offered_book_id ON book_exchanges(offered_book_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_requester_id ON book_exchanges(requester_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_owner_id ON book_exchanges(owner_id);
+-- Only one pending request per pair; ended requests may repeat
+CREATE UNIQUE INDEX IF NOT EXISTS idx_book_exchanges_pending_unique
+    ON book_exchanges(book_id, offered_book_id, requester_id) WHERE status = 'pending';
+
+-- Every transition of an exchange; actor_id 0 is the system
+CREATE TABLE IF NOT EXISTS book_exchange_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    exchange_id INTEGER NOT NULL,
+    from_status TEXT NOT NULL DEFAULT '',
+    to_status TEXT NOT NULL,
+    actor_id INTEGER NOT NULL DEFAULT 0,
+    note TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (exchange_id) REFERENCES book_exchanges(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_exchange_events_exchange ON book_exchange_events(exchange_id, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_book_exchange_offers_exchange;
+DROP TABLE IF EXISTS book_exchange_offers;
@@ -0,0 +1,27 @@
+-- The thread of offers of an exchange. The latest open offer is the one on the table;
+-- it is binding once the party that did not propose it accepts it.
+CREATE TABLE IF NOT EXISTS book_exchange_offers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    exchange_id INTEGER NOT NULL,
+    proposed_by INTEGER NOT NULL,
+    offered_book_id INTEGER NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'superseded', 'accepted', 'closed')),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (exchange_id) REFERENCES book_exchanges(id) ON DELETE CASCADE,
+    FOREIGN KEY (proposed_by) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (offered_book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_exchange_offers_exchange ON book_exchange_offers(exchange_id, id);
+
+-- Existing requests start their thread with the requester's offer
+INSERT INTO book_exchange_offers (exchange_id, proposed_by, offered_book_id, status, created_at)
+SELECT id, requester_id, offered_book_id,
+       CASE
+           WHEN status = 'pending' THEN 'open'
+           WHEN status IN ('accepted', 'meetup_scheduled', 'completed', 'disputed') THEN 'accepted'
+           ELSE 'closed'
+       END,
+       created_at
+FROM book_exchanges;
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS book_exchange_offer_books;
+DROP INDEX IF EXISTS idx_book_exchange_items_book;
+DROP TABLE IF EXISTS book_exchange_items;
+
+-- Bundles that only differ past their first books become duplicates; keep the first one pending
+UPDATE book_exchanges SET status = 'cancelled'
+WHERE status = 'pending' AND id NOT IN (
+    SELECT MIN(id) FROM book_exchanges WHERE status = 'pending' GROUP BY book_id, offered_book_id, requester_id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_book_exchanges_pending_unique
+    ON book_exchanges(book_id, offered_book_id, requester_id) WHERE status = 'pending';
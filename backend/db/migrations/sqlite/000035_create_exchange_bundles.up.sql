@@ -0,0 +1,34 @@
+-- Exchanges trade bundles: N requested books of the owner for M offered books of the
+-- requester. book_id and offered_book_id of book_exchanges stay the first book of each side.
+CREATE TABLE IF NOT EXISTS book_exchange_items (
+    exchange_id INTEGER NOT NULL,
+    book_id INTEGER NOT NULL,
+    side TEXT NOT NULL CHECK (side IN ('requested', 'offered')),
+    position INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (exchange_id, book_id),
+    FOREIGN KEY (exchange_id) REFERENCES book_exchanges(id) ON DELETE CASCADE,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_exchange_items_book ON book_exchange_items(book_id);
+
+INSERT INTO book_exchange_items (exchange_id, book_id, side)
+SELECT id, book_id, 'requested' FROM book_exchanges;
+INSERT OR IGNORE INTO book_exchange_items (exchange_id, book_id, side)
+SELECT id, offered_book_id, 'offered' FROM book_exchanges;
+
+-- The offered books of every offer in an exchange's thread
+CREATE TABLE IF NOT EXISTS book_exchange_offer_books (
+    offer_id INTEGER NOT NULL,
+    book_id INTEGER NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (offer_id, book_id),
+    FOREIGN KEY (offer_id) REFERENCES book_exchange_offers(id) ON DELETE CASCADE,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_exchange_offer_books (offer_id, book_id)
+SELECT id, offered_book_id FROM book_exchange_offers;
+
+-- Bundles sharing their first books are different requests, duplicates are checked on the whole bundle
+DROP INDEX IF EXISTS idx_book_exchanges_pending_unique;
@@ -0,0 +1,11 @@
+-- Books out on loan go back to listed with their loans gone
+UPDATE books SET status = 'listed', available = 1 WHERE status = 'lent_out';
+
+DROP INDEX IF EXISTS idx_book_loans_due;
+DROP INDEX IF EXISTS idx_book_loans_borrower_id;
+DROP INDEX IF EXISTS idx_book_loans_owner_id;
+DROP INDEX IF EXISTS idx_book_loans_book_id;
+DROP TABLE IF EXISTS book_loans;
+
+DROP INDEX IF EXISTS idx_books_listing_type;
+ALTER TABLE books DROP COLUMN listing_type;
@@ -0,0 +1,29 @@
+-- What a listing is offered for: 'exchange' (exchange requests) or 'lend' (borrow requests)
+ALTER TABLE books ADD COLUMN listing_type TEXT NOT NULL DEFAULT 'exchange';
+CREATE INDEX IF NOT EXISTS idx_books_listing_type ON books(listing_type);
+
+-- Borrow requests on lend listings. Dates are YYYY-MM-DD; the book is lent_out while a
+-- loan is active, until the owner marks it returned.
+CREATE TABLE IF NOT EXISTS book_loans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    borrower_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'returned', 'declined', 'cancelled')),
+    start_date TEXT NOT NULL,
+    due_date TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    returned_at DATETIME,
+    reminder_sent_at DATETIME,
+    overdue_notified_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (borrower_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_loans_book_id ON book_loans(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_owner_id ON book_loans(owner_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_borrower_id ON book_loans(borrower_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_due ON book_loans(status, due_date);
@@ -0,0 +1,12 @@
+-- Giveaway listings become exchange listings; books reserved for a recipient are listed again
+UPDATE books SET status = 'listed', available = 1
+WHERE status = 'reserved' AND id IN (SELECT book_id FROM giveaway_claims WHERE status = 'chosen');
+UPDATE books SET listing_type = 'exchange' WHERE listing_type = 'giveaway';
+
+DROP INDEX IF EXISTS idx_giveaway_claims_open_unique;
+DROP INDEX IF EXISTS idx_giveaway_claims_requester_id;
+DROP INDEX IF EXISTS idx_giveaway_claims_owner_id;
+DROP INDEX IF EXISTS idx_giveaway_claims_book_id;
+DROP TABLE IF EXISTS giveaway_claims;
+
+ALTER TABLE books DROP COLUMN giveaway_pick;
@@ -0,0 +1,27 @@
+-- How a giveaway listing picks its recipient: 'manual' (the owner chooses from the
+-- queue) or 'first' (the first eligible requester); empty for other listings
+ALTER TABLE books ADD COLUMN giveaway_pick TEXT NOT NULL DEFAULT '';
+
+-- Requests for giveaway listings. A chosen claim reserves the book for its requester
+-- until the owner marks it given; chosen_at counts towards the monthly claim limit.
+CREATE TABLE IF NOT EXISTS giveaway_claims (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    requester_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'chosen', 'completed', 'declined', 'cancelled')),
+    message TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    chosen_at DATETIME,
+    completed_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_giveaway_claims_book_id ON giveaway_claims(book_id, status);
+CREATE INDEX IF NOT EXISTS idx_giveaway_claims_owner_id ON giveaway_claims(owner_id);
+CREATE INDEX IF NOT EXISTS idx_giveaway_claims_requester_id ON giveaway_claims(requester_id, chosen_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_giveaway_claims_open_unique
+    ON giveaway_claims(book_id, requester_id) WHERE status IN ('pending', 'chosen');
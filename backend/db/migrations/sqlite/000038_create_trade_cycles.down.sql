@@ -0,0 +1,11 @@
+-- Books held for an active cycle are listed again
+UPDATE books SET status = 'listed', available = 1
+WHERE status = 'reserved' AND id IN (
+    SELECT l.book_id FROM trade_cycle_legs l JOIN trade_cycles c ON c.id = l.cycle_id WHERE c.status = 'active'
+);
+
+DROP INDEX IF EXISTS idx_trade_cycle_legs_book;
+DROP INDEX IF EXISTS idx_trade_cycle_legs_receiver;
+DROP TABLE IF EXISTS trade_cycle_legs;
+DROP INDEX IF EXISTS idx_trade_cycles_status;
+DROP TABLE IF EXISTS trade_cycles;
@@ -0,0 +1,34 @@
+-- Circular trades found by the matching engine: every participant receives the book of
+-- the next one. A cycle activates once all participants accept and completes once all
+-- of them confirm receiving their book. The signature keeps a cycle from being proposed twice.
+CREATE TABLE IF NOT EXISTS trade_cycles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    city TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'proposed' CHECK (status IN ('proposed', 'active', 'completed', 'declined', 'cancelled')),
+    signature TEXT NOT NULL UNIQUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    activated_at DATETIME,
+    completed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_trade_cycles_status ON trade_cycles(status);
+
+-- One leg per participant: giver_id hands book_id over to receiver_id
+CREATE TABLE IF NOT EXISTS trade_cycle_legs (
+    cycle_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    receiver_id INTEGER NOT NULL,
+    giver_id INTEGER NOT NULL,
+    book_id INTEGER NOT NULL,
+    accepted_at DATETIME,
+    received_at DATETIME,
+    PRIMARY KEY (cycle_id, position),
+    FOREIGN KEY (cycle_id) REFERENCES trade_cycles(id) ON DELETE CASCADE,
+    FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (giver_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_trade_cycle_legs_receiver ON trade_cycle_legs(receiver_id);
+CREATE INDEX IF NOT EXISTS idx_trade_cycle_legs_book ON trade_cycle_legs(book_id);
@@ -0,0 +1,37 @@
+-- Expired requests become cancelled ones under the previous status constraint
+CREATE TABLE book_exchanges_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    offered_book_id INTEGER NOT NULL,
+    requester_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'meetup_scheduled', 'completed', 'disputed', 'declined', 'cancelled')),
+    owner_confirmed_at DATETIME,
+    requester_confirmed_at DATETIME,
+    dispute_reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    completed_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (offered_book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_exchanges_old (id, book_id, offered_book_id, requester_id, owner_id, status, owner_confirmed_at,
+                                requester_confirmed_at, dispute_reason, created_at, updated_at, completed_at)
+SELECT id, book_id, offered_book_id, requester_id, owner_id,
+       CASE WHEN status = 'expired' THEN 'cancelled' ELSE status END, owner_confirmed_at,
+       requester_confirmed_at, dispute_reason, created_at, updated_at, completed_at
+FROM book_exchanges;
+
+UPDATE book_exchange_events SET to_status = 'cancelled' WHERE to_status = 'expired';
+
+DROP TABLE book_exchanges;
+ALTER TABLE book_exchanges_old RENAME TO book_exchanges;
+
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_book_id ON book_exchanges(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_offered_book_id ON book_exchanges(offered_book_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_requester_id ON book_exchanges(requester_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_owner_id ON book_exchanges(owner_id);
@@ -0,0 +1,36 @@
+-- Pending requests expire after a TTL: 'expired' joins the end states of an exchange.
+-- SQLite cannot alter a CHECK constraint, so the table is rebuilt.
+CREATE TABLE book_exchanges_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    offered_book_id INTEGER NOT NULL,
+    requester_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'meetup_scheduled', 'completed', 'disputed', 'declined', 'cancelled', 'expired')),
+    owner_confirmed_at DATETIME,
+    requester_confirmed_at DATETIME,
+    dispute_reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    completed_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (offered_book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (requester_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_exchanges_new (id, book_id, offered_book_id, requester_id, owner_id, status, owner_confirmed_at,
+                                requester_confirmed_at, dispute_reason, created_at, updated_at, completed_at)
+SELECT id, book_id, offered_book_id, requester_id, owner_id, status, owner_confirmed_at,
+       requester_confirmed_at, dispute_reason, created_at, updated_at, completed_at
+FROM book_exchanges;
+
+DROP TABLE book_exchanges;
+ALTER TABLE book_exchanges_new RENAME TO book_exchanges;
+
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_book_id ON book_exchanges(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_offered_book_id ON book_exchanges(offered_book_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_requester_id ON book_exchanges(requester_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_owner_id ON book_exchanges(owner_id);
+CREATE INDEX IF NOT EXISTS idx_book_exchanges_status ON book_exchanges(status, updated_at);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS calendar_feeds;
+DROP INDEX IF EXISTS idx_exchange_meetups_starts_at;
+DROP TABLE IF EXISTS exchange_meetups;
+DROP INDEX IF EXISTS idx_meetup_spots_city;
+DROP TABLE IF EXISTS meetup_spots;
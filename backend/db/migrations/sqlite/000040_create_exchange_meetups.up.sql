@@ -0,0 +1,66 @@
+-- Curated safe public places where exchange partners can hand books over, per city.
+-- Spots are retired (active = 0) rather than deleted so past meetups keep their place.
+CREATE TABLE IF NOT EXISTS meetup_spots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    city TEXT NOT NULL,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    latitude REAL,
+    longitude REAL,
+    active BOOLEAN NOT NULL DEFAULT 1,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (city, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_meetup_spots_city ON meetup_spots(city, active);
+
+INSERT OR IGNORE INTO meetup_spots (city, name, address) VALUES
+    ('Rabat', 'Bibliothèque Nationale du Royaume du Maroc', 'Avenue Ibn Khaldoun, Agdal'),
+    ('Rabat', 'Gare Rabat-Ville', 'Avenue Mohammed V'),
+    ('Rabat', 'Arribat Center', 'Avenue Mohammed VI'),
+    ('Salé', 'Gare Salé-Ville', ''),
+    ('Kenitra', 'Gare Kénitra', ''),
+    ('Casablanca', 'Gare Casa-Voyageurs', 'Boulevard Ba Hmad'),
+    ('Casablanca', 'Twin Center', 'Boulevard Zerktouni'),
+    ('Casablanca', 'Morocco Mall', 'Boulevard de la Corniche, Aïn Diab'),
+    ('Marrakesh', 'Gare de Marrakech', 'Avenue Hassan II, Guéliz'),
+    ('Marrakesh', 'Carré Eden', 'Avenue Mohammed V, Guéliz'),
+    ('Marrakesh', 'Menara Mall', 'Avenue Mohammed VI'),
+    ('Fes', 'Gare de Fès', ''),
+    ('Fes', 'Borj Fez', ''),
+    ('Meknes', 'Gare Meknès', ''),
+    ('Tangier', 'Gare Tanger-Ville', ''),
+    ('Tangier', 'Tanger City Mall', ''),
+    ('Agadir', 'Marina d''Agadir', ''),
+    ('Oujda', 'Gare d''Oujda', ''),
+    ('Beni Mellal', 'Gare routière de Béni Mellal', '');
+
+-- One meetup per exchange. starts_at is an RFC 3339 UTC time. A proposal waits for the
+-- other party's answer; a reschedule makes it a new proposal and bumps sequence so
+-- calendar apps replace the event instead of adding another.
+CREATE TABLE IF NOT EXISTS exchange_meetups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    exchange_id INTEGER NOT NULL UNIQUE,
+    spot_id INTEGER NOT NULL,
+    starts_at TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'proposed' CHECK (status IN ('proposed', 'accepted')),
+    proposed_by INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    sequence INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    accepted_at DATETIME,
+    FOREIGN KEY (exchange_id) REFERENCES book_exchanges(id) ON DELETE CASCADE,
+    FOREIGN KEY (spot_id) REFERENCES meetup_spots(id),
+    FOREIGN KEY (proposed_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_exchange_meetups_starts_at ON exchange_meetups(starts_at);
+
+-- The secret token of each user's personal meetup calendar feed
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+    user_id INTEGER PRIMARY KEY,
+    token TEXT NOT NULL UNIQUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
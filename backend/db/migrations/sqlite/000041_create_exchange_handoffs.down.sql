@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN exchange_count;
+DROP TABLE IF EXISTS exchange_handoff_codes;
@@ -0,0 +1,17 @@
+-- One-time handoff codes of accepted exchanges, one per side. holder is the side that
+-- shows the code ('owner' or 'requester'); the other party enters or scans it at the
+-- meetup. The exchange completes once both codes are verified. A code that was guessed
+-- wrong too often is replaced, so attempts only counts the current code.
+CREATE TABLE IF NOT EXISTS exchange_handoff_codes (
+    exchange_id INTEGER NOT NULL,
+    holder TEXT NOT NULL CHECK (holder IN ('owner', 'requester')),
+    code TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    verified_at DATETIME,
+    PRIMARY KEY (exchange_id, holder),
+    FOREIGN KEY (exchange_id) REFERENCES book_exchanges(id) ON DELETE CASCADE
+);
+
+-- Exchanges a user completed with verified handoff codes
+ALTER TABLE users ADD COLUMN exchange_count INTEGER NOT NULL DEFAULT 0;
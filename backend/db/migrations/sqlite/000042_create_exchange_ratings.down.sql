@@ -0,0 +1,2 @@
+ALTER TABLE book_exchanges DROP COLUMN no_show_user_id;
+DROP TABLE IF EXISTS exchange_ratings;
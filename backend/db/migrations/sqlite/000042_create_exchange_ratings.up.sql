@@ -0,0 +1,19 @@
+-- Ratings the parties of a completed exchange give each other, once per side
+CREATE TABLE IF NOT EXISTS exchange_ratings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    exchange_id INTEGER NOT NULL,
+    rater_id INTEGER NOT NULL,
+    ratee_id INTEGER NOT NULL,
+    stars INTEGER NOT NULL CHECK (stars BETWEEN 1 AND 5),
+    comment TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (exchange_id, rater_id),
+    FOREIGN KEY (exchange_id) REFERENCES book_exchanges(id) ON DELETE CASCADE,
+    FOREIGN KEY (rater_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (ratee_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_exchange_ratings_ratee ON exchange_ratings(ratee_id, created_at);
+
+-- The party reported as not showing up at the meetup of a cancelled exchange
+ALTER TABLE book_exchanges ADD COLUMN no_show_user_id INTEGER;
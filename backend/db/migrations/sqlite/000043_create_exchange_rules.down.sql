@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS exchange_rule_genres;
+DROP TABLE IF EXISTS exchange_rules;
@@ -0,0 +1,22 @@
+-- Conditions an owner sets on the exchange requests they receive, for one listing or,
+-- with book_id 0, for their whole library. Zero and empty values mean no condition.
+-- min_condition is a condition name; offered books must be in it or better.
+CREATE TABLE IF NOT EXISTS exchange_rules (
+    owner_id INTEGER NOT NULL,
+    book_id INTEGER NOT NULL DEFAULT 0,
+    same_city INTEGER NOT NULL DEFAULT 0,
+    min_condition TEXT NOT NULL DEFAULT '',
+    min_rating REAL NOT NULL DEFAULT 0,
+    min_account_age_days INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (owner_id, book_id),
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- The genres an owner accepts in return; none means any genre
+CREATE TABLE IF NOT EXISTS exchange_rule_genres (
+    owner_id INTEGER NOT NULL,
+    book_id INTEGER NOT NULL DEFAULT 0,
+    genre TEXT NOT NULL,
+    PRIMARY KEY (owner_id, book_id, genre)
+);
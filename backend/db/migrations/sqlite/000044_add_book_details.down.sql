@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_books_language;
+
+ALTER TABLE books DROP COLUMN series_volume;
+ALTER TABLE books DROP COLUMN series;
+ALTER TABLE books DROP COLUMN page_count;
+ALTER TABLE books DROP COLUMN publication_year;
+ALTER TABLE books DROP COLUMN publisher;
+ALTER TABLE books DROP COLUMN format;
+ALTER TABLE books DROP COLUMN language;
+
+DROP TABLE IF EXISTS formats;
+DROP TABLE IF EXISTS languages;
@@ -0,0 +1,45 @@
+-- Languages and formats join the admin-managed reference tables. A language name is
+-- its ISO 639 code.
+CREATE TABLE IF NOT EXISTS languages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    label_ar TEXT NOT NULL DEFAULT '',
+    label_fr TEXT NOT NULL DEFAULT '',
+    label_en TEXT NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS formats (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    label_ar TEXT NOT NULL DEFAULT '',
+    label_fr TEXT NOT NULL DEFAULT '',
+    label_en TEXT NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR IGNORE INTO languages (name, label_ar, label_fr, label_en, sort_order) VALUES
+    ('ar', 'العربية', 'Arabe', 'Arabic', 0),
+    ('fr', 'الفرنسية', 'Français', 'French', 1),
+    ('en', 'الإنجليزية', 'Anglais', 'English', 2),
+    ('es', 'الإسبانية', 'Espagnol', 'Spanish', 3),
+    ('zgh', 'الأمازيغية', 'Amazighe', 'Amazigh', 4),
+    ('de', 'الألمانية', 'Allemand', 'German', 5);
+
+INSERT OR IGNORE INTO formats (name, label_ar, label_fr, label_en, sort_order) VALUES
+    ('paperback', 'غلاف ورقي', 'Broché', 'Paperback', 0),
+    ('hardcover', 'غلاف مقوى', 'Relié', 'Hardcover', 1),
+    ('pocket', 'كتاب جيب', 'Poche', 'Pocket', 2);
+
+-- Bibliographic details of a listing; empty and zero values are unknown
+ALTER TABLE books ADD COLUMN language TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN format TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN publisher TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN publication_year INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN page_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN series TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN series_volume INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_books_language ON books(language);
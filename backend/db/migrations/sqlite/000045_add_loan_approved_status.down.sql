@@ -0,0 +1,37 @@
+-- Approved loans that have not started become cancelled ones under the previous status
+-- constraint, and their books are listed again
+UPDATE books SET status = 'listed', available = 1, updated_at = CURRENT_TIMESTAMP
+WHERE status = 'reserved' AND id IN (SELECT book_id FROM book_loans WHERE status = 'approved');
+
+CREATE TABLE book_loans_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    borrower_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'active', 'returned', 'declined', 'cancelled')),
+    start_date TEXT NOT NULL,
+    due_date TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    returned_at DATETIME,
+    reminder_sent_at DATETIME,
+    overdue_notified_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (borrower_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_loans_old (id, book_id, owner_id, borrower_id, status, start_date, due_date, message,
+                             created_at, updated_at, returned_at, reminder_sent_at, overdue_notified_at)
+SELECT id, book_id, owner_id, borrower_id, CASE WHEN status = 'approved' THEN 'cancelled' ELSE status END, start_date, due_date, message,
+       created_at, updated_at, returned_at, reminder_sent_at, overdue_notified_at
+FROM book_loans;
+
+DROP TABLE book_loans;
+ALTER TABLE book_loans_old RENAME TO book_loans;
+
+CREATE INDEX IF NOT EXISTS idx_book_loans_book_id ON book_loans(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_owner_id ON book_loans(owner_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_borrower_id ON book_loans(borrower_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_due ON book_loans(status, due_date);
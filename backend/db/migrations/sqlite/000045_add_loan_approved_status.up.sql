@@ -0,0 +1,36 @@
+-- An approved loan waits for its start date with the book reserved; the loan job makes
+-- it active and the book lent_out on that date. SQLite cannot alter a CHECK constraint,
+-- so the table is rebuilt.
+CREATE TABLE book_loans_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id INTEGER NOT NULL,
+    owner_id INTEGER NOT NULL,
+    borrower_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'active', 'returned', 'declined', 'cancelled')),
+    start_date TEXT NOT NULL,
+    due_date TEXT NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    returned_at DATETIME,
+    reminder_sent_at DATETIME,
+    overdue_notified_at DATETIME,
+    FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (borrower_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO book_loans_new (id, book_id, owner_id, borrower_id, status, start_date, due_date, message,
+                             created_at, updated_at, returned_at, reminder_sent_at, overdue_notified_at)
+SELECT id, book_id, owner_id, borrower_id, status, start_date, due_date, message,
+       created_at, updated_at, returned_at, reminder_sent_at, overdue_notified_at
+FROM book_loans;
+
+DROP TABLE book_loans;
+ALTER TABLE book_loans_new RENAME TO book_loans;
+
+CREATE INDEX IF NOT EXISTS idx_book_loans_book_id ON book_loans(book_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_owner_id ON book_loans(owner_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_borrower_id ON book_loans(borrower_id);
+CREATE INDEX IF NOT EXISTS idx_book_loans_due ON book_loans(status, due_date);
+CREATE INDEX IF NOT EXISTS idx_book_loans_start ON book_loans(status, start_date);
@@ -9,16 +9,86 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/mattn/go-sqlite3"
+	sqlite3driver "github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
+// driverName is the sqlite3 driver extended with the app's SQL functions
+const driverName = "sqlite3_ktabnet"
+
+func init() {
+	sql.Register(driverName, &sqlite3driver.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3driver.SQLiteConn) error {
+			// normalize_text(x) applies utils.NormalizeText so search triggers and
+			// queries fold text exactly like the Go code does
+			if err := conn.RegisterFunc("normalize_text", normalizeText, true); err != nil {
+				return err
+			}
+			// isbn13(x) returns the canonical ISBN-13 of x, or NULL when x is not a valid ISBN
+			if err := conn.RegisterFunc("isbn13", isbn13, true); err != nil {
+				return err
+			}
+			// haversine_km(lat1, lng1, lat2, lng2) is the distance in km between two
+			// points; this SQLite build has no math functions to compute it in SQL
+			return conn.RegisterFunc("haversine_km", haversineKm, true)
+		},
+	})
+}
+
+// isbn13 is the SQL-facing wrapper of utils.NormalizeISBN
+func isbn13(v interface{}) interface{} {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case []byte:
+		s = string(t)
+	default:
+		return nil
+	}
+	isbn, err := utils.NormalizeISBN(s)
+	if err != nil {
+		return nil
+	}
+	return isbn
+}
+
+// haversineKm is the SQL-facing wrapper of utils.HaversineKm; any NULL argument gives NULL
+func haversineKm(lat1, lng1, lat2, lng2 interface{}) interface{} {
+	coords := make([]float64, 4)
+	for i, v := range []interface{}{lat1, lng1, lat2, lng2} {
+		switch n := v.(type) {
+		case float64:
+			coords[i] = n
+		case int64:
+			coords[i] = float64(n)
+		default:
+			return nil
+		}
+	}
+	return utils.HaversineKm(coords[0], coords[1], coords[2], coords[3])
+}
+
+// normalizeText is the SQL-facing wrapper of utils.NormalizeText; NULL stays NULL
+func normalizeText(v interface{}) interface{} {
+	switch s := v.(type) {
+	case string:
+		return utils.NormalizeText(s)
+	case []byte:
+		if s == nil {
+			return nil
+		}
+		return utils.NormalizeText(string(s))
+	}
+	return v
+}
+
 func InitDB() {
 	var err error
 	dbPath := utils.GetDBPath()
 	fmt.Println("📂 Database path:", dbPath)
-	DB, err = sql.Open("sqlite3", dbPath)
+	DB, err = sql.Open(driverName, dbPath)
 	if err != nil {
 		log.Fatal("Failed to open DB:", err)
 	}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -262,6 +264,14 @@ func (h *AdminHandler) DeleteBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.bookService.DeleteBook(bookID)
+	if errors.Is(err, services.ErrBookNotDeletable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		fmt.Println("Error deleting book:", err)
 		http.Error(w, "Failed to delete book", http.StatusInternalServerError)
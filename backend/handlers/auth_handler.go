@@ -3,9 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"ktabnet/hub"
 	"ktabnet/models"
 	"ktabnet/services"
@@ -17,6 +15,7 @@ type Handler struct {
 	authService    *services.AuthService
 	sessionService *services.SessionService
 	Hub            *hub.Hub
+	images         *services.ImageService
 }
 
 type loginResponse struct {
@@ -36,8 +35,8 @@ type responseUser struct {
 	IsBanned  bool   `json:"is_banned"`
 }
 
-func NewHandler(service *services.AuthService, sessionService *services.SessionService, hub *hub.Hub) *Handler {
-	return &Handler{authService: service, sessionService: sessionService, Hub: hub}
+func NewHandler(service *services.AuthService, sessionService *services.SessionService, hub *hub.Hub, images *services.ImageService) *Handler {
+	return &Handler{authService: service, sessionService: sessionService, Hub: hub, images: images}
 }
 
 // handler/auth_handler.go
@@ -65,24 +64,13 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Handle avatar upload
-	file, header, err := r.FormFile("avatar")
+	_, header, err := r.FormFile("avatar")
 	if err == nil {
-		defer file.Close()
-
-		avatarPath := utils.GetUploadPath("avatars/" + header.Filename)
-		out, err := os.Create(avatarPath)
-		if err != nil {
-			http.Error(w, "Unable to save avatar", http.StatusInternalServerError)
-			return
-		}
-		defer out.Close()
-
-		_, err = io.Copy(out, file)
+		form.Avatar, err = h.images.SaveUpload(header, "avatars")
 		if err != nil {
-			http.Error(w, "Failed to write avatar", http.StatusInternalServerError)
+			writeImageError(w, err)
 			return
 		}
-		form.Avatar = "/uploads/avatars/" + header.Filename
 	} else {
 		form.Avatar = ""
 	}
@@ -1,31 +1,31 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
-	"time"
 
-	"ktabnet/hub"
 	"ktabnet/models"
+	"ktabnet/repositories"
 	"ktabnet/services"
 	"ktabnet/utils"
 )
 
 type BookHandler struct {
-	Service        *services.BookService
-	Session        *services.SessionService
-	NotifService   *services.NotificationService
-	Hub            *hub.Hub
-	ProfileService *services.ProfileService
+	Service         *services.BookService
+	Session         *services.SessionService
+	ProfileService  *services.ProfileService
+	MetadataService *services.BookMetadataService
+	Rules           *services.ExchangeRuleService
 }
 
-func NewBookHandler(service *services.BookService, session *services.SessionService, notifService *services.NotificationService, hub *hub.Hub, profileService *services.ProfileService) *BookHandler {
-	return &BookHandler{Service: service, Session: session, NotifService: notifService, Hub: hub, ProfileService: profileService}
+func NewBookHandler(service *services.BookService, session *services.SessionService, profileService *services.ProfileService, metadataService *services.BookMetadataService) *BookHandler {
+	return &BookHandler{Service: service, Session: session, ProfileService: profileService, MetadataService: metadataService}
 }
 
 func (h *BookHandler) BooksHandler(w http.ResponseWriter, r *http.Request) {
@@ -71,52 +71,169 @@ func (h *BookHandler) CreateBookHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	publicationYear, ok := formInt(w, r, "publication_year")
+	if !ok {
+		return
+	}
+	pageCount, ok := formInt(w, r, "page_count")
+	if !ok {
+		return
+	}
+	seriesVolume, ok := formInt(w, r, "series_volume")
+	if !ok {
+		return
+	}
+
 	book := models.Book{
-		OwnerID:     userID,
-		Title:       title,
-		Author:      author,
-		ISBN:        isbn,
-		Description: description,
-		Genre:       genre,
-		Condition:   condition,
-		City:        city,
-		Available:   true,
+		OwnerID:         userID,
+		Title:           title,
+		Author:          author,
+		ISBN:            isbn,
+		Description:     description,
+		Genre:           genre,
+		Condition:       condition,
+		Language:        r.FormValue("language"),
+		Format:          r.FormValue("format"),
+		Publisher:       r.FormValue("publisher"),
+		PublicationYear: publicationYear,
+		PageCount:       pageCount,
+		Series:          r.FormValue("series"),
+		SeriesVolume:    seriesVolume,
+		City:            city,
+		ListingType:     r.FormValue("listing_type"),
+		GiveawayPick:    r.FormValue("giveaway_pick"),
+		Available:       true,
+	}
+
+	// Run the photos through the upload pipeline first so a bad file rejects the whole listing
+	files := r.MultipartForm.File["images"]
+	imageURLs, err := h.storeImages(files)
+	if err != nil {
+		writeImageError(w, err)
+		return
 	}
 
 	bookID, err := h.Service.CreateBook(book)
 	if err != nil {
+		h.Service.DiscardImages(imageURLs)
+		if errors.Is(err, utils.ErrInvalidISBN) {
+			http.Error(w, "Invalid ISBN: expected a valid ISBN-10 or ISBN-13", http.StatusBadRequest)
+			return
+		}
+		if writeBookInputError(w, err) {
+			return
+		}
 		fmt.Println("Error creating book:", err)
 		http.Error(w, "Failed to create book", http.StatusInternalServerError)
 		return
 	}
 
-	// Handle multiple images
-	files := r.MultipartForm.File["images"]
-	for i, fileHeader := range files {
-		file, err := fileHeader.Open()
-		if err != nil {
-			continue
+	h.addBookImages(bookID, imageURLs, true)
+
+	// Attach the catalog cover when the user asked for it and uploaded no photos
+	if len(files) == 0 && isbn != "" && r.FormValue("attach_cover") == "true" && h.MetadataService != nil {
+		if err := h.attachCover(bookID, isbn); err != nil {
+			fmt.Println("Failed to attach cover:", err)
 		}
-		defer file.Close()
+	}
 
-		filename := fmt.Sprintf("%d_%d_%s", bookID, time.Now().UnixNano(), fileHeader.Filename)
-		dst := utils.GetUploadPath("books/" + filename)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"id": bookID})
+}
+
+// formInt reads an optional whole number form field, 0 when empty. It writes a 400
+// and returns false when the value is not a number.
+func formInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value := strings.TrimSpace(r.FormValue(name))
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		http.Error(w, "Invalid "+name+": must be a whole number", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
 
-		outFile, err := os.Create(dst)
+// storeImages saves uploaded book photos through the image pipeline and returns their URLs
+func (h *BookHandler) storeImages(files []*multipart.FileHeader) ([]string, error) {
+	urls := make([]string, 0, len(files))
+	for _, fileHeader := range files {
+		url, err := h.Service.Images.SaveUpload(fileHeader, "books")
 		if err != nil {
+			h.Service.DiscardImages(urls)
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
+
+// addBookImages records stored images for a book. When firstIsPrimary is set the
+// first one becomes the primary image.
+func (h *BookHandler) addBookImages(bookID int, urls []string, firstIsPrimary bool) int {
+	added := 0
+	for _, url := range urls {
+		isPrimary := firstIsPrimary && added == 0
+		if err := h.Service.AddImage(bookID, url, isPrimary); err != nil {
+			fmt.Println("Error adding book image:", err)
+			h.Service.DiscardImages([]string{url})
 			continue
 		}
-		defer outFile.Close()
+		added++
+	}
+	return added
+}
 
-		io.Copy(outFile, file)
+// attachCover downloads the metadata cover of isbn and stores it as the book's primary image
+func (h *BookHandler) attachCover(bookID int, isbn string) error {
+	meta, err := h.MetadataService.Lookup(isbn)
+	if err != nil {
+		return err
+	}
+	data, _, err := h.MetadataService.DownloadCover(meta)
+	if err != nil {
+		return err
+	}
+
+	url, err := h.Service.Images.Save(bytes.NewReader(data), "books")
+	if err != nil {
+		return err
+	}
+	return h.Service.AddImage(bookID, url, true)
+}
+
+// LookupBookHandler returns prefill data for a listing: GET /api/books/lookup?isbn=
+func (h *BookHandler) LookupBookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	isbn := r.URL.Query().Get("isbn")
+	if isbn == "" {
+		http.Error(w, "Missing isbn", http.StatusBadRequest)
+		return
+	}
 
-		imageURL := "/uploads/books/" + filename
-		isPrimary := i == 0 // First image is primary
-		h.Service.AddImage(bookID, imageURL, isPrimary)
+	meta, err := h.MetadataService.Lookup(isbn)
+	if err != nil {
+		if errors.Is(err, services.ErrMetadataNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidISBN) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Println("Error looking up ISBN:", err)
+		http.Error(w, "Failed to look up ISBN", http.StatusBadGateway)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]int{"id": bookID})
+	json.NewEncoder(w).Encode(meta)
 }
 
 func (h *BookHandler) GetFeedHandler(w http.ResponseWriter, r *http.Request) {
@@ -126,15 +243,49 @@ func (h *BookHandler) GetFeedHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	books, err := h.Service.GetFeedBooksWithOwner(userID)
+	filter, err := services.ParseFeedFilter(r.URL.Query())
 	if err != nil {
-		fmt.Println("hna1", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.Service.GetFeedBooksWithOwner(userID, filter)
+	if err != nil {
+		if errors.Is(err, repositories.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if writeBookInputError(w, err) {
+			return
+		}
+		fmt.Println("Error fetching feed:", err)
 		http.Error(w, "Failed to fetch books", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	json.NewEncoder(w).Encode(page)
+}
+
+// writeBookInputError answers 400 for a genre, condition, city, language or format
+// missing from the taxonomy, an unknown listing type or giveaway pick, invalid book
+// details or a missing profile location, and 409 for a listing or listing type that
+// cannot change now. It reports whether err was one of those.
+func writeBookInputError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrUnknownTerm), errors.Is(err, services.ErrInvalidListingType),
+		errors.Is(err, services.ErrInvalidGiveawayPick), errors.Is(err, services.ErrInvalidBookDetails):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrListingTypeLocked), errors.Is(err, services.ErrListingLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrUnknownCity):
+		http.Error(w, "Unknown city", http.StatusBadRequest)
+	case errors.Is(err, services.ErrNoLocation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		return false
+	}
+	return true
 }
 
 func (h *BookHandler) GetBookHandler(w http.ResponseWriter, r *http.Request) {
@@ -155,6 +306,29 @@ func (h *BookHandler) GetBookHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(book)
 }
 
+// GetWorkHandler returns a work with its available copies: GET /api/works/{id}
+func (h *BookHandler) GetWorkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	workID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/works/"))
+	if err != nil {
+		http.Error(w, "Invalid work ID", http.StatusBadRequest)
+		return
+	}
+
+	work, err := h.Service.GetWork(workID)
+	if err != nil {
+		http.Error(w, "Work not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(work)
+}
+
 func (h *BookHandler) GetMyBooksHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.Session.GetUserIDFromSession(w, r)
 	if !ok {
@@ -173,236 +347,495 @@ func (h *BookHandler) GetMyBooksHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(books)
 }
 
-func (h *BookHandler) UpdateBookHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// BookByIDHandler routes /api/books/{id} and its image sub-resources:
+//
+//	GET    /api/books/{id}
+//	PUT    /api/books/{id}                          replace the listing (owner only)
+//	PATCH  /api/books/{id}                          update some fields (owner only)
+//	DELETE /api/books/{id}                          delete the listing (owner only)
+//	PUT    /api/books/{id}/status                   archive or relist the book (owner only)
+//	GET    /api/books/{id}/status-history           status changes (owner only)
+//	GET    /api/books/{id}/images
+//	POST   /api/books/{id}/images                   upload images (owner only)
+//	PUT    /api/books/{id}/images/order             reorder images (owner only)
+//	DELETE /api/books/{id}/images/{imageID}         remove an image (owner only)
+//	PUT    /api/books/{id}/images/{imageID}/primary set the primary image (owner only)
+//	GET    /api/books/{id}/exchange-rules           the rules a request for the listing has to meet
+//	PUT    /api/books/{id}/exchange-rules           set the listing's exchange rules (owner only)
+//	DELETE /api/books/{id}/exchange-rules           remove the listing's exchange rules (owner only)
+func (h *BookHandler) BookByIDHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/books/"), "/"), "/")
+	bookID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid book ID", http.StatusBadRequest)
 		return
 	}
 
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			h.GetBookHandler(w, r)
+		case http.MethodPut:
+			h.updateBook(w, r, bookID)
+		case http.MethodPatch:
+			h.patchBook(w, r, bookID)
+		case http.MethodDelete:
+			h.deleteBook(w, r, bookID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case len(parts) == 2 && parts[1] == "status" && r.Method == http.MethodPut:
+		h.setBookStatus(w, r, bookID)
+	case len(parts) == 2 && parts[1] == "status-history" && r.Method == http.MethodGet:
+		h.getStatusHistory(w, r, bookID)
+	case len(parts) == 2 && parts[1] == "exchange-rules":
+		h.listingRules(w, r, bookID)
+	case parts[1] != "images":
+		http.NotFound(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		h.getImages(w, bookID, http.StatusOK)
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		h.addImages(w, r, bookID)
+	case len(parts) == 3 && parts[2] == "order" && r.Method == http.MethodPut:
+		h.reorderImages(w, r, bookID)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		h.imageAction(w, r, bookID, parts[2], h.Service.RemoveBookImage)
+	case len(parts) == 4 && parts[3] == "primary" && r.Method == http.MethodPut:
+		h.imageAction(w, r, bookID, parts[2], h.Service.SetPrimaryImage)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ExchangeRulesHandler manages the exchange rules of the session user's whole library:
+//
+//	GET    /api/exchange-rules  the rules, empty when none are set
+//	PUT    /api/exchange-rules  set the rules
+//	DELETE /api/exchange-rules  remove the rules
+func (h *BookHandler) ExchangeRulesHandler(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.Session.GetUserIDFromSession(w, r)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/books/")
-	bookID, err := strconv.Atoi(idStr)
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := h.Rules.Library(userID)
+		if err != nil {
+			fmt.Println("Error fetching exchange rules:", err)
+			http.Error(w, "Failed to fetch exchange rules", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPut:
+		h.saveRules(w, r, userID, 0)
+	case http.MethodDelete:
+		h.deleteRules(w, userID, 0)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *BookHandler) listingRules(w http.ResponseWriter, r *http.Request, bookID int) {
+	switch r.Method {
+	case http.MethodGet:
+		book, err := h.Service.GetBook(bookID)
+		if err != nil {
+			http.Error(w, "Book not found", http.StatusNotFound)
+			return
+		}
+		rules, err := h.Rules.ForBook(book)
+		if err != nil {
+			fmt.Println("Error fetching exchange rules:", err)
+			http.Error(w, "Failed to fetch exchange rules", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPut:
+		book, ok := h.ownedBook(w, r, bookID)
+		if !ok {
+			return
+		}
+		if book.ListingType != models.ListingTypeExchange {
+			http.Error(w, "Exchange rules only apply to exchange listings", http.StatusBadRequest)
+			return
+		}
+		h.saveRules(w, r, book.OwnerID, book.ID)
+	case http.MethodDelete:
+		book, ok := h.ownedBook(w, r, bookID)
+		if !ok {
+			return
+		}
+		h.deleteRules(w, book.OwnerID, book.ID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *BookHandler) saveRules(w http.ResponseWriter, r *http.Request, ownerID, bookID int) {
+	var req models.ExchangeRulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	rules, err := h.Rules.Set(ownerID, bookID, req)
+	if errors.Is(err, services.ErrInvalidExchangeRule) || errors.Is(err, services.ErrUnknownTerm) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		fmt.Println("Error saving exchange rules:", err)
+		http.Error(w, "Failed to save exchange rules", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
 
-	var book models.Book
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+func (h *BookHandler) deleteRules(w http.ResponseWriter, ownerID, bookID int) {
+	if err := h.Rules.Delete(ownerID, bookID); err != nil {
+		fmt.Println("Error deleting exchange rules:", err)
+		http.Error(w, "Failed to delete exchange rules", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
 
-	book.ID = bookID
-	book.OwnerID = userID
+// ownedBook loads a book and checks that the session user owns it.
+// It writes the error response and returns false otherwise.
+func (h *BookHandler) ownedBook(w http.ResponseWriter, r *http.Request, bookID int) (models.Book, bool) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		return models.Book{}, false
+	}
 
-	if err := h.Service.UpdateBook(book); err != nil {
-		http.Error(w, "Failed to update book", http.StatusInternalServerError)
-		return
+	if h.ProfileService != nil && h.ProfileService.IsBanned(userID) {
+		http.Error(w, "You are banned and cannot edit books", http.StatusForbidden)
+		return models.Book{}, false
 	}
 
-	w.WriteHeader(http.StatusOK)
+	book, err := h.Service.GetBook(bookID)
+	if err != nil {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return models.Book{}, false
+	}
+	if book.OwnerID != userID {
+		http.Error(w, "Forbidden: you do not own this book", http.StatusForbidden)
+		return models.Book{}, false
+	}
+	return book, true
 }
 
-func (h *BookHandler) DeleteBookHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// saveBookUpdate validates and stores an edited listing, with the owner's status
+// change when status is set
+func (h *BookHandler) saveBookUpdate(w http.ResponseWriter, book models.Book, status string) {
+	if book.Title == "" || book.Author == "" || book.Condition == "" || book.City == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
 
-	userID, ok := h.Session.GetUserIDFromSession(w, r)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	var err error
+	if status == "" {
+		err = h.Service.UpdateBook(book)
+	} else {
+		err = h.Service.UpdateBookAndStatus(book, status)
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidBookStatus) || errors.Is(err, services.ErrBookStatusForbidden) {
+			writeBookStatusError(w, err)
+			return
+		}
+		if errors.Is(err, utils.ErrInvalidISBN) {
+			http.Error(w, "Invalid ISBN: expected a valid ISBN-10 or ISBN-13", http.StatusBadRequest)
+			return
+		}
+		if writeBookInputError(w, err) {
+			return
+		}
+		fmt.Println("Error updating book:", err)
+		http.Error(w, "Failed to update book", http.StatusInternalServerError)
 		return
 	}
-	fmt.Println("User ID from session:", userID)
 
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/books/")
-	bookID, err := strconv.Atoi(idStr)
+	updated, err := h.Service.GetBook(book.ID)
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		http.Error(w, "Failed to load book", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
 
-	if err := h.Service.DeleteBook(bookID); err != nil {
-		http.Error(w, "Failed to delete book", http.StatusInternalServerError)
+func (h *BookHandler) updateBook(w http.ResponseWriter, r *http.Request, bookID int) {
+	current, ok := h.ownedBook(w, r, bookID)
+	if !ok {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	var book models.Book
+	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
 
-type exchangeRequest struct {
-	BookID        int `json:"book_id"`
-	OfferedBookID int `json:"offered_book_id"`
+	book.ID = bookID
+	book.OwnerID = current.OwnerID
+	h.saveBookUpdate(w, book, "")
 }
 
-func (h *BookHandler) ExchangeBookHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *BookHandler) patchBook(w http.ResponseWriter, r *http.Request, bookID int) {
+	book, ok := h.ownedBook(w, r, bookID)
+	if !ok {
 		return
 	}
 
-	userID, ok := h.Session.GetUserIDFromSession(w, r)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	var req models.UpdateBookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Check if user is banned
-	if h.ProfileService != nil && h.ProfileService.IsBanned(userID) {
-		http.Error(w, "You are banned and cannot exchange books", http.StatusForbidden)
+	if req.Title != nil {
+		book.Title = *req.Title
+	}
+	if req.Author != nil {
+		book.Author = *req.Author
+	}
+	if req.ISBN != nil {
+		book.ISBN = *req.ISBN
+	}
+	if req.Description != nil {
+		book.Description = *req.Description
+	}
+	if req.Genre != nil {
+		book.Genre = *req.Genre
+	}
+	if req.Condition != nil {
+		book.Condition = *req.Condition
+	}
+	if req.Language != nil {
+		book.Language = *req.Language
+	}
+	if req.Format != nil {
+		book.Format = *req.Format
+	}
+	if req.Publisher != nil {
+		book.Publisher = *req.Publisher
+	}
+	if req.PublicationYear != nil {
+		book.PublicationYear = *req.PublicationYear
+	}
+	if req.PageCount != nil {
+		book.PageCount = *req.PageCount
+	}
+	if req.Series != nil {
+		book.Series = *req.Series
+	}
+	if req.SeriesVolume != nil {
+		book.SeriesVolume = *req.SeriesVolume
+	}
+	if req.City != nil {
+		book.City = *req.City
+	}
+	if req.ListingType != nil {
+		book.ListingType = *req.ListingType
+	}
+	if req.GiveawayPick != nil {
+		book.GiveawayPick = *req.GiveawayPick
+	}
+	// The status change is applied with the other fields, once they are valid
+	status := ""
+	if req.Available != nil && *req.Available != book.Available {
+		status = models.BookStatusArchived
+		if *req.Available {
+			status = models.BookStatusListed
+		}
+	}
+	h.saveBookUpdate(w, book, status)
+}
+
+func (h *BookHandler) setBookStatus(w http.ResponseWriter, r *http.Request, bookID int) {
+	book, ok := h.ownedBook(w, r, bookID)
+	if !ok {
 		return
 	}
 
-	var req exchangeRequest
+	var req models.BookStatusRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
-	if req.BookID <= 0 || req.OfferedBookID <= 0 {
-		http.Error(w, "Invalid book ids", http.StatusBadRequest)
+	if err := h.Service.ChangeStatusByOwner(bookID, book.OwnerID, req.Status, req.Reason); err != nil {
+		writeBookStatusError(w, err)
 		return
 	}
 
-	id, isNew, err := h.Service.CreateExchangeRequest(userID, req.BookID, req.OfferedBookID)
+	updated, err := h.Service.GetBook(bookID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, "Failed to load book", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
 
-	// Only send notification if this is a new request (not a duplicate)
-	if isNew {
-		// Get book details to find the owner and book title
-		book, err := h.Service.GetBook(req.BookID)
-		if err == nil && book.OwnerID != userID {
-			// Get requester name
-			requesterName := "Someone" // Default
-			if h.NotifService != nil {
-				// Create notification for book owner
-				notification := models.Notification{
-					SenderID:       userID,
-					SenderNickname: requesterName,
-					Type:           models.NotificationTypeBookRequest,
-					Message:        fmt.Sprintf("Someone wants to exchange for your book \"%s\"", book.Title),
-					Seen:           false,
-					CreatedAt:      time.Now().Format(time.RFC3339),
-				}
-
-				// Save to database
-				h.NotifService.CreateNotification(models.CreateNotificationRequest{
-					UserID:   book.OwnerID,
-					SenderID: userID,
-					Type:     models.NotificationTypeBookRequest,
-					Message:  notification.Message,
-				})
-
-				// Send real-time notification via WebSocket
-				if h.Hub != nil {
-					h.Hub.SendNotification(notification, book.OwnerID)
-				}
-			}
-		}
+func writeBookStatusError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidBookStatus):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrBookStatusForbidden):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		fmt.Println("Error changing book status:", err)
+		http.Error(w, "Failed to change book status", http.StatusInternalServerError)
+	}
+}
+
+func (h *BookHandler) getStatusHistory(w http.ResponseWriter, r *http.Request, bookID int) {
+	if _, ok := h.ownedBook(w, r, bookID); !ok {
+		return
 	}
 
+	history, err := h.Service.GetStatusHistory(bookID)
+	if err != nil {
+		http.Error(w, "Failed to fetch status history", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]int{"id": id})
+	json.NewEncoder(w).Encode(history)
 }
 
-func (h *BookHandler) SearchBooksHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *BookHandler) deleteBook(w http.ResponseWriter, r *http.Request, bookID int) {
+	if _, ok := h.ownedBook(w, r, bookID); !ok {
 		return
 	}
 
-	query := r.URL.Query().Get("query")
-	if query == "" {
-		http.Error(w, "Missing search query", http.StatusBadRequest)
+	if err := h.Service.DeleteBook(bookID); err != nil {
+		if errors.Is(err, services.ErrBookNotDeletable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Println("Error deleting book:", err)
+		http.Error(w, "Failed to delete book", http.StatusInternalServerError)
 		return
 	}
 
-	results, err := h.Service.SearchBooks(query)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// getImages answers with the images of a book and the given status code
+func (h *BookHandler) getImages(w http.ResponseWriter, bookID, status int) {
+	images, err := h.Service.GetImages(bookID)
 	if err != nil {
-		http.Error(w, "Failed to search books", http.StatusInternalServerError)
+		http.Error(w, "Failed to fetch images", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(images)
 }
 
-// GetExchangeRequestsHandler returns all exchange requests for the current user
-func (h *BookHandler) GetExchangeRequestsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *BookHandler) addImages(w http.ResponseWriter, r *http.Request, bookID int) {
+	book, ok := h.ownedBook(w, r, bookID)
+	if !ok {
+		return
+	}
+	if err := h.Service.CheckEditable(book); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
 
-	userID, ok := h.Session.GetUserIDFromSession(w, r)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if err := r.ParseMultipartForm(50 << 20); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		http.Error(w, "No images provided", http.StatusBadRequest)
 		return
 	}
 
-	requests, err := h.Service.GetUserExchangeRequests(userID)
+	urls, err := h.storeImages(files)
 	if err != nil {
-		http.Error(w, "Failed to fetch exchange requests", http.StatusInternalServerError)
+		writeImageError(w, err)
 		return
 	}
 
-	if requests == nil {
-		requests = []models.BookExchangeRequest{}
+	existing, err := h.Service.GetImages(bookID)
+	if err != nil {
+		http.Error(w, "Failed to fetch images", http.StatusInternalServerError)
+		return
+	}
+	if h.addBookImages(bookID, urls, len(existing) == 0) == 0 {
+		http.Error(w, "Failed to save images", http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(requests)
+	h.getImages(w, bookID, http.StatusCreated)
 }
 
-// UpdateExchangeStatusHandler updates the status of an exchange request (accept/decline)
-func (h *BookHandler) UpdateExchangeStatusHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *BookHandler) reorderImages(w http.ResponseWriter, r *http.Request, bookID int) {
+	if _, ok := h.ownedBook(w, r, bookID); !ok {
 		return
 	}
 
-	userID, ok := h.Session.GetUserIDFromSession(w, r)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	var req models.ReorderImagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	var req struct {
-		ExchangeID int    `json:"exchange_id"`
-		Status     string `json:"status"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if err := h.Service.ReorderImages(bookID, req.ImageIDs); err != nil {
+		if errors.Is(err, repositories.ErrImageOrderMismatch) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, services.ErrListingLocked) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to reorder images", http.StatusInternalServerError)
 		return
 	}
+	h.getImages(w, bookID, http.StatusOK)
+}
 
-	if req.Status != "accepted" && req.Status != "declined" {
-		http.Error(w, "Invalid status", http.StatusBadRequest)
+// imageAction runs an owner-only action on one image of a book and replies with the new image list
+func (h *BookHandler) imageAction(w http.ResponseWriter, r *http.Request, bookID int, imageIDStr string, action func(bookID, imageID int) error) {
+	imageID, err := strconv.Atoi(imageIDStr)
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
 		return
 	}
-
-	if err := h.Service.UpdateExchangeStatus(req.ExchangeID, userID, req.Status); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if _, ok := h.ownedBook(w, r, bookID); !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	if err := action(bookID, imageID); err != nil {
+		if errors.Is(err, services.ErrImageNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, services.ErrListingLocked) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		fmt.Println("Error updating book image:", err)
+		http.Error(w, "Failed to update image", http.StatusInternalServerError)
+		return
+	}
+	h.getImages(w, bookID, http.StatusOK)
 }
 
-// CancelExchangeHandler cancels an exchange request
-func (h *BookHandler) CancelExchangeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+func (h *BookHandler) SearchBooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -413,19 +846,30 @@ func (h *BookHandler) CancelExchangeHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var req struct {
-		ExchangeID int `json:"exchange_id"`
+	filter, err := services.ParseSearchFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if filter.Query == "" {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.Service.CancelExchangeRequest(req.ExchangeID, userID); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	results, err := h.Service.SearchBooks(userID, filter)
+	if err != nil {
+		if errors.Is(err, repositories.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if writeBookInputError(w, err) {
+			return
+		}
+		fmt.Println("Error searching books:", err)
+		http.Error(w, "Failed to search books", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(results)
 }
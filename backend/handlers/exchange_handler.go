@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/services"
+)
+
+type ExchangeHandler struct {
+	Service        *services.ExchangeService
+	Session        *services.SessionService
+	ProfileService *services.ProfileService
+}
+
+func NewExchangeHandler(service *services.ExchangeService, session *services.SessionService, profileService *services.ProfileService) *ExchangeHandler {
+	return &ExchangeHandler{Service: service, Session: session, ProfileService: profileService}
+}
+
+// exchangeRequest asks for the books in BookIDs against the books in OfferedBookIDs;
+// the single BookID and OfferedBookID fields are accepted for one-for-one exchanges
+type exchangeRequest struct {
+	BookID         int   `json:"book_id"`
+	OfferedBookID  int   `json:"offered_book_id"`
+	BookIDs        []int `json:"book_ids"`
+	OfferedBookIDs []int `json:"offered_book_ids"`
+}
+
+func (h *ExchangeHandler) ExchangeBookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if user is banned
+	if h.ProfileService != nil && h.ProfileService.IsBanned(userID) {
+		http.Error(w, "You are banned and cannot exchange books", http.StatusForbidden)
+		return
+	}
+
+	var req exchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	bookIDs := services.BundleIDs(req.BookID, req.BookIDs)
+	offeredBookIDs := services.BundleIDs(req.OfferedBookID, req.OfferedBookIDs)
+	if len(bookIDs) == 0 || len(offeredBookIDs) == 0 {
+		http.Error(w, "Invalid book ids", http.StatusBadRequest)
+		return
+	}
+
+	id, created, err := h.Service.Create(userID, bookIDs, offeredBookIDs)
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
+}
+
+// GetExchangeRequestsHandler returns all exchange requests for the current user
+func (h *ExchangeHandler) GetExchangeRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	requests, err := h.Service.List(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch exchange requests", http.StatusInternalServerError)
+		return
+	}
+
+	if requests == nil {
+		requests = []models.BookExchangeRequest{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+// ExchangeByIDHandler serves a single exchange of the session user:
+//
+//	GET  /api/exchange-requests/{id}           the exchange with its offers and history
+//	GET  /api/exchange-requests/{id}/offers    the offer thread
+//	POST /api/exchange-requests/{id}/offers    make a counter-offer
+//	GET  /api/exchange-requests/{id}/handoff   your handoff code, to show the other party at the meetup
+//	POST /api/exchange-requests/{id}/handoff   verify the other party's code {"code": "..."}
+//	GET  /api/exchange-requests/{id}/handoff/qr.png  your handoff code as a QR code
+//	GET  /api/exchange-requests/{id}/rating    the ratings given and received for a completed exchange
+//	POST /api/exchange-requests/{id}/rating    rate the other party {"stars": 1-5, "comment": "..."}
+//	POST /api/exchange-requests/{id}/{action}  accept, decline, cancel, confirm, dispute or
+//	                                           no-show (the other party missed the meetup)
+//
+// Actions take an optional {"reason": "...", "offer_id": n} body; a dispute requires the reason.
+func (h *ExchangeHandler) ExchangeByIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/exchange-requests/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 || len(parts) > 3 || (len(parts) == 3 && parts[1] != "handoff") {
+		http.Error(w, "Invalid exchange ID", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		exchange, err := h.Service.Get(userID, id)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exchange)
+		return
+	}
+
+	if parts[1] == "offers" {
+		h.offers(w, r, userID, id)
+		return
+	}
+	if parts[1] == "handoff" {
+		h.handoff(w, r, userID, id, parts[2:])
+		return
+	}
+	if parts[1] == "rating" {
+		h.rating(w, r, userID, id)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req models.ExchangeActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	exchange, err := h.Service.Act(userID, id, parts[1], req)
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exchange)
+}
+
+func (h *ExchangeHandler) offers(w http.ResponseWriter, r *http.Request, userID, exchangeID int) {
+	switch r.Method {
+	case http.MethodGet:
+		exchange, err := h.Service.Get(userID, exchangeID)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exchange.Offers)
+	case http.MethodPost:
+		var req models.ExchangeOfferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		exchange, err := h.Service.Counter(userID, exchangeID, req)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(exchange)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *ExchangeHandler) handoff(w http.ResponseWriter, r *http.Request, userID, exchangeID int, rest []string) {
+	if len(rest) == 1 {
+		if rest[0] != "qr.png" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		png, err := h.Service.HandoffQR(userID, exchangeID)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(png)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		handoff, err := h.Service.Handoff(userID, exchangeID)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handoff)
+	case http.MethodPost:
+		var req models.HandoffVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		exchange, err := h.Service.VerifyHandoff(userID, exchangeID, req.Code)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exchange)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *ExchangeHandler) rating(w http.ResponseWriter, r *http.Request, userID, exchangeID int) {
+	switch r.Method {
+	case http.MethodGet:
+		ratings, err := h.Service.Ratings(userID, exchangeID)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ratings)
+	case http.MethodPost:
+		var req models.RatingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		ratings, err := h.Service.Rate(userID, exchangeID, req)
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ratings)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// AdminDisputesHandler lets moderators settle disputed exchanges:
+//
+//	GET  /api/admin/exchanges               the disputed exchanges, oldest first
+//	POST /api/admin/exchanges/{id}/resolve  {"outcome": "cancel"|"complete", "note": "..."}
+func (h *ExchangeHandler) AdminDisputesHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/exchanges"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		exchanges, err := h.Service.Disputes()
+		if err != nil {
+			writeExchangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exchanges)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 || len(parts) != 2 || parts[1] != "resolve" {
+		http.Error(w, "Invalid exchange ID", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	moderatorID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req models.DisputeResolutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	exchange, err := h.Service.ResolveDispute(moderatorID, id, req)
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exchange)
+}
+
+func writeExchangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrExchangeNotFound), errors.Is(err, services.ErrUnknownExchangeAction),
+		errors.Is(err, services.ErrExchangeBookNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrNotExchangeParty), errors.Is(err, services.ErrExchangeForbidden),
+		errors.Is(err, services.ErrOwnOffer), errors.Is(err, services.ErrExchangeRule):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInvalidTransition), errors.Is(err, services.ErrOfferTaken),
+		errors.Is(err, services.ErrHandoffVerified), errors.Is(err, services.ErrHandoffCodeReplaced),
+		errors.Is(err, services.ErrMeetupNotStarted), errors.Is(err, services.ErrAlreadyRated),
+		errors.Is(err, services.ErrRatingNotAllowed), errors.Is(err, services.ErrExchangeBookTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrDisputeReasonRequired), errors.Is(err, services.ErrInvalidOffer),
+		errors.Is(err, services.ErrInvalidBundle), errors.Is(err, services.ErrWrongHandoffCode),
+		errors.Is(err, services.ErrInvalidRating), errors.Is(err, services.ErrInvalidDisputeOutcome),
+		errors.Is(err, services.ErrOwnExchangeBook), errors.Is(err, services.ErrMixedBundleOwners),
+		errors.Is(err, services.ErrOfferedNotOwned), errors.Is(err, services.ErrNotForExchange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		fmt.Println("Error updating exchange:", err)
+		http.Error(w, "Failed to update exchange", http.StatusInternalServerError)
+	}
+}
+
+// UpdateExchangeStatusHandler accepts or declines an exchange request
+func (h *ExchangeHandler) UpdateExchangeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ExchangeID int    `json:"exchange_id"`
+		Status     string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	action := models.ExchangeActionAccept
+	switch req.Status {
+	case models.ExchangeStatusAccepted:
+	case models.ExchangeStatusDeclined:
+		action = models.ExchangeActionDecline
+	default:
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.Service.Act(userID, req.ExchangeID, action, models.ExchangeActionRequest{}); err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// CancelExchangeHandler cancels an exchange request
+func (h *ExchangeHandler) CancelExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ExchangeID int `json:"exchange_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.Service.Act(userID, req.ExchangeID, models.ExchangeActionCancel, models.ExchangeActionRequest{}); err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
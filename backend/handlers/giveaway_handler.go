@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/services"
+)
+
+type GiveawayHandler struct {
+	Service        *services.GiveawayService
+	Session        *services.SessionService
+	ProfileService *services.ProfileService
+}
+
+func NewGiveawayHandler(service *services.GiveawayService, session *services.SessionService, profileService *services.ProfileService) *GiveawayHandler {
+	return &GiveawayHandler{Service: service, Session: session, ProfileService: profileService}
+}
+
+// GiveawaysHandler lists the giveaway claims of the session user (GET), the queue of one
+// of their giveaway listings (GET ?book_id=), or claims a giveaway listing
+// (POST {"book_id", "message"})
+func (h *GiveawayHandler) GiveawaysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var claims []models.GiveawayClaim
+		var err error
+		if v := r.URL.Query().Get("book_id"); v != "" {
+			bookID, convErr := strconv.Atoi(v)
+			if convErr != nil || bookID <= 0 {
+				http.Error(w, "Invalid book ID", http.StatusBadRequest)
+				return
+			}
+			claims, err = h.Service.Queue(userID, bookID)
+		} else {
+			claims, err = h.Service.List(userID)
+		}
+		if err != nil {
+			writeGiveawayError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(claims)
+	case http.MethodPost:
+		if h.ProfileService != nil && h.ProfileService.IsBanned(userID) {
+			http.Error(w, "You are banned and cannot claim books", http.StatusForbidden)
+			return
+		}
+		var req models.GiveawayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BookID <= 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		claim, created, err := h.Service.Request(userID, req)
+		if err != nil {
+			writeGiveawayError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(claim)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GiveawayByIDHandler serves a single giveaway claim of the session user:
+//
+//	GET  /api/giveaways/{id}           the claim
+//	POST /api/giveaways/{id}/{action}  choose, decline, release, complete or cancel
+func (h *GiveawayHandler) GiveawayByIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/giveaways/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 || len(parts) > 2 {
+		http.Error(w, "Invalid claim ID", http.StatusBadRequest)
+		return
+	}
+
+	var claim models.GiveawayClaim
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claim, err = h.Service.Get(userID, id)
+	} else {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claim, err = h.Service.Act(userID, id, parts[1])
+	}
+	if err != nil {
+		writeGiveawayError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claim)
+}
+
+func writeGiveawayError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrClaimNotFound), errors.Is(err, services.ErrUnknownClaimAction),
+		errors.Is(err, services.ErrGiveawayBookMissing):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrNotClaimParty), errors.Is(err, services.ErrClaimForbidden),
+		errors.Is(err, services.ErrNotGiveawayOwner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrOwnGiveaway), errors.Is(err, services.ErrNotGiveaway):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrInvalidClaimChange), errors.Is(err, services.ErrClaimLimitReached),
+		errors.Is(err, services.ErrGiveawayUnavailable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		fmt.Println("Error updating giveaway claim:", err)
+		http.Error(w, "Failed to update giveaway claim", http.StatusInternalServerError)
+	}
+}
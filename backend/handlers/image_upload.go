@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ktabnet/utils"
+)
+
+// writeImageError replies to a failed upload with 413 for oversized files,
+// 400 for anything that is not a supported image and 500 otherwise
+func writeImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, utils.ErrImageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, utils.ErrUnsupportedImage):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		fmt.Println("Error saving image:", err)
+		http.Error(w, "Could not save image", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/services"
+)
+
+type LoanHandler struct {
+	Service        *services.LoanService
+	Session        *services.SessionService
+	ProfileService *services.ProfileService
+}
+
+func NewLoanHandler(service *services.LoanService, session *services.SessionService, profileService *services.ProfileService) *LoanHandler {
+	return &LoanHandler{Service: service, Session: session, ProfileService: profileService}
+}
+
+// LoansHandler lists the loans of the session user (GET) or asks to borrow a lend
+// listing (POST {"book_id", "start_date", "due_date", "message"})
+func (h *LoanHandler) LoansHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		loans, err := h.Service.List(userID)
+		if err != nil {
+			fmt.Println("Error fetching loans:", err)
+			http.Error(w, "Failed to fetch loans", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(loans)
+	case http.MethodPost:
+		if h.ProfileService != nil && h.ProfileService.IsBanned(userID) {
+			http.Error(w, "You are banned and cannot borrow books", http.StatusForbidden)
+			return
+		}
+		var req models.LoanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BookID <= 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		loan, created, err := h.Service.Request(userID, req)
+		if err != nil {
+			writeLoanError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(loan)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// LoanByIDHandler serves a single loan of the session user:
+//
+//	GET  /api/loans/{id}           the loan
+//	POST /api/loans/{id}/{action}  approve, decline, cancel or return
+func (h *LoanHandler) LoanByIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/loans/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 || len(parts) > 2 {
+		http.Error(w, "Invalid loan ID", http.StatusBadRequest)
+		return
+	}
+
+	var loan models.BookLoan
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		loan, err = h.Service.Get(userID, id)
+	} else {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		loan, err = h.Service.Act(userID, id, parts[1])
+	}
+	if err != nil {
+		writeLoanError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loan)
+}
+
+func writeLoanError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrLoanNotFound), errors.Is(err, services.ErrUnknownLoanAction),
+		errors.Is(err, services.ErrLoanBookNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrNotLoanParty), errors.Is(err, services.ErrLoanForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInvalidLoanDates), errors.Is(err, services.ErrOwnLoanBook),
+		errors.Is(err, services.ErrNotForLending):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrInvalidLoanChange), errors.Is(err, services.ErrLoanBookUnavailable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		fmt.Println("Error updating loan:", err)
+		http.Error(w, "Failed to update loan", http.StatusInternalServerError)
+	}
+}
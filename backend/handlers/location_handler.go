@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"ktabnet/services"
+)
+
+type LocationHandler struct {
+	Service *services.LocationService
+}
+
+func NewLocationHandler(service *services.LocationService) *LocationHandler {
+	return &LocationHandler{Service: service}
+}
+
+// CitiesHandler lists the cities books can be listed in, for pickers and near= filters
+func (h *LocationHandler) CitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cities, err := h.Service.GetCities()
+	if err != nil {
+		fmt.Println("Error fetching cities:", err)
+		http.Error(w, "Failed to fetch cities", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cities)
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/services"
+)
+
+type MeetupHandler struct {
+	Service *services.MeetupService
+	Session *services.SessionService
+}
+
+func NewMeetupHandler(service *services.MeetupService, session *services.SessionService) *MeetupHandler {
+	return &MeetupHandler{Service: service, Session: session}
+}
+
+// SpotsHandler lists the safe public meetup spots, of one city with ?city=
+func (h *MeetupHandler) SpotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	spots, err := h.Service.Spots(r.URL.Query().Get("city"))
+	if errors.Is(err, services.ErrUnknownCity) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error fetching meetup spots:", err)
+		http.Error(w, "Failed to fetch meetup spots", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spots)
+}
+
+// MeetupsHandler lists the upcoming meetups of the session user (GET) or proposes the
+// meetup of an accepted exchange (POST {"exchange_id", "spot_id", "starts_at", "note"})
+func (h *MeetupHandler) MeetupsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		meetups, err := h.Service.List(userID)
+		if err != nil {
+			fmt.Println("Error fetching meetups:", err)
+			http.Error(w, "Failed to fetch meetups", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meetups)
+	case http.MethodPost:
+		var req models.MeetupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExchangeID <= 0 || req.SpotID <= 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		meetup, err := h.Service.Propose(userID, req)
+		if err != nil {
+			writeMeetupError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(meetup)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MeetupByIDHandler serves a single meetup of the session user and their calendar feed:
+//
+//	GET  /api/meetups/{id}           the meetup
+//	GET  /api/meetups/{id}.ics       the meetup as a calendar file
+//	POST /api/meetups/{id}/{action}  accept, or reschedule with {"spot_id", "starts_at", "note"}
+//	GET  /api/meetups/feed           the URL of the personal calendar feed
+//	POST /api/meetups/feed           replace the feed URL, e.g. after sharing it by mistake
+func (h *MeetupHandler) MeetupByIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/meetups/"), "/"), "/")
+	if len(parts) == 1 && parts[0] == "feed" {
+		h.feedURL(w, r, userID)
+		return
+	}
+
+	ics := len(parts) == 1 && strings.HasSuffix(parts[0], ".ics")
+	id, err := strconv.Atoi(strings.TrimSuffix(parts[0], ".ics"))
+	if err != nil || id <= 0 || len(parts) > 2 {
+		http.Error(w, "Invalid meetup ID", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if ics {
+			calendar, err := h.Service.Calendar(userID, id)
+			if err != nil {
+				writeMeetupError(w, err)
+				return
+			}
+			writeCalendar(w, calendar, fmt.Sprintf("meetup-%d.ics", id))
+			return
+		}
+		meetup, err := h.Service.Get(userID, id)
+		if err != nil {
+			writeMeetupError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meetup)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req models.MeetupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	meetup, err := h.Service.Act(userID, id, parts[1], req)
+	if err != nil {
+		writeMeetupError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meetup)
+}
+
+func (h *MeetupHandler) feedURL(w http.ResponseWriter, r *http.Request, userID int) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, err := h.Service.FeedToken(userID, r.Method == http.MethodPost)
+	if err != nil {
+		fmt.Println("Error creating calendar feed:", err)
+		http.Error(w, "Failed to create calendar feed", http.StatusInternalServerError)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.CalendarFeed{URL: scheme + "://" + r.Host + "/api/calendar/" + token + ".ics"})
+}
+
+// CalendarFeedHandler serves the personal calendar feed of /api/calendar/{token}.ics.
+// Calendar apps cannot log in, so the secret token stands for the session.
+func (h *MeetupHandler) CalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/calendar/"), ".ics")
+	calendar, err := h.Service.Feed(token)
+	if errors.Is(err, services.ErrFeedNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error building calendar feed:", err)
+		http.Error(w, "Failed to build calendar feed", http.StatusInternalServerError)
+		return
+	}
+	writeCalendar(w, calendar, "meetups.ics")
+}
+
+func writeCalendar(w http.ResponseWriter, calendar, filename string) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, calendar)
+}
+
+// AdminSpotsHandler manages the curated meetup spots:
+//
+//	GET    /api/admin/meetup-spots       every spot, retired ones included
+//	POST   /api/admin/meetup-spots       add a spot
+//	PUT    /api/admin/meetup-spots/{id}  change a spot, "active" retires or restores it
+//	DELETE /api/admin/meetup-spots/{id}  retire a spot; planned meetups keep it
+func (h *MeetupHandler) AdminSpotsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/meetup-spots"), "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			spots, err := h.Service.AllSpots()
+			if err != nil {
+				writeMeetupError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(spots)
+		case http.MethodPost:
+			var req models.MeetupSpotRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			spot, err := h.Service.CreateSpot(req)
+			if err != nil {
+				writeMeetupError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(spot)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(path)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid spot ID", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var req models.MeetupSpotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		spot, err := h.Service.UpdateSpot(id, req)
+		if err != nil {
+			writeMeetupError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(spot)
+	case http.MethodDelete:
+		if err := h.Service.RetireSpot(id); err != nil {
+			writeMeetupError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeMeetupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrMeetupNotFound), errors.Is(err, services.ErrUnknownMeetupAction),
+		errors.Is(err, services.ErrExchangeNotFound), errors.Is(err, services.ErrSpotNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrNotMeetupParty), errors.Is(err, services.ErrNotExchangeParty),
+		errors.Is(err, services.ErrOwnMeetupProposal):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInvalidMeetupChange), errors.Is(err, services.ErrMeetupExists),
+		errors.Is(err, services.ErrDuplicateSpot):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrInvalidMeetupTime), errors.Is(err, services.ErrInvalidSpot):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		fmt.Println("Error updating meetup:", err)
+		http.Error(w, "Failed to update meetup", http.StatusInternalServerError)
+	}
+}
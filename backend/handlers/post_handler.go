@@ -4,25 +4,22 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"ktabnet/services"
-	"ktabnet/utils"
 )
 
 type PostHandler struct {
 	postService    *services.PostService
 	session        *services.SessionService
 	profileService *services.ProfileService
+	images         *services.ImageService
 }
 
-func NewPostHandler(postService *services.PostService, session *services.SessionService, profileService *services.ProfileService) *PostHandler {
-	return &PostHandler{postService: postService, session: session, profileService: profileService}
+func NewPostHandler(postService *services.PostService, session *services.SessionService, profileService *services.ProfileService, images *services.ImageService) *PostHandler {
+	return &PostHandler{postService: postService, session: session, profileService: profileService, images: images}
 }
 
 func (h *PostHandler) GetUserPostsHandler(w http.ResponseWriter, r *http.Request) {
@@ -96,23 +93,12 @@ func (h *PostHandler) CreatePostHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	var imageURL string
-	file, header, err := r.FormFile("image")
-	if err == nil && header != nil {
-		defer file.Close()
-		filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), header.Filename)
-		dst := utils.GetUploadPath(filename)
-		outFile, err := os.Create(dst)
+	if files := r.MultipartForm.File["image"]; len(files) > 0 {
+		imageURL, err = h.images.SaveUpload(files[0], "posts")
 		if err != nil {
-			http.Error(w, "Could not save image", http.StatusInternalServerError)
+			writeImageError(w, err)
 			return
 		}
-		defer outFile.Close()
-		_, err = io.Copy(outFile, file)
-		if err != nil {
-			http.Error(w, "Could not write image", http.StatusInternalServerError)
-			return
-		}
-		imageURL = "/uploads/" + filename
 	}
 
 	var recipientIDs []int
@@ -179,34 +165,13 @@ func (h *PostHandler) CreateCommentHandler(w http.ResponseWriter, r *http.Reques
 
 	content := r.FormValue("content")
 
-	file, fileHeader, err := r.FormFile("image")
 	var image string
-	if err == nil {
-		defer file.Close()
-
-		// Validate image format
-		if err := utils.IsValidImage(fileHeader); err != nil {
-			http.Error(w, "Format image non supporté", http.StatusBadRequest)
-			return
-		}
-
-		// Save image file (you can move this logic to a helper if you want)
-		filename := utils.GenerateFilename(fileHeader.Filename)
-		dstPath := utils.GetUploadPath(filename)
-		dst, err := os.Create(dstPath)
-		if err != nil {
-			http.Error(w, "Erreur serveur", http.StatusInternalServerError)
-			return
-		}
-		defer dst.Close()
-
-		_, err = io.Copy(dst, file)
+	if files := r.MultipartForm.File["image"]; len(files) > 0 {
+		image, err = h.images.SaveUpload(files[0], "posts")
 		if err != nil {
-			http.Error(w, "Erreur lors de l'enregistrement de l'image", http.StatusInternalServerError)
+			writeImageError(w, err)
 			return
 		}
-
-		image = "/uploads/" + filename
 	}
 
 	if content == "" && image == "" {
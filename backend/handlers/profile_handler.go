@@ -5,12 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"ktabnet/hub"
 	"ktabnet/models"
@@ -22,6 +19,8 @@ type ProfileHandler struct {
 	profileService *services.ProfileService
 	sessionService *services.SessionService
 	Hub            *hub.Hub
+	images         *services.ImageService
+	locations      *services.LocationService
 }
 
 type meResponse struct {
@@ -37,10 +36,22 @@ type meResponse struct {
 	IsBanned    bool   `json:"is_banned"`
 	About       string `json:"about"`
 	DateOfBirth string `json:"date_of_birth"`
+	// Approximate location, only ever shown to the user themselves
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
 }
 
-func NewProfileHandler(service *services.ProfileService, sessionService *services.SessionService, hub *hub.Hub) *ProfileHandler {
-	return &ProfileHandler{profileService: service, sessionService: sessionService, Hub: hub}
+func NewProfileHandler(service *services.ProfileService, sessionService *services.SessionService, hub *hub.Hub, images *services.ImageService, locations *services.LocationService) *ProfileHandler {
+	return &ProfileHandler{profileService: service, sessionService: sessionService, Hub: hub, images: images, locations: locations}
+}
+
+// addLocation fills the stored profile location into a meResponse
+func (h *ProfileHandler) addLocation(resp *meResponse) {
+	point, err := h.locations.GetUserLocation(resp.ID)
+	if err != nil || point == nil {
+		return
+	}
+	resp.Latitude, resp.Longitude = &point.Latitude, &point.Longitude
 }
 
 func (h *ProfileHandler) ProfileHandler(w http.ResponseWriter, r *http.Request) {
@@ -77,6 +88,10 @@ func (h *ProfileHandler) ProfileHandler(w http.ResponseWriter, r *http.Request)
 
 func (h *ProfileHandler) GetUserByIDHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if strings.HasSuffix(idStr, "/ratings") {
+		h.ratings(w, r, strings.TrimSuffix(idStr, "/ratings"))
+		return
+	}
 	targetID := 0
 	if idStr == "me" {
 		requesterID, ok := h.sessionService.GetUserIDFromSession(w, r)
@@ -117,6 +132,31 @@ func (h *ProfileHandler) GetUserByIDHandler(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(user)
 }
 
+// ratings lists the exchange ratings a user received, newest first
+func (h *ProfileHandler) ratings(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	targetID, err := strconv.Atoi(idStr)
+	if err != nil || targetID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	ratings, err := h.profileService.ReceivedRatings(targetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error fetching ratings:", err)
+		http.Error(w, "Failed to fetch ratings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(ratings)
+}
+
 func (h *ProfileHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("query")
 	if query == "" {
@@ -190,6 +230,7 @@ func (h *ProfileHandler) GetMe(w http.ResponseWriter, r *http.Request) {
 		About:       user.About,
 		DateOfBirth: user.DateOfBirth,
 	}
+	h.addLocation(&resp)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
@@ -232,22 +273,27 @@ func (h *ProfileHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		DateOfBirth: r.FormValue("date_of_birth"),
 	}
 
-	if file, header, err := r.FormFile("avatar"); err == nil {
-		defer file.Close()
-		filename := fmt.Sprintf("%d_%d_%s", userID, time.Now().UnixNano(), header.Filename)
-		avatarPath := utils.GetUploadPath("avatars/" + filename)
-		out, err := os.Create(avatarPath)
-		if err != nil {
-			http.Error(w, "Unable to save avatar", http.StatusInternalServerError)
+	// The location is only changed when the form sends it; empty values clear it
+	_, hasLat := r.MultipartForm.Value["latitude"]
+	_, hasLng := r.MultipartForm.Value["longitude"]
+	if hasLat || hasLng {
+		if err := h.locations.SetUserLocation(userID, r.FormValue("latitude"), r.FormValue("longitude")); err != nil {
+			if errors.Is(err, services.ErrInvalidLocation) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to update location", http.StatusInternalServerError)
 			return
 		}
-		defer out.Close()
+	}
 
-		if _, err := io.Copy(out, file); err != nil {
-			http.Error(w, "Failed to write avatar", http.StatusInternalServerError)
+	if files := r.MultipartForm.File["avatar"]; len(files) > 0 {
+		avatar, err := h.images.SaveUpload(files[0], "avatars")
+		if err != nil {
+			writeImageError(w, err)
 			return
 		}
-		req.Avatar = "/uploads/avatars/" + filename
+		req.Avatar = avatar
 	}
 
 	if err := h.profileService.UpdateProfile(userID, req); err != nil {
@@ -255,15 +301,10 @@ func (h *ProfileHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Avatar != "" && oldUser.Avatar != "" && oldUser.Avatar != req.Avatar {
-		oldPath := ""
-		if strings.HasPrefix(oldUser.Avatar, "/uploads/") {
-			oldPath = strings.TrimPrefix(oldUser.Avatar, "/uploads/")
-		} else if idx := strings.Index(oldUser.Avatar, "/uploads/"); idx >= 0 {
-			oldPath = oldUser.Avatar[idx+len("/uploads/"):]
-		}
-		if strings.HasPrefix(oldPath, "avatars/") {
-			_ = os.Remove(utils.GetUploadPath(oldPath))
+	if req.Avatar != "" && oldUser.Avatar != "" && oldUser.Avatar != req.Avatar &&
+		strings.Contains(oldUser.Avatar, "/uploads/avatars/") {
+		if inUse, err := h.profileService.ProfileRepo.AvatarInUse(oldUser.Avatar); err == nil && !inUse {
+			_ = h.images.Remove(oldUser.Avatar)
 		}
 	}
 
@@ -288,6 +329,7 @@ func (h *ProfileHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		About:       updatedUser.About,
 		DateOfBirth: updatedUser.DateOfBirth,
 	}
+	h.addLocation(&resp)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
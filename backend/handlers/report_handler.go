@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
@@ -225,6 +226,10 @@ func (h *ReportHandler) DeleteBookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	if err := h.Service.DeleteBook(id); err != nil {
+		if errors.Is(err, services.ErrBookNotDeletable) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to delete book", http.StatusInternalServerError)
 		return
 	}
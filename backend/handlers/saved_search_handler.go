@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+	"ktabnet/services"
+)
+
+type SavedSearchHandler struct {
+	Service *services.SavedSearchService
+	Session *services.SessionService
+}
+
+func NewSavedSearchHandler(service *services.SavedSearchService, session *services.SessionService) *SavedSearchHandler {
+	return &SavedSearchHandler{Service: service, Session: session}
+}
+
+// SavedSearchesHandler serves the session user's saved searches:
+//
+//	GET    /api/saved-searches                list searches
+//	POST   /api/saved-searches                save a search
+//	GET    /api/saved-searches/{id}           get a search
+//	PUT    /api/saved-searches/{id}           replace a search
+//	DELETE /api/saved-searches/{id}           delete a search
+//	GET    /api/saved-searches/{id}/results   run a search (cursor, limit)
+func (h *SavedSearchHandler) SavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/saved-searches"), "/"), "/")
+	if parts[0] == "" {
+		switch r.Method {
+		case http.MethodGet:
+			searches, err := h.Service.List(userID)
+			if err != nil {
+				http.Error(w, "Failed to fetch saved searches", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(searches)
+		case http.MethodPost:
+			var req models.SavedSearchRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			search, err := h.Service.Create(userID, req)
+			if err != nil {
+				writeSavedSearchError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(search)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "results" && r.Method == http.MethodGet {
+		h.results(w, r, userID, id)
+		return
+	}
+	if len(parts) > 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		search, err := h.Service.Get(userID, id)
+		if err != nil {
+			writeSavedSearchError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(search)
+	case http.MethodPut:
+		var req models.SavedSearchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		search, err := h.Service.Update(userID, id, req)
+		if err != nil {
+			writeSavedSearchError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(search)
+	case http.MethodDelete:
+		if err := h.Service.Delete(userID, id); err != nil {
+			writeSavedSearchError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *SavedSearchHandler) results(w http.ResponseWriter, r *http.Request, userID, id int) {
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.Service.Results(userID, id, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, repositories.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSavedSearchError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func writeSavedSearchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrSavedSearchNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidSavedSearch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		fmt.Println("Error updating saved search:", err)
+		http.Error(w, "Failed to update saved search", http.StatusInternalServerError)
+	}
+}
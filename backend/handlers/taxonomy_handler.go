@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/services"
+)
+
+type TaxonomyHandler struct {
+	Service *services.TaxonomyService
+}
+
+func NewTaxonomyHandler(service *services.TaxonomyService) *TaxonomyHandler {
+	return &TaxonomyHandler{Service: service}
+}
+
+// GetTaxonomyHandler lists every genre, condition and city with their ar/fr/en labels
+func (h *TaxonomyHandler) GetTaxonomyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taxonomy, err := h.Service.GetTaxonomy()
+	if err != nil {
+		fmt.Println("Error fetching taxonomy:", err)
+		http.Error(w, "Failed to fetch taxonomy", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(taxonomy)
+}
+
+// AdminTaxonomyHandler manages the reference tables; {kind} is genres, conditions, cities, languages or formats:
+//
+//	GET    /api/admin/taxonomy              everything, as GET /api/taxonomy
+//	GET    /api/admin/taxonomy/{kind}       list terms
+//	POST   /api/admin/taxonomy/{kind}       add a term
+//	GET    /api/admin/taxonomy/{kind}/{id}  get a term
+//	PUT    /api/admin/taxonomy/{kind}/{id}  change labels, order and city coordinates
+//	DELETE /api/admin/taxonomy/{kind}/{id}  remove a term no listing uses
+func (h *TaxonomyHandler) AdminTaxonomyHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/taxonomy"), "/")
+	if path == "" {
+		h.GetTaxonomyHandler(w, r)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	kind := parts[0]
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			terms, err := h.Service.List(kind)
+			if err != nil {
+				writeTaxonomyError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(terms)
+		case http.MethodPost:
+			var req models.TaxonomyTermRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			term, err := h.Service.Create(kind, req)
+			if err != nil {
+				writeTaxonomyError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(term)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		http.Error(w, "Invalid term ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		term, err := h.Service.Get(kind, id)
+		if err != nil {
+			writeTaxonomyError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(term)
+	case http.MethodPut:
+		var req models.TaxonomyTermRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		term, err := h.Service.Update(kind, id, req)
+		if err != nil {
+			writeTaxonomyError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(term)
+	case http.MethodDelete:
+		if err := h.Service.Delete(kind, id); err != nil {
+			writeTaxonomyError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeTaxonomyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownTaxonomyKind), errors.Is(err, services.ErrTermNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidTerm):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrDuplicateTerm), errors.Is(err, services.ErrTermInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		fmt.Println("Error updating taxonomy:", err)
+		http.Error(w, "Failed to update taxonomy", http.StatusInternalServerError)
+	}
+}
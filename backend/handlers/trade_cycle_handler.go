@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/services"
+)
+
+type TradeCycleHandler struct {
+	Service *services.TradeCycleService
+	Session *services.SessionService
+}
+
+func NewTradeCycleHandler(service *services.TradeCycleService, session *services.SessionService) *TradeCycleHandler {
+	return &TradeCycleHandler{Service: service, Session: session}
+}
+
+// TradeCyclesHandler lists the trade cycles the session user takes part in
+func (h *TradeCycleHandler) TradeCyclesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cycles, err := h.Service.List(userID)
+	if err != nil {
+		fmt.Println("Error fetching trade cycles:", err)
+		http.Error(w, "Failed to fetch trade cycles", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cycles)
+}
+
+// TradeCycleByIDHandler serves a single trade cycle of the session user:
+//
+//	GET  /api/trade-cycles/{id}           the cycle with its legs
+//	POST /api/trade-cycles/{id}/{action}  accept, decline or confirm
+func (h *TradeCycleHandler) TradeCycleByIDHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/trade-cycles/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 || len(parts) > 2 {
+		http.Error(w, "Invalid trade cycle ID", http.StatusBadRequest)
+		return
+	}
+
+	var cycle models.TradeCycle
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cycle, err = h.Service.Get(userID, id)
+	} else {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cycle, err = h.Service.Act(userID, id, parts[1])
+	}
+	if err != nil {
+		writeTradeCycleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cycle)
+}
+
+func writeTradeCycleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrTradeCycleNotFound), errors.Is(err, services.ErrUnknownCycleAction):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrNotCycleParticipant):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInvalidCycleChange), errors.Is(err, services.ErrCycleUnavailable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		fmt.Println("Error updating trade cycle:", err)
+		http.Error(w, "Failed to update trade cycle", http.StatusInternalServerError)
+	}
+}
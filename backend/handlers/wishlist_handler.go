@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/services"
+	"ktabnet/utils"
+)
+
+type WishlistHandler struct {
+	Service *services.WishlistService
+	Session *services.SessionService
+}
+
+func NewWishlistHandler(service *services.WishlistService, session *services.SessionService) *WishlistHandler {
+	return &WishlistHandler{Service: service, Session: session}
+}
+
+// WishlistHandler serves the session user's wishlist:
+//
+//	GET    /api/wishlist       list wishes
+//	POST   /api/wishlist       add a wish
+//	GET    /api/wishlist/{id}  get a wish
+//	PUT    /api/wishlist/{id}  replace a wish
+//	DELETE /api/wishlist/{id}  remove a wish
+func (h *WishlistHandler) WishlistHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.Session.GetUserIDFromSession(w, r)
+	if !ok {
+		return
+	}
+
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/wishlist"), "/")
+	if idStr == "" {
+		switch r.Method {
+		case http.MethodGet:
+			wishes, err := h.Service.List(userID)
+			if err != nil {
+				http.Error(w, "Failed to fetch wishlist", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(wishes)
+		case http.MethodPost:
+			var req models.WishlistRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			wish, err := h.Service.Create(userID, req)
+			if err != nil {
+				writeWishlistError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(wish)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid wishlist ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		wish, err := h.Service.Get(userID, id)
+		if err != nil {
+			writeWishlistError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wish)
+	case http.MethodPut:
+		var req models.WishlistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		wish, err := h.Service.Update(userID, id, req)
+		if err != nil {
+			writeWishlistError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wish)
+	case http.MethodDelete:
+		if err := h.Service.Delete(userID, id); err != nil {
+			writeWishlistError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeWishlistError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrWishlistNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidWish):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, utils.ErrInvalidISBN):
+		http.Error(w, "Invalid ISBN: expected a valid ISBN-10 or ISBN-13", http.StatusBadRequest)
+	default:
+		fmt.Println("Error updating wishlist:", err)
+		http.Error(w, "Failed to update wishlist", http.StatusInternalServerError)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	hubS "ktabnet/hub"
 	"ktabnet/repositories"
 	"ktabnet/services"
+	"ktabnet/storage"
 	"ktabnet/utils"
 )
 
@@ -21,6 +22,7 @@ func main() {
 		utils.GetUploadPath("avatars"),
 		utils.GetUploadPath("group_posts"),
 		utils.GetUploadPath("books"),
+		utils.GetUploadPath("posts"),
 	}
 
 	fmt.Println("📂 Data directory:", utils.DataDir)
@@ -40,6 +42,18 @@ func main() {
 	profileRepo := repositories.NewProfileRepository(db)
 	sessionRepo := repositories.NewSessionRepo(db)
 	bookRepo := repositories.NewBookRepository(db)
+	wishlistRepo := repositories.NewWishlistRepository(db)
+	savedSearchRepo := repositories.NewSavedSearchRepository(db)
+	catalogRepo := repositories.NewCatalogRepository(db)
+	locationRepo := repositories.NewLocationRepository(db)
+	taxonomyRepo := repositories.NewTaxonomyRepository(db)
+	exchangeRepo := repositories.NewExchangeRepository(db)
+	loanRepo := repositories.NewLoanRepository(db)
+	giveawayRepo := repositories.NewGiveawayRepository(db)
+	tradeCycleRepo := repositories.NewTradeCycleRepository(db)
+	meetupRepo := repositories.NewMeetupRepository(db)
+	ratingRepo := repositories.NewRatingRepository(db)
+	exchangeRuleRepo := repositories.NewExchangeRuleRepository(db)
 
 	authService := services.NewService(*authRepo)
 	sessionService := services.NewSessionService(sessionRepo)
@@ -49,24 +63,75 @@ func main() {
 	followService := services.NewFollowService(followRepo, notifRepo)
 	notifService := services.NewNotificationService(notifRepo)
 	profileService := services.NewProfileService(*profileRepo)
+	profileService.Ratings = ratingRepo
 
 	postService := services.NewPostService(postRepo)
-	bookService := services.NewBookService(bookRepo)
+	blobStore, err := storage.NewFromConfig()
+	if err != nil {
+		fmt.Printf("❌ Failed to set up upload storage: %v\n", err)
+		return
+	}
+	imageService := services.NewImageService(blobStore, utils.GetMaxUploadBytes())
+	locationService := services.NewLocationService(locationRepo)
+	taxonomyService := services.NewTaxonomyService(taxonomyRepo, locationService)
+	bookService := services.NewBookService(bookRepo, imageService, locationService, taxonomyService)
+
+	catalogProvider := services.NewLocalCatalogProvider(catalogRepo)
+	if catalogFile := utils.GetCatalogFile(); catalogFile != "" {
+		if n, err := catalogProvider.ImportJSON(catalogFile); err != nil {
+			fmt.Printf("❌ Failed to import book catalog '%s': %v\n", catalogFile, err)
+		} else {
+			fmt.Printf("📚 Imported %d catalog entries\n", n)
+		}
+	}
+	var remoteProvider services.BookMetadataProvider
+	if metadataURL := utils.GetMetadataProviderURL(); metadataURL != "" {
+		remoteProvider = services.NewHTTPMetadataProvider(metadataURL)
+	}
+	metadataService := services.NewBookMetadataService(catalogProvider, remoteProvider)
 
 	hub := hubS.NewHub(chatService)
 	hub.SetProfileService(profileService)
 	go hub.Run()
 
+	wishlistService := services.NewWishlistService(wishlistRepo, notifService, hub)
+	bookService.Wishlists = wishlistService
+	savedSearchService := services.NewSavedSearchService(savedSearchRepo, bookService, notifService, hub)
+	go savedSearchService.Run(utils.GetSavedSearchInterval())
+	exchangeService := services.NewExchangeService(exchangeRepo, notifService, hub)
+	exchangeService.RequestTTL = utils.GetExchangeRequestTTL()
+	exchangeService.RatingRepo = ratingRepo
+	exchangeRuleService := services.NewExchangeRuleService(exchangeRuleRepo, taxonomyService)
+	exchangeService.Rules = exchangeRuleService
+	bookService.Exchanges = exchangeService
+	go exchangeService.Run(utils.GetExchangeCleanupInterval())
+	loanService := services.NewLoanService(loanRepo, notifService, hub)
+	go loanService.Run(utils.GetLoanCheckInterval())
+	giveawayService := services.NewGiveawayService(giveawayRepo, bookRepo, notifService, hub, utils.GetGiveawayMonthlyClaims())
+	tradeCycleService := services.NewTradeCycleService(tradeCycleRepo, notifService, hub)
+	go tradeCycleService.Run(utils.GetTradeCycleInterval())
+	meetupService := services.NewMeetupService(meetupRepo, exchangeRepo, locationService, notifService, hub)
+
 	// 5. Initialize Handlers
-	authHandler := handlers.NewHandler(authService, sessionService, hub)
+	authHandler := handlers.NewHandler(authService, sessionService, hub, imageService)
 	chatHandler := handlers.NewChatHandler(chatService, sessionService)
 	followHandler := handlers.NewFollowHandler(followService, sessionService, hub)
 	hubHandler := hubS.NewHandler(authService, sessionService, hub)
 	notifHandler := handlers.NewNotificationHandler(notifService, sessionService)
-	postHandler := handlers.NewPostHandler(postService, sessionService, profileService)
-	profileHandler := handlers.NewProfileHandler(profileService, sessionService, hub)
-	bookHandler := handlers.NewBookHandler(bookService, sessionService, notifService, hub, profileService)
+	postHandler := handlers.NewPostHandler(postService, sessionService, profileService, imageService)
+	profileHandler := handlers.NewProfileHandler(profileService, sessionService, hub, imageService, locationService)
+	bookHandler := handlers.NewBookHandler(bookService, sessionService, profileService, metadataService)
+	bookHandler.Rules = exchangeRuleService
+	wishlistHandler := handlers.NewWishlistHandler(wishlistService, sessionService)
+	savedSearchHandler := handlers.NewSavedSearchHandler(savedSearchService, sessionService)
 	adminHandler := handlers.NewAdminHandler(profileService, sessionService, bookService)
+	locationHandler := handlers.NewLocationHandler(locationService)
+	taxonomyHandler := handlers.NewTaxonomyHandler(taxonomyService)
+	exchangeHandler := handlers.NewExchangeHandler(exchangeService, sessionService, profileService)
+	loanHandler := handlers.NewLoanHandler(loanService, sessionService, profileService)
+	giveawayHandler := handlers.NewGiveawayHandler(giveawayService, sessionService, profileService)
+	tradeCycleHandler := handlers.NewTradeCycleHandler(tradeCycleService, sessionService)
+	meetupHandler := handlers.NewMeetupHandler(meetupService, sessionService)
 
 	// 6. Setup Router
 	mux := http.NewServeMux()
@@ -116,17 +181,43 @@ func main() {
 	// Book routes
 	mux.Handle("/api/books", sessionService.Middleware(http.HandlerFunc(bookHandler.BooksHandler)))
 	mux.Handle("/api/books/search", sessionService.Middleware(http.HandlerFunc(bookHandler.SearchBooksHandler)))
-	mux.Handle("/api/books/", sessionService.Middleware(http.HandlerFunc(bookHandler.GetBookHandler)))
-	mux.Handle("/api/books/exchange", sessionService.Middleware(http.HandlerFunc(bookHandler.ExchangeBookHandler)))
+	mux.Handle("/api/books/lookup", sessionService.Middleware(http.HandlerFunc(bookHandler.LookupBookHandler)))
+	mux.Handle("/api/books/", sessionService.Middleware(http.HandlerFunc(bookHandler.BookByIDHandler)))
+	mux.Handle("/api/books/exchange", sessionService.Middleware(http.HandlerFunc(exchangeHandler.ExchangeBookHandler)))
+	mux.Handle("/api/wishlist", sessionService.Middleware(http.HandlerFunc(wishlistHandler.WishlistHandler)))
+	mux.Handle("/api/wishlist/", sessionService.Middleware(http.HandlerFunc(wishlistHandler.WishlistHandler)))
+	mux.Handle("/api/saved-searches", sessionService.Middleware(http.HandlerFunc(savedSearchHandler.SavedSearchesHandler)))
+	mux.Handle("/api/saved-searches/", sessionService.Middleware(http.HandlerFunc(savedSearchHandler.SavedSearchesHandler)))
+	mux.HandleFunc("/api/cities", locationHandler.CitiesHandler)
+	mux.HandleFunc("/api/taxonomy", taxonomyHandler.GetTaxonomyHandler)
+	mux.Handle("/api/works/", sessionService.Middleware(http.HandlerFunc(bookHandler.GetWorkHandler)))
 	mux.Handle("/api/my-books", sessionService.Middleware(http.HandlerFunc(bookHandler.GetMyBooksHandler)))
-	mux.Handle("/api/exchange-requests", sessionService.Middleware(http.HandlerFunc(bookHandler.GetExchangeRequestsHandler)))
-	mux.Handle("/api/exchange-requests/update", sessionService.Middleware(http.HandlerFunc(bookHandler.UpdateExchangeStatusHandler)))
-	mux.Handle("/api/exchange-requests/cancel", sessionService.Middleware(http.HandlerFunc(bookHandler.CancelExchangeHandler)))
+	mux.Handle("/api/exchange-rules", sessionService.Middleware(http.HandlerFunc(bookHandler.ExchangeRulesHandler)))
+	mux.Handle("/api/exchange-requests", sessionService.Middleware(http.HandlerFunc(exchangeHandler.GetExchangeRequestsHandler)))
+	mux.Handle("/api/exchange-requests/update", sessionService.Middleware(http.HandlerFunc(exchangeHandler.UpdateExchangeStatusHandler)))
+	mux.Handle("/api/exchange-requests/cancel", sessionService.Middleware(http.HandlerFunc(exchangeHandler.CancelExchangeHandler)))
+	mux.Handle("/api/exchange-requests/", sessionService.Middleware(http.HandlerFunc(exchangeHandler.ExchangeByIDHandler)))
+	mux.Handle("/api/loans", sessionService.Middleware(http.HandlerFunc(loanHandler.LoansHandler)))
+	mux.Handle("/api/loans/", sessionService.Middleware(http.HandlerFunc(loanHandler.LoanByIDHandler)))
+	mux.Handle("/api/giveaways", sessionService.Middleware(http.HandlerFunc(giveawayHandler.GiveawaysHandler)))
+	mux.Handle("/api/giveaways/", sessionService.Middleware(http.HandlerFunc(giveawayHandler.GiveawayByIDHandler)))
+	mux.Handle("/api/trade-cycles", sessionService.Middleware(http.HandlerFunc(tradeCycleHandler.TradeCyclesHandler)))
+	mux.Handle("/api/trade-cycles/", sessionService.Middleware(http.HandlerFunc(tradeCycleHandler.TradeCycleByIDHandler)))
+	mux.Handle("/api/meetups", sessionService.Middleware(http.HandlerFunc(meetupHandler.MeetupsHandler)))
+	mux.Handle("/api/meetups/", sessionService.Middleware(http.HandlerFunc(meetupHandler.MeetupByIDHandler)))
+	mux.HandleFunc("/api/meetup-spots", meetupHandler.SpotsHandler)
+	mux.HandleFunc("/api/calendar/", meetupHandler.CalendarFeedHandler)
 
 	// Admin routes (protected by AdminOnly middleware)
 	mux.Handle("/api/admin/users", sessionService.Middleware(adminHandler.AdminOnly(adminHandler.GetAllUsers)))
 	mux.Handle("/api/admin/users/", sessionService.Middleware(adminHandler.AdminOnlyStrict(adminHandler.UserHandler)))
 	mux.Handle("/api/admin/books/", sessionService.Middleware(adminHandler.AdminOnly(adminHandler.DeleteBook)))
+	mux.Handle("/api/admin/taxonomy", sessionService.Middleware(adminHandler.AdminOnlyStrict(taxonomyHandler.AdminTaxonomyHandler)))
+	mux.Handle("/api/admin/taxonomy/", sessionService.Middleware(adminHandler.AdminOnlyStrict(taxonomyHandler.AdminTaxonomyHandler)))
+	mux.Handle("/api/admin/meetup-spots", sessionService.Middleware(adminHandler.AdminOnlyStrict(meetupHandler.AdminSpotsHandler)))
+	mux.Handle("/api/admin/meetup-spots/", sessionService.Middleware(adminHandler.AdminOnlyStrict(meetupHandler.AdminSpotsHandler)))
+	mux.Handle("/api/admin/exchanges", sessionService.Middleware(adminHandler.AdminOnly(exchangeHandler.AdminDisputesHandler)))
+	mux.Handle("/api/admin/exchanges/", sessionService.Middleware(adminHandler.AdminOnly(exchangeHandler.AdminDisputesHandler)))
 
 	// Group routes
 
@@ -136,9 +227,10 @@ func main() {
 		hubHandler.ServeWS(hub, w, r)
 	})
 
-	// Static files route
-	uploadsDir := utils.GetUploadPath("")
-	mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir(uploadsDir))))
+	// Static files route, only needed when uploads live on the local disk
+	if local, ok := blobStore.(*storage.LocalStore); ok {
+		mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir(local.Dir))))
+	}
 
 	// 7. Setup Middleware
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -10,41 +10,197 @@ type Book struct {
 	Description string    `json:"description"`
 	Genre       string    `json:"genre"`
 	Condition   string    `json:"condition"`
-	City        string    `json:"city"`
-	Available   bool      `json:"available"`
-	Images      []string  `json:"images"`
-	CreatedAt   string    `json:"created_at"`
-	UpdatedAt   string    `json:"updated_at"`
+	// Bibliographic details; empty and zero values are unknown. Language is an ISO 639
+	// code from the languages taxonomy and Format a name from the formats taxonomy.
+	Language        string `json:"language"`
+	Format          string `json:"format"`
+	Publisher       string `json:"publisher"`
+	PublicationYear int    `json:"publication_year"`
+	PageCount       int    `json:"page_count"`
+	Series          string `json:"series"`
+	SeriesVolume    int    `json:"series_volume"` // number within Series
+	City            string `json:"city"`
+	Available       bool   `json:"available"` // status is listed
+	Status          string `json:"status"`
+	ListingType     string `json:"listing_type"`
+	// GiveawayPick is how a giveaway listing picks its recipient, empty for other listings
+	GiveawayPick string   `json:"giveaway_pick,omitempty"`
+	WorkID       int      `json:"work_id"`
+	Images       []string `json:"images"`
+	CreatedAt    string   `json:"created_at"`
+	UpdatedAt    string   `json:"updated_at"`
 }
 type BookOwner struct {
-	ID             int    `json:"id"`
-	FirstName      string `json:"first_name"`
-	LastName       string `json:"last_name"`
-	Avatar         string `json:"avatar"`
-	City           string `json:"city"`
-	BooksListed    int    `json:"booksListed"`
-	BooksExchanged int    `json:"booksExchanged"`
+	ID             int        `json:"id"`
+	FirstName      string     `json:"first_name"`
+	LastName       string     `json:"last_name"`
+	Avatar         string     `json:"avatar"`
+	City           string     `json:"city"`
+	BooksListed    int        `json:"booksListed"`
+	BooksExchanged int        `json:"booksExchanged"` // completed exchanges (confirmed, handoff-verified or resolved by a moderator) and trade cycles
+	Reputation     Reputation `json:"reputation"`
 }
 
 type BookWithOwner struct {
-	ID             int      `json:"id"`
-	OwnerID        int      `json:"owner_id"`
-	Title          string   `json:"title"`
-	Author         string   `json:"author"`
-	ISBN           string   `json:"isbn"`
-	Description    string   `json:"description"`
-	Genre          string   `json:"genre"`
-	Condition      string   `json:"condition"`
-	City           string   `json:"city"`
-	Available      bool     `json:"available"`
-	Images         []string `json:"images"`
-	CreatedAt      string   `json:"created_at"`
-	UpdatedAt      string   `json:"updated_at"`
-	OwnerName      string   `json:"owner_name"`
-	OwnerFirstName string   `json:"owner_first_name"`
-	OwnerLastName  string   `json:"owner_last_name"`
-	OwnerAvatar    string   `json:"owner_avatar"`
-	OwnerCity      string   `json:"owner_city"`
+	ID              int      `json:"id"`
+	OwnerID         int      `json:"owner_id"`
+	Title           string   `json:"title"`
+	Author          string   `json:"author"`
+	ISBN            string   `json:"isbn"`
+	Description     string   `json:"description"`
+	Genre           string   `json:"genre"`
+	Condition       string   `json:"condition"`
+	Language        string   `json:"language"`
+	Format          string   `json:"format"`
+	Publisher       string   `json:"publisher"`
+	PublicationYear int      `json:"publication_year"`
+	PageCount       int      `json:"page_count"`
+	Series          string   `json:"series"`
+	SeriesVolume    int      `json:"series_volume"`
+	City            string   `json:"city"`
+	Available       bool     `json:"available"`
+	Status          string   `json:"status"`
+	ListingType     string   `json:"listing_type"`
+	GiveawayPick    string   `json:"giveaway_pick,omitempty"`
+	WorkID          int      `json:"work_id"`
+	Images          []string `json:"images"`
+	CreatedAt       string   `json:"created_at"`
+	UpdatedAt       string   `json:"updated_at"`
+	OwnerName       string   `json:"owner_name"`
+	OwnerFirstName  string   `json:"owner_first_name"`
+	OwnerLastName   string   `json:"owner_last_name"`
+	OwnerAvatar     string   `json:"owner_avatar"`
+	OwnerCity       string   `json:"owner_city"`
+	// DistanceKm is the distance from the requested origin, set when the feed has one
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+	// Owner details with their reputation, set in the feed
+	Owner *BookOwner `json:"owner,omitempty"`
+}
+
+// Listing types: what the owner offers a book for. Exchange listings take part in
+// exchange requests, lend listings in borrow requests and giveaway listings in
+// giveaway claims, which need no book in return.
+const (
+	ListingTypeExchange = "exchange"
+	ListingTypeLend     = "lend"
+	ListingTypeGiveaway = "giveaway"
+)
+
+// Giveaway picks: the owner chooses the recipient from the queue, or the first eligible
+// requester gets the book
+const (
+	GiveawayPickManual = "manual"
+	GiveawayPickFirst  = "first"
+)
+
+// Book statuses. Only listed books show in the feed and search and can take part
+// in a new exchange request.
+const (
+	BookStatusListed     = "listed"
+	BookStatusReserved   = "reserved"    // an exchange for it was accepted
+	BookStatusInExchange = "in_exchange" // a meetup for its exchange is scheduled
+	BookStatusLentOut    = "lent_out"
+	BookStatusExchanged  = "exchanged"
+	BookStatusArchived   = "archived" // hidden by its owner without deleting it
+)
+
+// BookStatusChange is one entry of a book's status history; ChangedBy 0 is the system
+type BookStatusChange struct {
+	ID            int    `json:"id"`
+	BookID        int    `json:"book_id"`
+	FromStatus    string `json:"from_status"`
+	ToStatus      string `json:"to_status"`
+	ChangedBy     int    `json:"changed_by"`
+	ChangedByName string `json:"changed_by_name"`
+	Reason        string `json:"reason"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// BookStatusRequest is the body of PUT /api/books/{id}/status
+type BookStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// UpdateBookRequest is the body of PATCH /api/books/{id}; nil fields are left unchanged.
+// Available false archives a listed book and true lists it again.
+type UpdateBookRequest struct {
+	Title           *string `json:"title"`
+	Author          *string `json:"author"`
+	ISBN            *string `json:"isbn"`
+	Description     *string `json:"description"`
+	Genre           *string `json:"genre"`
+	Condition       *string `json:"condition"`
+	Language        *string `json:"language"`
+	Format          *string `json:"format"`
+	Publisher       *string `json:"publisher"`
+	PublicationYear *int    `json:"publication_year"`
+	PageCount       *int    `json:"page_count"`
+	Series          *string `json:"series"`
+	SeriesVolume    *int    `json:"series_volume"`
+	City            *string `json:"city"`
+	ListingType     *string `json:"listing_type"`
+	GiveawayPick    *string `json:"giveaway_pick"`
+	Available       *bool   `json:"available"`
+}
+
+// ReorderImagesRequest is the body of PUT /api/books/{id}/images/order
+type ReorderImagesRequest struct {
+	ImageIDs []int `json:"image_ids"`
+}
+
+// Book feed sort orders
+const (
+	BookSortNewest  = "newest"
+	BookSortOldest  = "oldest"
+	BookSortTitle   = "title"
+	BookSortNearest = "nearest"
+)
+
+// BookFeedFilter holds the filters, sort order and cursor accepted by the book feed
+type BookFeedFilter struct {
+	Genre        string
+	Condition    string
+	MinCondition string
+	City         string
+	Author       string
+	ListingType  string
+	Since        string // normalized to "YYYY-MM-DD HH:MM:SS" (UTC)
+	Sort         string
+	Cursor       string
+	Limit        int
+
+	// OwnerID, when set, keeps only that owner's listings, the viewer's own included
+	OwnerID int
+
+	LocationFilter
+
+	// MinRating drops books whose owner has no rating or a lower average rating
+	MinRating float64
+
+	BookDetailsFilter
+
+	// Bounds on listing IDs, set internally by saved search digests
+	AfterID int
+	UpToID  int
+}
+
+// BookFeedPage is one page of the book feed; NextCursor is empty on the last page
+type BookFeedPage struct {
+	Books      []BookWithOwner `json:"books"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// Work groups every listing of the same book across editions and owners
+type Work struct {
+	ID              int             `json:"id"`
+	Title           string          `json:"title"`
+	Author          string          `json:"author"`
+	ISBNs           []string        `json:"isbns"`
+	CopiesAvailable int             `json:"copies_available"`
+	CityCount       int             `json:"city_count"`
+	Cities          []string        `json:"cities"`
+	Books           []BookWithOwner `json:"books"`
 }
 
 type BookImage struct {
@@ -56,15 +212,77 @@ type BookImage struct {
 }
 
 type BookSearchResult struct {
-	ID     int    `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author"`
-	Genre  string `json:"genre"`
-	City   string `json:"city"`
-	Image  string `json:"image"`
+	ID              int    `json:"id"`
+	Title           string `json:"title"`
+	Author          string `json:"author"`
+	Genre           string `json:"genre"`
+	Language        string `json:"language"`
+	Format          string `json:"format"`
+	Publisher       string `json:"publisher"`
+	PublicationYear int    `json:"publication_year"`
+	PageCount       int    `json:"page_count"`
+	Series          string `json:"series"`
+	SeriesVolume    int    `json:"series_volume"`
+	City            string `json:"city"`
+	Image           string `json:"image"`
+	Snippet         string `json:"snippet"` // matched text with <mark> highlights
+	// DistanceKm is the distance from the requested origin, set when the search has one
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+}
+
+// BookSearchFilter holds the query, location filter and cursor accepted by book search
+type BookSearchFilter struct {
+	Query  string
+	Cursor string
+	Limit  int
+
+	LocationFilter
+	BookDetailsFilter
+}
+
+// LocationFilter holds the location filter shared by the feed and search. Near is a
+// city name or "me" (the viewer's profile location); RadiusKm, when positive, drops
+// books farther than that from it.
+type LocationFilter struct {
+	Near     string
+	RadiusKm float64
+	// Origin is the position Near resolves to, set by BookService
+	Origin *GeoPoint
+}
+
+// BookDetailsFilter holds the bibliographic filters shared by the feed and search.
+// Publisher and Series match a part of the value, ignoring case; the years bound
+// PublicationYear and drop books whose year is unknown.
+type BookDetailsFilter struct {
+	Language  string
+	Format    string
+	Publisher string
+	Series    string
+	YearFrom  int
+	YearTo    int
+}
+
+// BookSearchPage is one page of ranked search results; NextCursor is empty on the last page
+type BookSearchPage struct {
+	Results    []BookSearchResult `json:"results"`
+	NextCursor string             `json:"next_cursor,omitempty"`
 }
 
-// BookExchangeRequest represents a book exchange request with full details
+// BookMetadata is bibliographic data used to prefill a listing from its ISBN
+type BookMetadata struct {
+	ISBN        string `json:"isbn"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	Description string `json:"description"`
+	Genre       string `json:"genre"`
+	Language    string `json:"language"`
+	CoverURL    string `json:"cover_url"`
+	Source      string `json:"source"` // "local" or "remote"
+}
+
+// BookExchangeRequest represents a book exchange request with full details. An exchange
+// trades a bundle of the owner's books for a bundle of the requester's; BookID and
+// OfferedBookID with their titles, authors and images describe the first book of each side.
 type BookExchangeRequest struct {
 	ID              int    `json:"id"`
 	BookID          int    `json:"book_id"`
@@ -83,5 +301,148 @@ type BookExchangeRequest struct {
 	OwnerAvatar     string `json:"owner_avatar"`
 	Status          string `json:"status"`
 	CreatedAt       string `json:"created_at"`
-	IsIncoming      bool   `json:"is_incoming"` // true if current user is the book owner
+	UpdatedAt       string `json:"updated_at"`
+	CompletedAt     string `json:"completed_at,omitempty"`
+	// Completion needs a confirmation from both parties
+	OwnerConfirmed     bool   `json:"owner_confirmed"`
+	RequesterConfirmed bool   `json:"requester_confirmed"`
+	DisputeReason      string `json:"dispute_reason,omitempty"`
+	IsIncoming         bool   `json:"is_incoming"` // true if current user is the book owner
+	// All books on each side, in the order they were listed
+	RequestedBooks []ExchangeBook `json:"requested_books"`
+	OfferedBooks   []ExchangeBook `json:"offered_books"`
+	// LastOfferBy proposed the offer on the table; the other party may accept it
+	LastOfferBy int             `json:"last_offer_by"`
+	Offers      []ExchangeOffer `json:"offers,omitempty"`
+	History     []ExchangeEvent `json:"history,omitempty"`
+	// ExpiresAt is when a pending request expires without further activity
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// NoShowUserID is the party reported missing at the meetup of a cancelled exchange
+	NoShowUserID int `json:"no_show_user_id,omitempty"`
+}
+
+// Exchange statuses. An exchange moves pending -> accepted -> meetup_scheduled ->
+// completed, reaching meetup_scheduled when a meetup proposal is accepted; declined,
+// cancelled, expired and completed end it. A disputed exchange waits for a moderator
+// to cancel or complete it. A pending request expires once it sees no activity for
+// the request TTL.
+const (
+	ExchangeStatusPending         = "pending"
+	ExchangeStatusAccepted        = "accepted"
+	ExchangeStatusMeetupScheduled = "meetup_scheduled"
+	ExchangeStatusCompleted       = "completed"
+	ExchangeStatusDisputed        = "disputed"
+	ExchangeStatusDeclined        = "declined"
+	ExchangeStatusCancelled       = "cancelled"
+	ExchangeStatusExpired         = "expired"
+)
+
+// Exchange actions, each one moves an exchange along the state machine
+const (
+	ExchangeActionAccept  = "accept"
+	ExchangeActionDecline = "decline"
+	ExchangeActionCancel  = "cancel"
+	ExchangeActionConfirm = "confirm"
+	ExchangeActionDispute = "dispute"
+	ExchangeActionNoShow  = "no-show"
+)
+
+// Dispute outcomes a moderator can choose
+const (
+	DisputeOutcomeCancel   = "cancel"   // the books are listed again
+	DisputeOutcomeComplete = "complete" // the books change hands
+)
+
+// DisputeResolutionRequest is the body of POST /api/admin/exchanges/{id}/resolve
+type DisputeResolutionRequest struct {
+	Outcome string `json:"outcome"`
+	Note    string `json:"note"`
+}
+
+// Sides of an exchange bundle: the owner's requested books and the requester's offered books
+const (
+	ExchangeSideRequested = "requested"
+	ExchangeSideOffered   = "offered"
+)
+
+// ExchangeBook is one book of an exchange bundle
+type ExchangeBook struct {
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Image  string `json:"image"`
+}
+
+// ExchangeEvent is one transition of an exchange; ActorID 0 is the system
+type ExchangeEvent struct {
+	ID         int    `json:"id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	ActorID    int    `json:"actor_id"`
+	Note       string `json:"note,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ExchangeActionRequest is the optional body of an exchange action. OfferID pins an
+// accept to the offer the user saw, so a newer counter-offer is not accepted unseen.
+type ExchangeActionRequest struct {
+	Reason  string `json:"reason"`
+	OfferID int    `json:"offer_id"`
+}
+
+// Offer statuses. Only the latest offer of a pending exchange is open.
+const (
+	OfferStatusOpen       = "open"
+	OfferStatusSuperseded = "superseded"
+	OfferStatusAccepted   = "accepted"
+	OfferStatusClosed     = "closed"
+)
+
+// ExchangeOffer is one proposal in the offer thread of an exchange: the books of the
+// requester's library that would be given for the requested books. The Offered fields
+// describe the first of Books.
+type ExchangeOffer struct {
+	ID            int            `json:"id"`
+	ProposedBy    int            `json:"proposed_by"`
+	OfferedBookID int            `json:"offered_book_id"`
+	OfferedTitle  string         `json:"offered_title"`
+	OfferedAuthor string         `json:"offered_author"`
+	OfferedImage  string         `json:"offered_image"`
+	Books         []ExchangeBook `json:"books"`
+	Message       string         `json:"message,omitempty"`
+	Status        string         `json:"status"`
+	CreatedAt     string         `json:"created_at"`
+}
+
+// ExchangeOfferRequest is a counter-offer on a pending exchange. OfferedBookIDs lists the
+// offered bundle; a single OfferedBookID is accepted too.
+type ExchangeOfferRequest struct {
+	OfferedBookID  int    `json:"offered_book_id"`
+	OfferedBookIDs []int  `json:"offered_book_ids"`
+	Message        string `json:"message"`
+}
+
+// Handoff code holders: the party of an exchange that shows the code
+const (
+	HandoffHolderOwner     = "owner"
+	HandoffHolderRequester = "requester"
+)
+
+// ExchangeHandoff is one party's view of the handoff codes of an exchange with a
+// scheduled meetup. Code is shown to the other party, as text or as the QR code at
+// QRURL, who enters it at the meetup to prove the books changed hands.
+type ExchangeHandoff struct {
+	ExchangeID    int    `json:"exchange_id"`
+	Code          string `json:"code,omitempty"`
+	QRPayload     string `json:"qr_payload,omitempty"`
+	QRURL         string `json:"qr_url,omitempty"`
+	CodeVerified  bool   `json:"code_verified"`  // the other party entered your code
+	OtherVerified bool   `json:"other_verified"` // you entered the other party's code
+	Completed     bool   `json:"completed"`
+}
+
+// HandoffVerifyRequest is the body of POST /api/exchange-requests/{id}/handoff: the other
+// party's code, typed or as the scanned QR payload
+type HandoffVerifyRequest struct {
+	Code string `json:"code"`
 }
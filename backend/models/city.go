@@ -0,0 +1,15 @@
+package models
+
+// City is a place books can be listed in, with the coordinates used for distance sorting
+type City struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// GeoPoint is a position in decimal degrees
+type GeoPoint struct {
+	Latitude  float64
+	Longitude float64
+}
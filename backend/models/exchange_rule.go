@@ -0,0 +1,36 @@
+package models
+
+// ExchangeRules are the conditions an owner sets on the exchange requests they receive,
+// for one listing (BookID) or for their whole library (BookID 0). Zero values mean no
+// condition. The rules of the library and of each requested listing all apply.
+type ExchangeRules struct {
+	BookID int `json:"book_id,omitempty"`
+	// SameCity only takes requests from users living in the city of the listing
+	SameCity bool `json:"same_city"`
+	// MinCondition is the worst condition accepted for the offered books
+	MinCondition string `json:"min_condition,omitempty"`
+	// MinRating is the lowest average rating accepted from the requester
+	MinRating         float64 `json:"min_rating,omitempty"`
+	MinAccountAgeDays int     `json:"min_account_age_days,omitempty"`
+	// Genres are the genres accepted for the offered books; empty accepts any
+	Genres    []string `json:"genres"`
+	UpdatedAt string   `json:"updated_at,omitempty"`
+}
+
+// ExchangeRulesRequest is the body of PUT /api/exchange-rules and
+// PUT /api/books/{id}/exchange-rules
+type ExchangeRulesRequest struct {
+	SameCity          bool     `json:"same_city"`
+	MinCondition      string   `json:"min_condition"`
+	MinRating         float64  `json:"min_rating"`
+	MinAccountAgeDays int      `json:"min_account_age_days"`
+	Genres            []string `json:"genres"`
+}
+
+// ListingExchangeRules are the rules a request for a listing has to meet: those of the
+// owner's library and those of the listing, nil when not set
+type ListingExchangeRules struct {
+	BookID  int            `json:"book_id"`
+	Library *ExchangeRules `json:"library,omitempty"`
+	Listing *ExchangeRules `json:"listing,omitempty"`
+}
@@ -0,0 +1,52 @@
+package models
+
+// Giveaway claim statuses. Claims wait in the book's queue as pending until one is
+// chosen; the chosen claim ends completed once the owner hands the book over.
+const (
+	ClaimStatusPending   = "pending"
+	ClaimStatusChosen    = "chosen"
+	ClaimStatusCompleted = "completed"
+	ClaimStatusDeclined  = "declined"
+	ClaimStatusCancelled = "cancelled"
+)
+
+// Giveaway claim actions. The owner chooses, declines, releases (drops a chosen
+// recipient) and completes; the requester cancels.
+const (
+	ClaimActionChoose   = "choose"
+	ClaimActionDecline  = "decline"
+	ClaimActionRelease  = "release"
+	ClaimActionComplete = "complete"
+	ClaimActionCancel   = "cancel"
+)
+
+// GiveawayClaim is a request for a giveaway listing
+type GiveawayClaim struct {
+	ID              int    `json:"id"`
+	BookID          int    `json:"book_id"`
+	BookTitle       string `json:"book_title"`
+	BookAuthor      string `json:"book_author"`
+	BookImage       string `json:"book_image"`
+	OwnerID         int    `json:"owner_id"`
+	OwnerName       string `json:"owner_name"`
+	RequesterID     int    `json:"requester_id"`
+	RequesterName   string `json:"requester_name"`
+	RequesterAvatar string `json:"requester_avatar"`
+	Status          string `json:"status"`
+	Message         string `json:"message,omitempty"`
+	CreatedAt       string `json:"created_at"`
+	UpdatedAt       string `json:"updated_at"`
+	ChosenAt        string `json:"chosen_at,omitempty"`
+	CompletedAt     string `json:"completed_at,omitempty"`
+	// ClaimsThisMonth counts the requester's chosen claims this month; Eligible is false
+	// once it reaches the monthly limit. Both are set in the owner's queue.
+	ClaimsThisMonth int  `json:"claims_this_month"`
+	Eligible        bool `json:"eligible"`
+	IsIncoming      bool `json:"is_incoming"` // true if current user is the book owner
+}
+
+// GiveawayRequest is the body of POST /api/giveaways
+type GiveawayRequest struct {
+	BookID  int    `json:"book_id"`
+	Message string `json:"message"`
+}
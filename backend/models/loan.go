@@ -0,0 +1,52 @@
+package models
+
+// Loan statuses. A loan moves pending -> approved -> active -> returned: an approved
+// loan holds the book reserved until its start date, when it becomes active and the
+// book lent_out. Declined and cancelled end a loan that was never lent.
+const (
+	LoanStatusPending   = "pending"
+	LoanStatusApproved  = "approved"
+	LoanStatusActive    = "active"
+	LoanStatusReturned  = "returned"
+	LoanStatusDeclined  = "declined"
+	LoanStatusCancelled = "cancelled"
+)
+
+// Loan actions, each one moves a loan along its statuses
+const (
+	LoanActionApprove = "approve"
+	LoanActionDecline = "decline"
+	LoanActionCancel  = "cancel"
+	LoanActionReturn  = "return"
+)
+
+// BookLoan is a borrow request on a lend listing. Dates are YYYY-MM-DD.
+type BookLoan struct {
+	ID             int    `json:"id"`
+	BookID         int    `json:"book_id"`
+	BookTitle      string `json:"book_title"`
+	BookAuthor     string `json:"book_author"`
+	BookImage      string `json:"book_image"`
+	OwnerID        int    `json:"owner_id"`
+	OwnerName      string `json:"owner_name"`
+	BorrowerID     int    `json:"borrower_id"`
+	BorrowerName   string `json:"borrower_name"`
+	BorrowerAvatar string `json:"borrower_avatar"`
+	Status         string `json:"status"`
+	StartDate      string `json:"start_date"`
+	DueDate        string `json:"due_date"`
+	Message        string `json:"message,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+	ReturnedAt     string `json:"returned_at,omitempty"`
+	Overdue        bool   `json:"overdue"`
+	IsIncoming     bool   `json:"is_incoming"` // true if current user is the book owner
+}
+
+// LoanRequest is the body of POST /api/loans
+type LoanRequest struct {
+	BookID    int    `json:"book_id"`
+	StartDate string `json:"start_date"`
+	DueDate   string `json:"due_date"`
+	Message   string `json:"message"`
+}
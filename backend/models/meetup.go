@@ -0,0 +1,75 @@
+package models
+
+// Meetup statuses. A proposed meetup waits for the other party of the exchange; once
+// accepted the exchange is meetup_scheduled. A reschedule makes it a proposal again.
+const (
+	MeetupStatusProposed = "proposed"
+	MeetupStatusAccepted = "accepted"
+)
+
+// Meetup actions
+const (
+	MeetupActionAccept     = "accept"
+	MeetupActionReschedule = "reschedule"
+)
+
+// MeetupSpot is a curated safe public place of a city where books can be handed over
+type MeetupSpot struct {
+	ID        int      `json:"id"`
+	City      string   `json:"city"`
+	Name      string   `json:"name"`
+	Address   string   `json:"address,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Active    bool     `json:"active"`
+}
+
+// MeetupSpotRequest is the body of the admin spot endpoints
+type MeetupSpotRequest struct {
+	City      string   `json:"city"`
+	Name      string   `json:"name"`
+	Address   string   `json:"address"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	// Active retires (false) or restores (true) a spot; omitted keeps it as it is
+	Active *bool `json:"active"`
+}
+
+// ExchangeMeetup is the handover of an accepted exchange: a time and a spot. Times are
+// RFC 3339 in UTC; EndsAt is derived from the meetup length.
+type ExchangeMeetup struct {
+	ID             int        `json:"id"`
+	ExchangeID     int        `json:"exchange_id"`
+	ExchangeStatus string     `json:"exchange_status"`
+	Spot           MeetupSpot `json:"spot"`
+	StartsAt       string     `json:"starts_at"`
+	EndsAt         string     `json:"ends_at"`
+	Status         string     `json:"status"`
+	ProposedBy     int        `json:"proposed_by"`
+	Note           string     `json:"note,omitempty"`
+	Sequence       int        `json:"sequence"`
+	OwnerID        int        `json:"owner_id"`
+	OwnerName      string     `json:"owner_name"`
+	RequesterID    int        `json:"requester_id"`
+	RequesterName  string     `json:"requester_name"`
+	CreatedAt      string     `json:"created_at"`
+	UpdatedAt      string     `json:"updated_at"`
+	AcceptedAt     string     `json:"accepted_at,omitempty"`
+	// The books each side hands over, for the calendar event description
+	RequestedBooks []ExchangeBook `json:"requested_books"`
+	OfferedBooks   []ExchangeBook `json:"offered_books"`
+}
+
+// MeetupRequest is the body of POST /api/meetups; a reschedule takes the same fields
+// without the exchange
+type MeetupRequest struct {
+	ExchangeID int    `json:"exchange_id"`
+	SpotID     int    `json:"spot_id"`
+	StartsAt   string `json:"starts_at"`
+	Note       string `json:"note"`
+}
+
+// CalendarFeed is the personal ICS feed of a user
+type CalendarFeed struct {
+	URL string `json:"url"`
+}
@@ -34,6 +34,18 @@ const (
 	NotificationTypeBookRequest   = "book_request"
 	NotificationTypeBookAccepted  = "book_accepted"
 	NotificationTypeLike          = "like"
+	NotificationTypeWishlistMatch = "wishlist_match"
+	NotificationTypeSearchDigest  = "saved_search_digest"
+	NotificationTypeExchange      = "exchange_update"
+	NotificationTypeExchangeOffer = "exchange_offer"
+	NotificationTypeLoanRequest   = "loan_request"
+	NotificationTypeLoanUpdate    = "loan_update"
+	NotificationTypeLoanReminder  = "loan_reminder"
+	NotificationTypeLoanOverdue   = "loan_overdue"
+	NotificationTypeGiveaway      = "giveaway_update"
+	NotificationTypeGiveawayClaim = "giveaway_claim"
+	NotificationTypeTradeCycle    = "trade_cycle"
+	NotificationTypeMeetup        = "meetup"
 )
 
 // CreateNotificationRequest for generic notification creation
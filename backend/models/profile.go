@@ -16,6 +16,8 @@ type Profile struct {
 	IsOwner    bool `json:"is_owner"`
 	IsFollowed bool `json:"is_followed"`
 	IsPending  bool `json:"is_pending"`
+	// Reputation as an exchange partner, shown on private profiles too
+	Reputation Reputation `json:"reputation"`
 }
 
 type SearchResult struct {
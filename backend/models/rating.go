@@ -0,0 +1,46 @@
+package models
+
+// Star bounds of an exchange rating
+const (
+	MinRatingStars = 1
+	MaxRatingStars = 5
+)
+
+// ExchangeRating is the rating one party of a completed exchange gave the other
+type ExchangeRating struct {
+	ID         int    `json:"id"`
+	ExchangeID int    `json:"exchange_id"`
+	RaterID    int    `json:"rater_id"`
+	RaterName  string `json:"rater_name"`
+	RateeID    int    `json:"ratee_id"`
+	Stars      int    `json:"stars"`
+	Comment    string `json:"comment,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// RatingRequest is the body of POST /api/exchange-requests/{id}/rating
+type RatingRequest struct {
+	Stars   int    `json:"stars"`
+	Comment string `json:"comment"`
+}
+
+// ExchangeRatings is one party's view of the ratings of an exchange
+type ExchangeRatings struct {
+	ExchangeID int             `json:"exchange_id"`
+	CanRate    bool            `json:"can_rate"` // completed and not rated by you yet
+	Given      *ExchangeRating `json:"given,omitempty"`
+	Received   *ExchangeRating `json:"received,omitempty"`
+}
+
+// Reputation sums up how reliable a user is as an exchange partner. CompletionRate is
+// the share of ClosedExchanges (exchanges that ended after being accepted) that
+// completed; it is 0 when there are none. NoShows counts the meetups the user was
+// reported missing.
+type Reputation struct {
+	AverageRating      float64 `json:"average_rating"`
+	RatingCount        int     `json:"rating_count"`
+	CompletedExchanges int     `json:"completed_exchanges"`
+	ClosedExchanges    int     `json:"closed_exchanges"`
+	CompletionRate     float64 `json:"completion_rate"`
+	NoShows            int     `json:"no_shows"`
+}
@@ -0,0 +1,33 @@
+package models
+
+// Saved search digest frequencies
+const (
+	SavedSearchHourly = "hourly"
+	SavedSearchDaily  = "daily"
+)
+
+// SavedSearch is a stored book feed query whose new listings are sent to its
+// owner as one digest notification per period
+type SavedSearch struct {
+	ID        int               `json:"id"`
+	UserID    int               `json:"user_id"`
+	Name      string            `json:"name"`
+	Filters   map[string]string `json:"filters"`
+	Frequency string            `json:"frequency"`
+	LastRunAt string            `json:"last_run_at"`
+	CreatedAt string            `json:"created_at"`
+	UpdatedAt string            `json:"updated_at"`
+
+	// Query is Filters URL-encoded as stored; LastBookID is the digest watermark
+	Query      string `json:"-"`
+	LastBookID int    `json:"-"`
+}
+
+// SavedSearchRequest is the body of POST /api/saved-searches and PUT /api/saved-searches/{id}.
+// Filters takes the book feed parameters (genre, condition, min_condition, city, author, min_rating,
+// language, format, publisher, series, year_from, year_to).
+type SavedSearchRequest struct {
+	Name      string            `json:"name"`
+	Filters   map[string]string `json:"filters"`
+	Frequency string            `json:"frequency"`
+}
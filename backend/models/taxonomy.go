@@ -0,0 +1,48 @@
+package models
+
+// Taxonomy kinds, as used in /api/admin/taxonomy/{kind}
+const (
+	TaxonomyGenres     = "genres"
+	TaxonomyConditions = "conditions"
+	TaxonomyCities     = "cities"
+	TaxonomyLanguages  = "languages"
+	TaxonomyFormats    = "formats"
+)
+
+// TaxonomyLabels are the localized display names of a taxonomy term
+type TaxonomyLabels struct {
+	Ar string `json:"ar"`
+	Fr string `json:"fr"`
+	En string `json:"en"`
+}
+
+// TaxonomyTerm is a genre, condition, city, language or format. Name is the value stored on books;
+// for conditions, SortOrder ranks them from best to worst.
+type TaxonomyTerm struct {
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	Labels    TaxonomyLabels `json:"labels"`
+	SortOrder int            `json:"sort_order"`
+	// Coordinates, for cities only
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+// Taxonomy is the body of GET /api/taxonomy
+type Taxonomy struct {
+	Genres     []TaxonomyTerm `json:"genres"`
+	Conditions []TaxonomyTerm `json:"conditions"`
+	Cities     []TaxonomyTerm `json:"cities"`
+	Languages  []TaxonomyTerm `json:"languages"`
+	Formats    []TaxonomyTerm `json:"formats"`
+}
+
+// TaxonomyTermRequest is the body of POST and PUT /api/admin/taxonomy/{kind}.
+// Name is only read on creation: books refer to terms by name.
+type TaxonomyTermRequest struct {
+	Name      string         `json:"name"`
+	Labels    TaxonomyLabels `json:"labels"`
+	SortOrder int            `json:"sort_order"`
+	Latitude  *float64       `json:"latitude"`
+	Longitude *float64       `json:"longitude"`
+}
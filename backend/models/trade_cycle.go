@@ -0,0 +1,55 @@
+package models
+
+// Trade cycle statuses. A proposed cycle becomes active once every participant accepts
+// and completed once every participant received their book; one decline ends it.
+const (
+	TradeCycleStatusProposed  = "proposed"
+	TradeCycleStatusActive    = "active"
+	TradeCycleStatusCompleted = "completed"
+	TradeCycleStatusDeclined  = "declined"
+	TradeCycleStatusCancelled = "cancelled" // a book left the market before activation
+)
+
+// Trade cycle actions of a participant
+const (
+	TradeCycleActionAccept  = "accept"
+	TradeCycleActionDecline = "decline"
+	TradeCycleActionConfirm = "confirm" // the participant received their book
+)
+
+// TradeWant is an edge of the matching graph: WanterID wants BookID, listed by OwnerID
+// in City
+type TradeWant struct {
+	WanterID int
+	BookID   int
+	OwnerID  int
+	City     string
+}
+
+// TradeCycle is a circular trade between 3 to 5 users of one city
+type TradeCycle struct {
+	ID          int             `json:"id"`
+	City        string          `json:"city"`
+	Status      string          `json:"status"`
+	Legs        []TradeCycleLeg `json:"legs"`
+	CreatedAt   string          `json:"created_at"`
+	UpdatedAt   string          `json:"updated_at"`
+	ActivatedAt string          `json:"activated_at,omitempty"`
+	CompletedAt string          `json:"completed_at,omitempty"`
+}
+
+// TradeCycleLeg is one hand-over of a cycle: the giver's book goes to the receiver.
+// Accepted and Received are the receiver's answers.
+type TradeCycleLeg struct {
+	Position     int    `json:"position"`
+	ReceiverID   int    `json:"receiver_id"`
+	ReceiverName string `json:"receiver_name"`
+	GiverID      int    `json:"giver_id"`
+	GiverName    string `json:"giver_name"`
+	BookID       int    `json:"book_id"`
+	BookTitle    string `json:"book_title"`
+	BookAuthor   string `json:"book_author"`
+	BookImage    string `json:"book_image"`
+	Accepted     bool   `json:"accepted"`
+	Received     bool   `json:"received"`
+}
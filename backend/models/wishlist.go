@@ -0,0 +1,22 @@
+package models
+
+// Wishlist is a book a user is looking for. It names an ISBN, a title and
+// author, or both, and may be limited to some cities.
+type Wishlist struct {
+	ID        int      `json:"id"`
+	UserID    int      `json:"user_id"`
+	ISBN      string   `json:"isbn"`
+	Title     string   `json:"title"`
+	Author    string   `json:"author"`
+	Cities    []string `json:"cities"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// WishlistRequest is the body of POST /api/wishlist and PUT /api/wishlist/{id}
+type WishlistRequest struct {
+	ISBN   string   `json:"isbn"`
+	Title  string   `json:"title"`
+	Author string   `json:"author"`
+	Cities []string `json:"cities"`
+}
@@ -2,10 +2,14 @@ package repositories
 
 import (
 	"database/sql"
-	"fmt"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
 	"strings"
 
 	"ktabnet/models"
+	"ktabnet/utils"
 )
 
 type BookRepository struct {
@@ -17,24 +21,72 @@ func NewBookRepository(db *sql.DB) *BookRepository {
 }
 
 func (r *BookRepository) CreateBook(book models.Book) (int, error) {
-	result, err := r.DB.Exec(`
-		INSERT INTO books (owner_id, title, author, isbn, description, genre, condition, city, available)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, book.OwnerID, book.Title, book.Author, book.ISBN, book.Description, book.Genre, book.Condition, book.City, book.Available)
+	workID, err := r.resolveWorkID(0, book.Title, book.Author, book.ISBN)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO books (owner_id, title, author, isbn, description, genre, condition, language, format, publisher, publication_year, page_count, series, series_volume, city, available, status, listing_type, giveaway_pick, work_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?)
+	`, book.OwnerID, book.Title, book.Author, book.ISBN, book.Description, book.Genre, book.Condition, book.Language, book.Format, book.Publisher, book.PublicationYear, book.PageCount, book.Series, book.SeriesVolume, book.City, models.BookStatusListed, book.ListingType, book.GiveawayPick, workID)
 	if err != nil {
 		return 0, err
 	}
 	id, err := result.LastInsertId()
-	fmt.Println("hhhhhh", err)
-	return int(id), err
+	if err != nil {
+		return 0, err
+	}
+	if err := recordBookStatus(tx, int(id), "", models.BookStatusListed, book.OwnerID, "listed"); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// workKey identifies a work by its normalized title and author. It must stay in
+// sync with the backfill in migration 000027.
+func workKey(title, author string) string {
+	return utils.NormalizeText(strings.Trim(title, " ")) + "|" + utils.NormalizeText(strings.Trim(author, " "))
+}
+
+// resolveWorkID returns the work a listing belongs to, creating it when needed.
+// Another listing with the same ISBN decides first; otherwise the title and author
+// do, which groups different editions of the same book. bookID is the listing being
+// resolved (0 for a new one) so it never matches itself.
+func (r *BookRepository) resolveWorkID(bookID int, title, author, isbn string) (int, error) {
+	var workID int
+	if isbn != "" {
+		err := r.DB.QueryRow(`
+			SELECT work_id FROM books WHERE isbn = ? AND id != ? AND work_id IS NOT NULL LIMIT 1
+		`, isbn, bookID).Scan(&workID)
+		if err == nil {
+			return workID, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+
+	key := workKey(title, author)
+	if _, err := r.DB.Exec(`INSERT OR IGNORE INTO works (work_key, title, author) VALUES (?, ?, ?)`, key, title, author); err != nil {
+		return 0, err
+	}
+	err := r.DB.QueryRow(`SELECT id FROM works WHERE work_key = ?`, key).Scan(&workID)
+	return workID, err
 }
 
 func (r *BookRepository) GetBookByID(bookID int) (models.Book, error) {
 	var book models.Book
 	err := r.DB.QueryRow(`
-		SELECT id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at
+		SELECT id, owner_id, title, author, isbn, description, genre, condition, language, format, publisher, publication_year, page_count, series, series_volume, city, available, status, listing_type, giveaway_pick, COALESCE(work_id, 0), created_at, updated_at
 		FROM books WHERE id = ?
-	`, bookID).Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.City, &book.Available, &book.CreatedAt, &book.UpdatedAt)
+	`, bookID).Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.Language, &book.Format, &book.Publisher, &book.PublicationYear, &book.PageCount, &book.Series, &book.SeriesVolume, &book.City, &book.Available, &book.Status, &book.ListingType, &book.GiveawayPick, &book.WorkID, &book.CreatedAt, &book.UpdatedAt)
 	if err != nil {
 		return book, err
 	}
@@ -56,16 +108,7 @@ func (r *BookRepository) GetBookByID(bookID int) (models.Book, error) {
 		}
 	}
 	book.Images = images
-	err = r.DB.QueryRow(`
-	SELECT id, first_name, last_name, avatar
-	FROM users
-	WHERE id = ?
-`, book.OwnerID).Scan(
-		&book.Owner.ID,
-		&book.Owner.FirstName,
-		&book.Owner.LastName,
-		&book.Owner.Avatar,
-	)
+	book.Owner, err = bookOwner(r.DB, book.OwnerID)
 	if err != nil {
 		return book, err
 	}
@@ -73,9 +116,26 @@ func (r *BookRepository) GetBookByID(bookID int) (models.Book, error) {
 	return book, nil
 }
 
+// bookOwner returns the public details of a book owner: their listed books, completed
+// exchanges and reputation
+func bookOwner(db dbtx, userID int) (models.BookOwner, error) {
+	var owner models.BookOwner
+	err := db.QueryRow(`
+		SELECT id, first_name, last_name, avatar, COALESCE(city, ''), exchange_count,
+		       (SELECT COUNT(*) FROM books WHERE owner_id = users.id AND status = 'listed')
+		FROM users
+		WHERE id = ?
+	`, userID).Scan(&owner.ID, &owner.FirstName, &owner.LastName, &owner.Avatar, &owner.City, &owner.BooksExchanged, &owner.BooksListed)
+	if err != nil {
+		return owner, err
+	}
+	owner.Reputation, err = userReputation(db, userID)
+	return owner, err
+}
+
 func (r *BookRepository) GetUserBooks(userID int) ([]models.Book, error) {
 	rows, err := r.DB.Query(`
-		SELECT id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at
+		SELECT id, owner_id, title, author, isbn, description, genre, condition, language, format, publisher, publication_year, page_count, series, series_volume, city, available, status, listing_type, giveaway_pick, COALESCE(work_id, 0), created_at, updated_at
 		FROM books WHERE owner_id = ? ORDER BY created_at DESC
 	`, userID)
 	if err != nil {
@@ -86,7 +146,7 @@ func (r *BookRepository) GetUserBooks(userID int) ([]models.Book, error) {
 	var books []models.Book
 	for rows.Next() {
 		var book models.Book
-		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.City, &book.Available, &book.CreatedAt, &book.UpdatedAt); err == nil {
+		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.Language, &book.Format, &book.Publisher, &book.PublicationYear, &book.PageCount, &book.Series, &book.SeriesVolume, &book.City, &book.Available, &book.Status, &book.ListingType, &book.GiveawayPick, &book.WorkID, &book.CreatedAt, &book.UpdatedAt); err == nil {
 			// Fetch images for this book
 			imgRows, _ := r.DB.Query(`
 				SELECT image_url FROM book_images WHERE book_id = ? ORDER BY order_index
@@ -108,8 +168,8 @@ func (r *BookRepository) GetUserBooks(userID int) ([]models.Book, error) {
 
 func (r *BookRepository) GetAllBooks(excludeUserID int) ([]models.Book, error) {
 	rows, err := r.DB.Query(`
-		SELECT id, owner_id, title, author, isbn, description, genre, condition, city, available, created_at, updated_at
-		FROM books WHERE available = 1 AND owner_id != ? ORDER BY created_at DESC
+		SELECT id, owner_id, title, author, isbn, description, genre, condition, language, format, publisher, publication_year, page_count, series, series_volume, city, available, status, listing_type, giveaway_pick, COALESCE(work_id, 0), created_at, updated_at
+		FROM books WHERE status = 'listed' AND owner_id != ? ORDER BY created_at DESC
 	`, excludeUserID)
 	if err != nil {
 		return nil, err
@@ -119,7 +179,7 @@ func (r *BookRepository) GetAllBooks(excludeUserID int) ([]models.Book, error) {
 	var books []models.Book
 	for rows.Next() {
 		var book models.Book
-		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.City, &book.Available, &book.CreatedAt, &book.UpdatedAt); err == nil {
+		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.Language, &book.Format, &book.Publisher, &book.PublicationYear, &book.PageCount, &book.Series, &book.SeriesVolume, &book.City, &book.Available, &book.Status, &book.ListingType, &book.GiveawayPick, &book.WorkID, &book.CreatedAt, &book.UpdatedAt); err == nil {
 			imgRows, _ := r.DB.Query(`
 				SELECT image_url FROM book_images WHERE book_id = ? ORDER BY order_index
 			`, book.ID)
@@ -138,46 +198,251 @@ func (r *BookRepository) GetAllBooks(excludeUserID int) ([]models.Book, error) {
 	return books, nil
 }
 
-func (r *BookRepository) GetAllBooksWithOwner(excludeUserID int) ([]models.BookWithOwner, error) {
-	rows, err := r.DB.Query(`
-		SELECT b.id, b.owner_id, b.title, b.author, b.isbn, b.description, b.genre, b.condition, b.city, b.available, b.created_at, b.updated_at,
-		       COALESCE(u.first_name || ' ' || u.last_name, 'Unknown') as owner_name,
-		       COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
-		       COALESCE(u.avatar, ''), COALESCE(b.city, 'Unknown')
-		FROM books b
-		LEFT JOIN users u ON b.owner_id = u.id
-		WHERE b.available = 1 AND b.owner_id != ? ORDER BY b.created_at DESC
-	`, excludeUserID)
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// feedCursor is the keyset position encoded into BookFeedPage.NextCursor
+type feedCursor struct {
+	Rank int    `json:"r"`
+	Key  string `json:"k"`
+	ID   int    `json:"i"`
+}
+
+// searchCursor is the result offset encoded into BookSearchPage.NextCursor
+type searchCursor struct {
+	Offset int `json:"o"`
+}
+
+// encodeCursor turns a cursor struct into an opaque URL-safe token
+func encodeCursor(c interface{}) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor reverses encodeCursor into c
+func decodeCursor(s string, c interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		return nil, err
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// GetAllBooksWithOwner returns one page of listed books not owned by excludeUserID.
+// Pagination is keyset based: rows are ordered by (feed_rank, feed_key, id) and the
+// cursor holds the last row's values, so pages stay stable while new books are listed.
+func (r *BookRepository) GetAllBooksWithOwner(excludeUserID int, filter models.BookFeedFilter) (models.BookFeedPage, error) {
+	page := models.BookFeedPage{Books: []models.BookWithOwner{}}
+
+	rankExpr := "0"
+	keyExpr := "strftime('%Y-%m-%d %H:%M:%S', b.created_at)"
+	order := "DESC"
+	args := []interface{}{}
+	switch filter.Sort {
+	case models.BookSortOldest:
+		order = "ASC"
+	case models.BookSortTitle:
+		keyExpr = "LOWER(b.title)"
+		order = "ASC"
+	case models.BookSortNearest:
+		if filter.Origin != nil {
+			// Nearest whole kilometre first, newest first at the same distance;
+			// books in cities without coordinates come last
+			rankExpr = "COALESCE(CAST(ROUND(" + distanceExpr + ") AS INTEGER), " + unknownDistanceRank + ")"
+			args = append(args, filter.Origin.Latitude, filter.Origin.Longitude)
+		} else {
+			// Books in the viewer's own city come first, newest first within each group
+			rankExpr = "CASE WHEN b.city = (SELECT city FROM users WHERE id = ?) THEN 0 ELSE 1 END"
+			args = append(args, excludeUserID)
+		}
+	}
+
+	distExpr := "NULL"
+	if filter.Origin != nil {
+		distExpr = distanceExpr
+		args = append(args, filter.Origin.Latitude, filter.Origin.Longitude)
+	}
+
+	query := `
+		SELECT * FROM (
+			SELECT b.id, b.owner_id, b.title, b.author, b.isbn, b.description, b.genre, b.condition, b.language, b.format, b.publisher, b.publication_year, b.page_count, b.series, b.series_volume, b.city, b.available, b.status, b.listing_type, b.giveaway_pick, COALESCE(b.work_id, 0) as work_id, b.created_at, b.updated_at,
+			       COALESCE(u.first_name || ' ' || u.last_name, 'Unknown') as owner_name,
+			       COALESCE(u.first_name, '') as owner_first_name, COALESCE(u.last_name, '') as owner_last_name,
+			       COALESCE(u.avatar, '') as owner_avatar, COALESCE(b.city, 'Unknown') as owner_city,
+			       ` + rankExpr + ` as feed_rank, ` + keyExpr + ` as feed_key, ` + distExpr + ` as distance_km
+			FROM books b
+			LEFT JOIN users u ON b.owner_id = u.id
+			LEFT JOIN cities c ON c.name = b.city
+			WHERE b.status = 'listed'`
+	if filter.OwnerID > 0 {
+		// A single owner's listings, the viewer's own included
+		query += " AND b.owner_id = ?"
+		args = append(args, filter.OwnerID)
+	} else {
+		query += " AND b.owner_id != ?"
+		args = append(args, excludeUserID)
+	}
+
+	if filter.Genre != "" {
+		query += " AND b.genre = ?"
+		args = append(args, filter.Genre)
+	}
+	if filter.Condition != "" {
+		query += " AND b.condition = ?"
+		args = append(args, filter.Condition)
+	}
+	if filter.MinCondition != "" {
+		// Conditions are ranked from best to worst by their sort order
+		query += ` AND b.condition IN (
+			SELECT name FROM conditions WHERE sort_order <= (SELECT sort_order FROM conditions WHERE name = ?))`
+		args = append(args, filter.MinCondition)
+	}
+	if filter.City != "" {
+		query += " AND b.city = ?"
+		args = append(args, filter.City)
+	}
+	if filter.ListingType != "" {
+		query += " AND b.listing_type = ?"
+		args = append(args, filter.ListingType)
+	}
+	if filter.Author != "" {
+		query += " AND LOWER(b.author) LIKE ?"
+		args = append(args, "%"+strings.ToLower(filter.Author)+"%")
+	}
+	if filter.Since != "" {
+		query += " AND b.created_at >= ?"
+		args = append(args, filter.Since)
+	}
+	if filter.MinRating > 0 {
+		query += " AND (SELECT AVG(stars) FROM exchange_ratings WHERE ratee_id = b.owner_id) >= ?"
+		args = append(args, filter.MinRating)
+	}
+	detailsWhere, detailsArgs := bookDetailsWhere(filter.BookDetailsFilter)
+	query += detailsWhere
+	args = append(args, detailsArgs...)
+	if filter.AfterID > 0 {
+		query += " AND b.id > ?"
+		args = append(args, filter.AfterID)
+	}
+	if filter.UpToID > 0 {
+		query += " AND b.id <= ?"
+		args = append(args, filter.UpToID)
+	}
+	if filter.Origin != nil && filter.RadiusKm > 0 {
+		query += " AND " + distanceExpr + " <= ?"
+		args = append(args, filter.Origin.Latitude, filter.Origin.Longitude, filter.RadiusKm)
+	}
+	query += `
+		)`
+
+	if filter.Cursor != "" {
+		var cursor feedCursor
+		if err := decodeCursor(filter.Cursor, &cursor); err != nil {
+			return page, err
+		}
+		cmp := "<"
+		if order == "ASC" {
+			cmp = ">"
+		}
+		query += ` WHERE feed_rank > ? OR (feed_rank = ? AND (feed_key ` + cmp + ` ? OR (feed_key = ? AND id ` + cmp + ` ?)))`
+		args = append(args, cursor.Rank, cursor.Rank, cursor.Key, cursor.Key, cursor.ID)
+	}
+
+	// Fetch one extra row to know whether another page follows
+	query += ` ORDER BY feed_rank ASC, feed_key ` + order + `, id ` + order + ` LIMIT ?`
+	args = append(args, filter.Limit+1)
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return page, err
 	}
 	defer rows.Close()
 
-	var books []models.BookWithOwner
+	var last feedCursor
 	for rows.Next() {
 		var book models.BookWithOwner
-		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.City, &book.Available, &book.CreatedAt, &book.UpdatedAt, &book.OwnerName, &book.OwnerFirstName, &book.OwnerLastName, &book.OwnerAvatar, &book.OwnerCity); err == nil {
-			imgRows, _ := r.DB.Query(`
-				SELECT image_url FROM book_images WHERE book_id = ? ORDER BY order_index
-			`, book.ID)
-			var images []string
-			for imgRows.Next() {
-				var url string
-				if err := imgRows.Scan(&url); err == nil {
-					images = append(images, url)
-				}
+		var cursor feedCursor
+		var distance sql.NullFloat64
+		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.Language, &book.Format, &book.Publisher, &book.PublicationYear, &book.PageCount, &book.Series, &book.SeriesVolume, &book.City, &book.Available, &book.Status, &book.ListingType, &book.GiveawayPick, &book.WorkID, &book.CreatedAt, &book.UpdatedAt, &book.OwnerName, &book.OwnerFirstName, &book.OwnerLastName, &book.OwnerAvatar, &book.OwnerCity, &cursor.Rank, &cursor.Key, &distance); err != nil {
+			return page, err
+		}
+		book.DistanceKm = roundDistance(distance)
+		if len(page.Books) == filter.Limit {
+			page.NextCursor = encodeCursor(last)
+			break
+		}
+		cursor.ID = book.ID
+		last = cursor
+		page.Books = append(page.Books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	owners := map[int]*models.BookOwner{}
+	for i := range page.Books {
+		page.Books[i].Images = r.getBookImages(page.Books[i].ID)
+		ownerID := page.Books[i].OwnerID
+		if _, ok := owners[ownerID]; !ok {
+			owner, err := bookOwner(r.DB, ownerID)
+			switch {
+			case err == nil:
+				owners[ownerID] = &owner
+			case errors.Is(err, sql.ErrNoRows):
+				// The owner's account is gone
+				owners[ownerID] = nil
+			default:
+				return page, err
 			}
-			imgRows.Close()
-			book.Images = images
-			books = append(books, book)
 		}
+		page.Books[i].Owner = owners[ownerID]
 	}
-	return books, nil
+	return page, nil
+}
+
+// distanceExpr is the distance in km from an origin (two ? parameters: latitude,
+// longitude) to a book's city, NULL when the city has no coordinates. Queries
+// using it join the cities table as c.
+const distanceExpr = "haversine_km(?, ?, c.latitude, c.longitude)"
+
+// unknownDistanceRank sorts books whose distance is unknown after every other one
+const unknownDistanceRank = "1000000"
+
+// roundDistance keeps one decimal of a scanned distance, nil when it is NULL
+func roundDistance(d sql.NullFloat64) *float64 {
+	if !d.Valid {
+		return nil
+	}
+	km := math.Round(d.Float64*10) / 10
+	return &km
+}
+
+// getBookImages returns the image URLs of a book in display order
+func (r *BookRepository) getBookImages(bookID int) []string {
+	rows, err := r.DB.Query(`
+		SELECT image_url FROM book_images WHERE book_id = ? ORDER BY order_index
+	`, bookID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var images []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err == nil {
+			images = append(images, url)
+		}
+	}
+	return images
 }
 
 func (r *BookRepository) GetUserBooksWithOwner(userID int) ([]models.BookWithOwner, error) {
 	rows, err := r.DB.Query(`
-		SELECT b.id, b.owner_id, b.title, b.author, b.isbn, b.description, b.genre, b.condition, b.city, b.available, b.created_at, b.updated_at,
+		SELECT b.id, b.owner_id, b.title, b.author, b.isbn, b.description, b.genre, b.condition, b.language, b.format, b.publisher, b.publication_year, b.page_count, b.series, b.series_volume, b.city, b.available, b.status, b.listing_type, b.giveaway_pick, COALESCE(b.work_id, 0) as work_id, b.created_at, b.updated_at,
 		       COALESCE(u.first_name || ' ' || u.last_name, 'Unknown') as owner_name,
 		       COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
 		       COALESCE(u.avatar, ''), COALESCE(b.city, 'Unknown')
@@ -193,7 +458,7 @@ func (r *BookRepository) GetUserBooksWithOwner(userID int) ([]models.BookWithOwn
 	var books []models.BookWithOwner
 	for rows.Next() {
 		var book models.BookWithOwner
-		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.City, &book.Available, &book.CreatedAt, &book.UpdatedAt, &book.OwnerName, &book.OwnerFirstName, &book.OwnerLastName, &book.OwnerAvatar, &book.OwnerCity); err == nil {
+		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.Language, &book.Format, &book.Publisher, &book.PublicationYear, &book.PageCount, &book.Series, &book.SeriesVolume, &book.City, &book.Available, &book.Status, &book.ListingType, &book.GiveawayPick, &book.WorkID, &book.CreatedAt, &book.UpdatedAt, &book.OwnerName, &book.OwnerFirstName, &book.OwnerLastName, &book.OwnerAvatar, &book.OwnerCity); err == nil {
 			imgRows, _ := r.DB.Query(`
 				SELECT image_url FROM book_images WHERE book_id = ? ORDER BY order_index
 			`, book.ID)
@@ -213,18 +478,247 @@ func (r *BookRepository) GetUserBooksWithOwner(userID int) ([]models.BookWithOwn
 }
 
 func (r *BookRepository) UpdateBook(book models.Book) error {
-	_, err := r.DB.Exec(`
-		UPDATE books SET title = ?, author = ?, isbn = ?, description = ?, genre = ?, condition = ?, available = ?, updated_at = CURRENT_TIMESTAMP
+	workID, err := r.resolveWorkID(book.ID, book.Title, book.Author, book.ISBN)
+	if err != nil {
+		return err
+	}
+	return updateBookRow(r.DB, book, workID)
+}
+
+// UpdateBookAndStatus stores an edited listing and moves it to status to in one
+// transaction, like SetBookStatus, so neither change lands without the other
+func (r *BookRepository) UpdateBookAndStatus(book models.Book, from []string, to string, changedBy int, reason string) error {
+	workID, err := r.resolveWorkID(book.ID, book.Title, book.Author, book.ISBN)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setBookStatus(tx, book.ID, from, to, changedBy, reason); err != nil {
+		return err
+	}
+	if err := updateBookRow(tx, book, workID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateBookRow(db dbtx, book models.Book, workID int) error {
+	_, err := db.Exec(`
+		UPDATE books SET title = ?, author = ?, isbn = ?, description = ?, genre = ?, condition = ?,
+			language = ?, format = ?, publisher = ?, publication_year = ?, page_count = ?, series = ?, series_volume = ?,
+			city = ?, listing_type = ?, giveaway_pick = ?, work_id = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
-	`, book.Title, book.Author, book.ISBN, book.Description, book.Genre, book.Condition, book.Available, book.ID)
+	`, book.Title, book.Author, book.ISBN, book.Description, book.Genre, book.Condition,
+		book.Language, book.Format, book.Publisher, book.PublicationYear, book.PageCount, book.Series, book.SeriesVolume,
+		book.City, book.ListingType, book.GiveawayPick, workID, book.ID)
 	return err
 }
 
-func (r *BookRepository) DeleteBook(bookID int) error {
-	_, err := r.DB.Exec(`DELETE FROM books WHERE id = ?`, bookID)
+// ErrBookStatusConflict is returned when a book is not in a status the change may start from
+var ErrBookStatusConflict = errors.New("book status does not allow this change")
+
+// dbtx is implemented by both *sql.DB and *sql.Tx, so helpers can run inside a
+// caller's transaction
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// setBookStatus moves a book to status to and records the change. When from is
+// not empty the book must currently be in one of those statuses, otherwise
+// ErrBookStatusConflict is returned. changedBy 0 means the system.
+func setBookStatus(db dbtx, bookID int, from []string, to string, changedBy int, reason string) error {
+	var current string
+	if err := db.QueryRow(`SELECT status FROM books WHERE id = ?`, bookID).Scan(&current); err != nil {
+		return err
+	}
+	if len(from) > 0 && !containsString(from, current) {
+		return ErrBookStatusConflict
+	}
+	if current == to {
+		return nil
+	}
+
+	// The status guard keeps a concurrent change from being overwritten
+	res, err := db.Exec(`
+		UPDATE books SET status = ?, available = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?
+	`, to, to == models.BookStatusListed, bookID, current)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrBookStatusConflict
+	}
+	return recordBookStatus(db, bookID, current, to, changedBy, reason)
+}
+
+func recordBookStatus(db dbtx, bookID int, from, to string, changedBy int, reason string) error {
+	_, err := db.Exec(`
+		INSERT INTO book_status_history (book_id, from_status, to_status, changed_by, reason) VALUES (?, ?, ?, ?, ?)
+	`, bookID, from, to, changedBy, reason)
 	return err
 }
 
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBookStatus changes the status of a book in its own transaction, see setBookStatus
+func (r *BookRepository) SetBookStatus(bookID int, from []string, to string, changedBy int, reason string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setBookStatus(tx, bookID, from, to, changedBy, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetStatusHistory returns the status changes of a book, oldest first
+func (r *BookRepository) GetStatusHistory(bookID int) ([]models.BookStatusChange, error) {
+	rows, err := r.DB.Query(`
+		SELECT h.id, h.book_id, h.from_status, h.to_status, h.changed_by,
+		       COALESCE(u.first_name || ' ' || u.last_name, ''), h.reason, h.created_at
+		FROM book_status_history h
+		LEFT JOIN users u ON u.id = h.changed_by
+		WHERE h.book_id = ?
+		ORDER BY h.id
+	`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.BookStatusChange{}
+	for rows.Next() {
+		var c models.BookStatusChange
+		if err := rows.Scan(&c.ID, &c.BookID, &c.FromStatus, &c.ToStatus, &c.ChangedBy, &c.ChangedByName, &c.Reason, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
+
+// GetWork returns a work with its listed copies and how many cities they are spread across
+func (r *BookRepository) GetWork(workID int) (models.Work, error) {
+	work := models.Work{ISBNs: []string{}, Cities: []string{}, Books: []models.BookWithOwner{}}
+	err := r.DB.QueryRow(`SELECT id, title, author FROM works WHERE id = ?`, workID).Scan(&work.ID, &work.Title, &work.Author)
+	if err != nil {
+		return work, err
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT b.id, b.owner_id, b.title, b.author, b.isbn, b.description, b.genre, b.condition, b.language, b.format, b.publisher, b.publication_year, b.page_count, b.series, b.series_volume, b.city, b.available, b.status, b.listing_type, b.giveaway_pick, COALESCE(b.work_id, 0), b.created_at, b.updated_at,
+		       COALESCE(u.first_name || ' ' || u.last_name, 'Unknown') as owner_name,
+		       COALESCE(u.first_name, ''), COALESCE(u.last_name, ''),
+		       COALESCE(u.avatar, ''), COALESCE(b.city, 'Unknown')
+		FROM books b
+		LEFT JOIN users u ON b.owner_id = u.id
+		WHERE b.work_id = ? AND b.status = 'listed'
+		ORDER BY b.created_at DESC
+	`, workID)
+	if err != nil {
+		return work, err
+	}
+	defer rows.Close()
+
+	seenISBN := map[string]bool{}
+	seenCity := map[string]bool{}
+	for rows.Next() {
+		var book models.BookWithOwner
+		if err := rows.Scan(&book.ID, &book.OwnerID, &book.Title, &book.Author, &book.ISBN, &book.Description, &book.Genre, &book.Condition, &book.Language, &book.Format, &book.Publisher, &book.PublicationYear, &book.PageCount, &book.Series, &book.SeriesVolume, &book.City, &book.Available, &book.Status, &book.ListingType, &book.GiveawayPick, &book.WorkID, &book.CreatedAt, &book.UpdatedAt, &book.OwnerName, &book.OwnerFirstName, &book.OwnerLastName, &book.OwnerAvatar, &book.OwnerCity); err != nil {
+			return work, err
+		}
+		if book.ISBN != "" && !seenISBN[book.ISBN] {
+			seenISBN[book.ISBN] = true
+			work.ISBNs = append(work.ISBNs, book.ISBN)
+		}
+		if !seenCity[book.City] {
+			seenCity[book.City] = true
+			work.Cities = append(work.Cities, book.City)
+		}
+		work.Books = append(work.Books, book)
+	}
+	if err := rows.Err(); err != nil {
+		return work, err
+	}
+
+	for i := range work.Books {
+		work.Books[i].Images = r.getBookImages(work.Books[i].ID)
+	}
+	work.CopiesAvailable = len(work.Books)
+	work.CityCount = len(work.Cities)
+	return work, nil
+}
+
+// ErrBookOnLoan is returned when a book with an approved or active loan is deleted
+var ErrBookOnLoan = errors.New("book has an approved or active loan")
+
+// DeleteBook deletes a book and its image rows (foreign keys are not enforced,
+// so images are removed explicitly). Only a listed or archived book can go: one held
+// by an exchange returns ErrBookStatusConflict, or the exchange could not move on, and
+// one promised or lent to a borrower returns ErrBookOnLoan.
+func (r *BookRepository) DeleteBook(bookID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM books WHERE id = ?`, bookID).Scan(&status); err != nil {
+		return err
+	}
+	if status != models.BookStatusListed && status != models.BookStatusArchived {
+		return ErrBookStatusConflict
+	}
+	var loans int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM book_loans WHERE book_id = ? AND status IN ('approved', 'active')
+	`, bookID).Scan(&loans); err != nil {
+		return err
+	}
+	if loans > 0 {
+		return ErrBookOnLoan
+	}
+
+	if _, err := tx.Exec(`DELETE FROM book_images WHERE book_id = ?`, bookID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM book_status_history WHERE book_id = ?`, bookID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM book_loans WHERE book_id = ?`, bookID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM giveaway_claims WHERE book_id = ?`, bookID); err != nil {
+		return err
+	}
+	if err := deleteExchangeRules(tx, `book_id = ?`, bookID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM books WHERE id = ?`, bookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (r *BookRepository) AddImage(bookID int, imageURL string, isPrimary bool) error {
 	_, err := r.DB.Exec(`
 		INSERT INTO book_images (book_id, image_url, is_primary, order_index)
@@ -238,189 +732,285 @@ func (r *BookRepository) RemoveImage(imageID int) error {
 	return err
 }
 
-func (r *BookRepository) CreateExchangeRequest(bookID, offeredBookID, requesterID int) (int, bool, error) {
-	// Validate target book availability and ownership
-	var targetOwner int
-	var targetAvailable bool
-	err := r.DB.QueryRow(`SELECT owner_id, available FROM books WHERE id = ?`, bookID).Scan(&targetOwner, &targetAvailable)
+// MaxBookID returns the highest listing ID, 0 when there are none
+func (r *BookRepository) MaxBookID() (int, error) {
+	var id int
+	err := r.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM books`).Scan(&id)
+	return id, err
+}
+
+// ImageURLInUse reports whether any book image still points at url
+func (r *BookRepository) ImageURLInUse(url string) (bool, error) {
+	var n int
+	err := r.DB.QueryRow(`SELECT COUNT(*) FROM book_images WHERE image_url = ?`, url).Scan(&n)
+	return n > 0, err
+}
+
+// GetImages returns the image rows of a book in display order
+func (r *BookRepository) GetImages(bookID int) ([]models.BookImage, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, book_id, image_url, COALESCE(is_primary, 0), COALESCE(order_index, 0)
+		FROM book_images WHERE book_id = ? ORDER BY order_index, id
+	`, bookID)
 	if err != nil {
-		return 0, false, err
-	}
-	if targetOwner == requesterID {
-		return 0, false, fmt.Errorf("cannot exchange with your own book")
+		return nil, err
 	}
-	if !targetAvailable {
-		return 0, false, fmt.Errorf("target book not available")
+	defer rows.Close()
+
+	images := []models.BookImage{}
+	for rows.Next() {
+		var img models.BookImage
+		if err := rows.Scan(&img.ID, &img.BookID, &img.ImageURL, &img.IsPrimary, &img.Order); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
 	}
+	return images, rows.Err()
+}
 
-	// Validate offered book belongs to requester and is available
-	var offeredOwner int
-	var offeredAvailable bool
-	err = r.DB.QueryRow(`SELECT owner_id, available FROM books WHERE id = ?`, offeredBookID).Scan(&offeredOwner, &offeredAvailable)
+// SetImageOrder renumbers the images of a book following imageIDs, which must list
+// every image of the book exactly once. The first image becomes the primary one, so
+// the primary image is always the one shown first.
+func (r *BookRepository) SetImageOrder(bookID int, imageIDs []int) error {
+	images, err := r.GetImages(bookID)
 	if err != nil {
-		return 0, false, err
+		return err
 	}
-	if offeredOwner != requesterID {
-		return 0, false, fmt.Errorf("offered book does not belong to requester")
+	if len(images) != len(imageIDs) {
+		return ErrImageOrderMismatch
 	}
-	if !offeredAvailable {
-		return 0, false, fmt.Errorf("offered book not available")
+	belongs := map[int]bool{}
+	for _, img := range images {
+		belongs[img.ID] = true
 	}
-
-	// Avoid duplicate pending request for same pair
-	var existing int
-	_ = r.DB.QueryRow(`
-		SELECT id FROM book_exchanges 
-		WHERE book_id = ? AND offered_book_id = ? AND requester_id = ? AND status = 'pending'
-	`, bookID, offeredBookID, requesterID).Scan(&existing)
-	if existing != 0 {
-		return existing, false, nil // Not a new request
+	for _, id := range imageIDs {
+		if !belongs[id] {
+			return ErrImageOrderMismatch
+		}
+		delete(belongs, id) // a repeated ID fails on its second occurrence
 	}
 
-	res, err := r.DB.Exec(`
-		INSERT INTO book_exchanges (book_id, offered_book_id, requester_id, status)
-		VALUES (?, ?, ?, 'pending')
-	`, bookID, offeredBookID, requesterID)
+	tx, err := r.DB.Begin()
 	if err != nil {
-		return 0, false, err
+		return err
 	}
-	id, _ := res.LastInsertId()
-	return int(id), true, nil // New request created
+	defer tx.Rollback()
+
+	if err := writeImageOrder(tx, bookID, imageIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *BookRepository) SearchBooks(query string) ([]models.BookSearchResult, error) {
-	search := "%" + strings.ToLower(query) + "%"
-	rows, err := r.DB.Query(`
-		SELECT b.id, b.title, b.author, b.genre, b.city,
-		       (SELECT image_url FROM book_images WHERE book_id = b.id ORDER BY order_index LIMIT 1) as image
-		FROM books b
-		WHERE b.available = 1 AND (LOWER(b.title) LIKE ? OR LOWER(b.author) LIKE ?)
-		ORDER BY b.created_at DESC
-		LIMIT 10
-	`, search, search)
+// RemoveBookImage deletes one image of a book and renumbers the remaining ones in
+// their current order, in a single transaction
+func (r *BookRepository) RemoveBookImage(bookID, imageID int) error {
+	tx, err := r.DB.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var results []models.BookSearchResult
+	if _, err := tx.Exec(`DELETE FROM book_images WHERE id = ? AND book_id = ?`, imageID, bookID); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT id FROM book_images WHERE book_id = ? ORDER BY order_index, id`, bookID)
+	if err != nil {
+		return err
+	}
+	remaining := []int{}
 	for rows.Next() {
-		var book models.BookSearchResult
-		var image sql.NullString
-		if err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Genre, &book.City, &image); err != nil {
-			continue
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
 		}
-		if image.Valid {
-			book.Image = image.String
+		remaining = append(remaining, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := writeImageOrder(tx, bookID, remaining); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeImageOrder numbers imageIDs from 0 and makes the first one primary
+func writeImageOrder(db dbtx, bookID int, imageIDs []int) error {
+	for i, id := range imageIDs {
+		if _, err := db.Exec(`
+			UPDATE book_images SET order_index = ?, is_primary = ? WHERE id = ? AND book_id = ?
+		`, i, i == 0, id, bookID); err != nil {
+			return err
 		}
-		results = append(results, book)
 	}
-	return results, nil
+	return nil
 }
 
-// GetUserExchangeRequests returns all exchange requests for a user (both incoming and outgoing)
-func (r *BookRepository) GetUserExchangeRequests(userID int) ([]models.BookExchangeRequest, error) {
+// ErrImageOrderMismatch is returned when a new image order does not list exactly the book's images
+var ErrImageOrderMismatch = errors.New("image order must list every image of the book once")
+
+// SearchBooks runs a ranked full-text search over listed books using the books_fts index.
+// The index holds normalized text (see utils.NormalizeText), so the query is normalized the
+// same way. Title and ISBN matches weigh more than author, genre and description matches.
+// With an origin, results are ordered by whole kilometres of distance first.
+func (r *BookRepository) SearchBooks(filter models.BookSearchFilter) (models.BookSearchPage, error) {
+	page := models.BookSearchPage{Results: []models.BookSearchResult{}}
+
+	terms := searchQueryTerms(filter.Query)
+	if len(terms) == 0 {
+		return page, nil
+	}
+
+	var c searchCursor
+	if filter.Cursor != "" {
+		if err := decodeCursor(filter.Cursor, &c); err != nil {
+			return page, err
+		}
+	}
+
+	distExpr := "NULL"
+	selectArgs := []interface{}{}
+	orderBy := "bm25(books_fts, 10.0, 6.0, 1.0, 2.0, 10.0), b.id"
+	args := []interface{}{buildFTSQuery(terms)}
+	where := "books_fts MATCH ? AND b.status = 'listed'"
+	if filter.Origin != nil {
+		distExpr = distanceExpr
+		selectArgs = append(selectArgs, filter.Origin.Latitude, filter.Origin.Longitude)
+		orderBy = "COALESCE(CAST(ROUND(distance_km) AS INTEGER), " + unknownDistanceRank + "), " + orderBy
+		if filter.RadiusKm > 0 {
+			where += " AND " + distanceExpr + " <= ?"
+			args = append(args, filter.Origin.Latitude, filter.Origin.Longitude, filter.RadiusKm)
+		}
+	}
+	detailsWhere, detailsArgs := bookDetailsWhere(filter.BookDetailsFilter)
+	where += detailsWhere
+	args = append(args, detailsArgs...)
+	args = append(selectArgs, append(args, filter.Limit+1, c.Offset)...)
+
 	rows, err := r.DB.Query(`
-		SELECT 
-			e.id,
-			e.book_id,
-			b.title as book_title,
-			b.author as book_author,
-			(SELECT image_url FROM book_images WHERE book_id = b.id ORDER BY order_index LIMIT 1) as book_image,
-			e.offered_book_id,
-			ob.title as offered_title,
-			ob.author as offered_author,
-			(SELECT image_url FROM book_images WHERE book_id = ob.id ORDER BY order_index LIMIT 1) as offered_image,
-			e.requester_id,
-			COALESCE(ru.first_name || ' ' || ru.last_name, '') as requester_name,
-			COALESCE(ru.avatar, '') as requester_avatar,
-			b.owner_id,
-			COALESCE(ou.first_name || ' ' || ou.last_name, '') as owner_name,
-			COALESCE(ou.avatar, '') as owner_avatar,
-			e.status,
-			e.created_at
-		FROM book_exchanges e
-		JOIN books b ON e.book_id = b.id
-		JOIN books ob ON e.offered_book_id = ob.id
-		JOIN users ru ON e.requester_id = ru.id
-		JOIN users ou ON b.owner_id = ou.id
-		WHERE e.requester_id = ? OR b.owner_id = ?
-		ORDER BY e.created_at DESC
-	`, userID, userID)
+		SELECT b.id, b.title, b.author, COALESCE(b.genre, ''),
+		       b.language, b.format, b.publisher, b.publication_year, b.page_count, b.series, b.series_volume, b.city,
+		       (SELECT image_url FROM book_images WHERE book_id = b.id ORDER BY order_index LIMIT 1) as image,
+		       COALESCE(b.description, ''), COALESCE(b.isbn, ''), `+distExpr+` as distance_km
+		FROM books_fts
+		JOIN books b ON b.id = books_fts.rowid
+		LEFT JOIN cities c ON c.name = b.city
+		WHERE `+where+`
+		ORDER BY `+orderBy+`
+		LIMIT ? OFFSET ?
+	`, args...)
 	if err != nil {
-		return nil, err
+		return page, err
 	}
 	defer rows.Close()
 
-	var requests []models.BookExchangeRequest
 	for rows.Next() {
-		var req models.BookExchangeRequest
-		var bookImage, offeredImage sql.NullString
-		if err := rows.Scan(
-			&req.ID,
-			&req.BookID,
-			&req.BookTitle,
-			&req.BookAuthor,
-			&bookImage,
-			&req.OfferedBookID,
-			&req.OfferedTitle,
-			&req.OfferedAuthor,
-			&offeredImage,
-			&req.RequesterID,
-			&req.RequesterName,
-			&req.RequesterAvatar,
-			&req.OwnerID,
-			&req.OwnerName,
-			&req.OwnerAvatar,
-			&req.Status,
-			&req.CreatedAt,
-		); err != nil {
-			continue
+		var book models.BookSearchResult
+		var image sql.NullString
+		var description, isbn string
+		var distance sql.NullFloat64
+		if err := rows.Scan(&book.ID, &book.Title, &book.Author, &book.Genre,
+			&book.Language, &book.Format, &book.Publisher, &book.PublicationYear, &book.PageCount, &book.Series, &book.SeriesVolume,
+			&book.City, &image, &description, &isbn, &distance); err != nil {
+			return page, err
 		}
-		if bookImage.Valid {
-			req.BookImage = bookImage.String
+		if len(page.Results) == filter.Limit {
+			page.NextCursor = encodeCursor(searchCursor{Offset: c.Offset + filter.Limit})
+			break
 		}
-		if offeredImage.Valid {
-			req.OfferedImage = offeredImage.String
+		book.DistanceKm = roundDistance(distance)
+		if image.Valid {
+			book.Image = image.String
+		}
+		// Highlight the original text (not the normalized copy in the index),
+		// trying the fields in the same order as their ranking weight
+		for _, field := range []string{book.Title, isbn, book.Author, book.Genre, description} {
+			if snippet, ok := utils.HighlightSnippet(field, terms, 12); ok {
+				book.Snippet = snippet
+				break
+			}
 		}
-		req.IsIncoming = req.OwnerID == userID
-		requests = append(requests, req)
+		page.Results = append(page.Results, book)
 	}
-	return requests, nil
+	return page, rows.Err()
 }
 
-// UpdateExchangeStatus updates the status of an exchange request
-func (r *BookRepository) UpdateExchangeStatus(exchangeID, userID int, status string) error {
-	// Verify the user is the owner of the requested book
-	var ownerID int
-	err := r.DB.QueryRow(`
-		SELECT b.owner_id 
-		FROM book_exchanges e
-		JOIN books b ON e.book_id = b.id
-		WHERE e.id = ?
-	`, exchangeID).Scan(&ownerID)
-	if err != nil {
-		return err
+// bookDetailsWhere returns the conditions, each starting with AND, and arguments of
+// the bibliographic filters on books aliased b
+func bookDetailsWhere(filter models.BookDetailsFilter) (string, []interface{}) {
+	where := ""
+	args := []interface{}{}
+	if filter.Language != "" {
+		where += " AND b.language = ?"
+		args = append(args, filter.Language)
 	}
-	if ownerID != userID {
-		return fmt.Errorf("unauthorized: only book owner can update exchange status")
+	if filter.Format != "" {
+		where += " AND b.format = ?"
+		args = append(args, filter.Format)
 	}
-
-	_, err = r.DB.Exec(`UPDATE book_exchanges SET status = ? WHERE id = ?`, status, exchangeID)
-	return err
+	if filter.Publisher != "" {
+		where += " AND LOWER(b.publisher) LIKE ?"
+		args = append(args, "%"+strings.ToLower(filter.Publisher)+"%")
+	}
+	if filter.Series != "" {
+		where += " AND LOWER(b.series) LIKE ?"
+		args = append(args, "%"+strings.ToLower(filter.Series)+"%")
+	}
+	if filter.YearFrom > 0 {
+		where += " AND b.publication_year >= ?"
+		args = append(args, filter.YearFrom)
+	}
+	if filter.YearTo > 0 {
+		where += " AND b.publication_year > 0 AND b.publication_year <= ?"
+		args = append(args, filter.YearTo)
+	}
+	return where, args
 }
 
-// CancelExchangeRequest cancels an exchange request (only requester can cancel)
-func (r *BookRepository) CancelExchangeRequest(exchangeID, userID int) error {
-	var requesterID int
-	err := r.DB.QueryRow(`SELECT requester_id FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&requesterID)
-	if err != nil {
-		return err
+// searchQueryTerms splits a search query into normalized terms. Hyphens inside
+// ISBN-like words (digits, hyphens and X only) are dropped first so "978-2-07"
+// stays one term and matches "978207...".
+func searchQueryTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		if isISBNLike(word) {
+			// Listings store ISBN-13, so an ISBN-10 query is converted first
+			if isbn, err := utils.NormalizeISBN(word); err == nil {
+				word = isbn
+			} else {
+				word = strings.ReplaceAll(word, "-", "")
+			}
+		}
+		terms = append(terms, utils.SearchTerms(word)...)
 	}
-	if requesterID != userID {
-		return fmt.Errorf("unauthorized: only requester can cancel")
+	return terms
+}
+
+// buildFTSQuery turns search terms into an FTS5 MATCH expression where every term
+// is a quoted prefix, e.g. [harry pott] becomes `"harry"* "pott"*`. Terms only hold
+// letters and digits, so FTS5 operators and quotes never reach the expression.
+func buildFTSQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
 	}
+	return strings.Join(quoted, " ")
+}
 
-	_, err = r.DB.Exec(`UPDATE book_exchanges SET status = 'cancelled' WHERE id = ?`, exchangeID)
-	return err
+func isISBNLike(word string) bool {
+	digits := 0
+	for _, c := range word {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '-' || c == 'x' || c == 'X':
+		default:
+			return false
+		}
+	}
+	return digits > 0
 }
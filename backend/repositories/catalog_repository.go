@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"ktabnet/models"
+)
+
+type CatalogRepository struct {
+	DB *sql.DB
+}
+
+func NewCatalogRepository(db *sql.DB) *CatalogRepository {
+	return &CatalogRepository{DB: db}
+}
+
+// GetByISBN returns the catalog entry for an ISBN, or sql.ErrNoRows
+func (r *CatalogRepository) GetByISBN(isbn string) (*models.BookMetadata, error) {
+	var meta models.BookMetadata
+	err := r.DB.QueryRow(`
+		SELECT isbn, title, COALESCE(author, ''), COALESCE(description, ''), COALESCE(genre, ''),
+		       COALESCE(language, ''), COALESCE(cover_url, ''), source
+		FROM book_catalog WHERE isbn = ?
+	`, isbn).Scan(&meta.ISBN, &meta.Title, &meta.Author, &meta.Description, &meta.Genre, &meta.Language, &meta.CoverURL, &meta.Source)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Upsert inserts or replaces the catalog entry for meta.ISBN
+func (r *CatalogRepository) Upsert(meta models.BookMetadata) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO book_catalog (isbn, title, author, description, genre, language, cover_url, source, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(isbn) DO UPDATE SET
+			title = excluded.title,
+			author = excluded.author,
+			description = excluded.description,
+			genre = excluded.genre,
+			language = excluded.language,
+			cover_url = excluded.cover_url,
+			source = excluded.source,
+			updated_at = CURRENT_TIMESTAMP
+	`, meta.ISBN, meta.Title, meta.Author, meta.Description, meta.Genre, meta.Language, meta.CoverURL, meta.Source)
+	return err
+}
@@ -0,0 +1,1193 @@
+package repositories
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"ktabnet/models"
+)
+
+var (
+	// ErrExchangeTransition is returned when an exchange is not in a status that allows a change
+	ErrExchangeTransition = errors.New("exchange status does not allow this change")
+	// ErrMeetupNotStarted is returned when a no-show is reported before the agreed meetup time
+	ErrMeetupNotStarted = errors.New("the meetup has not started yet")
+	// ErrOfferBookInvalid is returned when a counter-offer names a book the requester cannot give
+	ErrOfferBookInvalid = errors.New("the offered books must be listed books of the requester")
+	// ErrOfferDuplicate is returned when the requester already has a pending request for the same bundles
+	ErrOfferDuplicate = errors.New("the same bundles are already offered in another pending request")
+	// ErrHandoffCode is returned when a handoff code does not match
+	ErrHandoffCode = errors.New("wrong handoff code")
+	// ErrHandoffCodeReplaced is returned when a wrong handoff code used up the attempts and was replaced
+	ErrHandoffCodeReplaced = errors.New("handoff code replaced after too many wrong attempts")
+	// ErrHandoffVerified is returned when a handoff code was already verified
+	ErrHandoffVerified = errors.New("handoff code already verified")
+	// ErrOwnExchangeBook is returned when a user requests their own book
+	ErrOwnExchangeBook = errors.New("cannot exchange with your own book")
+	// ErrMixedOwners is returned when the requested books belong to different owners
+	ErrMixedOwners = errors.New("requested books must belong to the same owner")
+	// ErrOfferedNotOwned is returned when an offered book does not belong to the requester
+	ErrOfferedNotOwned = errors.New("offered book does not belong to requester")
+	// ErrNotForExchange is returned when a book of either bundle is not listed for exchange
+	ErrNotForExchange = errors.New("book is not listed for exchange")
+	// ErrExchangeBookUnavailable is returned when a book of either bundle is no longer listed
+	ErrExchangeBookUnavailable = errors.New("book not available")
+)
+
+type ExchangeRepository struct {
+	DB *sql.DB
+}
+
+func NewExchangeRepository(db *sql.DB) *ExchangeRepository {
+	return &ExchangeRepository{DB: db}
+}
+
+// MissingBook returns the first of ids that is not a book, 0 when they all exist
+func (r *ExchangeRepository) MissingBook(ids []int) (int, error) {
+	for _, id := range ids {
+		var n int
+		if err := r.DB.QueryRow(`SELECT COUNT(*) FROM books WHERE id = ?`, id).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+// Create adds a pending exchange request for the bundle bookIDs, all of one owner, against
+// the bundle offeredBookIDs of the requester. When the same request is already pending its
+// id is returned with false.
+func (r *ExchangeRepository) Create(bookIDs, offeredBookIDs []int, requesterID int) (int, bool, error) {
+	// The checks read through the transaction so the books cannot change before the insert
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	// Validate target books availability and ownership
+	targetOwner := 0
+	for _, bookID := range bookIDs {
+		var owner int
+		var status, listingType string
+		err := tx.QueryRow(`SELECT owner_id, status, listing_type FROM books WHERE id = ?`, bookID).Scan(&owner, &status, &listingType)
+		if err != nil {
+			return 0, false, err
+		}
+		if owner == requesterID {
+			return 0, false, ErrOwnExchangeBook
+		}
+		if targetOwner != 0 && owner != targetOwner {
+			return 0, false, ErrMixedOwners
+		}
+		if listingType != models.ListingTypeExchange {
+			return 0, false, fmt.Errorf("requested %w", ErrNotForExchange)
+		}
+		if status != models.BookStatusListed {
+			return 0, false, fmt.Errorf("requested %w (%s)", ErrExchangeBookUnavailable, status)
+		}
+		targetOwner = owner
+	}
+
+	// Validate offered books belong to requester and are available
+	for _, bookID := range offeredBookIDs {
+		var owner int
+		var status, listingType string
+		err := tx.QueryRow(`SELECT owner_id, status, listing_type FROM books WHERE id = ?`, bookID).Scan(&owner, &status, &listingType)
+		if err != nil {
+			return 0, false, err
+		}
+		if owner != requesterID {
+			return 0, false, ErrOfferedNotOwned
+		}
+		if listingType != models.ListingTypeExchange {
+			return 0, false, fmt.Errorf("offered %w", ErrNotForExchange)
+		}
+		if status != models.BookStatusListed {
+			return 0, false, fmt.Errorf("offered %w (%s)", ErrExchangeBookUnavailable, status)
+		}
+	}
+
+	// Avoid duplicate pending request for the same bundles
+	existing, err := findPendingBundle(tx, 0, requesterID, bookIDs, offeredBookIDs)
+	if err != nil {
+		return 0, false, err
+	}
+	if existing != 0 {
+		return existing, false, nil // Not a new request
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO book_exchanges (book_id, offered_book_id, requester_id, owner_id, status)
+		VALUES (?, ?, ?, ?, 'pending')
+	`, bookIDs[0], offeredBookIDs[0], requesterID, targetOwner)
+	if err != nil {
+		return 0, false, err
+	}
+	id64, _ := res.LastInsertId()
+	id := int(id64)
+	if err := insertExchangeItems(tx, id, models.ExchangeSideRequested, bookIDs); err != nil {
+		return 0, false, err
+	}
+	if err := insertExchangeItems(tx, id, models.ExchangeSideOffered, offeredBookIDs); err != nil {
+		return 0, false, err
+	}
+	if _, err := insertOffer(tx, id, requesterID, offeredBookIDs, ""); err != nil {
+		return 0, false, err
+	}
+	if err := recordExchangeEvent(tx, id, "", models.ExchangeStatusPending, requesterID, ""); err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil // New request created
+}
+
+func insertExchangeItems(db dbtx, exchangeID int, side string, bookIDs []int) error {
+	for i, bookID := range bookIDs {
+		if _, err := db.Exec(`
+			INSERT INTO book_exchange_items (exchange_id, book_id, side, position) VALUES (?, ?, ?, ?)
+		`, exchangeID, bookID, side, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertOffer adds an open offer with its books and returns its id
+func insertOffer(db dbtx, exchangeID, proposedBy int, bookIDs []int, message string) (int, error) {
+	res, err := db.Exec(`
+		INSERT INTO book_exchange_offers (exchange_id, proposed_by, offered_book_id, message) VALUES (?, ?, ?, ?)
+	`, exchangeID, proposedBy, bookIDs[0], message)
+	if err != nil {
+		return 0, err
+	}
+	offerID, _ := res.LastInsertId()
+	for i, bookID := range bookIDs {
+		if _, err := db.Exec(`
+			INSERT INTO book_exchange_offer_books (offer_id, book_id, position) VALUES (?, ?, ?)
+		`, offerID, bookID, i); err != nil {
+			return 0, err
+		}
+	}
+	return int(offerID), nil
+}
+
+// exchangeItems returns the requested and the offered books of an exchange
+func exchangeItems(db dbtx, exchangeID int) ([]int, []int, error) {
+	rows, err := db.Query(`
+		SELECT book_id, side FROM book_exchange_items WHERE exchange_id = ? ORDER BY position, book_id
+	`, exchangeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var requested, offered []int
+	for rows.Next() {
+		var bookID int
+		var side string
+		if err := rows.Scan(&bookID, &side); err != nil {
+			return nil, nil, err
+		}
+		if side == models.ExchangeSideRequested {
+			requested = append(requested, bookID)
+		} else {
+			offered = append(offered, bookID)
+		}
+	}
+	return requested, offered, rows.Err()
+}
+
+// findPendingBundle returns a pending exchange of the requester, other than exceptID, that
+// trades exactly these bundles, or 0
+func findPendingBundle(db dbtx, exceptID, requesterID int, bookIDs, offeredBookIDs []int) (int, error) {
+	rows, err := db.Query(`
+		SELECT id FROM book_exchanges WHERE requester_id = ? AND status = 'pending' AND id != ?
+	`, requesterID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	var candidates []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		candidates = append(candidates, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range candidates {
+		requested, offered, err := exchangeItems(db, id)
+		if err != nil {
+			return 0, err
+		}
+		if sameIDs(requested, bookIDs) && sameIDs(offered, offeredBookIDs) {
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+// sameIDs reports whether two lists hold the same ids, in any order
+func sameIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[int]int{}
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		if seen[id] == 0 {
+			return false
+		}
+		seen[id]--
+	}
+	return true
+}
+
+// exchangeSelect reads exchanges with both books and both parties. The owner is the one
+// stored on the exchange, as completing it hands the requested book to the requester.
+const exchangeSelect = `
+	SELECT
+		e.id,
+		e.book_id,
+		COALESCE(b.title, '') as book_title,
+		COALESCE(b.author, '') as book_author,
+		(SELECT image_url FROM book_images WHERE book_id = b.id ORDER BY order_index LIMIT 1) as book_image,
+		e.offered_book_id,
+		COALESCE(ob.title, '') as offered_title,
+		COALESCE(ob.author, '') as offered_author,
+		(SELECT image_url FROM book_images WHERE book_id = ob.id ORDER BY order_index LIMIT 1) as offered_image,
+		e.requester_id,
+		COALESCE(ru.first_name || ' ' || ru.last_name, '') as requester_name,
+		COALESCE(ru.avatar, '') as requester_avatar,
+		e.owner_id,
+		COALESCE(ou.first_name || ' ' || ou.last_name, '') as owner_name,
+		COALESCE(ou.avatar, '') as owner_avatar,
+		e.status,
+		e.created_at,
+		e.updated_at,
+		e.completed_at,
+		e.owner_confirmed_at IS NOT NULL,
+		e.requester_confirmed_at IS NOT NULL,
+		e.dispute_reason,
+		COALESCE((SELECT proposed_by FROM book_exchange_offers WHERE exchange_id = e.id ORDER BY id DESC LIMIT 1), e.requester_id),
+		COALESCE(e.no_show_user_id, 0)
+	FROM book_exchanges e
+	LEFT JOIN books b ON e.book_id = b.id
+	LEFT JOIN books ob ON e.offered_book_id = ob.id
+	JOIN users ru ON e.requester_id = ru.id
+	JOIN users ou ON e.owner_id = ou.id
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExchange(row rowScanner) (models.BookExchangeRequest, error) {
+	var req models.BookExchangeRequest
+	var bookImage, offeredImage, completedAt sql.NullString
+	err := row.Scan(
+		&req.ID,
+		&req.BookID,
+		&req.BookTitle,
+		&req.BookAuthor,
+		&bookImage,
+		&req.OfferedBookID,
+		&req.OfferedTitle,
+		&req.OfferedAuthor,
+		&offeredImage,
+		&req.RequesterID,
+		&req.RequesterName,
+		&req.RequesterAvatar,
+		&req.OwnerID,
+		&req.OwnerName,
+		&req.OwnerAvatar,
+		&req.Status,
+		&req.CreatedAt,
+		&req.UpdatedAt,
+		&completedAt,
+		&req.OwnerConfirmed,
+		&req.RequesterConfirmed,
+		&req.DisputeReason,
+		&req.LastOfferBy,
+		&req.NoShowUserID,
+	)
+	if bookImage.Valid {
+		req.BookImage = bookImage.String
+	}
+	if offeredImage.Valid {
+		req.OfferedImage = offeredImage.String
+	}
+	req.CompletedAt = completedAt.String
+	return req, err
+}
+
+// exchangeBooksSelect reads the bundles of exchanges, ordered for loadExchangeBooks; a
+// deleted book keeps its id with an empty title so the history stays readable
+const exchangeBooksSelect = `
+	SELECT i.exchange_id, i.side, i.book_id, COALESCE(b.title, ''), COALESCE(b.author, ''),
+	       (SELECT image_url FROM book_images WHERE book_id = i.book_id ORDER BY order_index LIMIT 1)
+	FROM book_exchange_items i
+	LEFT JOIN books b ON b.id = i.book_id
+`
+
+// loadExchangeBooks fills the bundles of the exchanges from rows of exchangeBooksSelect
+func loadExchangeBooks(requests []models.BookExchangeRequest, rows *sql.Rows) error {
+	defer rows.Close()
+	index := map[int]int{}
+	for i := range requests {
+		index[requests[i].ID] = i
+		requests[i].RequestedBooks = []models.ExchangeBook{}
+		requests[i].OfferedBooks = []models.ExchangeBook{}
+	}
+	for rows.Next() {
+		var exchangeID int
+		var side string
+		var book models.ExchangeBook
+		var image sql.NullString
+		if err := rows.Scan(&exchangeID, &side, &book.ID, &book.Title, &book.Author, &image); err != nil {
+			return err
+		}
+		book.Image = image.String
+		i, ok := index[exchangeID]
+		if !ok {
+			continue
+		}
+		if side == models.ExchangeSideRequested {
+			requests[i].RequestedBooks = append(requests[i].RequestedBooks, book)
+		} else {
+			requests[i].OfferedBooks = append(requests[i].OfferedBooks, book)
+		}
+	}
+	return rows.Err()
+}
+
+// GetByID returns one exchange with its bundles, or sql.ErrNoRows
+func (r *ExchangeRepository) GetByID(exchangeID int) (models.BookExchangeRequest, error) {
+	req, err := scanExchange(r.DB.QueryRow(exchangeSelect+` WHERE e.id = ?`, exchangeID))
+	if err != nil {
+		return req, err
+	}
+	rows, err := r.DB.Query(exchangeBooksSelect+` WHERE i.exchange_id = ? ORDER BY i.position, i.book_id`, exchangeID)
+	if err != nil {
+		return req, err
+	}
+	requests := []models.BookExchangeRequest{req}
+	err = loadExchangeBooks(requests, rows)
+	return requests[0], err
+}
+
+// GetForUser returns all exchange requests for a user (both incoming and outgoing)
+func (r *ExchangeRepository) GetForUser(userID int) ([]models.BookExchangeRequest, error) {
+	rows, err := r.DB.Query(exchangeSelect+`
+		WHERE e.requester_id = ? OR e.owner_id = ?
+		ORDER BY e.created_at DESC, e.id DESC
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.BookExchangeRequest
+	for rows.Next() {
+		req, err := scanExchange(rows)
+		if err != nil {
+			return nil, err
+		}
+		req.IsIncoming = req.OwnerID == userID
+		requests = append(requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(requests) == 0 {
+		return requests, nil
+	}
+
+	bookRows, err := r.DB.Query(exchangeBooksSelect+`
+		JOIN book_exchanges e ON e.id = i.exchange_id
+		WHERE e.requester_id = ? OR e.owner_id = ?
+		ORDER BY i.exchange_id, i.position, i.book_id
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return requests, loadExchangeBooks(requests, bookRows)
+}
+
+// GetEvents returns the transitions of an exchange, oldest first
+func (r *ExchangeRepository) GetEvents(exchangeID int) ([]models.ExchangeEvent, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, from_status, to_status, actor_id, note, created_at
+		FROM book_exchange_events WHERE exchange_id = ? ORDER BY id
+	`, exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.ExchangeEvent{}
+	for rows.Next() {
+		var e models.ExchangeEvent
+		if err := rows.Scan(&e.ID, &e.FromStatus, &e.ToStatus, &e.ActorID, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// GetOffers returns the offer thread of an exchange, oldest first
+func (r *ExchangeRepository) GetOffers(exchangeID int) ([]models.ExchangeOffer, error) {
+	rows, err := r.DB.Query(`
+		SELECT o.id, o.proposed_by, o.offered_book_id, COALESCE(b.title, ''), COALESCE(b.author, ''),
+		       (SELECT image_url FROM book_images WHERE book_id = o.offered_book_id ORDER BY order_index LIMIT 1),
+		       o.message, o.status, o.created_at
+		FROM book_exchange_offers o
+		LEFT JOIN books b ON b.id = o.offered_book_id
+		WHERE o.exchange_id = ?
+		ORDER BY o.id
+	`, exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offers := []models.ExchangeOffer{}
+	index := map[int]int{}
+	for rows.Next() {
+		var o models.ExchangeOffer
+		var image sql.NullString
+		if err := rows.Scan(&o.ID, &o.ProposedBy, &o.OfferedBookID, &o.OfferedTitle, &o.OfferedAuthor, &image, &o.Message, &o.Status, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		o.OfferedImage = image.String
+		o.Books = []models.ExchangeBook{}
+		index[o.ID] = len(offers)
+		offers = append(offers, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	bookRows, err := r.DB.Query(`
+		SELECT ob.offer_id, ob.book_id, COALESCE(b.title, ''), COALESCE(b.author, ''),
+		       (SELECT image_url FROM book_images WHERE book_id = ob.book_id ORDER BY order_index LIMIT 1)
+		FROM book_exchange_offer_books ob
+		JOIN book_exchange_offers o ON o.id = ob.offer_id
+		LEFT JOIN books b ON b.id = ob.book_id
+		WHERE o.exchange_id = ?
+		ORDER BY ob.offer_id, ob.position, ob.book_id
+	`, exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	defer bookRows.Close()
+	for bookRows.Next() {
+		var offerID int
+		var book models.ExchangeBook
+		var image sql.NullString
+		if err := bookRows.Scan(&offerID, &book.ID, &book.Title, &book.Author, &image); err != nil {
+			return nil, err
+		}
+		book.Image = image.String
+		if i, ok := index[offerID]; ok {
+			offers[i].Books = append(offers[i].Books, book)
+		}
+	}
+	return offers, bookRows.Err()
+}
+
+// latestOffer returns the id and proposer of the open offer of an exchange
+func latestOffer(db dbtx, exchangeID int) (int, int, error) {
+	var id, proposedBy int
+	err := db.QueryRow(`
+		SELECT id, proposed_by FROM book_exchange_offers WHERE exchange_id = ? AND status = 'open' ORDER BY id DESC LIMIT 1
+	`, exchangeID).Scan(&id, &proposedBy)
+	return id, proposedBy, err
+}
+
+func recordExchangeEvent(db dbtx, exchangeID int, from, to string, actorID int, note string) error {
+	_, err := db.Exec(`
+		INSERT INTO book_exchange_events (exchange_id, from_status, to_status, actor_id, note) VALUES (?, ?, ?, ?, ?)
+	`, exchangeID, from, to, actorID, note)
+	return err
+}
+
+// transitionExchange moves an exchange from one of the from statuses to to and records
+// the event. It returns the status the exchange had, or ErrExchangeTransition.
+func transitionExchange(db dbtx, exchangeID int, from []string, to string, actorID int, note string) (string, error) {
+	var current string
+	if err := db.QueryRow(`SELECT status FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&current); err != nil {
+		return "", err
+	}
+	if !containsString(from, current) {
+		return current, ErrExchangeTransition
+	}
+
+	res, err := db.Exec(`
+		UPDATE book_exchanges SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?
+	`, to, exchangeID, current)
+	if err != nil {
+		return current, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return current, ErrExchangeTransition
+	}
+	if to == models.ExchangeStatusDeclined || to == models.ExchangeStatusCancelled || to == models.ExchangeStatusExpired {
+		if _, err := db.Exec(`
+			UPDATE book_exchange_offers SET status = 'closed' WHERE exchange_id = ? AND status = 'open'
+		`, exchangeID); err != nil {
+			return current, err
+		}
+	}
+	return current, recordExchangeEvent(db, exchangeID, current, to, actorID, note)
+}
+
+// setExchangeBooksStatus moves all books of an exchange, see setBookStatus
+func setExchangeBooksStatus(db dbtx, exchangeID int, from []string, to string, changedBy int) error {
+	requested, offered, err := exchangeItems(db, exchangeID)
+	if err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("exchange #%d", exchangeID)
+	for _, bookID := range append(requested, offered...) {
+		if err := setBookStatus(db, bookID, from, to, changedBy, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Accept accepts the open offer of a pending exchange, which makes it binding, and reserves
+// all books. The offer must not be the actor's own and, when offerID is set, must be that
+// offer. Other pending requests with any of these books can no longer happen: those asking
+// for one of them are declined and those only offering them are cancelled. The ids of
+// these closed requests are returned.
+func (r *ExchangeRepository) Accept(exchangeID, actorID, offerID int) ([]int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	openID, proposedBy, err := latestOffer(tx, exchangeID)
+	if err == sql.ErrNoRows {
+		return nil, ErrExchangeTransition
+	}
+	if err != nil {
+		return nil, err
+	}
+	if proposedBy == actorID || (offerID != 0 && offerID != openID) {
+		return nil, ErrExchangeTransition
+	}
+	if _, err := tx.Exec(`UPDATE book_exchange_offers SET status = 'accepted' WHERE id = ?`, openID); err != nil {
+		return nil, err
+	}
+
+	if _, err := transitionExchange(tx, exchangeID, []string{models.ExchangeStatusPending}, models.ExchangeStatusAccepted, actorID, ""); err != nil {
+		return nil, err
+	}
+	if err := setExchangeBooksStatus(tx, exchangeID, []string{models.BookStatusListed}, models.BookStatusReserved, actorID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT e.id, MAX(i.side = 'requested')
+		FROM book_exchanges e
+		JOIN book_exchange_items i ON i.exchange_id = e.id
+		WHERE e.status = 'pending' AND e.id != ?
+		  AND i.book_id IN (SELECT book_id FROM book_exchange_items WHERE exchange_id = ?)
+		GROUP BY e.id
+	`, exchangeID, exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	competing := map[int]string{}
+	var ids []int
+	for rows.Next() {
+		var id int
+		var asksForOne bool
+		if err := rows.Scan(&id, &asksForOne); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		competing[id] = models.ExchangeStatusCancelled
+		if asksForOne {
+			competing[id] = models.ExchangeStatusDeclined
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	note := fmt.Sprintf("book reserved by exchange #%d", exchangeID)
+	for _, id := range ids {
+		if _, err := transitionExchange(tx, id, []string{models.ExchangeStatusPending}, competing[id], 0, note); err != nil {
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
+}
+
+// Counter puts a new offer on a pending exchange: another bundle of listed books of the
+// requester in place of the one on the table. The previous offer is superseded. It returns
+// the offer id.
+func (r *ExchangeRepository) Counter(exchangeID, actorID int, offeredBookIDs []int, message string) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var requesterID int
+	err = tx.QueryRow(`SELECT status, requester_id FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&status, &requesterID)
+	if err != nil {
+		return 0, err
+	}
+	if status != models.ExchangeStatusPending {
+		return 0, ErrExchangeTransition
+	}
+	requested, offered, err := exchangeItems(tx, exchangeID)
+	if err != nil {
+		return 0, err
+	}
+	if sameIDs(offered, offeredBookIDs) {
+		return 0, ErrOfferBookInvalid
+	}
+
+	for _, bookID := range offeredBookIDs {
+		var owner int
+		var bookStatus, listingType string
+		err = tx.QueryRow(`SELECT owner_id, status, listing_type FROM books WHERE id = ?`, bookID).Scan(&owner, &bookStatus, &listingType)
+		if err == sql.ErrNoRows {
+			return 0, ErrOfferBookInvalid
+		}
+		if err != nil {
+			return 0, err
+		}
+		if owner != requesterID || bookStatus != models.BookStatusListed || listingType != models.ListingTypeExchange {
+			return 0, ErrOfferBookInvalid
+		}
+	}
+
+	existing, err := findPendingBundle(tx, exchangeID, requesterID, requested, offeredBookIDs)
+	if err != nil {
+		return 0, err
+	}
+	if existing != 0 {
+		return 0, ErrOfferDuplicate
+	}
+
+	res, err := tx.Exec(`
+		UPDATE book_exchanges SET offered_book_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'
+	`, offeredBookIDs[0], exchangeID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrExchangeTransition
+	}
+	if _, err := tx.Exec(`DELETE FROM book_exchange_items WHERE exchange_id = ? AND side = 'offered'`, exchangeID); err != nil {
+		return 0, err
+	}
+	if err := insertExchangeItems(tx, exchangeID, models.ExchangeSideOffered, offeredBookIDs); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE book_exchange_offers SET status = 'superseded' WHERE exchange_id = ? AND status = 'open'
+	`, exchangeID); err != nil {
+		return 0, err
+	}
+	offerID, err := insertOffer(tx, exchangeID, actorID, offeredBookIDs, message)
+	if err != nil {
+		return 0, err
+	}
+	note := fmt.Sprintf("offer #%d", offerID)
+	if err := recordExchangeEvent(tx, exchangeID, status, status, actorID, note); err != nil {
+		return 0, err
+	}
+	return offerID, tx.Commit()
+}
+
+// Decline declines a pending exchange
+func (r *ExchangeRepository) Decline(exchangeID, actorID int, reason string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := transitionExchange(tx, exchangeID, []string{models.ExchangeStatusPending}, models.ExchangeStatusDeclined, actorID, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Cancel cancels an exchange that has not ended yet. Books reserved for an accepted
+// exchange are listed again.
+func (r *ExchangeRepository) Cancel(exchangeID, actorID int, reason string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	from, err := transitionExchange(tx, exchangeID, []string{
+		models.ExchangeStatusPending, models.ExchangeStatusAccepted, models.ExchangeStatusMeetupScheduled,
+	}, models.ExchangeStatusCancelled, actorID, reason)
+	if err != nil {
+		return err
+	}
+	if from != models.ExchangeStatusPending {
+		err := setExchangeBooksStatus(tx, exchangeID, []string{models.BookStatusReserved, models.BookStatusInExchange}, models.BookStatusListed, actorID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// scheduleMeetup moves an accepted exchange to meetup_scheduled and its books in exchange.
+// Only an accepted meetup proposal gets an exchange there, so the meetup row always exists.
+func scheduleMeetup(db dbtx, exchangeID, actorID int, note string) error {
+	if _, err := transitionExchange(db, exchangeID, []string{models.ExchangeStatusAccepted}, models.ExchangeStatusMeetupScheduled, actorID, note); err != nil {
+		return err
+	}
+	return setExchangeBooksStatus(db, exchangeID, []string{models.BookStatusReserved}, models.BookStatusInExchange, actorID)
+}
+
+// Dispute stops an accepted exchange; the books stay held until a moderator resolves it
+// with ResolveDispute
+func (r *ExchangeRepository) Dispute(exchangeID, actorID int, reason string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := transitionExchange(tx, exchangeID, []string{
+		models.ExchangeStatusAccepted, models.ExchangeStatusMeetupScheduled,
+	}, models.ExchangeStatusDisputed, actorID, reason); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE book_exchanges SET dispute_reason = ? WHERE id = ?`, reason, exchangeID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDisputed returns the disputed exchanges with their bundles, oldest first
+func (r *ExchangeRepository) GetDisputed() ([]models.BookExchangeRequest, error) {
+	rows, err := r.DB.Query(exchangeSelect + ` WHERE e.status = 'disputed' ORDER BY e.updated_at, e.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.BookExchangeRequest{}
+	for rows.Next() {
+		req, err := scanExchange(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(requests) == 0 {
+		return requests, nil
+	}
+
+	bookRows, err := r.DB.Query(exchangeBooksSelect + `
+		JOIN book_exchanges e ON e.id = i.exchange_id
+		WHERE e.status = 'disputed'
+		ORDER BY i.exchange_id, i.position, i.book_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return requests, loadExchangeBooks(requests, bookRows)
+}
+
+// ResolveDispute ends a disputed exchange as moderator actorID decided: cancelled with
+// its books listed again, or completed as if both parties had confirmed it
+func (r *ExchangeRepository) ResolveDispute(exchangeID, actorID int, outcome, note string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch outcome {
+	case models.DisputeOutcomeCancel:
+		if _, err := transitionExchange(tx, exchangeID, []string{models.ExchangeStatusDisputed}, models.ExchangeStatusCancelled, actorID, note); err != nil {
+			return err
+		}
+		err = setExchangeBooksStatus(tx, exchangeID, []string{models.BookStatusReserved, models.BookStatusInExchange}, models.BookStatusListed, actorID)
+	case models.DisputeOutcomeComplete:
+		var ownerID, requesterID int
+		err = tx.QueryRow(`SELECT owner_id, requester_id FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&ownerID, &requesterID)
+		if err != nil {
+			return err
+		}
+		err = completeExchange(tx, exchangeID, models.ExchangeStatusDisputed, ownerID, requesterID, note)
+	default:
+		return fmt.Errorf("unknown dispute outcome %q", outcome)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReportNoShow cancels an exchange whose agreed meetup started at or before now (RFC 3339,
+// UTC) without the other party, recording them as missing. The books are listed again.
+// It returns ErrMeetupNotStarted when no accepted meetup has started yet.
+func (r *ExchangeRepository) ReportNoShow(exchangeID, actorID, missingID int, now, reason string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var started bool
+	err = tx.QueryRow(`
+		SELECT starts_at <= ? FROM exchange_meetups WHERE exchange_id = ? AND status = 'accepted'
+	`, now, exchangeID).Scan(&started)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if !started {
+		return ErrMeetupNotStarted
+	}
+
+	note := "no-show reported"
+	if reason != "" {
+		note += ": " + reason
+	}
+	if _, err := transitionExchange(tx, exchangeID, []string{models.ExchangeStatusMeetupScheduled}, models.ExchangeStatusCancelled, actorID, note); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE book_exchanges SET no_show_user_id = ? WHERE id = ?`, missingID, exchangeID); err != nil {
+		return err
+	}
+	err = setExchangeBooksStatus(tx, exchangeID, []string{models.BookStatusReserved, models.BookStatusInExchange}, models.BookStatusListed, actorID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConfirmCompletion records that one party of an exchange with a scheduled meetup
+// considers it done. Once both parties confirmed, the exchange is completed: each user
+// becomes the owner of the books they received and all books are marked exchanged. It
+// reports whether the exchange completed.
+func (r *ExchangeRepository) ConfirmCompletion(exchangeID, actorID int, asOwner bool) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var ownerID, requesterID int
+	var ownerConfirmed, requesterConfirmed bool
+	err = tx.QueryRow(`
+		SELECT status, owner_id, requester_id, owner_confirmed_at IS NOT NULL, requester_confirmed_at IS NOT NULL
+		FROM book_exchanges WHERE id = ?
+	`, exchangeID).Scan(&status, &ownerID, &requesterID, &ownerConfirmed, &requesterConfirmed)
+	if err != nil {
+		return false, err
+	}
+	// The books change hands at the meetup, so only a scheduled one can be confirmed
+	if status != models.ExchangeStatusMeetupScheduled {
+		return false, ErrExchangeTransition
+	}
+
+	column, party := "requester_confirmed_at", "requester"
+	if asOwner {
+		column, party = "owner_confirmed_at", "owner"
+	}
+	res, err := tx.Exec(`
+		UPDATE book_exchanges SET `+column+` = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ? AND `+column+` IS NULL
+	`, exchangeID, status)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Already confirmed by this party
+		return false, ErrExchangeTransition
+	}
+	if err := recordExchangeEvent(tx, exchangeID, status, status, actorID, party+" confirmed completion"); err != nil {
+		return false, err
+	}
+
+	if (asOwner && !requesterConfirmed) || (!asOwner && !ownerConfirmed) {
+		return false, tx.Commit()
+	}
+
+	if err := completeExchange(tx, exchangeID, status, ownerID, requesterID, "both parties confirmed"); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// completeExchange completes an exchange in status from: each user becomes the owner of
+// the books they received and all books are marked exchanged. The listing rules of the
+// previous owners are dropped and both users count one more exchange.
+func completeExchange(db dbtx, exchangeID int, from string, ownerID, requesterID int, note string) error {
+	if _, err := transitionExchange(db, exchangeID, []string{from}, models.ExchangeStatusCompleted, 0, note); err != nil {
+		return err
+	}
+	if err := deleteExchangeRules(db, `book_id IN (SELECT book_id FROM book_exchange_items WHERE exchange_id = ?)`, exchangeID); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE book_exchanges SET completed_at = CURRENT_TIMESTAMP WHERE id = ?`, exchangeID); err != nil {
+		return err
+	}
+	if err := setExchangeBooksStatus(db, exchangeID, []string{models.BookStatusReserved, models.BookStatusInExchange}, models.BookStatusExchanged, 0); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`
+		UPDATE books SET owner_id = CASE
+			(SELECT side FROM book_exchange_items WHERE exchange_id = ? AND book_id = books.id)
+			WHEN 'requested' THEN ? ELSE ? END
+		WHERE id IN (SELECT book_id FROM book_exchange_items WHERE exchange_id = ?)
+	`, exchangeID, requesterID, ownerID, exchangeID); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE users SET exchange_count = exchange_count + 1 WHERE id IN (?, ?)`, ownerID, requesterID)
+	return err
+}
+
+// pendingIDs returns the ids of the pending exchanges a query selects
+func (r *ExchangeRepository) pendingIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// endPending moves the given pending exchanges to status to as the system and returns
+// the ids it changed; an exchange that moved on meanwhile is skipped
+func (r *ExchangeRepository) endPending(ids []int, to, note string) ([]int, error) {
+	var ended []int
+	for _, id := range ids {
+		_, err := transitionExchange(r.DB, id, []string{models.ExchangeStatusPending}, to, 0, note)
+		if err == ErrExchangeTransition {
+			continue
+		}
+		if err != nil {
+			return ended, err
+		}
+		ended = append(ended, id)
+	}
+	return ended, nil
+}
+
+// ExpirePending expires the pending requests without activity since cutoff
+// ("YYYY-MM-DD HH:MM:SS", UTC) and returns their ids
+func (r *ExchangeRepository) ExpirePending(cutoff, note string) ([]int, error) {
+	ids, err := r.pendingIDs(`
+		SELECT id FROM book_exchanges WHERE status = 'pending' AND updated_at < ? ORDER BY id
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	return r.endPending(ids, models.ExchangeStatusExpired, note)
+}
+
+// CancelUnavailable cancels the pending requests with a book that was deleted, is no
+// longer a listed exchange book, or no longer belongs to its side, and returns their ids
+func (r *ExchangeRepository) CancelUnavailable(note string) ([]int, error) {
+	ids, err := r.pendingIDs(`
+		SELECT DISTINCT e.id
+		FROM book_exchanges e
+		JOIN book_exchange_items i ON i.exchange_id = e.id
+		LEFT JOIN books b ON b.id = i.book_id
+		WHERE e.status = 'pending'
+		  AND (b.id IS NULL OR b.status != 'listed' OR b.listing_type != 'exchange'
+		       OR b.owner_id != CASE i.side WHEN 'requested' THEN e.owner_id ELSE e.requester_id END)
+		ORDER BY e.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return r.endPending(ids, models.ExchangeStatusCancelled, note)
+}
+
+// HandoffCode is the code one party of an exchange shows to the other
+type HandoffCode struct {
+	Code     string
+	Verified bool
+}
+
+// handoffStatus returns the status and parties of an exchange whose books are due to
+// change hands at its scheduled meetup, or ErrExchangeTransition
+func handoffStatus(db dbtx, exchangeID int) (string, int, int, error) {
+	var status string
+	var ownerID, requesterID int
+	err := db.QueryRow(`SELECT status, owner_id, requester_id FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&status, &ownerID, &requesterID)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if status != models.ExchangeStatusMeetupScheduled {
+		return status, ownerID, requesterID, ErrExchangeTransition
+	}
+	return status, ownerID, requesterID, nil
+}
+
+// GetHandoffCodes returns the handoff codes of a scheduled exchange by holder, first
+// creating the missing ones with generate
+func (r *ExchangeRepository) GetHandoffCodes(exchangeID int, generate func() (string, error)) (map[string]HandoffCode, error) {
+	if _, _, _, err := handoffStatus(r.DB, exchangeID); err != nil {
+		return nil, err
+	}
+	for _, holder := range []string{models.HandoffHolderOwner, models.HandoffHolderRequester} {
+		code, err := generate()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.DB.Exec(`
+			INSERT OR IGNORE INTO exchange_handoff_codes (exchange_id, holder, code) VALUES (?, ?, ?)
+		`, exchangeID, holder, code); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT holder, code, verified_at IS NOT NULL FROM exchange_handoff_codes WHERE exchange_id = ?
+	`, exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := map[string]HandoffCode{}
+	for rows.Next() {
+		var holder string
+		var c HandoffCode
+		if err := rows.Scan(&holder, &c.Code, &c.Verified); err != nil {
+			return nil, err
+		}
+		codes[holder] = c
+	}
+	return codes, rows.Err()
+}
+
+// VerifyHandoff checks the code of holder entered by the other party, actorID. A match
+// also confirms the exchange for the actor; once both codes are verified the exchange
+// completes and both users' exchange counts go up. After maxAttempts wrong codes the
+// code is replaced by replacement. It reports whether the exchange completed.
+func (r *ExchangeRepository) VerifyHandoff(exchangeID, actorID int, holder, code string, maxAttempts int, replacement string) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	status, ownerID, requesterID, err := handoffStatus(tx, exchangeID)
+	if err != nil {
+		return false, err
+	}
+	var stored string
+	var attempts int
+	var verified bool
+	err = tx.QueryRow(`
+		SELECT code, attempts, verified_at IS NOT NULL FROM exchange_handoff_codes WHERE exchange_id = ? AND holder = ?
+	`, exchangeID, holder).Scan(&stored, &attempts, &verified)
+	if err == sql.ErrNoRows {
+		// The holder never opened their code, so there is nothing to match
+		return false, ErrHandoffCode
+	}
+	if err != nil {
+		return false, err
+	}
+	if verified {
+		return false, ErrHandoffVerified
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(code)) != 1 {
+		attempts++
+		result := ErrHandoffCode
+		if attempts >= maxAttempts {
+			stored, attempts, result = replacement, 0, ErrHandoffCodeReplaced
+		}
+		if _, err := tx.Exec(`
+			UPDATE exchange_handoff_codes SET code = ?, attempts = ? WHERE exchange_id = ? AND holder = ?
+		`, stored, attempts, exchangeID, holder); err != nil {
+			return false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+		return false, result
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE exchange_handoff_codes SET verified_at = CURRENT_TIMESTAMP WHERE exchange_id = ? AND holder = ?
+	`, exchangeID, holder); err != nil {
+		return false, err
+	}
+	// Entering the other party's code confirms the exchange on the actor's side
+	column := "owner_confirmed_at"
+	if holder == models.HandoffHolderOwner {
+		column = "requester_confirmed_at"
+	}
+	if _, err := tx.Exec(`
+		UPDATE book_exchanges SET `+column+` = COALESCE(`+column+`, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, exchangeID); err != nil {
+		return false, err
+	}
+	if err := recordExchangeEvent(tx, exchangeID, status, status, actorID, holder+"'s handoff code verified"); err != nil {
+		return false, err
+	}
+
+	var pending int
+	if err := tx.QueryRow(`
+		SELECT 2 - COUNT(*) FROM exchange_handoff_codes WHERE exchange_id = ? AND verified_at IS NOT NULL
+	`, exchangeID).Scan(&pending); err != nil {
+		return false, err
+	}
+	if pending > 0 {
+		return false, tx.Commit()
+	}
+
+	if err := completeExchange(tx, exchangeID, status, ownerID, requesterID, "both handoff codes verified"); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
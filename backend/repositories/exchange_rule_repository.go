@@ -0,0 +1,141 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"ktabnet/models"
+)
+
+type ExchangeRuleRepository struct {
+	DB *sql.DB
+}
+
+func NewExchangeRuleRepository(db *sql.DB) *ExchangeRuleRepository {
+	return &ExchangeRuleRepository{DB: db}
+}
+
+// RuleBook is what the exchange rules look at in a book
+type RuleBook struct {
+	ID        int
+	OwnerID   int
+	Title     string
+	City      string
+	Genre     string
+	Condition string
+	// ConditionRank orders conditions from best (lowest) to worst
+	ConditionRank int
+}
+
+// RuleRequester is what the exchange rules look at in a requester
+type RuleRequester struct {
+	City           string
+	AccountAgeDays float64
+	Reputation     models.Reputation
+}
+
+// Get returns the rules of a listing, or of the library of ownerID when bookID is 0.
+// It returns sql.ErrNoRows when none are set.
+func (r *ExchangeRuleRepository) Get(ownerID, bookID int) (models.ExchangeRules, error) {
+	rules := models.ExchangeRules{BookID: bookID, Genres: []string{}}
+	err := r.DB.QueryRow(`
+		SELECT same_city, min_condition, min_rating, min_account_age_days, updated_at
+		FROM exchange_rules WHERE owner_id = ? AND book_id = ?
+	`, ownerID, bookID).Scan(&rules.SameCity, &rules.MinCondition, &rules.MinRating, &rules.MinAccountAgeDays, &rules.UpdatedAt)
+	if err != nil {
+		return rules, err
+	}
+
+	rows, err := r.DB.Query(`
+		SELECT genre FROM exchange_rule_genres WHERE owner_id = ? AND book_id = ? ORDER BY genre
+	`, ownerID, bookID)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var genre string
+		if err := rows.Scan(&genre); err != nil {
+			return rules, err
+		}
+		rules.Genres = append(rules.Genres, genre)
+	}
+	return rules, rows.Err()
+}
+
+// Save replaces the rules of a listing, or of the library when bookID is 0
+func (r *ExchangeRuleRepository) Save(ownerID, bookID int, rules models.ExchangeRules) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO exchange_rules (owner_id, book_id, same_city, min_condition, min_rating, min_account_age_days)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (owner_id, book_id) DO UPDATE SET
+			same_city = excluded.same_city, min_condition = excluded.min_condition,
+			min_rating = excluded.min_rating, min_account_age_days = excluded.min_account_age_days,
+			updated_at = CURRENT_TIMESTAMP
+	`, ownerID, bookID, rules.SameCity, rules.MinCondition, rules.MinRating, rules.MinAccountAgeDays)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM exchange_rule_genres WHERE owner_id = ? AND book_id = ?`, ownerID, bookID); err != nil {
+		return err
+	}
+	for _, genre := range rules.Genres {
+		if _, err := tx.Exec(`
+			INSERT INTO exchange_rule_genres (owner_id, book_id, genre) VALUES (?, ?, ?)
+		`, ownerID, bookID, genre); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes the rules of a listing, or of the library when bookID is 0
+func (r *ExchangeRuleRepository) Delete(ownerID, bookID int) error {
+	return deleteExchangeRules(r.DB, `owner_id = ? AND book_id = ?`, ownerID, bookID)
+}
+
+// deleteExchangeRules removes the rules matching a condition on owner_id and book_id
+func deleteExchangeRules(db dbtx, where string, args ...interface{}) error {
+	if _, err := db.Exec(`DELETE FROM exchange_rule_genres WHERE `+where, args...); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM exchange_rules WHERE `+where, args...)
+	return err
+}
+
+// GetBook returns what the rules look at in a book
+func (r *ExchangeRuleRepository) GetBook(bookID int) (RuleBook, error) {
+	book := RuleBook{ID: bookID}
+	err := r.DB.QueryRow(`
+		SELECT b.owner_id, b.title, b.city, b.genre, b.condition, COALESCE(c.sort_order, 1000000)
+		FROM books b
+		LEFT JOIN conditions c ON c.name = b.condition
+		WHERE b.id = ?
+	`, bookID).Scan(&book.OwnerID, &book.Title, &book.City, &book.Genre, &book.Condition, &book.ConditionRank)
+	return book, err
+}
+
+// ConditionRank returns the rank of a condition name, lower being better
+func (r *ExchangeRuleRepository) ConditionRank(name string) (int, error) {
+	var rank int
+	err := r.DB.QueryRow(`SELECT sort_order FROM conditions WHERE name = ?`, name).Scan(&rank)
+	return rank, err
+}
+
+// GetRequester returns what the rules look at in a requester
+func (r *ExchangeRuleRepository) GetRequester(userID int) (RuleRequester, error) {
+	var requester RuleRequester
+	err := r.DB.QueryRow(`
+		SELECT COALESCE(city, ''), COALESCE(julianday('now') - julianday(created_at), 0) FROM users WHERE id = ?
+	`, userID).Scan(&requester.City, &requester.AccountAgeDays)
+	if err != nil {
+		return requester, err
+	}
+	requester.Reputation, err = userReputation(r.DB, userID)
+	return requester, err
+}
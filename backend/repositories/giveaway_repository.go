@@ -0,0 +1,334 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"ktabnet/models"
+)
+
+var (
+	// ErrClaimTransition is returned when a claim is not in a status that allows a change
+	ErrClaimTransition = errors.New("claim status does not allow this change")
+	// ErrClaimLimit is returned when the requester reached the monthly claim limit
+	ErrClaimLimit = errors.New("monthly giveaway claim limit reached")
+	// ErrOwnGiveaway is returned when a user claims their own book
+	ErrOwnGiveaway = errors.New("cannot claim your own book")
+	// ErrNotGiveaway is returned when the claimed book is not listed as a giveaway
+	ErrNotGiveaway = errors.New("book is not listed as a giveaway")
+	// ErrGiveawayUnavailable is returned when the giveaway is no longer open to claims
+	ErrGiveawayUnavailable = errors.New("book not available")
+)
+
+type GiveawayRepository struct {
+	DB *sql.DB
+}
+
+func NewGiveawayRepository(db *sql.DB) *GiveawayRepository {
+	return &GiveawayRepository{DB: db}
+}
+
+// Create queues a claim for a giveaway listing. When the requester already has an open
+// claim on the book its id is returned with false.
+func (r *GiveawayRepository) Create(claim models.GiveawayClaim) (int, bool, error) {
+	var owner int
+	var status, listingType string
+	err := r.DB.QueryRow(`SELECT owner_id, status, listing_type FROM books WHERE id = ?`, claim.BookID).Scan(&owner, &status, &listingType)
+	if err != nil {
+		return 0, false, err
+	}
+	if owner == claim.RequesterID {
+		return 0, false, ErrOwnGiveaway
+	}
+	if listingType != models.ListingTypeGiveaway {
+		return 0, false, ErrNotGiveaway
+	}
+	// A reserved giveaway still queues claims in case its recipient drops out
+	if status != models.BookStatusListed && status != models.BookStatusReserved {
+		return 0, false, fmt.Errorf("%w (%s)", ErrGiveawayUnavailable, status)
+	}
+
+	var existing int
+	err = r.DB.QueryRow(`
+		SELECT id FROM giveaway_claims WHERE book_id = ? AND requester_id = ? AND status IN ('pending', 'chosen')
+	`, claim.BookID, claim.RequesterID).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if existing != 0 {
+		return existing, false, nil
+	}
+
+	res, err := r.DB.Exec(`
+		INSERT INTO giveaway_claims (book_id, owner_id, requester_id, status, message)
+		VALUES (?, ?, ?, 'pending', ?)
+	`, claim.BookID, owner, claim.RequesterID, claim.Message)
+	if err != nil {
+		return 0, false, err
+	}
+	id, _ := res.LastInsertId()
+	return int(id), true, nil
+}
+
+// claimSelect reads claims with their book and both users; the single parameter is the
+// start of the current month ("YYYY-MM-DD HH:MM:SS"), used to count the requester's claims
+const claimSelect = `
+	SELECT c.id, c.book_id, b.title, b.author,
+	       (SELECT image_url FROM book_images WHERE book_id = b.id ORDER BY order_index LIMIT 1),
+	       c.owner_id, COALESCE(ou.first_name || ' ' || ou.last_name, ''),
+	       c.requester_id, COALESCE(ru.first_name || ' ' || ru.last_name, ''), COALESCE(ru.avatar, ''),
+	       c.status, c.message, c.created_at, c.updated_at, c.chosen_at, c.completed_at,
+	       (SELECT COUNT(*) FROM giveaway_claims m
+	        WHERE m.requester_id = c.requester_id AND m.status IN ('chosen', 'completed') AND m.chosen_at >= ?)
+	FROM giveaway_claims c
+	JOIN books b ON b.id = c.book_id
+	JOIN users ou ON ou.id = c.owner_id
+	JOIN users ru ON ru.id = c.requester_id
+`
+
+func scanClaim(row rowScanner) (models.GiveawayClaim, error) {
+	var claim models.GiveawayClaim
+	var image, chosenAt, completedAt sql.NullString
+	err := row.Scan(&claim.ID, &claim.BookID, &claim.BookTitle, &claim.BookAuthor, &image,
+		&claim.OwnerID, &claim.OwnerName, &claim.RequesterID, &claim.RequesterName, &claim.RequesterAvatar,
+		&claim.Status, &claim.Message, &claim.CreatedAt, &claim.UpdatedAt, &chosenAt, &completedAt,
+		&claim.ClaimsThisMonth)
+	claim.BookImage = image.String
+	claim.ChosenAt = chosenAt.String
+	claim.CompletedAt = completedAt.String
+	return claim, err
+}
+
+func (r *GiveawayRepository) queryClaims(query string, args ...interface{}) ([]models.GiveawayClaim, error) {
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claims := []models.GiveawayClaim{}
+	for rows.Next() {
+		claim, err := scanClaim(rows)
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, claim)
+	}
+	return claims, rows.Err()
+}
+
+// GetByID returns one claim, or sql.ErrNoRows
+func (r *GiveawayRepository) GetByID(claimID int, monthStart string) (models.GiveawayClaim, error) {
+	return scanClaim(r.DB.QueryRow(claimSelect+` WHERE c.id = ?`, monthStart, claimID))
+}
+
+// GetForUser returns the claims a user made or received, newest first
+func (r *GiveawayRepository) GetForUser(userID int, monthStart string) ([]models.GiveawayClaim, error) {
+	claims, err := r.queryClaims(claimSelect+`
+		WHERE c.owner_id = ? OR c.requester_id = ?
+		ORDER BY c.created_at DESC, c.id DESC
+	`, monthStart, userID, userID)
+	for i := range claims {
+		claims[i].IsIncoming = claims[i].OwnerID == userID
+	}
+	return claims, err
+}
+
+// GetQueue returns the open claims of a book, oldest first
+func (r *GiveawayRepository) GetQueue(bookID int, monthStart string) ([]models.GiveawayClaim, error) {
+	return r.queryClaims(claimSelect+`
+		WHERE c.book_id = ? AND c.status IN ('pending', 'chosen')
+		ORDER BY c.created_at, c.id
+	`, monthStart, bookID)
+}
+
+// CountChosenSince counts the claims of a requester chosen since monthStart
+func (r *GiveawayRepository) CountChosenSince(requesterID int, monthStart string) (int, error) {
+	return countChosenSince(r.DB, requesterID, monthStart)
+}
+
+func countChosenSince(db dbtx, requesterID int, monthStart string) (int, error) {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM giveaway_claims
+		WHERE requester_id = ? AND status IN ('chosen', 'completed') AND chosen_at >= ?
+	`, requesterID, monthStart).Scan(&n)
+	return n, err
+}
+
+// NextEligible returns the oldest pending claim on a book whose requester is under the
+// monthly limit, or 0 when there is none
+func (r *GiveawayRepository) NextEligible(bookID int, monthStart string, limit int) (int, error) {
+	var id int
+	err := r.DB.QueryRow(`
+		SELECT c.id FROM giveaway_claims c
+		WHERE c.book_id = ? AND c.status = 'pending'
+		  AND (SELECT COUNT(*) FROM giveaway_claims m
+		       WHERE m.requester_id = c.requester_id AND m.status IN ('chosen', 'completed') AND m.chosen_at >= ?) < ?
+		ORDER BY c.created_at, c.id
+		LIMIT 1
+	`, bookID, monthStart, limit).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// transitionClaim moves a claim from one of the from statuses to status to and returns
+// the previous status, or ErrClaimTransition
+func transitionClaim(db dbtx, claimID int, from []string, to string) (string, error) {
+	var current string
+	if err := db.QueryRow(`SELECT status FROM giveaway_claims WHERE id = ?`, claimID).Scan(&current); err != nil {
+		return "", err
+	}
+	if !containsString(from, current) {
+		return current, ErrClaimTransition
+	}
+
+	res, err := db.Exec(`
+		UPDATE giveaway_claims SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?
+	`, to, claimID, current)
+	if err != nil {
+		return current, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return current, ErrClaimTransition
+	}
+	return current, nil
+}
+
+func claimBook(db dbtx, claimID int) (bookID, requesterID int, err error) {
+	err = db.QueryRow(`SELECT book_id, requester_id FROM giveaway_claims WHERE id = ?`, claimID).Scan(&bookID, &requesterID)
+	return bookID, requesterID, err
+}
+
+// Choose makes a pending claim the recipient and reserves the book for it. It returns
+// ErrClaimLimit when the requester was already chosen limit times since monthStart.
+func (r *GiveawayRepository) Choose(claimID, actorID int, monthStart string, limit int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	bookID, requesterID, err := claimBook(tx, claimID)
+	if err != nil {
+		return err
+	}
+	n, err := countChosenSince(tx, requesterID, monthStart)
+	if err != nil {
+		return err
+	}
+	if n >= limit {
+		return ErrClaimLimit
+	}
+	if _, err := transitionClaim(tx, claimID, []string{models.ClaimStatusPending}, models.ClaimStatusChosen); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE giveaway_claims SET chosen_at = CURRENT_TIMESTAMP WHERE id = ?`, claimID); err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("giveaway claim #%d", claimID)
+	if err := setBookStatus(tx, bookID, []string{models.BookStatusListed}, models.BookStatusReserved, actorID, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Decline removes a pending claim from the queue
+func (r *GiveawayRepository) Decline(claimID int) error {
+	_, err := transitionClaim(r.DB, claimID, []string{models.ClaimStatusPending}, models.ClaimStatusDeclined)
+	return err
+}
+
+// endClaim closes a pending or chosen claim; a chosen one lists the book again
+func (r *GiveawayRepository) endClaim(claimID, actorID int, from []string, to, reason string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	bookID, _, err := claimBook(tx, claimID)
+	if err != nil {
+		return err
+	}
+	previous, err := transitionClaim(tx, claimID, from, to)
+	if err != nil {
+		return err
+	}
+	if previous == models.ClaimStatusChosen {
+		if err := setBookStatus(tx, bookID, []string{models.BookStatusReserved}, models.BookStatusListed, actorID, reason); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Cancel withdraws a pending or chosen claim of its requester
+func (r *GiveawayRepository) Cancel(claimID, actorID int) error {
+	return r.endClaim(claimID, actorID, []string{models.ClaimStatusPending, models.ClaimStatusChosen},
+		models.ClaimStatusCancelled, fmt.Sprintf("giveaway claim #%d cancelled", claimID))
+}
+
+// Release drops the chosen recipient of a giveaway and lists the book again
+func (r *GiveawayRepository) Release(claimID, actorID int) error {
+	return r.endClaim(claimID, actorID, []string{models.ClaimStatusChosen},
+		models.ClaimStatusDeclined, fmt.Sprintf("giveaway claim #%d released", claimID))
+}
+
+// Complete hands the book of a chosen claim over to its requester, who becomes the
+// owner of an exchange listing, and declines the rest of the queue. The ids of the
+// declined claims are returned.
+func (r *GiveawayRepository) Complete(claimID, actorID int) ([]int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bookID, requesterID, err := claimBook(tx, claimID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := transitionClaim(tx, claimID, []string{models.ClaimStatusChosen}, models.ClaimStatusCompleted); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE giveaway_claims SET completed_at = CURRENT_TIMESTAMP WHERE id = ?`, claimID); err != nil {
+		return nil, err
+	}
+	reason := fmt.Sprintf("given away with claim #%d", claimID)
+	if err := setBookStatus(tx, bookID, []string{models.BookStatusReserved}, models.BookStatusExchanged, actorID, reason); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE books SET owner_id = ?, listing_type = ?, giveaway_pick = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, requesterID, models.ListingTypeExchange, bookID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT id FROM giveaway_claims WHERE book_id = ? AND status = 'pending'`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	var others []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		others = append(others, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range others {
+		if _, err := transitionClaim(tx, id, []string{models.ClaimStatusPending}, models.ClaimStatusDeclined); err != nil {
+			return nil, err
+		}
+	}
+	return others, tx.Commit()
+}
@@ -0,0 +1,339 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"ktabnet/models"
+)
+
+var (
+	// ErrLoanTransition is returned when a loan is not in a status that allows a change
+	ErrLoanTransition = errors.New("loan status does not allow this change")
+	// ErrOwnLoanBook is returned when a user asks to borrow their own book
+	ErrOwnLoanBook = errors.New("cannot borrow your own book")
+	// ErrNotForLending is returned when the book is not listed for lending
+	ErrNotForLending = errors.New("book is not listed for lending")
+	// ErrLoanBookUnavailable is returned when the book is no longer listed
+	ErrLoanBookUnavailable = errors.New("book not available")
+)
+
+type LoanRepository struct {
+	DB *sql.DB
+}
+
+func NewLoanRepository(db *sql.DB) *LoanRepository {
+	return &LoanRepository{DB: db}
+}
+
+// Create adds a pending borrow request. When the borrower already has a pending request
+// for the book its id is returned with false.
+func (r *LoanRepository) Create(loan models.BookLoan) (int, bool, error) {
+	// The checks read through the transaction so the book cannot change before the insert
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var owner int
+	var status, listingType string
+	err = tx.QueryRow(`SELECT owner_id, status, listing_type FROM books WHERE id = ?`, loan.BookID).Scan(&owner, &status, &listingType)
+	if err != nil {
+		return 0, false, err
+	}
+	if owner == loan.BorrowerID {
+		return 0, false, ErrOwnLoanBook
+	}
+	if listingType != models.ListingTypeLend {
+		return 0, false, ErrNotForLending
+	}
+	if status != models.BookStatusListed {
+		return 0, false, fmt.Errorf("%w (%s)", ErrLoanBookUnavailable, status)
+	}
+
+	var existing int
+	err = tx.QueryRow(`
+		SELECT id FROM book_loans WHERE book_id = ? AND borrower_id = ? AND status = 'pending'
+	`, loan.BookID, loan.BorrowerID).Scan(&existing)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if existing != 0 {
+		return existing, false, nil
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO book_loans (book_id, owner_id, borrower_id, status, start_date, due_date, message)
+		VALUES (?, ?, ?, 'pending', ?, ?, ?)
+	`, loan.BookID, owner, loan.BorrowerID, loan.StartDate, loan.DueDate, loan.Message)
+	if err != nil {
+		return 0, false, err
+	}
+	id, _ := res.LastInsertId()
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return int(id), true, nil
+}
+
+// loanSelect reads loans with their book and both users; the single parameter is
+// today's date (YYYY-MM-DD), used to flag overdue loans
+const loanSelect = `
+	SELECT l.id, l.book_id, b.title, b.author,
+	       (SELECT image_url FROM book_images WHERE book_id = b.id ORDER BY order_index LIMIT 1),
+	       l.owner_id, COALESCE(ou.first_name || ' ' || ou.last_name, ''),
+	       l.borrower_id, COALESCE(bu.first_name || ' ' || bu.last_name, ''), COALESCE(bu.avatar, ''),
+	       l.status, l.start_date, l.due_date, l.message, l.created_at, l.updated_at, l.returned_at,
+	       l.status = 'active' AND l.due_date < ?
+	FROM book_loans l
+	JOIN books b ON b.id = l.book_id
+	JOIN users ou ON ou.id = l.owner_id
+	JOIN users bu ON bu.id = l.borrower_id
+`
+
+func scanLoan(row rowScanner) (models.BookLoan, error) {
+	var loan models.BookLoan
+	var image, returnedAt sql.NullString
+	err := row.Scan(&loan.ID, &loan.BookID, &loan.BookTitle, &loan.BookAuthor, &image,
+		&loan.OwnerID, &loan.OwnerName, &loan.BorrowerID, &loan.BorrowerName, &loan.BorrowerAvatar,
+		&loan.Status, &loan.StartDate, &loan.DueDate, &loan.Message, &loan.CreatedAt, &loan.UpdatedAt, &returnedAt,
+		&loan.Overdue)
+	loan.BookImage = image.String
+	loan.ReturnedAt = returnedAt.String
+	return loan, err
+}
+
+func (r *LoanRepository) queryLoans(query string, args ...interface{}) ([]models.BookLoan, error) {
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []models.BookLoan{}
+	for rows.Next() {
+		loan, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+// GetByID returns one loan, or sql.ErrNoRows
+func (r *LoanRepository) GetByID(loanID int, today string) (models.BookLoan, error) {
+	return scanLoan(r.DB.QueryRow(loanSelect+` WHERE l.id = ?`, today, loanID))
+}
+
+// GetForUser returns the loans a user lends or borrows, newest first
+func (r *LoanRepository) GetForUser(userID int, today string) ([]models.BookLoan, error) {
+	loans, err := r.queryLoans(loanSelect+`
+		WHERE l.owner_id = ? OR l.borrower_id = ?
+		ORDER BY l.created_at DESC, l.id DESC
+	`, today, userID, userID)
+	for i := range loans {
+		loans[i].IsIncoming = loans[i].OwnerID == userID
+	}
+	return loans, err
+}
+
+// transitionLoan moves a loan from status from to status to, or returns ErrLoanTransition
+func transitionLoan(db dbtx, loanID int, from, to string) error {
+	res, err := db.Exec(`
+		UPDATE book_loans SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?
+	`, to, loanID, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLoanTransition
+	}
+	return nil
+}
+
+// Approve promises the book of a pending loan to its borrower: the loan becomes
+// approved, the book reserved, and the other pending requests for the book are
+// declined. Their ids are returned. A loan starting today or earlier starts at once.
+func (r *LoanRepository) Approve(loanID, actorID int, today string) ([]int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var bookID int
+	var startDate string
+	if err := tx.QueryRow(`SELECT book_id, start_date FROM book_loans WHERE id = ?`, loanID).Scan(&bookID, &startDate); err != nil {
+		return nil, err
+	}
+	if err := transitionLoan(tx, loanID, models.LoanStatusPending, models.LoanStatusApproved); err != nil {
+		return nil, err
+	}
+	reason := fmt.Sprintf("loan #%d approved", loanID)
+	if err := setBookStatus(tx, bookID, []string{models.BookStatusListed}, models.BookStatusReserved, actorID, reason); err != nil {
+		return nil, err
+	}
+	if startDate <= today {
+		if err := startLoan(tx, loanID, bookID, actorID); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(`SELECT id FROM book_loans WHERE book_id = ? AND status = 'pending'`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	var others []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		others = append(others, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range others {
+		if err := transitionLoan(tx, id, models.LoanStatusPending, models.LoanStatusDeclined); err != nil {
+			return nil, err
+		}
+	}
+	return others, tx.Commit()
+}
+
+// Decline declines a pending loan
+func (r *LoanRepository) Decline(loanID int) error {
+	return transitionLoan(r.DB, loanID, models.LoanStatusPending, models.LoanStatusDeclined)
+}
+
+// startLoan makes an approved loan active and lends its book out
+func startLoan(db dbtx, loanID, bookID, actorID int) error {
+	if err := transitionLoan(db, loanID, models.LoanStatusApproved, models.LoanStatusActive); err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("loan #%d", loanID)
+	return setBookStatus(db, bookID, []string{models.BookStatusReserved}, models.BookStatusLentOut, actorID, reason)
+}
+
+// StartDue starts the approved loans whose start date is today or earlier and returns
+// them. A loan whose book cannot be lent out is skipped and logged.
+func (r *LoanRepository) StartDue(today string) ([]models.BookLoan, error) {
+	due, err := r.queryLoans(loanSelect+`
+		WHERE l.status = 'approved' AND l.start_date <= ?
+		ORDER BY l.id
+	`, today, today)
+	if err != nil {
+		return nil, err
+	}
+
+	started := []models.BookLoan{}
+	for _, loan := range due {
+		tx, err := r.DB.Begin()
+		if err != nil {
+			return started, err
+		}
+		err = startLoan(tx, loan.ID, loan.BookID, 0)
+		if err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if err != nil {
+			fmt.Printf("Error starting loan %d: %v\n", loan.ID, err)
+			continue
+		}
+		loan.Status = models.LoanStatusActive
+		started = append(started, loan)
+	}
+	return started, nil
+}
+
+// Cancel withdraws a pending loan, or calls off an approved one before it starts and
+// lists its book again
+func (r *LoanRepository) Cancel(loanID, actorID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var bookID int
+	var status string
+	if err := tx.QueryRow(`SELECT book_id, status FROM book_loans WHERE id = ?`, loanID).Scan(&bookID, &status); err != nil {
+		return err
+	}
+	switch status {
+	case models.LoanStatusPending:
+		err = transitionLoan(tx, loanID, models.LoanStatusPending, models.LoanStatusCancelled)
+	case models.LoanStatusApproved:
+		if err = transitionLoan(tx, loanID, models.LoanStatusApproved, models.LoanStatusCancelled); err == nil {
+			reason := fmt.Sprintf("loan #%d cancelled", loanID)
+			err = setBookStatus(tx, bookID, []string{models.BookStatusReserved}, models.BookStatusListed, actorID, reason)
+		}
+	default:
+		err = ErrLoanTransition
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MarkReturned ends an active loan and lists the book again
+func (r *LoanRepository) MarkReturned(loanID, actorID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var bookID int
+	if err := tx.QueryRow(`SELECT book_id FROM book_loans WHERE id = ?`, loanID).Scan(&bookID); err != nil {
+		return err
+	}
+	if err := transitionLoan(tx, loanID, models.LoanStatusActive, models.LoanStatusReturned); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE book_loans SET returned_at = CURRENT_TIMESTAMP WHERE id = ?`, loanID); err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("returned from loan #%d", loanID)
+	if err := setBookStatus(tx, bookID, []string{models.BookStatusLentOut}, models.BookStatusListed, actorID, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDueForReminder returns active loans due between today and remindBy (inclusive)
+// that were not reminded yet
+func (r *LoanRepository) GetDueForReminder(today, remindBy string) ([]models.BookLoan, error) {
+	return r.queryLoans(loanSelect+`
+		WHERE l.status = 'active' AND l.reminder_sent_at IS NULL AND l.due_date >= ? AND l.due_date <= ?
+		ORDER BY l.id
+	`, today, today, remindBy)
+}
+
+// GetOverdue returns active loans past their due date that got no overdue notice since
+// dayStart ("YYYY-MM-DD 00:00:00"), so each one is noticed once a day
+func (r *LoanRepository) GetOverdue(today, dayStart string) ([]models.BookLoan, error) {
+	return r.queryLoans(loanSelect+`
+		WHERE l.status = 'active' AND l.due_date < ?
+		  AND (l.overdue_notified_at IS NULL OR l.overdue_notified_at < ?)
+		ORDER BY l.id
+	`, today, today, dayStart)
+}
+
+func (r *LoanRepository) MarkReminded(loanID int, at string) error {
+	_, err := r.DB.Exec(`UPDATE book_loans SET reminder_sent_at = ? WHERE id = ?`, at, loanID)
+	return err
+}
+
+func (r *LoanRepository) MarkOverdueNotified(loanID int, at string) error {
+	_, err := r.DB.Exec(`UPDATE book_loans SET overdue_notified_at = ? WHERE id = ?`, at, loanID)
+	return err
+}
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"ktabnet/models"
+)
+
+type LocationRepository struct {
+	DB *sql.DB
+}
+
+func NewLocationRepository(db *sql.DB) *LocationRepository {
+	return &LocationRepository{DB: db}
+}
+
+// GetCities returns every known city sorted by name
+func (r *LocationRepository) GetCities() ([]models.City, error) {
+	rows, err := r.DB.Query(`SELECT id, name, latitude, longitude FROM cities ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cities := []models.City{}
+	for rows.Next() {
+		var c models.City
+		if err := rows.Scan(&c.ID, &c.Name, &c.Latitude, &c.Longitude); err != nil {
+			return nil, err
+		}
+		cities = append(cities, c)
+	}
+	return cities, rows.Err()
+}
+
+// FindCity looks a city up by name or by its French or Arabic label, ignoring case
+// and accents; it returns sql.ErrNoRows when unknown
+func (r *LocationRepository) FindCity(name string) (models.City, error) {
+	var c models.City
+	err := r.DB.QueryRow(`
+		SELECT id, name, latitude, longitude FROM cities
+		WHERE name_key = normalize_text(TRIM(?1))
+		   OR normalize_text(label_fr) = normalize_text(TRIM(?1))
+		   OR normalize_text(label_ar) = normalize_text(TRIM(?1))
+		ORDER BY name_key = normalize_text(TRIM(?1)) DESC
+		LIMIT 1
+	`, name).Scan(&c.ID, &c.Name, &c.Latitude, &c.Longitude)
+	return c, err
+}
+
+// GetUserLocation returns the approximate location stored on a profile, nil when unset
+func (r *LocationRepository) GetUserLocation(userID int) (*models.GeoPoint, error) {
+	var lat, lng sql.NullFloat64
+	err := r.DB.QueryRow(`SELECT latitude, longitude FROM users WHERE id = ?`, userID).Scan(&lat, &lng)
+	if err != nil || !lat.Valid || !lng.Valid {
+		return nil, err
+	}
+	return &models.GeoPoint{Latitude: lat.Float64, Longitude: lng.Float64}, nil
+}
+
+// GetUserOrigin returns where a user is for distance sorting: the profile location,
+// or else the coordinates of the profile city. It is nil when neither is known.
+func (r *LocationRepository) GetUserOrigin(userID int) (*models.GeoPoint, error) {
+	var lat, lng sql.NullFloat64
+	err := r.DB.QueryRow(`
+		SELECT COALESCE(u.latitude, c.latitude), COALESCE(u.longitude, c.longitude)
+		FROM users u
+		LEFT JOIN cities c ON c.name_key = normalize_text(TRIM(u.city))
+		WHERE u.id = ?
+	`, userID).Scan(&lat, &lng)
+	if err != nil || !lat.Valid || !lng.Valid {
+		return nil, err
+	}
+	return &models.GeoPoint{Latitude: lat.Float64, Longitude: lng.Float64}, nil
+}
+
+// SetUserLocation stores the approximate location of a user; nil clears it
+func (r *LocationRepository) SetUserLocation(userID int, point *models.GeoPoint) error {
+	var lat, lng interface{}
+	if point != nil {
+		lat, lng = point.Latitude, point.Longitude
+	}
+	_, err := r.DB.Exec(`UPDATE users SET latitude = ?, longitude = ? WHERE id = ?`, lat, lng, userID)
+	return err
+}
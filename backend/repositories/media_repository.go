@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// mediaColumns lists every column that stores the URL of an uploaded file
+var mediaColumns = []struct{ table, column string }{
+	{"book_images", "image_url"},
+	{"users", "avatar"},
+	{"posts", "image_url"},
+	{"comments", "image"},
+}
+
+// MediaRepository works on upload URLs across all tables
+type MediaRepository struct {
+	DB *sql.DB
+}
+
+func NewMediaRepository(db *sql.DB) *MediaRepository {
+	return &MediaRepository{DB: db}
+}
+
+// RewriteURLs replaces every stored upload URL for which rewrite reports true
+// and returns the number of rows changed
+func (r *MediaRepository) RewriteURLs(rewrite func(url string) (string, bool)) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	for _, mc := range mediaColumns {
+		rows, err := tx.Query(fmt.Sprintf(`SELECT rowid, %s FROM %s WHERE %s IS NOT NULL AND %s != ''`,
+			mc.column, mc.table, mc.column, mc.column))
+		if err != nil {
+			return 0, err
+		}
+
+		updates := map[int64]string{}
+		for rows.Next() {
+			var id int64
+			var url string
+			if err := rows.Scan(&id, &url); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if newURL, ok := rewrite(url); ok && newURL != url {
+				updates[id] = newURL
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		for id, url := range updates {
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, mc.table, mc.column), url, id); err != nil {
+				return 0, err
+			}
+			changed++
+		}
+	}
+	return changed, tx.Commit()
+}
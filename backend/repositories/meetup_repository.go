@@ -0,0 +1,348 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"ktabnet/models"
+)
+
+var (
+	// ErrMeetupTransition is returned when a meetup is not in a status that allows a change
+	ErrMeetupTransition = errors.New("meetup status does not allow this change")
+	// ErrMeetupExists is returned when the exchange already has a meetup to reschedule
+	ErrMeetupExists = errors.New("the exchange already has a meetup")
+	// ErrSpotUnavailable is returned for an unknown or retired meetup spot
+	ErrSpotUnavailable = errors.New("meetup spot not available")
+)
+
+type MeetupRepository struct {
+	DB *sql.DB
+}
+
+func NewMeetupRepository(db *sql.DB) *MeetupRepository {
+	return &MeetupRepository{DB: db}
+}
+
+const spotSelect = `SELECT id, city, name, address, latitude, longitude, active FROM meetup_spots`
+
+func scanSpot(row rowScanner) (models.MeetupSpot, error) {
+	var spot models.MeetupSpot
+	var lat, lng sql.NullFloat64
+	err := row.Scan(&spot.ID, &spot.City, &spot.Name, &spot.Address, &lat, &lng, &spot.Active)
+	if lat.Valid && lng.Valid {
+		spot.Latitude, spot.Longitude = &lat.Float64, &lng.Float64
+	}
+	return spot, err
+}
+
+// GetSpots lists the spots of a city, or of every city when city is empty, by city and
+// name. Retired spots are only included with all.
+func (r *MeetupRepository) GetSpots(city string, all bool) ([]models.MeetupSpot, error) {
+	var where []string
+	var args []interface{}
+	if city != "" {
+		where = append(where, "city = ?")
+		args = append(args, city)
+	}
+	if !all {
+		where = append(where, "active = 1")
+	}
+	query := spotSelect
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := r.DB.Query(query+" ORDER BY city, name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	spots := []models.MeetupSpot{}
+	for rows.Next() {
+		spot, err := scanSpot(rows)
+		if err != nil {
+			return nil, err
+		}
+		spots = append(spots, spot)
+	}
+	return spots, rows.Err()
+}
+
+// GetSpot returns one spot, or sql.ErrNoRows
+func (r *MeetupRepository) GetSpot(id int) (models.MeetupSpot, error) {
+	return scanSpot(r.DB.QueryRow(spotSelect+` WHERE id = ?`, id))
+}
+
+// SpotExists reports whether a city already has a spot with this name, ignoring case
+func (r *MeetupRepository) SpotExists(city, name string, exceptID int) (bool, error) {
+	var n int
+	err := r.DB.QueryRow(`
+		SELECT COUNT(*) FROM meetup_spots WHERE city = ? AND name = ? COLLATE NOCASE AND id != ?
+	`, city, name, exceptID).Scan(&n)
+	return n > 0, err
+}
+
+func (r *MeetupRepository) CreateSpot(spot models.MeetupSpot) (int, error) {
+	res, err := r.DB.Exec(`
+		INSERT INTO meetup_spots (city, name, address, latitude, longitude) VALUES (?, ?, ?, ?, ?)
+	`, spot.City, spot.Name, spot.Address, spot.Latitude, spot.Longitude)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	return int(id), nil
+}
+
+func (r *MeetupRepository) UpdateSpot(spot models.MeetupSpot) error {
+	_, err := r.DB.Exec(`
+		UPDATE meetup_spots SET city = ?, name = ?, address = ?, latitude = ?, longitude = ?, active = ? WHERE id = ?
+	`, spot.City, spot.Name, spot.Address, spot.Latitude, spot.Longitude, spot.Active, spot.ID)
+	return err
+}
+
+// meetupSelect reads meetups with their spot, exchange status and both parties
+const meetupSelect = `
+	SELECT m.id, m.exchange_id, e.status,
+	       s.id, s.city, s.name, s.address, s.latitude, s.longitude, s.active,
+	       m.starts_at, m.status, m.proposed_by, m.note, m.sequence,
+	       e.owner_id, COALESCE(ou.first_name || ' ' || ou.last_name, ''),
+	       e.requester_id, COALESCE(ru.first_name || ' ' || ru.last_name, ''),
+	       m.created_at, m.updated_at, m.accepted_at
+	FROM exchange_meetups m
+	JOIN book_exchanges e ON e.id = m.exchange_id
+	JOIN meetup_spots s ON s.id = m.spot_id
+	JOIN users ou ON ou.id = e.owner_id
+	JOIN users ru ON ru.id = e.requester_id
+`
+
+func scanMeetup(row rowScanner) (models.ExchangeMeetup, error) {
+	var m models.ExchangeMeetup
+	var lat, lng sql.NullFloat64
+	var acceptedAt sql.NullString
+	err := row.Scan(&m.ID, &m.ExchangeID, &m.ExchangeStatus,
+		&m.Spot.ID, &m.Spot.City, &m.Spot.Name, &m.Spot.Address, &lat, &lng, &m.Spot.Active,
+		&m.StartsAt, &m.Status, &m.ProposedBy, &m.Note, &m.Sequence,
+		&m.OwnerID, &m.OwnerName, &m.RequesterID, &m.RequesterName,
+		&m.CreatedAt, &m.UpdatedAt, &acceptedAt)
+	if lat.Valid && lng.Valid {
+		m.Spot.Latitude, m.Spot.Longitude = &lat.Float64, &lng.Float64
+	}
+	m.AcceptedAt = acceptedAt.String
+	return m, err
+}
+
+// queryMeetups runs a meetupSelect query and loads the books of each exchange
+func (r *MeetupRepository) queryMeetups(query string, args ...interface{}) ([]models.ExchangeMeetup, error) {
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	meetups := []models.ExchangeMeetup{}
+	for rows.Next() {
+		m, err := scanMeetup(rows)
+		if err != nil {
+			return nil, err
+		}
+		meetups = append(meetups, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range meetups {
+		bookRows, err := r.DB.Query(exchangeBooksSelect+` WHERE i.exchange_id = ? ORDER BY i.position, i.book_id`, meetups[i].ExchangeID)
+		if err != nil {
+			return nil, err
+		}
+		exchanges := []models.BookExchangeRequest{{ID: meetups[i].ExchangeID}}
+		if err := loadExchangeBooks(exchanges, bookRows); err != nil {
+			return nil, err
+		}
+		meetups[i].RequestedBooks, meetups[i].OfferedBooks = exchanges[0].RequestedBooks, exchanges[0].OfferedBooks
+	}
+	return meetups, nil
+}
+
+func (r *MeetupRepository) getOne(query string, args ...interface{}) (models.ExchangeMeetup, error) {
+	meetups, err := r.queryMeetups(query, args...)
+	if err != nil {
+		return models.ExchangeMeetup{}, err
+	}
+	if len(meetups) == 0 {
+		return models.ExchangeMeetup{}, sql.ErrNoRows
+	}
+	return meetups[0], nil
+}
+
+// GetByID returns one meetup, or sql.ErrNoRows
+func (r *MeetupRepository) GetByID(meetupID int) (models.ExchangeMeetup, error) {
+	return r.getOne(meetupSelect+` WHERE m.id = ?`, meetupID)
+}
+
+// GetByExchange returns the meetup of an exchange, or sql.ErrNoRows
+func (r *MeetupRepository) GetByExchange(exchangeID int) (models.ExchangeMeetup, error) {
+	return r.getOne(meetupSelect+` WHERE m.exchange_id = ?`, exchangeID)
+}
+
+// GetUpcoming returns the meetups of a user's ongoing exchanges starting at or after
+// from (RFC 3339, UTC), soonest first
+func (r *MeetupRepository) GetUpcoming(userID int, from string) ([]models.ExchangeMeetup, error) {
+	return r.queryMeetups(meetupSelect+`
+		WHERE (e.owner_id = ? OR e.requester_id = ?) AND m.starts_at >= ?
+		  AND e.status IN ('accepted', 'meetup_scheduled')
+		ORDER BY m.starts_at, m.id
+	`, userID, userID, from)
+}
+
+// checkMeetupChange makes sure the exchange is still heading to a handover and the spot
+// is active
+func checkMeetupChange(db dbtx, exchangeID, spotID int) (string, error) {
+	var status string
+	if err := db.QueryRow(`SELECT status FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&status); err != nil {
+		return "", err
+	}
+	if status != models.ExchangeStatusAccepted && status != models.ExchangeStatusMeetupScheduled {
+		return status, ErrExchangeTransition
+	}
+	var active bool
+	err := db.QueryRow(`SELECT active FROM meetup_spots WHERE id = ?`, spotID).Scan(&active)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		return status, ErrSpotUnavailable
+	}
+	return status, err
+}
+
+// Create proposes the meetup of an accepted exchange; the other party answers it
+func (r *MeetupRepository) Create(exchangeID, actorID, spotID int, startsAt, note string) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	status, err := checkMeetupChange(tx, exchangeID, spotID)
+	if err != nil {
+		return 0, err
+	}
+	var existing int
+	_ = tx.QueryRow(`SELECT id FROM exchange_meetups WHERE exchange_id = ?`, exchangeID).Scan(&existing)
+	if existing != 0 {
+		return existing, ErrMeetupExists
+	}
+	res, err := tx.Exec(`
+		INSERT INTO exchange_meetups (exchange_id, spot_id, starts_at, proposed_by, note) VALUES (?, ?, ?, ?, ?)
+	`, exchangeID, spotID, startsAt, actorID, note)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if err := recordExchangeEvent(tx, exchangeID, status, status, actorID, "meetup proposed for "+startsAt); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// Reschedule replaces the time and spot of a meetup with a new proposal of the actor
+func (r *MeetupRepository) Reschedule(meetupID, actorID, spotID int, startsAt, note string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exchangeID int
+	if err := tx.QueryRow(`SELECT exchange_id FROM exchange_meetups WHERE id = ?`, meetupID).Scan(&exchangeID); err != nil {
+		return err
+	}
+	status, err := checkMeetupChange(tx, exchangeID, spotID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE exchange_meetups
+		SET spot_id = ?, starts_at = ?, note = ?, proposed_by = ?, status = 'proposed', accepted_at = NULL,
+		    sequence = sequence + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, spotID, startsAt, note, actorID, meetupID); err != nil {
+		return err
+	}
+	if err := recordExchangeEvent(tx, exchangeID, status, status, actorID, "meetup rescheduled to "+startsAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Accept agrees to the proposed meetup of the other party. The first accepted meetup of
+// an exchange schedules it: its books are then in exchange.
+func (r *MeetupRepository) Accept(meetupID, actorID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exchangeID int
+	var startsAt string
+	err = tx.QueryRow(`SELECT exchange_id, starts_at FROM exchange_meetups WHERE id = ?`, meetupID).Scan(&exchangeID, &startsAt)
+	if err != nil {
+		return err
+	}
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&status); err != nil {
+		return err
+	}
+
+	// The guard keeps the proposer from accepting their own proposal
+	res, err := tx.Exec(`
+		UPDATE exchange_meetups SET status = 'accepted', accepted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'proposed' AND proposed_by != ?
+	`, meetupID, actorID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMeetupTransition
+	}
+
+	note := "meetup accepted for " + startsAt
+	switch status {
+	case models.ExchangeStatusAccepted:
+		err = scheduleMeetup(tx, exchangeID, actorID, note)
+	case models.ExchangeStatusMeetupScheduled:
+		err = recordExchangeEvent(tx, exchangeID, status, status, actorID, note)
+	default:
+		err = ErrExchangeTransition
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetFeedToken returns the calendar feed token of a user, or sql.ErrNoRows
+func (r *MeetupRepository) GetFeedToken(userID int) (string, error) {
+	var token string
+	err := r.DB.QueryRow(`SELECT token FROM calendar_feeds WHERE user_id = ?`, userID).Scan(&token)
+	return token, err
+}
+
+// SetFeedToken gives a user a new calendar feed token, replacing the previous one
+func (r *MeetupRepository) SetFeedToken(userID int, token string) error {
+	_, err := r.DB.Exec(`
+		INSERT INTO calendar_feeds (user_id, token) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET token = excluded.token, created_at = CURRENT_TIMESTAMP
+	`, userID, token)
+	return err
+}
+
+// GetFeedUser returns the user of a calendar feed token, or sql.ErrNoRows
+func (r *MeetupRepository) GetFeedUser(token string) (int, error) {
+	var userID int
+	err := r.DB.QueryRow(`SELECT user_id FROM calendar_feeds WHERE token = ?`, token).Scan(&userID)
+	return userID, err
+}
@@ -3,6 +3,7 @@ package repositories
 import (
 	"database/sql"
 	"ktabnet/models"
+	"ktabnet/utils"
 	"strings"
 )
 
@@ -89,13 +90,16 @@ func (r *SqliteProfileRepo) IsPending(followerID, followedID int) (bool, error)
 	return status == "pending", nil
 }
 
+// SearchUsers matches names and nicknames after folding both sides with
+// normalize_text, so "emile" finds "Émile" and Arabic spelling variants match
 func (ur *SqliteProfileRepo) SearchUsers(query string) ([]models.SearchResult, error) {
-	search := "%" + strings.ToLower(query) + "%"
+	search := "%" + utils.NormalizeText(strings.TrimSpace(query)) + "%"
 	rows, err := ur.db.Query(`
 		SELECT id, first_name, last_name, nickname
 		FROM users
-		WHERE LOWER(first_name) LIKE ? OR LOWER(last_name) LIKE ? OR LOWER(nickname) LIKE ?
-	`, search, search, search)
+		WHERE normalize_text(first_name) LIKE ? OR normalize_text(last_name) LIKE ? OR normalize_text(nickname) LIKE ?
+		   OR normalize_text(first_name || ' ' || last_name) LIKE ?
+	`, search, search, search, search)
 	if err != nil {
 		return nil, err
 	}
@@ -118,6 +122,13 @@ func (r *SqliteProfileRepo) TogglePrivacy(userID int, isPrivate bool) error {
 	return err
 }
 
+// AvatarInUse reports whether any user has url as avatar
+func (r *SqliteProfileRepo) AvatarInUse(url string) (bool, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM users WHERE avatar = ?`, url).Scan(&n)
+	return n > 0, err
+}
+
 // UpdateProfile updates a user's profile fields
 func (r *SqliteProfileRepo) UpdateProfile(userID int, req models.UpdateProfileRequest) error {
 	if req.Avatar != "" {
@@ -0,0 +1,126 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+
+	"ktabnet/models"
+)
+
+// ErrAlreadyRated is returned when a party rates the same exchange twice
+var ErrAlreadyRated = errors.New("exchange already rated")
+
+type RatingRepository struct {
+	DB *sql.DB
+}
+
+func NewRatingRepository(db *sql.DB) *RatingRepository {
+	return &RatingRepository{DB: db}
+}
+
+const ratingSelect = `
+	SELECT r.id, r.exchange_id, r.rater_id, COALESCE(u.first_name || ' ' || u.last_name, ''),
+	       r.ratee_id, r.stars, r.comment, r.created_at
+	FROM exchange_ratings r
+	LEFT JOIN users u ON u.id = r.rater_id`
+
+func queryRatings(db dbtx, query string, args ...interface{}) ([]models.ExchangeRating, error) {
+	rows, err := db.Query(ratingSelect+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := []models.ExchangeRating{}
+	for rows.Next() {
+		var rating models.ExchangeRating
+		if err := rows.Scan(&rating.ID, &rating.ExchangeID, &rating.RaterID, &rating.RaterName,
+			&rating.RateeID, &rating.Stars, &rating.Comment, &rating.CreatedAt); err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, rating)
+	}
+	return ratings, rows.Err()
+}
+
+// Create saves the rating raterID gives rateeID for a completed exchange. It returns
+// ErrExchangeTransition when the exchange is not completed and ErrAlreadyRated when
+// the rater already rated it.
+func (r *RatingRepository) Create(exchangeID, raterID, rateeID, stars int, comment string) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM book_exchanges WHERE id = ?`, exchangeID).Scan(&status); err != nil {
+		return 0, err
+	}
+	if status != models.ExchangeStatusCompleted {
+		return 0, ErrExchangeTransition
+	}
+	res, err := tx.Exec(`
+		INSERT OR IGNORE INTO exchange_ratings (exchange_id, rater_id, ratee_id, stars, comment) VALUES (?, ?, ?, ?, ?)
+	`, exchangeID, raterID, rateeID, stars, comment)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrAlreadyRated
+	}
+	id, _ := res.LastInsertId()
+	return int(id), tx.Commit()
+}
+
+// GetForExchange returns the ratings of an exchange, at most one per party
+func (r *RatingRepository) GetForExchange(exchangeID int) ([]models.ExchangeRating, error) {
+	return queryRatings(r.DB, ` WHERE r.exchange_id = ? ORDER BY r.id`, exchangeID)
+}
+
+// GetReceived returns the latest ratings a user received, newest first
+func (r *RatingRepository) GetReceived(userID, limit int) ([]models.ExchangeRating, error) {
+	return queryRatings(r.DB, ` WHERE r.ratee_id = ? ORDER BY r.created_at DESC, r.id DESC LIMIT ?`, userID, limit)
+}
+
+// GetReputation returns the reputation of a user
+func (r *RatingRepository) GetReputation(userID int) (models.Reputation, error) {
+	return userReputation(r.DB, userID)
+}
+
+// userReputation computes a user's reputation from the ratings they received and the
+// exchanges they took part in. An exchange counts as closed once it ended after being
+// accepted: completed, disputed, or cancelled after acceptance. Exchanges cancelled
+// because the other party did not show up are not held against the user.
+func userReputation(db dbtx, userID int) (models.Reputation, error) {
+	var rep models.Reputation
+	var average sql.NullFloat64
+	err := db.QueryRow(`
+		SELECT AVG(stars), COUNT(*) FROM exchange_ratings WHERE ratee_id = ?
+	`, userID).Scan(&average, &rep.RatingCount)
+	if err != nil {
+		return rep, err
+	}
+	if average.Valid {
+		rep.AverageRating = math.Round(average.Float64*10) / 10
+	}
+
+	err = db.QueryRow(`
+		SELECT COALESCE(SUM(e.status = 'completed'), 0),
+		       COALESCE(SUM(e.status IN ('completed', 'disputed') OR (e.status = 'cancelled'
+		           AND (e.no_show_user_id IS NULL OR e.no_show_user_id = ?)
+		           AND EXISTS (SELECT 1 FROM book_exchange_events ev WHERE ev.exchange_id = e.id AND ev.to_status = 'accepted'))), 0),
+		       COALESCE(SUM(e.no_show_user_id = ?), 0)
+		FROM book_exchanges e
+		WHERE e.owner_id = ? OR e.requester_id = ?
+	`, userID, userID, userID, userID).Scan(&rep.CompletedExchanges, &rep.ClosedExchanges, &rep.NoShows)
+	if err != nil {
+		return rep, err
+	}
+	if rep.ClosedExchanges > 0 {
+		rate := float64(rep.CompletedExchanges) / float64(rep.ClosedExchanges)
+		rep.CompletionRate = math.Round(rate*100) / 100
+	}
+	return rep, nil
+}
@@ -174,15 +174,9 @@ func (r *ReportRepository) SetUserRole(userID int, role string) error {
 	return err
 }
 
+// DeleteBook deletes a book the way its owner would, with the same status guard
 func (r *ReportRepository) DeleteBook(bookID int) error {
-	// First delete book images
-	_, err := r.DB.Exec("DELETE FROM book_images WHERE book_id = ?", bookID)
-	if err != nil {
-		return err
-	}
-	// Then delete the book
-	_, err = r.DB.Exec("DELETE FROM books WHERE id = ?", bookID)
-	return err
+	return NewBookRepository(r.DB).DeleteBook(bookID)
 }
 
 func (r *ReportRepository) GetAllUsers() ([]models.UserAdmin, error) {
@@ -224,6 +218,10 @@ func (r *ReportRepository) DeleteUser(userID int) error {
 	if err != nil {
 		return err
 	}
+	_, err = r.DB.Exec("DELETE FROM book_status_history WHERE book_id IN (SELECT id FROM books WHERE owner_id = ?)", userID)
+	if err != nil {
+		return err
+	}
 	_, err = r.DB.Exec("DELETE FROM books WHERE owner_id = ?", userID)
 	if err != nil {
 		return err
@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"ktabnet/models"
+)
+
+type SavedSearchRepository struct {
+	DB *sql.DB
+}
+
+func NewSavedSearchRepository(db *sql.DB) *SavedSearchRepository {
+	return &SavedSearchRepository{DB: db}
+}
+
+const savedSearchColumns = `id, user_id, name, query, frequency, last_book_id, last_run_at, created_at, updated_at`
+
+func scanSavedSearch(row interface{ Scan(...interface{}) error }) (models.SavedSearch, error) {
+	var s models.SavedSearch
+	err := row.Scan(&s.ID, &s.UserID, &s.Name, &s.Query, &s.Frequency, &s.LastBookID, &s.LastRunAt, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+func (r *SavedSearchRepository) Create(s models.SavedSearch) (int, error) {
+	res, err := r.DB.Exec(`
+		INSERT INTO saved_searches (user_id, name, query, frequency, last_book_id, last_run_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, s.UserID, s.Name, s.Query, s.Frequency, s.LastBookID, s.LastRunAt)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func (r *SavedSearchRepository) Update(s models.SavedSearch) error {
+	_, err := r.DB.Exec(`
+		UPDATE saved_searches SET name = ?, query = ?, frequency = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, s.Name, s.Query, s.Frequency, s.ID)
+	return err
+}
+
+func (r *SavedSearchRepository) Delete(id int) error {
+	_, err := r.DB.Exec(`DELETE FROM saved_searches WHERE id = ?`, id)
+	return err
+}
+
+func (r *SavedSearchRepository) GetByID(id int) (models.SavedSearch, error) {
+	return scanSavedSearch(r.DB.QueryRow(`SELECT `+savedSearchColumns+` FROM saved_searches WHERE id = ?`, id))
+}
+
+func (r *SavedSearchRepository) GetByUser(userID int) ([]models.SavedSearch, error) {
+	rows, err := r.DB.Query(`SELECT `+savedSearchColumns+` FROM saved_searches WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanSavedSearches(rows)
+}
+
+// GetDue returns the searches whose digest period has elapsed at now ("YYYY-MM-DD HH:MM:SS", UTC)
+func (r *SavedSearchRepository) GetDue(now string) ([]models.SavedSearch, error) {
+	rows, err := r.DB.Query(`
+		SELECT `+savedSearchColumns+` FROM saved_searches
+		WHERE (frequency = 'hourly' AND last_run_at <= datetime(?, '-1 hours'))
+		   OR (frequency = 'daily' AND last_run_at <= datetime(?, '-1 days'))
+		ORDER BY id
+	`, now, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanSavedSearches(rows)
+}
+
+// MarkRun records that a digest covered the listings up to lastBookID at runAt
+func (r *SavedSearchRepository) MarkRun(id, lastBookID int, runAt string) error {
+	_, err := r.DB.Exec(`UPDATE saved_searches SET last_book_id = ?, last_run_at = ? WHERE id = ?`, lastBookID, runAt, id)
+	return err
+}
+
+func scanSavedSearches(rows *sql.Rows) ([]models.SavedSearch, error) {
+	defer rows.Close()
+	searches := []models.SavedSearch{}
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, s)
+	}
+	return searches, rows.Err()
+}
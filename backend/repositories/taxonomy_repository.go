@@ -0,0 +1,176 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"ktabnet/models"
+)
+
+// taxonomyColumns maps each taxonomy kind to its table and the books column holding its names
+var taxonomyColumns = map[string]string{
+	models.TaxonomyGenres:     "genre",
+	models.TaxonomyConditions: "condition",
+	models.TaxonomyCities:     "city",
+	models.TaxonomyLanguages:  "language",
+	models.TaxonomyFormats:    "format",
+}
+
+// taxonomyReferences lists the tables besides books that hold term names of a kind,
+// as "table.column" pairs
+var taxonomyReferences = map[string][]string{
+	models.TaxonomyGenres:     {"exchange_rule_genres.genre"},
+	models.TaxonomyConditions: {"exchange_rules.min_condition"},
+	models.TaxonomyCities:     {"users.city", "wishlist_cities.city", "meetup_spots.city"},
+}
+
+type TaxonomyRepository struct {
+	DB *sql.DB
+}
+
+func NewTaxonomyRepository(db *sql.DB) *TaxonomyRepository {
+	return &TaxonomyRepository{DB: db}
+}
+
+// table returns the table of a kind; kinds come from the URL, so only known ones pass
+func (r *TaxonomyRepository) table(kind string) (string, error) {
+	if _, ok := taxonomyColumns[kind]; !ok {
+		return "", fmt.Errorf("unknown taxonomy kind %q", kind)
+	}
+	return kind, nil
+}
+
+// termColumns selects a term; only cities have coordinates
+func termColumns(kind string) string {
+	coords := "NULL, NULL"
+	if kind == models.TaxonomyCities {
+		coords = "latitude, longitude"
+	}
+	return "id, name, label_ar, label_fr, label_en, sort_order, " + coords
+}
+
+func scanTerm(row interface{ Scan(...interface{}) error }) (models.TaxonomyTerm, error) {
+	var t models.TaxonomyTerm
+	var lat, lng sql.NullFloat64
+	err := row.Scan(&t.ID, &t.Name, &t.Labels.Ar, &t.Labels.Fr, &t.Labels.En, &t.SortOrder, &lat, &lng)
+	if lat.Valid && lng.Valid {
+		t.Latitude, t.Longitude = &lat.Float64, &lng.Float64
+	}
+	return t, err
+}
+
+// List returns the terms of a kind in display order
+func (r *TaxonomyRepository) List(kind string) ([]models.TaxonomyTerm, error) {
+	table, err := r.table(kind)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.Query(`SELECT ` + termColumns(kind) + ` FROM ` + table + ` ORDER BY sort_order, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := []models.TaxonomyTerm{}
+	for rows.Next() {
+		t, err := scanTerm(rows)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, rows.Err()
+}
+
+func (r *TaxonomyRepository) GetByID(kind string, id int) (models.TaxonomyTerm, error) {
+	table, err := r.table(kind)
+	if err != nil {
+		return models.TaxonomyTerm{}, err
+	}
+	return scanTerm(r.DB.QueryRow(`SELECT `+termColumns(kind)+` FROM `+table+` WHERE id = ?`, id))
+}
+
+// FindByName looks a term up ignoring case; it returns sql.ErrNoRows when unknown
+func (r *TaxonomyRepository) FindByName(kind, name string) (models.TaxonomyTerm, error) {
+	table, err := r.table(kind)
+	if err != nil {
+		return models.TaxonomyTerm{}, err
+	}
+	return scanTerm(r.DB.QueryRow(`SELECT `+termColumns(kind)+` FROM `+table+` WHERE name = ? COLLATE NOCASE`, name))
+}
+
+func (r *TaxonomyRepository) Create(kind string, t models.TaxonomyTerm) (int, error) {
+	var res sql.Result
+	var err error
+	switch kind {
+	case models.TaxonomyCities:
+		res, err = r.DB.Exec(`
+			INSERT INTO cities (name, name_key, label_ar, label_fr, label_en, sort_order, latitude, longitude)
+			VALUES (?, normalize_text(?), ?, ?, ?, ?, ?, ?)
+		`, t.Name, t.Name, t.Labels.Ar, t.Labels.Fr, t.Labels.En, t.SortOrder, t.Latitude, t.Longitude)
+	default:
+		table, terr := r.table(kind)
+		if terr != nil {
+			return 0, terr
+		}
+		res, err = r.DB.Exec(`
+			INSERT INTO `+table+` (name, label_ar, label_fr, label_en, sort_order) VALUES (?, ?, ?, ?, ?)
+		`, t.Name, t.Labels.Ar, t.Labels.Fr, t.Labels.En, t.SortOrder)
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// Update changes the labels and order of a term, and the coordinates of a city.
+// The name is kept since books refer to it.
+func (r *TaxonomyRepository) Update(kind string, t models.TaxonomyTerm) error {
+	var err error
+	switch kind {
+	case models.TaxonomyCities:
+		_, err = r.DB.Exec(`
+			UPDATE cities SET label_ar = ?, label_fr = ?, label_en = ?, sort_order = ?, latitude = ?, longitude = ?
+			WHERE id = ?
+		`, t.Labels.Ar, t.Labels.Fr, t.Labels.En, t.SortOrder, t.Latitude, t.Longitude, t.ID)
+	default:
+		table, terr := r.table(kind)
+		if terr != nil {
+			return terr
+		}
+		_, err = r.DB.Exec(`
+			UPDATE `+table+` SET label_ar = ?, label_fr = ?, label_en = ?, sort_order = ? WHERE id = ?
+		`, t.Labels.Ar, t.Labels.Fr, t.Labels.En, t.SortOrder, t.ID)
+	}
+	return err
+}
+
+func (r *TaxonomyRepository) Delete(kind string, id int) error {
+	table, err := r.table(kind)
+	if err != nil {
+		return err
+	}
+	_, err = r.DB.Exec(`DELETE FROM `+table+` WHERE id = ?`, id)
+	return err
+}
+
+// InUse reports whether any listing, profile, wish, meetup spot or exchange rule still
+// uses the term name of a kind
+func (r *TaxonomyRepository) InUse(kind, name string) (bool, error) {
+	column, ok := taxonomyColumns[kind]
+	if !ok {
+		return false, fmt.Errorf("unknown taxonomy kind %q", kind)
+	}
+	refs := append([]string{"books." + column}, taxonomyReferences[kind]...)
+	for _, ref := range refs {
+		table, col, _ := strings.Cut(ref, ".")
+		var n int
+		err := r.DB.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE `+col+` = ?`, name).Scan(&n)
+		if err != nil || n > 0 {
+			return n > 0, err
+		}
+	}
+	return false, nil
+}
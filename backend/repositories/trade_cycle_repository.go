@@ -0,0 +1,458 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"ktabnet/models"
+)
+
+var (
+	// ErrTradeCycleTransition is returned when a cycle or a participant's leg is not in a
+	// state that allows a change
+	ErrTradeCycleTransition = errors.New("trade cycle status does not allow this change")
+	// ErrTradeCycleUnavailable is returned when a book of a cycle left the market
+	ErrTradeCycleUnavailable = errors.New("a book of the trade cycle is no longer available")
+)
+
+type TradeCycleRepository struct {
+	DB *sql.DB
+}
+
+func NewTradeCycleRepository(db *sql.DB) *TradeCycleRepository {
+	return &TradeCycleRepository{DB: db}
+}
+
+// openCycleUsers selects the participants of proposed and active cycles
+const openCycleUsers = `
+	SELECT l.receiver_id FROM trade_cycle_legs l JOIN trade_cycles c ON c.id = l.cycle_id
+	WHERE c.status IN ('proposed', 'active')
+`
+
+// GetWants returns the matching graph: for every user, the listed exchange books of
+// other users they asked for in a pending or declined exchange request or that satisfy
+// one of their wishes. Users already in an open cycle are left out.
+func (r *TradeCycleRepository) GetWants() ([]models.TradeWant, error) {
+	rows, err := r.DB.Query(`
+		SELECT DISTINCT x.user_id, b.id, b.owner_id, b.city
+		FROM (
+			SELECT e.requester_id AS user_id, i.book_id
+			FROM book_exchange_items i
+			JOIN book_exchanges e ON e.id = i.exchange_id
+			WHERE i.side = 'requested' AND e.status IN ('pending', 'declined')
+			UNION
+			SELECT w.user_id, wb.id
+			FROM wishlists w
+			JOIN books wb ON (w.isbn != '' AND w.isbn = wb.isbn)
+			              OR (w.match_key != '' AND w.match_key = (SELECT work_key FROM works WHERE id = wb.work_id))
+			WHERE NOT EXISTS (SELECT 1 FROM wishlist_cities c WHERE c.wishlist_id = w.id)
+			   OR EXISTS (SELECT 1 FROM wishlist_cities c WHERE c.wishlist_id = w.id AND c.city = wb.city COLLATE NOCASE)
+		) x
+		JOIN books b ON b.id = x.book_id
+		WHERE b.status = 'listed' AND b.listing_type = 'exchange' AND b.owner_id != x.user_id
+		  AND x.user_id NOT IN (` + openCycleUsers + `)
+		  AND b.owner_id NOT IN (` + openCycleUsers + `)
+		ORDER BY x.user_id, b.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wants []models.TradeWant
+	for rows.Next() {
+		var w models.TradeWant
+		if err := rows.Scan(&w.WanterID, &w.BookID, &w.OwnerID, &w.City); err != nil {
+			return nil, err
+		}
+		wants = append(wants, w)
+	}
+	return wants, rows.Err()
+}
+
+// GetSignatures returns the signatures of every cycle proposed so far
+func (r *TradeCycleRepository) GetSignatures() (map[string]bool, error) {
+	rows, err := r.DB.Query(`SELECT signature FROM trade_cycles`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	signatures := map[string]bool{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		signatures[s] = true
+	}
+	return signatures, rows.Err()
+}
+
+// Create stores a proposed cycle. legs[i].WanterID receives legs[i].BookID from
+// legs[i].OwnerID.
+func (r *TradeCycleRepository) Create(city, signature string, legs []models.TradeWant) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO trade_cycles (city, signature) VALUES (?, ?)`, city, signature)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	for i, leg := range legs {
+		if _, err := tx.Exec(`
+			INSERT INTO trade_cycle_legs (cycle_id, position, receiver_id, giver_id, book_id) VALUES (?, ?, ?, ?, ?)
+		`, id, i, leg.WanterID, leg.OwnerID, leg.BookID); err != nil {
+			return 0, err
+		}
+	}
+	return int(id), tx.Commit()
+}
+
+// CancelStale cancels the proposed cycles with a book that is no longer a listed
+// exchange book of its giver and returns their ids
+func (r *TradeCycleRepository) CancelStale() ([]int, error) {
+	rows, err := r.DB.Query(`
+		SELECT DISTINCT c.id
+		FROM trade_cycles c
+		JOIN trade_cycle_legs l ON l.cycle_id = c.id
+		LEFT JOIN books b ON b.id = l.book_id
+		WHERE c.status = 'proposed'
+		  AND (b.id IS NULL OR b.status != 'listed' OR b.listing_type != 'exchange' OR b.owner_id != l.giver_id)
+		ORDER BY c.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var cancelled []int
+	for _, id := range ids {
+		if _, err := transitionCycle(r.DB, id, []string{models.TradeCycleStatusProposed}, models.TradeCycleStatusCancelled); err != nil {
+			if err == ErrTradeCycleTransition {
+				continue
+			}
+			return cancelled, err
+		}
+		cancelled = append(cancelled, id)
+	}
+	return cancelled, nil
+}
+
+// GetByID returns a cycle with its legs, or sql.ErrNoRows
+func (r *TradeCycleRepository) GetByID(cycleID int) (models.TradeCycle, error) {
+	var c models.TradeCycle
+	var activatedAt, completedAt sql.NullString
+	err := r.DB.QueryRow(`
+		SELECT id, city, status, created_at, updated_at, activated_at, completed_at FROM trade_cycles WHERE id = ?
+	`, cycleID).Scan(&c.ID, &c.City, &c.Status, &c.CreatedAt, &c.UpdatedAt, &activatedAt, &completedAt)
+	if err != nil {
+		return c, err
+	}
+	c.ActivatedAt = activatedAt.String
+	c.CompletedAt = completedAt.String
+	c.Legs, err = r.getLegs(cycleID)
+	return c, err
+}
+
+func (r *TradeCycleRepository) getLegs(cycleID int) ([]models.TradeCycleLeg, error) {
+	rows, err := r.DB.Query(`
+		SELECT l.position, l.receiver_id, COALESCE(ru.first_name || ' ' || ru.last_name, ''),
+		       l.giver_id, COALESCE(gu.first_name || ' ' || gu.last_name, ''),
+		       l.book_id, COALESCE(b.title, ''), COALESCE(b.author, ''),
+		       (SELECT image_url FROM book_images WHERE book_id = l.book_id ORDER BY order_index LIMIT 1),
+		       l.accepted_at IS NOT NULL, l.received_at IS NOT NULL
+		FROM trade_cycle_legs l
+		LEFT JOIN books b ON b.id = l.book_id
+		LEFT JOIN users ru ON ru.id = l.receiver_id
+		LEFT JOIN users gu ON gu.id = l.giver_id
+		WHERE l.cycle_id = ?
+		ORDER BY l.position
+	`, cycleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	legs := []models.TradeCycleLeg{}
+	for rows.Next() {
+		var leg models.TradeCycleLeg
+		var image sql.NullString
+		if err := rows.Scan(&leg.Position, &leg.ReceiverID, &leg.ReceiverName, &leg.GiverID, &leg.GiverName,
+			&leg.BookID, &leg.BookTitle, &leg.BookAuthor, &image, &leg.Accepted, &leg.Received); err != nil {
+			return nil, err
+		}
+		leg.BookImage = image.String
+		legs = append(legs, leg)
+	}
+	return legs, rows.Err()
+}
+
+// GetForUser returns the cycles a user takes part in, newest first
+func (r *TradeCycleRepository) GetForUser(userID int) ([]models.TradeCycle, error) {
+	rows, err := r.DB.Query(`
+		SELECT DISTINCT c.id FROM trade_cycles c JOIN trade_cycle_legs l ON l.cycle_id = c.id
+		WHERE l.receiver_id = ?
+		ORDER BY c.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	cycles := []models.TradeCycle{}
+	for _, id := range ids {
+		c, err := r.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, c)
+	}
+	return cycles, nil
+}
+
+// transitionCycle moves a cycle from one of the from statuses to status to and returns
+// the previous status, or ErrTradeCycleTransition
+func transitionCycle(db dbtx, cycleID int, from []string, to string) (string, error) {
+	var current string
+	if err := db.QueryRow(`SELECT status FROM trade_cycles WHERE id = ?`, cycleID).Scan(&current); err != nil {
+		return "", err
+	}
+	if !containsString(from, current) {
+		return current, ErrTradeCycleTransition
+	}
+
+	res, err := db.Exec(`
+		UPDATE trade_cycles SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?
+	`, to, cycleID, current)
+	if err != nil {
+		return current, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return current, ErrTradeCycleTransition
+	}
+	return current, nil
+}
+
+// markLeg sets column (accepted_at or received_at) on the leg a user receives in, once
+func markLeg(db dbtx, cycleID, userID int, column string) error {
+	res, err := db.Exec(`
+		UPDATE trade_cycle_legs SET `+column+` = CURRENT_TIMESTAMP
+		WHERE cycle_id = ? AND receiver_id = ? AND `+column+` IS NULL
+	`, cycleID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTradeCycleTransition
+	}
+	return nil
+}
+
+// legsPending counts the legs of a cycle where column is not set yet
+func legsPending(db dbtx, cycleID int, column string) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM trade_cycle_legs WHERE cycle_id = ? AND `+column+` IS NULL`, cycleID).Scan(&n)
+	return n, err
+}
+
+type cycleLeg struct {
+	receiverID, giverID, bookID int
+}
+
+func cycleLegs(db dbtx, cycleID int) ([]cycleLeg, error) {
+	rows, err := db.Query(`SELECT receiver_id, giver_id, book_id FROM trade_cycle_legs WHERE cycle_id = ? ORDER BY position`, cycleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var legs []cycleLeg
+	for rows.Next() {
+		var leg cycleLeg
+		if err := rows.Scan(&leg.receiverID, &leg.giverID, &leg.bookID); err != nil {
+			return nil, err
+		}
+		legs = append(legs, leg)
+	}
+	return legs, rows.Err()
+}
+
+// Accept records a participant's acceptance of a proposed cycle. The last acceptance
+// activates the cycle and reserves all its books; when one of them left the market the
+// cycle is cancelled and ErrTradeCycleUnavailable returned.
+func (r *TradeCycleRepository) Accept(cycleID, userID int) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM trade_cycles WHERE id = ?`, cycleID).Scan(&status); err != nil {
+		return false, err
+	}
+	if status != models.TradeCycleStatusProposed {
+		return false, ErrTradeCycleTransition
+	}
+	if err := markLeg(tx, cycleID, userID, "accepted_at"); err != nil {
+		return false, err
+	}
+	pending, err := legsPending(tx, cycleID, "accepted_at")
+	if err != nil {
+		return false, err
+	}
+	if pending > 0 {
+		return false, tx.Commit()
+	}
+
+	legs, err := cycleLegs(tx, cycleID)
+	if err != nil {
+		return false, err
+	}
+	// A book that left the market since the proposal cancels the whole cycle
+	unavailable := func() (bool, error) {
+		tx.Rollback()
+		if _, err := transitionCycle(r.DB, cycleID, []string{models.TradeCycleStatusProposed}, models.TradeCycleStatusCancelled); err != nil {
+			return false, err
+		}
+		return false, ErrTradeCycleUnavailable
+	}
+	reason := fmt.Sprintf("trade cycle #%d", cycleID)
+	for _, leg := range legs {
+		var owner int
+		var listingType string
+		err := tx.QueryRow(`SELECT owner_id, listing_type FROM books WHERE id = ?`, leg.bookID).Scan(&owner, &listingType)
+		if err == sql.ErrNoRows || err == nil && (owner != leg.giverID || listingType != models.ListingTypeExchange) {
+			return unavailable()
+		}
+		if err != nil {
+			return false, err
+		}
+		err = setBookStatus(tx, leg.bookID, []string{models.BookStatusListed}, models.BookStatusReserved, 0, reason)
+		if err == ErrBookStatusConflict {
+			return unavailable()
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if _, err := transitionCycle(tx, cycleID, []string{models.TradeCycleStatusProposed}, models.TradeCycleStatusActive); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE trade_cycles SET activated_at = CURRENT_TIMESTAMP WHERE id = ?`, cycleID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Decline ends a proposed or active cycle; the books of an active one are listed again
+func (r *TradeCycleRepository) Decline(cycleID, userID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	previous, err := transitionCycle(tx, cycleID, []string{models.TradeCycleStatusProposed, models.TradeCycleStatusActive}, models.TradeCycleStatusDeclined)
+	if err != nil {
+		return err
+	}
+	if previous == models.TradeCycleStatusActive {
+		legs, err := cycleLegs(tx, cycleID)
+		if err != nil {
+			return err
+		}
+		reason := fmt.Sprintf("trade cycle #%d declined", cycleID)
+		for _, leg := range legs {
+			err := setBookStatus(tx, leg.bookID, []string{models.BookStatusReserved}, models.BookStatusListed, userID, reason)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Confirm records that a participant received their book. The last confirmation
+// completes the cycle: every book goes to its receiver and every participant's
+// exchange count goes up by one.
+func (r *TradeCycleRepository) Confirm(cycleID, userID int) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM trade_cycles WHERE id = ?`, cycleID).Scan(&status); err != nil {
+		return false, err
+	}
+	if status != models.TradeCycleStatusActive {
+		return false, ErrTradeCycleTransition
+	}
+	if err := markLeg(tx, cycleID, userID, "received_at"); err != nil {
+		return false, err
+	}
+	pending, err := legsPending(tx, cycleID, "received_at")
+	if err != nil {
+		return false, err
+	}
+	if pending > 0 {
+		return false, tx.Commit()
+	}
+
+	legs, err := cycleLegs(tx, cycleID)
+	if err != nil {
+		return false, err
+	}
+	reason := fmt.Sprintf("trade cycle #%d completed", cycleID)
+	for _, leg := range legs {
+		if err := setBookStatus(tx, leg.bookID, []string{models.BookStatusReserved}, models.BookStatusExchanged, userID, reason); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(`UPDATE books SET owner_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, leg.receiverID, leg.bookID); err != nil {
+			return false, err
+		}
+		// Each participant receives one book, so this counts one exchange per participant
+		if _, err := tx.Exec(`UPDATE users SET exchange_count = exchange_count + 1 WHERE id = ?`, leg.receiverID); err != nil {
+			return false, err
+		}
+	}
+	if _, err := transitionCycle(tx, cycleID, []string{models.TradeCycleStatusActive}, models.TradeCycleStatusCompleted); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`UPDATE trade_cycles SET completed_at = CURRENT_TIMESTAMP WHERE id = ?`, cycleID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,178 @@
+package repositories
+
+import (
+	"database/sql"
+	"strings"
+
+	"ktabnet/models"
+)
+
+type WishlistRepository struct {
+	DB *sql.DB
+}
+
+func NewWishlistRepository(db *sql.DB) *WishlistRepository {
+	return &WishlistRepository{DB: db}
+}
+
+// wishMatchKey is the work key of a wish, or "" when it has no title and author
+func wishMatchKey(w models.Wishlist) string {
+	if strings.TrimSpace(w.Title) == "" || strings.TrimSpace(w.Author) == "" {
+		return ""
+	}
+	return workKey(w.Title, w.Author)
+}
+
+func (r *WishlistRepository) Create(w models.Wishlist) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO wishlists (user_id, isbn, title, author, match_key) VALUES (?, ?, ?, ?, ?)
+	`, w.UserID, w.ISBN, w.Title, w.Author, wishMatchKey(w))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := setWishlistCities(tx, int(id), w.Cities); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+func (r *WishlistRepository) Update(w models.Wishlist) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE wishlists SET isbn = ?, title = ?, author = ?, match_key = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, w.ISBN, w.Title, w.Author, wishMatchKey(w), w.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM wishlist_cities WHERE wishlist_id = ?`, w.ID); err != nil {
+		return err
+	}
+	if err := setWishlistCities(tx, w.ID, w.Cities); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setWishlistCities(tx *sql.Tx, wishlistID int, cities []string) error {
+	for _, city := range cities {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO wishlist_cities (wishlist_id, city) VALUES (?, ?)`, wishlistID, city); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *WishlistRepository) Delete(id int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM wishlist_cities WHERE wishlist_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM wishlists WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *WishlistRepository) GetByID(id int) (models.Wishlist, error) {
+	var w models.Wishlist
+	err := r.DB.QueryRow(`
+		SELECT id, user_id, isbn, title, author, created_at, updated_at FROM wishlists WHERE id = ?
+	`, id).Scan(&w.ID, &w.UserID, &w.ISBN, &w.Title, &w.Author, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return w, err
+	}
+	w.Cities, err = r.getCities(id)
+	return w, err
+}
+
+func (r *WishlistRepository) GetByUser(userID int) ([]models.Wishlist, error) {
+	rows, err := r.DB.Query(`
+		SELECT id, user_id, isbn, title, author, created_at, updated_at
+		FROM wishlists WHERE user_id = ? ORDER BY created_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	wishes, err := scanWishlists(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range wishes {
+		if wishes[i].Cities, err = r.getCities(wishes[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return wishes, nil
+}
+
+// FindMatches returns the wishes of other users that a new listing satisfies: same
+// ISBN, or same normalized title and author (directly or through the listing's
+// work), in one of the wish's cities when it has any
+func (r *WishlistRepository) FindMatches(book models.Book) ([]models.Wishlist, error) {
+	rows, err := r.DB.Query(`
+		SELECT w.id, w.user_id, w.isbn, w.title, w.author, w.created_at, w.updated_at
+		FROM wishlists w
+		WHERE w.user_id != ?
+		  AND ((w.isbn != '' AND w.isbn = ?)
+		       OR (w.match_key != '' AND w.match_key IN (?, COALESCE((SELECT work_key FROM works WHERE id = ?), ''))))
+		  AND (NOT EXISTS (SELECT 1 FROM wishlist_cities c WHERE c.wishlist_id = w.id)
+		       OR EXISTS (SELECT 1 FROM wishlist_cities c WHERE c.wishlist_id = w.id AND c.city = ? COLLATE NOCASE))
+		ORDER BY w.id
+	`, book.OwnerID, book.ISBN, workKey(book.Title, book.Author), book.WorkID, book.City)
+	if err != nil {
+		return nil, err
+	}
+	return scanWishlists(rows)
+}
+
+func scanWishlists(rows *sql.Rows) ([]models.Wishlist, error) {
+	defer rows.Close()
+	wishes := []models.Wishlist{}
+	for rows.Next() {
+		var w models.Wishlist
+		if err := rows.Scan(&w.ID, &w.UserID, &w.ISBN, &w.Title, &w.Author, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, err
+		}
+		wishes = append(wishes, w)
+	}
+	return wishes, rows.Err()
+}
+
+func (r *WishlistRepository) getCities(wishlistID int) ([]string, error) {
+	rows, err := r.DB.Query(`SELECT city FROM wishlist_cities WHERE wishlist_id = ? ORDER BY city`, wishlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cities := []string{}
+	for rows.Next() {
+		var city string
+		if err := rows.Scan(&city); err != nil {
+			return nil, err
+		}
+		cities = append(cities, city)
+	}
+	return cities, rows.Err()
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+	"ktabnet/utils"
+)
+
+// ErrMetadataNotFound is returned when no provider knows an ISBN
+var ErrMetadataNotFound = errors.New("no metadata found for this ISBN")
+
+// BookMetadataProvider looks up bibliographic data by ISBN
+type BookMetadataProvider interface {
+	LookupISBN(isbn string) (*models.BookMetadata, error)
+}
+
+// LocalCatalogProvider serves metadata from the book_catalog table
+type LocalCatalogProvider struct {
+	Repo *repositories.CatalogRepository
+}
+
+func NewLocalCatalogProvider(repo *repositories.CatalogRepository) *LocalCatalogProvider {
+	return &LocalCatalogProvider{Repo: repo}
+}
+
+func (p *LocalCatalogProvider) LookupISBN(isbn string) (*models.BookMetadata, error) {
+	meta, err := p.Repo.GetByISBN(isbn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMetadataNotFound
+	}
+	return meta, err
+}
+
+// ImportJSON loads a JSON array of models.BookMetadata into the catalog.
+// Entries without an ISBN or title are skipped.
+func (p *LocalCatalogProvider) ImportJSON(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var entries []models.BookMetadata
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, entry := range entries {
+		entry.ISBN = utils.CleanISBN(entry.ISBN)
+		if entry.ISBN == "" || entry.Title == "" {
+			continue
+		}
+		entry.Source = "local"
+		if err := p.Repo.Upsert(entry); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// HTTPMetadataProvider queries a Google Books compatible API
+// (GET {BaseURL}/volumes?q=isbn:{isbn}). BaseURL can point at a mock server.
+type HTTPMetadataProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewHTTPMetadataProvider(baseURL string) *HTTPMetadataProvider {
+	return &HTTPMetadataProvider{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type volumesResponse struct {
+	Items []struct {
+		VolumeInfo struct {
+			Title       string   `json:"title"`
+			Subtitle    string   `json:"subtitle"`
+			Authors     []string `json:"authors"`
+			Description string   `json:"description"`
+			Categories  []string `json:"categories"`
+			Language    string   `json:"language"`
+			ImageLinks  struct {
+				SmallThumbnail string `json:"smallThumbnail"`
+				Thumbnail      string `json:"thumbnail"`
+			} `json:"imageLinks"`
+		} `json:"volumeInfo"`
+	} `json:"items"`
+}
+
+func (p *HTTPMetadataProvider) LookupISBN(isbn string) (*models.BookMetadata, error) {
+	resp, err := p.Client.Get(p.BaseURL + "/volumes?q=" + url.QueryEscape("isbn:"+isbn))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrMetadataNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata provider returned %s", resp.Status)
+	}
+
+	var body volumesResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Items) == 0 || body.Items[0].VolumeInfo.Title == "" {
+		return nil, ErrMetadataNotFound
+	}
+
+	info := body.Items[0].VolumeInfo
+	title := info.Title
+	if info.Subtitle != "" {
+		title += ": " + info.Subtitle
+	}
+	cover := info.ImageLinks.Thumbnail
+	if cover == "" {
+		cover = info.ImageLinks.SmallThumbnail
+	}
+	// Google Books hands out http:// cover links; keep them on https when the API is
+	if strings.HasPrefix(p.BaseURL, "https://") {
+		cover = strings.Replace(cover, "http://", "https://", 1)
+	}
+
+	return &models.BookMetadata{
+		ISBN:        isbn,
+		Title:       title,
+		Author:      strings.Join(info.Authors, ", "),
+		Description: info.Description,
+		Genre:       matchGenre(info.Categories),
+		Language:    info.Language,
+		CoverURL:    cover,
+		Source:      "remote",
+	}, nil
+}
+
+// genreKeywords maps category keywords to the genres a listing can use.
+// Order matters: "science fiction" must win over plain "fiction".
+var genreKeywords = []struct {
+	keyword string
+	genre   string
+}{
+	{"science fiction", "Science Fiction"},
+	{"fantasy", "Fantasy"},
+	{"romance", "Romance"},
+	{"mystery", "Mystery"},
+	{"detective", "Mystery"},
+	{"thriller", "Mystery"},
+	{"biography", "Biography"},
+	{"autobiography", "Biography"},
+	{"history", "History"},
+	{"self-help", "Self-Help"},
+	{"fiction", "Fiction"},
+}
+
+// matchGenre picks the listing genre for free-text provider categories, or ""
+func matchGenre(categories []string) string {
+	for _, kw := range genreKeywords {
+		for _, category := range categories {
+			if strings.Contains(strings.ToLower(category), kw.keyword) {
+				return kw.genre
+			}
+		}
+	}
+	return ""
+}
+
+// BookMetadataService resolves ISBNs against the local catalog first and falls
+// back to a remote provider, caching remote hits in the catalog.
+type BookMetadataService struct {
+	Local  *LocalCatalogProvider
+	Remote BookMetadataProvider // optional
+	Client *http.Client
+}
+
+func NewBookMetadataService(local *LocalCatalogProvider, remote BookMetadataProvider) *BookMetadataService {
+	return &BookMetadataService{
+		Local:  local,
+		Remote: remote,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *BookMetadataService) Lookup(isbn string) (*models.BookMetadata, error) {
+	isbn = utils.CleanISBN(isbn)
+	if len(isbn) != 10 && len(isbn) != 13 {
+		return nil, utils.ErrInvalidISBN
+	}
+
+	meta, err := s.Local.LookupISBN(isbn)
+	if err == nil {
+		return meta, nil
+	}
+	if !errors.Is(err, ErrMetadataNotFound) || s.Remote == nil {
+		return nil, err
+	}
+
+	meta, err = s.Remote.LookupISBN(isbn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Local.Repo.Upsert(*meta); err != nil {
+		fmt.Println("Failed to cache book metadata:", err)
+	}
+	return meta, nil
+}
+
+// maxCoverSize bounds the cover images downloaded from metadata providers
+const maxCoverSize = 5 << 20
+
+// DownloadCover fetches the cover image of meta and returns its bytes and
+// detected content type. Only image responses are accepted.
+func (s *BookMetadataService) DownloadCover(meta *models.BookMetadata) ([]byte, string, error) {
+	if meta.CoverURL == "" {
+		return nil, "", errors.New("no cover image available")
+	}
+	resp, err := s.Client.Get(meta.CoverURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("cover download returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCoverSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxCoverSize {
+		return nil, "", errors.New("cover image too large")
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", errors.New("cover is not an image")
+	}
+	return data, contentType, nil
+}
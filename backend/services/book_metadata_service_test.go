@@ -0,0 +1,258 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testISBN = "9782070612758"
+
+// newCatalogProvider returns a local catalog backed by an in-memory database
+func newCatalogProvider(t *testing.T) *LocalCatalogProvider {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`
+		CREATE TABLE book_catalog (
+			isbn TEXT PRIMARY KEY,
+			title TEXT NOT NULL,
+			author TEXT,
+			description TEXT,
+			genre TEXT,
+			language TEXT,
+			cover_url TEXT,
+			source TEXT NOT NULL DEFAULT 'local',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLocalCatalogProvider(repositories.NewCatalogRepository(db))
+}
+
+// newMockProvider serves handler as a Google Books compatible API
+func newMockProvider(t *testing.T, handler http.HandlerFunc) (*HTTPMetadataProvider, *int) {
+	t.Helper()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/volumes" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return NewHTTPMetadataProvider(server.URL + "/"), &calls
+}
+
+const volumeJSON = `{"items":[{"volumeInfo":{
+	"title":"Le Petit Prince","subtitle":"avec des aquarelles de l'auteur",
+	"authors":["Antoine de Saint-Exupéry"],"description":"Un conte.",
+	"categories":["Juvenile Fiction / Classics"],"language":"fr",
+	"imageLinks":{"smallThumbnail":"http://covers.test/s.jpg","thumbnail":"http://covers.test/t.jpg"}}}]}`
+
+func TestHTTPMetadataProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    *models.BookMetadata
+		wantErr error
+	}{
+		{
+			name: "hit",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if q := r.URL.Query().Get("q"); q != "isbn:"+testISBN {
+					t.Errorf("query = %q", q)
+				}
+				fmt.Fprint(w, volumeJSON)
+			},
+			want: &models.BookMetadata{
+				ISBN:        testISBN,
+				Title:       "Le Petit Prince: avec des aquarelles de l'auteur",
+				Author:      "Antoine de Saint-Exupéry",
+				Description: "Un conte.",
+				Genre:       "Fiction",
+				Language:    "fr",
+				CoverURL:    "http://covers.test/t.jpg",
+				Source:      "remote",
+			},
+		},
+		{
+			name: "no items",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"totalItems":0}`)
+			},
+			wantErr: ErrMetadataNotFound,
+		},
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			wantErr: ErrMetadataNotFound,
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "boom", http.StatusInternalServerError)
+			},
+		},
+		{
+			name: "malformed json",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"items":[{"volumeInfo":`)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, _ := newMockProvider(t, tt.handler)
+			got, err := provider.LookupISBN(testISBN)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *tt.want {
+				t.Fatalf("got %+v\nwant %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPMetadataProviderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	provider, _ := newMockProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	// Registered after the server, so it runs before server.Close waits for the handler
+	t.Cleanup(func() { close(release) })
+	provider.Client.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := provider.LookupISBN(testISBN); err == nil {
+		t.Fatal("want a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("lookup took %s", elapsed)
+	}
+}
+
+func TestHTTPMetadataProviderHTTPSCovers(t *testing.T) {
+	provider := &HTTPMetadataProvider{BaseURL: "https://books.test", Client: &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(volumeJSON)), Header: http.Header{}}, nil
+		}),
+	}}
+	got, err := provider.LookupISBN(testISBN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CoverURL != "https://covers.test/t.jpg" {
+		t.Fatalf("cover = %q", got.CoverURL)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestBookMetadataServiceLookup(t *testing.T) {
+	t.Run("local catalog first", func(t *testing.T) {
+		local := newCatalogProvider(t)
+		if err := local.Repo.Upsert(models.BookMetadata{ISBN: testISBN, Title: "Local title", Source: "local"}); err != nil {
+			t.Fatal(err)
+		}
+		remote, calls := newMockProvider(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, volumeJSON)
+		})
+		got, err := NewBookMetadataService(local, remote).Lookup(testISBN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Local title" || got.Source != "local" || *calls != 0 {
+			t.Fatalf("got %+v after %d remote calls", got, *calls)
+		}
+	})
+
+	t.Run("falls back to remote and caches the hit", func(t *testing.T) {
+		local := newCatalogProvider(t)
+		remote, calls := newMockProvider(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, volumeJSON)
+		})
+		service := NewBookMetadataService(local, remote)
+		// The hyphens are cleaned before the lookup
+		for i := 0; i < 2; i++ {
+			got, err := service.Lookup("978-2-07-061275-8")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != "Le Petit Prince: avec des aquarelles de l'auteur" {
+				t.Fatalf("title = %q", got.Title)
+			}
+		}
+		if *calls != 1 {
+			t.Fatalf("remote called %d times, want 1", *calls)
+		}
+	})
+
+	t.Run("miss everywhere", func(t *testing.T) {
+		remote, _ := newMockProvider(t, http.NotFound)
+		_, err := NewBookMetadataService(newCatalogProvider(t), remote).Lookup(testISBN)
+		if !errors.Is(err, ErrMetadataNotFound) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("no remote", func(t *testing.T) {
+		_, err := NewBookMetadataService(newCatalogProvider(t), nil).Lookup(testISBN)
+		if !errors.Is(err, ErrMetadataNotFound) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("remote failure is not cached", func(t *testing.T) {
+		local := newCatalogProvider(t)
+		remote, _ := newMockProvider(t, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `not json`)
+		})
+		if _, err := NewBookMetadataService(local, remote).Lookup(testISBN); err == nil {
+			t.Fatal("want an error")
+		}
+		if _, err := local.LookupISBN(testISBN); !errors.Is(err, ErrMetadataNotFound) {
+			t.Fatalf("catalog lookup err = %v", err)
+		}
+	})
+
+	t.Run("invalid isbn", func(t *testing.T) {
+		_, err := NewBookMetadataService(newCatalogProvider(t), nil).Lookup("12345")
+		if err == nil {
+			t.Fatal("want an error")
+		}
+	})
+}
@@ -1,20 +1,143 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
 	"ktabnet/models"
 	"ktabnet/repositories"
+	"ktabnet/utils"
 )
 
 type BookService struct {
-	Repo *repositories.BookRepository
+	Repo      *repositories.BookRepository
+	Images    *ImageService
+	Locations *LocationService
+	Taxonomy  *TaxonomyService
+	// Wishlists, when set, is told about every new listing
+	Wishlists *WishlistService
+	// Exchanges, when set, cancels the pending requests of books that leave the market
+	Exchanges *ExchangeService
 }
 
-func NewBookService(repo *repositories.BookRepository) *BookService {
-	return &BookService{Repo: repo}
+func NewBookService(repo *repositories.BookRepository, images *ImageService, locations *LocationService, taxonomy *TaxonomyService) *BookService {
+	return &BookService{Repo: repo, Images: images, Locations: locations, Taxonomy: taxonomy}
 }
 
 func (s *BookService) CreateBook(book models.Book) (int, error) {
-	return s.Repo.CreateBook(book)
+	if err := normalizeBookISBN(&book); err != nil {
+		return 0, err
+	}
+	if book.ListingType == "" {
+		book.ListingType = models.ListingTypeExchange
+	}
+	if !validListingType(book.ListingType) {
+		return 0, ErrInvalidListingType
+	}
+	if err := normalizeGiveawayPick(&book); err != nil {
+		return 0, err
+	}
+	if err := normalizeBookDetails(&book); err != nil {
+		return 0, err
+	}
+	if err := s.Taxonomy.NormalizeBook(&book); err != nil {
+		return 0, err
+	}
+	id, err := s.Repo.CreateBook(book)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.Wishlists != nil {
+		// Reload the listing so matching sees its work
+		if created, err := s.Repo.GetBookByID(id); err == nil {
+			s.Wishlists.NotifyMatches(created)
+		}
+	}
+	return id, nil
+}
+
+var (
+	ErrInvalidListingType  = errors.New("invalid listing_type: must be exchange, lend or giveaway")
+	ErrListingTypeLocked   = errors.New("the listing type can only change while the book is listed or archived")
+	ErrListingLocked       = errors.New("a book can only be edited while it is listed or archived")
+	ErrInvalidGiveawayPick = errors.New("invalid giveaway_pick: must be manual or first")
+	ErrInvalidBookDetails  = errors.New("invalid book details")
+)
+
+// Bounds of the bibliographic details of a listing; zero values are always accepted
+const (
+	maxPublisherLength = 200
+	maxSeriesLength    = 200
+	minPublicationYear = 1450
+	maxPageCount       = 20000
+	maxSeriesVolume    = 1000
+)
+
+func validListingType(t string) bool {
+	return t == models.ListingTypeExchange || t == models.ListingTypeLend || t == models.ListingTypeGiveaway
+}
+
+// normalizeGiveawayPick defaults the pick of a giveaway listing to manual and clears it
+// on other listings
+func normalizeGiveawayPick(book *models.Book) error {
+	if book.ListingType != models.ListingTypeGiveaway {
+		book.GiveawayPick = ""
+		return nil
+	}
+	switch book.GiveawayPick {
+	case "":
+		book.GiveawayPick = models.GiveawayPickManual
+	case models.GiveawayPickManual, models.GiveawayPickFirst:
+	default:
+		return ErrInvalidGiveawayPick
+	}
+	return nil
+}
+
+// normalizeBookDetails trims and bounds the publisher, publication year, page count
+// and series of a listing. A series volume needs a series.
+func normalizeBookDetails(book *models.Book) error {
+	book.Publisher = strings.TrimSpace(book.Publisher)
+	book.Series = strings.TrimSpace(book.Series)
+	if len([]rune(book.Publisher)) > maxPublisherLength {
+		return fmt.Errorf("%w: publisher must be at most %d characters", ErrInvalidBookDetails, maxPublisherLength)
+	}
+	if len([]rune(book.Series)) > maxSeriesLength {
+		return fmt.Errorf("%w: series must be at most %d characters", ErrInvalidBookDetails, maxSeriesLength)
+	}
+	if maxYear := time.Now().Year() + 1; book.PublicationYear != 0 &&
+		(book.PublicationYear < minPublicationYear || book.PublicationYear > maxYear) {
+		return fmt.Errorf("%w: publication_year must be between %d and %d", ErrInvalidBookDetails, minPublicationYear, maxYear)
+	}
+	if book.PageCount < 0 || book.PageCount > maxPageCount {
+		return fmt.Errorf("%w: page_count must be between 1 and %d", ErrInvalidBookDetails, maxPageCount)
+	}
+	if book.SeriesVolume < 0 || book.SeriesVolume > maxSeriesVolume {
+		return fmt.Errorf("%w: series_volume must be between 1 and %d", ErrInvalidBookDetails, maxSeriesVolume)
+	}
+	if book.SeriesVolume > 0 && book.Series == "" {
+		return fmt.Errorf("%w: series_volume needs a series", ErrInvalidBookDetails)
+	}
+	return nil
+}
+
+// normalizeBookISBN validates the ISBN of a listing, if any, and stores it as ISBN-13
+func normalizeBookISBN(book *models.Book) error {
+	if strings.TrimSpace(book.ISBN) == "" {
+		book.ISBN = ""
+		return nil
+	}
+	isbn, err := utils.NormalizeISBN(book.ISBN)
+	if err != nil {
+		return err
+	}
+	book.ISBN = isbn
+	return nil
 }
 
 func (s *BookService) GetBook(bookID int) (models.Book, error) {
@@ -33,16 +156,412 @@ func (s *BookService) GetFeedBooks(currentUserID int) ([]models.Book, error) {
 	return s.Repo.GetAllBooks(currentUserID)
 }
 
-func (s *BookService) GetFeedBooksWithOwner(currentUserID int) ([]models.BookWithOwner, error) {
-	return s.Repo.GetAllBooksWithOwner(currentUserID)
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+// maxRadiusKm bounds the radius_km parameter; Morocco fits well within it
+const maxRadiusKm = 3000
+
+// parseNear reads the near and radius_km parameters shared by the feed and search
+func parseNear(q url.Values) (string, float64, error) {
+	near := strings.TrimSpace(q.Get("near"))
+	radiusStr := q.Get("radius_km")
+	if radiusStr == "" {
+		return near, 0, nil
+	}
+	radius, err := strconv.ParseFloat(radiusStr, 64)
+	if err != nil || radius <= 0 || radius > maxRadiusKm {
+		return near, 0, fmt.Errorf("invalid radius_km: must be a number of km between 0 and %d", maxRadiusKm)
+	}
+	if near == "" {
+		return near, 0, fmt.Errorf("radius_km needs near (a city or \"me\")")
+	}
+	return near, radius, nil
+}
+
+// parseDetailsFilter reads the bibliographic parameters shared by the feed and search:
+// language, format, publisher, series, year_from and year_to
+func parseDetailsFilter(q url.Values) (models.BookDetailsFilter, error) {
+	filter := models.BookDetailsFilter{
+		Language:  strings.TrimSpace(q.Get("language")),
+		Format:    strings.TrimSpace(q.Get("format")),
+		Publisher: strings.TrimSpace(q.Get("publisher")),
+		Series:    strings.TrimSpace(q.Get("series")),
+	}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"year_from", &filter.YearFrom}, {"year_to", &filter.YearTo}} {
+		str := q.Get(p.name)
+		if str == "" {
+			continue
+		}
+		year, err := strconv.Atoi(str)
+		if err != nil || year <= 0 {
+			return filter, fmt.Errorf("invalid %s: must be a year", p.name)
+		}
+		*p.value = year
+	}
+	if filter.YearFrom > 0 && filter.YearTo > 0 && filter.YearFrom > filter.YearTo {
+		return filter, fmt.Errorf("invalid year_from: must not be after year_to")
+	}
+	return filter, nil
+}
+
+// resolveDetailsFilter replaces the language and format of a filter with their
+// stored spelling
+func (s *BookService) resolveDetailsFilter(filter *models.BookDetailsFilter) error {
+	var err error
+	if filter.Language != "" {
+		if filter.Language, err = s.Taxonomy.CanonicalName(models.TaxonomyLanguages, filter.Language); err != nil {
+			return err
+		}
+	}
+	if filter.Format != "" {
+		filter.Format, err = s.Taxonomy.CanonicalName(models.TaxonomyFormats, filter.Format)
+	}
+	return err
+}
+
+// ParseFeedFilter reads the feed query parameters: genre, condition, min_condition,
+// city, author, owner_id, since (YYYY-MM-DD or RFC3339), near, radius_km, min_rating, the
+// bibliographic parameters of parseDetailsFilter, sort, cursor and limit
+func ParseFeedFilter(q url.Values) (models.BookFeedFilter, error) {
+	filter := models.BookFeedFilter{
+		Genre:        q.Get("genre"),
+		Condition:    q.Get("condition"),
+		MinCondition: q.Get("min_condition"),
+		City:         q.Get("city"),
+		Author:       strings.TrimSpace(q.Get("author")),
+		ListingType:  q.Get("listing_type"),
+		Sort:         q.Get("sort"),
+		Cursor:       q.Get("cursor"),
+	}
+
+	switch filter.Sort {
+	case "", models.BookSortNewest, models.BookSortOldest, models.BookSortTitle, models.BookSortNearest:
+	default:
+		return filter, fmt.Errorf("invalid sort: must be newest, oldest, title or nearest")
+	}
+
+	if filter.ListingType != "" && !validListingType(filter.ListingType) {
+		return filter, ErrInvalidListingType
+	}
+
+	if ownerStr := q.Get("owner_id"); ownerStr != "" {
+		ownerID, err := strconv.Atoi(ownerStr)
+		if err != nil || ownerID <= 0 {
+			return filter, fmt.Errorf("invalid owner_id")
+		}
+		filter.OwnerID = ownerID
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit")
+		}
+		filter.Limit = limit
+	}
+
+	var err error
+	if filter.Near, filter.RadiusKm, err = parseNear(q); err != nil {
+		return filter, err
+	}
+	if filter.BookDetailsFilter, err = parseDetailsFilter(q); err != nil {
+		return filter, err
+	}
+
+	if ratingStr := q.Get("min_rating"); ratingStr != "" {
+		rating, err := strconv.ParseFloat(ratingStr, 64)
+		if err != nil || rating < models.MinRatingStars || rating > models.MaxRatingStars {
+			return filter, fmt.Errorf("invalid min_rating: must be a number between %d and %d", models.MinRatingStars, models.MaxRatingStars)
+		}
+		filter.MinRating = rating
+	}
+
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse("2006-01-02", since)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, since)
+		}
+		if err != nil {
+			return filter, fmt.Errorf("invalid since: use YYYY-MM-DD or RFC3339")
+		}
+		filter.Since = t.UTC().Format("2006-01-02 15:04:05")
+	}
+
+	return filter, nil
+}
+
+// ParseSearchFilter reads the search query parameters: query, near, radius_km, the
+// bibliographic parameters of parseDetailsFilter, cursor and limit
+func ParseSearchFilter(q url.Values) (models.BookSearchFilter, error) {
+	filter := models.BookSearchFilter{
+		Query:  q.Get("query"),
+		Cursor: q.Get("cursor"),
+	}
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit")
+		}
+		filter.Limit = limit
+	}
+
+	var err error
+	if filter.Near, filter.RadiusKm, err = parseNear(q); err != nil {
+		return filter, err
+	}
+	filter.BookDetailsFilter, err = parseDetailsFilter(q)
+	return filter, err
+}
+
+// resolveFeedFilter checks the parts of a feed filter that depend on stored data
+// (the min_condition, language and format terms and the near location) and resolves
+// them for the repository
+func (s *BookService) resolveFeedFilter(userID int, filter *models.BookFeedFilter) error {
+	if filter.MinCondition != "" {
+		condition, err := s.Taxonomy.CanonicalName(models.TaxonomyConditions, filter.MinCondition)
+		if err != nil {
+			return err
+		}
+		filter.MinCondition = condition
+	}
+	if err := s.resolveDetailsFilter(&filter.BookDetailsFilter); err != nil {
+		return err
+	}
+
+	origin, err := s.Locations.ResolveNear(userID, filter.Near)
+	if err != nil {
+		return err
+	}
+	filter.Origin = origin
+	return nil
+}
+
+// ValidateFeedFilter reports whether a feed filter can run for userID
+func (s *BookService) ValidateFeedFilter(userID int, filter models.BookFeedFilter) error {
+	return s.resolveFeedFilter(userID, &filter)
+}
+
+func (s *BookService) GetFeedBooksWithOwner(currentUserID int, filter models.BookFeedFilter) (models.BookFeedPage, error) {
+	if err := s.resolveFeedFilter(currentUserID, &filter); err != nil {
+		return models.BookFeedPage{}, err
+	}
+
+	if filter.Sort == "" && filter.Origin != nil {
+		filter.Sort = models.BookSortNearest
+	}
+	if filter.Sort == models.BookSortNearest && filter.Origin == nil {
+		// Without near, sort from where the viewer is; the repository falls back to
+		// the viewer's city when no position is known
+		var err error
+		if filter.Origin, err = s.Locations.UserOrigin(currentUserID); err != nil {
+			return models.BookFeedPage{}, err
+		}
+	}
+	if filter.Sort == "" {
+		filter.Sort = models.BookSortNewest
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultFeedLimit
+	} else if filter.Limit > maxFeedLimit {
+		filter.Limit = maxFeedLimit
+	}
+	return s.Repo.GetAllBooksWithOwner(currentUserID, filter)
 }
 
 func (s *BookService) UpdateBook(book models.Book) error {
-	return s.Repo.UpdateBook(book)
+	return s.updateBook(book, "")
+}
+
+// UpdateBookAndStatus validates an edited listing, then stores it together with an
+// owner's status change, so an invalid edit leaves the status untouched
+func (s *BookService) UpdateBookAndStatus(book models.Book, status string) error {
+	if _, ok := ownerStatusChanges[status]; !ok {
+		return ErrInvalidBookStatus
+	}
+	return s.updateBook(book, status)
+}
+
+// updateBook validates and stores an edited listing; status, when set, is an owner's
+// status change applied in the same transaction
+func (s *BookService) updateBook(book models.Book, status string) error {
+	if err := normalizeBookISBN(&book); err != nil {
+		return err
+	}
+	current, err := s.Repo.GetBookByID(book.ID)
+	if err != nil {
+		return err
+	}
+	if book.ListingType == "" {
+		book.ListingType = current.ListingType
+	}
+	if !validListingType(book.ListingType) {
+		return ErrInvalidListingType
+	}
+	if book.GiveawayPick == "" && book.ListingType == current.ListingType {
+		book.GiveawayPick = current.GiveawayPick
+	}
+	if err := normalizeGiveawayPick(&book); err != nil {
+		return err
+	}
+	// A book lent out or promised in an exchange keeps the type it was requested under
+	if book.ListingType != current.ListingType && !editableStatus(current.Status) {
+		return ErrListingTypeLocked
+	}
+	if err := normalizeBookDetails(&book); err != nil {
+		return err
+	}
+	if err := s.Taxonomy.NormalizeBook(&book); err != nil {
+		return err
+	}
+	if !editableStatus(current.Status) && bookContentChanged(current, book) {
+		return ErrListingLocked
+	}
+	if status == "" {
+		err = s.Repo.UpdateBook(book)
+	} else {
+		err = s.Repo.UpdateBookAndStatus(book, ownerStatusChanges[status], status, book.OwnerID, status+" by owner")
+	}
+	if errors.Is(err, repositories.ErrBookStatusConflict) {
+		return ErrBookStatusForbidden
+	}
+	if err != nil {
+		return err
+	}
+	if book.ListingType != current.ListingType || status == models.BookStatusArchived {
+		s.cancelUnavailableExchanges()
+	}
+	return nil
+}
+
+// editableStatus reports whether an owner may edit a book in status; a book reserved,
+// in an exchange or lent out stays as the other party agreed to take or borrow it
+func editableStatus(status string) bool {
+	return status == models.BookStatusListed || status == models.BookStatusArchived
+}
+
+// bookContentChanged reports whether edited differs from current in what describes the book
+func bookContentChanged(current, edited models.Book) bool {
+	return current.Title != edited.Title || current.Author != edited.Author || current.ISBN != edited.ISBN ||
+		current.Description != edited.Description || current.Genre != edited.Genre ||
+		current.Condition != edited.Condition || current.Language != edited.Language ||
+		current.Format != edited.Format || current.Publisher != edited.Publisher ||
+		current.PublicationYear != edited.PublicationYear || current.PageCount != edited.PageCount ||
+		current.Series != edited.Series || current.SeriesVolume != edited.SeriesVolume ||
+		current.City != edited.City
+}
+
+// CheckEditable returns ErrListingLocked when book cannot be edited in its current status
+func (s *BookService) CheckEditable(book models.Book) error {
+	if !editableStatus(book.Status) {
+		return ErrListingLocked
+	}
+	return nil
+}
+
+func (s *BookService) checkEditable(bookID int) error {
+	book, err := s.Repo.GetBookByID(bookID)
+	if err != nil {
+		return err
+	}
+	return s.CheckEditable(book)
+}
+
+// cancelUnavailableExchanges cancels right away the pending requests that a change
+// made impossible, instead of leaving them to the periodic cleanup
+func (s *BookService) cancelUnavailableExchanges() {
+	if s.Exchanges == nil {
+		return
+	}
+	if err := s.Exchanges.CancelUnavailable(); err != nil {
+		fmt.Println("Error cancelling unavailable exchange requests:", err)
+	}
+}
+
+var (
+	ErrInvalidBookStatus   = errors.New("owners can only set a book's status to listed or archived")
+	ErrBookStatusForbidden = errors.New("the book's current status does not allow this change")
+	ErrBookNotDeletable    = errors.New("only a listed or archived book with no approved or active loan can be deleted")
+)
+
+// ownerStatusChanges maps the statuses an owner may set to the statuses they may
+// set them from; the other statuses follow exchanges and loans
+var ownerStatusChanges = map[string][]string{
+	models.BookStatusArchived: {models.BookStatusListed},
+	models.BookStatusListed:   {models.BookStatusArchived, models.BookStatusExchanged},
 }
 
+// ChangeStatusByOwner archives a listed book or lists an archived or newly
+// received one again, recording the change in the book's history
+func (s *BookService) ChangeStatusByOwner(bookID, userID int, status, reason string) error {
+	from, ok := ownerStatusChanges[status]
+	if !ok {
+		return ErrInvalidBookStatus
+	}
+	if reason = strings.TrimSpace(reason); reason == "" {
+		reason = status + " by owner"
+	}
+	err := s.Repo.SetBookStatus(bookID, from, status, userID, reason)
+	if errors.Is(err, repositories.ErrBookStatusConflict) {
+		return ErrBookStatusForbidden
+	}
+	if err == nil && status == models.BookStatusArchived {
+		s.cancelUnavailableExchanges()
+	}
+	return err
+}
+
+func (s *BookService) GetStatusHistory(bookID int) ([]models.BookStatusChange, error) {
+	return s.Repo.GetStatusHistory(bookID)
+}
+
+func (s *BookService) GetWork(workID int) (models.Work, error) {
+	return s.Repo.GetWork(workID)
+}
+
+// DeleteBook deletes a listed or archived book with its images, including the image
+// files; other statuses and books on loan return ErrBookNotDeletable
 func (s *BookService) DeleteBook(bookID int) error {
-	return s.Repo.DeleteBook(bookID)
+	images, err := s.Repo.GetImages(bookID)
+	if err != nil {
+		return err
+	}
+	err = s.Repo.DeleteBook(bookID)
+	if errors.Is(err, repositories.ErrBookStatusConflict) || errors.Is(err, repositories.ErrBookOnLoan) {
+		return ErrBookNotDeletable
+	}
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		s.removeImageFile(img.ImageURL)
+	}
+	s.cancelUnavailableExchanges()
+	return nil
+}
+
+// DiscardImages deletes stored images that never got attached to a listing, such as
+// the photos of a listing that failed to save
+func (s *BookService) DiscardImages(urls []string) {
+	for _, url := range urls {
+		s.removeImageFile(url)
+	}
+}
+
+// removeImageFile deletes the files of an image URL once no listing uses it anymore
+func (s *BookService) removeImageFile(url string) {
+	inUse, err := s.Repo.ImageURLInUse(url)
+	if err != nil || inUse {
+		return
+	}
+	if err := s.Images.Remove(url); err != nil {
+		fmt.Println("Failed to remove book image file:", err)
+	}
 }
 
 func (s *BookService) AddImage(bookID int, imageURL string, isPrimary bool) error {
@@ -53,25 +572,93 @@ func (s *BookService) RemoveImage(imageID int) error {
 	return s.Repo.RemoveImage(imageID)
 }
 
-func (s *BookService) SearchBooks(query string) ([]models.BookSearchResult, error) {
-	if query == "" {
-		return nil, nil
-	}
-	return s.Repo.SearchBooks(query)
+// ErrImageNotFound is returned when an image does not belong to the book
+var ErrImageNotFound = errors.New("image not found")
+
+func (s *BookService) GetImages(bookID int) ([]models.BookImage, error) {
+	return s.Repo.GetImages(bookID)
 }
 
-func (s *BookService) CreateExchangeRequest(userID, bookID, offeredBookID int) (int, bool, error) {
-	return s.Repo.CreateExchangeRequest(bookID, offeredBookID, userID)
+// RemoveBookImage deletes one image of a book and its file, then renumbers the
+// remaining images so the first of them becomes primary
+func (s *BookService) RemoveBookImage(bookID, imageID int) error {
+	if err := s.checkEditable(bookID); err != nil {
+		return err
+	}
+	images, err := s.Repo.GetImages(bookID)
+	if err != nil {
+		return err
+	}
+
+	var removed *models.BookImage
+	for i := range images {
+		if images[i].ID == imageID {
+			removed = &images[i]
+		}
+	}
+	if removed == nil {
+		return ErrImageNotFound
+	}
+
+	// The file goes only once the row is gone, so a failed delete never leaves a broken image
+	if err := s.Repo.RemoveBookImage(bookID, imageID); err != nil {
+		return err
+	}
+	s.removeImageFile(removed.ImageURL)
+	return nil
 }
 
-func (s *BookService) GetUserExchangeRequests(userID int) ([]models.BookExchangeRequest, error) {
-	return s.Repo.GetUserExchangeRequests(userID)
+// ReorderImages sets the display order of a book's images; the first one becomes primary
+func (s *BookService) ReorderImages(bookID int, imageIDs []int) error {
+	if err := s.checkEditable(bookID); err != nil {
+		return err
+	}
+	return s.Repo.SetImageOrder(bookID, imageIDs)
 }
 
-func (s *BookService) UpdateExchangeStatus(exchangeID, userID int, status string) error {
-	return s.Repo.UpdateExchangeStatus(exchangeID, userID, status)
+// SetPrimaryImage makes an image primary by moving it to the front of the order
+func (s *BookService) SetPrimaryImage(bookID, imageID int) error {
+	if err := s.checkEditable(bookID); err != nil {
+		return err
+	}
+	images, err := s.Repo.GetImages(bookID)
+	if err != nil {
+		return err
+	}
+
+	order := []int{imageID}
+	found := false
+	for _, img := range images {
+		if img.ID == imageID {
+			found = true
+		} else {
+			order = append(order, img.ID)
+		}
+	}
+	if !found {
+		return ErrImageNotFound
+	}
+	return s.Repo.SetImageOrder(bookID, order)
 }
 
-func (s *BookService) CancelExchangeRequest(exchangeID, userID int) error {
-	return s.Repo.CancelExchangeRequest(exchangeID, userID)
+// SearchBooks ranks books by relevance; with near set, nearer books come first
+func (s *BookService) SearchBooks(userID int, filter models.BookSearchFilter) (models.BookSearchPage, error) {
+	if filter.Query == "" {
+		return models.BookSearchPage{Results: []models.BookSearchResult{}}, nil
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultFeedLimit
+	} else if filter.Limit > maxFeedLimit {
+		filter.Limit = maxFeedLimit
+	}
+
+	if err := s.resolveDetailsFilter(&filter.BookDetailsFilter); err != nil {
+		return models.BookSearchPage{}, err
+	}
+	origin, err := s.Locations.ResolveNear(userID, filter.Near)
+	if err != nil {
+		return models.BookSearchPage{}, err
+	}
+	filter.Origin = origin
+	return s.Repo.SearchBooks(filter)
 }
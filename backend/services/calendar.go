@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ktabnet/models"
+)
+
+// meetupLength is how long a meetup lasts in calendars
+const meetupLength = time.Hour
+
+const icsTimeLayout = "20060102T150405Z"
+
+// icsTime turns an RFC 3339 time into an iCalendar UTC time; bad input gives ""
+func icsTime(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(icsTimeLayout)
+}
+
+// icsText escapes a value for an iCalendar text property (RFC 5545, 3.3.11)
+func icsText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "").Replace(value)
+}
+
+// writeICSLine writes a content line folded at 75 octets, never inside a UTF-8 sequence
+func writeICSLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space of a continuation line counts toward its length
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// meetupCalendar renders meetups as an iCalendar document from the point of view of
+// viewerID: each event names the other party and the books given and received
+func meetupCalendar(name string, viewerID int, meetups []models.ExchangeMeetup) string {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//ktabnet//Meetups//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:"+icsText(name))
+	for _, m := range meetups {
+		writeMeetupEvent(&b, viewerID, m)
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+func writeMeetupEvent(b *strings.Builder, viewerID int, m models.ExchangeMeetup) {
+	other, give, receive := m.OwnerName, m.OfferedBooks, m.RequestedBooks
+	if viewerID == m.OwnerID {
+		other, give, receive = m.RequesterName, m.RequestedBooks, m.OfferedBooks
+	}
+
+	status := "TENTATIVE"
+	switch {
+	case m.ExchangeStatus != models.ExchangeStatusAccepted && m.ExchangeStatus != models.ExchangeStatusMeetupScheduled &&
+		m.ExchangeStatus != models.ExchangeStatusCompleted:
+		status = "CANCELLED"
+	case m.Status == models.MeetupStatusAccepted:
+		status = "CONFIRMED"
+	}
+
+	location := m.Spot.Name
+	if m.Spot.Address != "" {
+		location += ", " + m.Spot.Address
+	}
+	location += ", " + m.Spot.City
+
+	description := fmt.Sprintf("You give %s and receive %s.", bundleTitles(give), bundleTitles(receive))
+	if m.Status == models.MeetupStatusProposed {
+		description += " This time is proposed and not agreed yet."
+	}
+	if m.Note != "" {
+		description += "\n\n" + m.Note
+	}
+
+	writeICSLine(b, "BEGIN:VEVENT")
+	writeICSLine(b, fmt.Sprintf("UID:meetup-%d@ktabnet", m.ID))
+	writeICSLine(b, "DTSTAMP:"+icsTime(m.UpdatedAt))
+	writeICSLine(b, "DTSTART:"+icsTime(m.StartsAt))
+	writeICSLine(b, "DTEND:"+icsTime(m.EndsAt))
+	writeICSLine(b, fmt.Sprintf("SEQUENCE:%d", m.Sequence))
+	writeICSLine(b, "SUMMARY:"+icsText("Book exchange with "+partyName(other)))
+	writeICSLine(b, "LOCATION:"+icsText(location))
+	if m.Spot.Latitude != nil && m.Spot.Longitude != nil {
+		writeICSLine(b, fmt.Sprintf("GEO:%.6f;%.6f", *m.Spot.Latitude, *m.Spot.Longitude))
+	}
+	writeICSLine(b, "DESCRIPTION:"+icsText(description))
+	writeICSLine(b, "STATUS:"+status)
+	writeICSLine(b, "END:VEVENT")
+}
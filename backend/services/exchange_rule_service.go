@@ -0,0 +1,222 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+)
+
+var (
+	// ErrExchangeRule is wrapped with the reason a request breaks the owner's rules
+	ErrExchangeRule        = errors.New("the request does not meet the owner's exchange rules")
+	ErrInvalidExchangeRule = errors.New("invalid exchange rule")
+)
+
+const (
+	maxRuleAccountAgeDays = 3650
+	maxRuleGenres         = 20
+)
+
+type ExchangeRuleService struct {
+	Repo     *repositories.ExchangeRuleRepository
+	Taxonomy *TaxonomyService
+}
+
+func NewExchangeRuleService(repo *repositories.ExchangeRuleRepository, taxonomy *TaxonomyService) *ExchangeRuleService {
+	return &ExchangeRuleService{Repo: repo, Taxonomy: taxonomy}
+}
+
+// get returns the rules of a listing or library, nil when none are set
+func (s *ExchangeRuleService) get(ownerID, bookID int) (*models.ExchangeRules, error) {
+	rules, err := s.Repo.Get(ownerID, bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// Library returns the rules ownerID set on their whole library; unset rules are empty
+func (s *ExchangeRuleService) Library(ownerID int) (models.ExchangeRules, error) {
+	rules, err := s.get(ownerID, 0)
+	if err != nil || rules == nil {
+		return models.ExchangeRules{Genres: []string{}}, err
+	}
+	return *rules, nil
+}
+
+// ForBook returns the rules a request for a listing has to meet
+func (s *ExchangeRuleService) ForBook(book models.Book) (models.ListingExchangeRules, error) {
+	view := models.ListingExchangeRules{BookID: book.ID}
+	var err error
+	if view.Library, err = s.get(book.OwnerID, 0); err != nil {
+		return view, err
+	}
+	view.Listing, err = s.get(book.OwnerID, book.ID)
+	return view, err
+}
+
+// buildRules validates a rules request against the taxonomy
+func (s *ExchangeRuleService) buildRules(req models.ExchangeRulesRequest) (models.ExchangeRules, error) {
+	rules := models.ExchangeRules{
+		SameCity:          req.SameCity,
+		MinRating:         req.MinRating,
+		MinAccountAgeDays: req.MinAccountAgeDays,
+		Genres:            []string{},
+	}
+	if rules.MinRating != 0 && (rules.MinRating < models.MinRatingStars || rules.MinRating > models.MaxRatingStars) {
+		return rules, fmt.Errorf("%w: min_rating must be between %d and %d", ErrInvalidExchangeRule, models.MinRatingStars, models.MaxRatingStars)
+	}
+	if rules.MinAccountAgeDays < 0 || rules.MinAccountAgeDays > maxRuleAccountAgeDays {
+		return rules, fmt.Errorf("%w: min_account_age_days must be between 0 and %d", ErrInvalidExchangeRule, maxRuleAccountAgeDays)
+	}
+	if strings.TrimSpace(req.MinCondition) != "" {
+		condition, err := s.Taxonomy.CanonicalName(models.TaxonomyConditions, req.MinCondition)
+		if err != nil {
+			return rules, err
+		}
+		rules.MinCondition = condition
+	}
+	if len(req.Genres) > maxRuleGenres {
+		return rules, fmt.Errorf("%w: at most %d genres", ErrInvalidExchangeRule, maxRuleGenres)
+	}
+	for _, g := range req.Genres {
+		genre, err := s.Taxonomy.CanonicalName(models.TaxonomyGenres, g)
+		if err != nil {
+			return rules, err
+		}
+		if !containsFold(rules.Genres, genre) {
+			rules.Genres = append(rules.Genres, genre)
+		}
+	}
+	return rules, nil
+}
+
+// Set replaces the rules of a listing of ownerID, or of their library when bookID is 0
+func (s *ExchangeRuleService) Set(ownerID, bookID int, req models.ExchangeRulesRequest) (models.ExchangeRules, error) {
+	rules, err := s.buildRules(req)
+	if err != nil {
+		return rules, err
+	}
+	if err := s.Repo.Save(ownerID, bookID, rules); err != nil {
+		return rules, err
+	}
+	return s.Repo.Get(ownerID, bookID)
+}
+
+// Delete removes the rules of a listing of ownerID, or of their library when bookID is 0
+func (s *ExchangeRuleService) Delete(ownerID, bookID int) error {
+	return s.Repo.Delete(ownerID, bookID)
+}
+
+func ruleError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrExchangeRule, fmt.Sprintf(format, args...))
+}
+
+// Check reports whether requesterID may ask for the books bookIDs against offeredBookIDs
+// under the rules of their owner's library and listings. The error wraps ErrExchangeRule
+// and gives the first rule the request breaks.
+func (s *ExchangeRuleService) Check(requesterID int, bookIDs, offeredBookIDs []int) error {
+	var sets []models.ExchangeRules
+	var requested []repositories.RuleBook
+	for _, id := range bookIDs {
+		book, err := s.Repo.GetBook(id)
+		if err != nil {
+			return err
+		}
+		if len(requested) == 0 {
+			library, err := s.get(book.OwnerID, 0)
+			if err != nil {
+				return err
+			}
+			if library != nil {
+				sets = append(sets, *library)
+			}
+		}
+		listing, err := s.get(book.OwnerID, id)
+		if err != nil {
+			return err
+		}
+		if listing != nil {
+			sets = append(sets, *listing)
+		}
+		requested = append(requested, book)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	requester, err := s.Repo.GetRequester(requesterID)
+	if err != nil {
+		return err
+	}
+	offered := make([]repositories.RuleBook, 0, len(offeredBookIDs))
+	for _, id := range offeredBookIDs {
+		book, err := s.Repo.GetBook(id)
+		if err != nil {
+			return err
+		}
+		offered = append(offered, book)
+	}
+
+	for _, rules := range sets {
+		if rules.SameCity {
+			for _, book := range requested {
+				if rules.BookID != 0 && rules.BookID != book.ID {
+					continue
+				}
+				if !strings.EqualFold(requester.City, book.City) {
+					return ruleError("the owner only exchanges %q with users living in %s", book.Title, book.City)
+				}
+			}
+		}
+		if rules.MinAccountAgeDays > 0 && requester.AccountAgeDays < float64(rules.MinAccountAgeDays) {
+			return ruleError("the owner only exchanges with accounts at least %d days old", rules.MinAccountAgeDays)
+		}
+		if rules.MinRating > 0 {
+			if requester.Reputation.RatingCount == 0 {
+				return ruleError("the owner requires an average rating of %.1f or more and you have no ratings yet", rules.MinRating)
+			}
+			if requester.Reputation.AverageRating < rules.MinRating {
+				return ruleError("the owner requires an average rating of %.1f or more; yours is %.1f", rules.MinRating, requester.Reputation.AverageRating)
+			}
+		}
+		if rules.MinCondition != "" {
+			rank, err := s.Repo.ConditionRank(rules.MinCondition)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("the owner's minimum condition %q is no longer a known condition", rules.MinCondition)
+			}
+			if err != nil {
+				return err
+			}
+			for _, book := range offered {
+				if book.ConditionRank > rank {
+					return ruleError("%q is in %s condition; the owner accepts %s or better", book.Title, book.Condition, rules.MinCondition)
+				}
+			}
+		}
+		if len(rules.Genres) > 0 {
+			for _, book := range offered {
+				if !containsFold(rules.Genres, book.Genre) {
+					return ruleError("%q is %s; the owner accepts %s", book.Title, book.Genre, strings.Join(rules.Genres, ", "))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
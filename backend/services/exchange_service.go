@@ -0,0 +1,675 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+
+	"github.com/skip2/go-qrcode"
+)
+
+var (
+	ErrExchangeNotFound      = errors.New("exchange request not found")
+	ErrNotExchangeParty      = errors.New("you are not part of this exchange")
+	ErrExchangeForbidden     = errors.New("your side of the exchange cannot do this")
+	ErrInvalidTransition     = errors.New("the exchange status does not allow this")
+	ErrUnknownExchangeAction = errors.New("unknown exchange action")
+	ErrDisputeReasonRequired = errors.New("a dispute needs a reason")
+	ErrOwnOffer              = errors.New("the offer on the table is yours; the other party has to accept it")
+	ErrInvalidOffer          = errors.New("the offered books must be listed books of the requester and differ from the offer on the table")
+	ErrOfferTaken            = errors.New("the same bundles are already offered in another pending request")
+	ErrInvalidBundle         = errors.New("each side of an exchange needs 1 to 5 different books")
+	ErrWrongHandoffCode      = errors.New("wrong handoff code")
+	ErrHandoffCodeReplaced   = errors.New("too many wrong codes: the code was replaced, ask the other party for the new one")
+	ErrHandoffVerified       = errors.New("you already verified the other party's handoff code")
+	ErrMeetupNotStarted      = errors.New("a no-show can only be reported once the agreed meetup time has passed")
+	ErrAlreadyRated          = errors.New("you already rated this exchange")
+	ErrRatingNotAllowed      = errors.New("only completed exchanges can be rated")
+	ErrInvalidRating         = errors.New("a rating needs 1 to 5 stars")
+	ErrInvalidDisputeOutcome = errors.New("a dispute is resolved with the outcome cancel or complete")
+	ErrExchangeBookNotFound  = errors.New("book not found")
+	ErrOwnExchangeBook       = errors.New("you cannot exchange with your own book")
+	ErrMixedBundleOwners     = errors.New("the requested books must belong to the same owner")
+	ErrOfferedNotOwned       = errors.New("the offered books must be yours")
+	ErrNotForExchange        = errors.New("the books of an exchange must be listed for exchange")
+	ErrExchangeBookTaken     = errors.New("a book of the exchange is no longer available")
+)
+
+const (
+	maxExchangeReasonLength = 500
+	maxRatingCommentLength  = 1000
+	maxBundleBooks          = 5
+	// Handoff codes avoid look-alike characters such as 0 and O, or 1 and I
+	handoffCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	handoffCodeLength   = 6
+	maxHandoffAttempts  = 5
+	handoffQRSize       = 256
+)
+
+// BundleIDs returns the book ids of one side of an exchange from a request that gives
+// either a list or a single id
+func BundleIDs(single int, list []int) []int {
+	if len(list) == 0 && single != 0 {
+		return []int{single}
+	}
+	return list
+}
+
+func validateBundle(ids []int) error {
+	if len(ids) == 0 || len(ids) > maxBundleBooks {
+		return ErrInvalidBundle
+	}
+	seen := map[int]bool{}
+	for _, id := range ids {
+		if id <= 0 || seen[id] {
+			return ErrInvalidBundle
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// bundleTitles lists the quoted titles of a bundle, e.g. "Dune" and "Emma"
+func bundleTitles(books []models.ExchangeBook) string {
+	titles := make([]string, len(books))
+	for i, b := range books {
+		titles[i] = fmt.Sprintf("\"%s\"", b.Title)
+		if b.Title == "" {
+			titles[i] = "a deleted book"
+		}
+	}
+	if len(titles) < 2 {
+		return strings.Join(titles, "")
+	}
+	return strings.Join(titles[:len(titles)-1], ", ") + " and " + titles[len(titles)-1]
+}
+
+type ExchangeService struct {
+	Repo         *repositories.ExchangeRepository
+	NotifService *NotificationService
+	Notifier     Notifier
+	// RequestTTL is how long a pending request lives without activity; 0 keeps it forever
+	RequestTTL time.Duration
+	RatingRepo *repositories.RatingRepository
+	// Rules, when set, enforces the owners' exchange rules on new requests
+	Rules *ExchangeRuleService
+}
+
+func NewExchangeService(repo *repositories.ExchangeRepository, notifService *NotificationService, notifier Notifier) *ExchangeService {
+	return &ExchangeService{Repo: repo, NotifService: notifService, Notifier: notifier}
+}
+
+// Create adds an exchange request of the bundle offeredBookIDs for the bundle bookIDs and
+// lets the owner of the requested books know. It reports false when the same request was
+// already pending.
+func (s *ExchangeService) Create(userID int, bookIDs, offeredBookIDs []int) (int, bool, error) {
+	if err := validateBundle(bookIDs); err != nil {
+		return 0, false, err
+	}
+	if err := validateBundle(offeredBookIDs); err != nil {
+		return 0, false, err
+	}
+	for _, id := range offeredBookIDs {
+		for _, requested := range bookIDs {
+			if id == requested {
+				return 0, false, ErrInvalidBundle
+			}
+		}
+	}
+
+	missing, err := s.Repo.MissingBook(append(append([]int{}, bookIDs...), offeredBookIDs...))
+	if err != nil {
+		return 0, false, err
+	}
+	if missing != 0 {
+		return 0, false, ErrExchangeBookNotFound
+	}
+	// Any error from the rules blocks the request, so a broken rule never lets one through
+	if err := s.checkRules(userID, bookIDs, offeredBookIDs); err != nil {
+		return 0, false, err
+	}
+
+	id, isNew, err := s.Repo.Create(bookIDs, offeredBookIDs, userID)
+	switch {
+	case errors.Is(err, repositories.ErrOwnExchangeBook):
+		return 0, false, ErrOwnExchangeBook
+	case errors.Is(err, repositories.ErrMixedOwners):
+		return 0, false, ErrMixedBundleOwners
+	case errors.Is(err, repositories.ErrOfferedNotOwned):
+		return 0, false, ErrOfferedNotOwned
+	case errors.Is(err, repositories.ErrNotForExchange):
+		return 0, false, ErrNotForExchange
+	case errors.Is(err, repositories.ErrExchangeBookUnavailable):
+		return 0, false, ErrExchangeBookTaken
+	case err != nil || !isNew:
+		return id, isNew, err
+	}
+
+	if ex, err := s.Repo.GetByID(id); err == nil {
+		s.NotifService.Notify(s.Notifier, ex.OwnerID, userID, models.NotificationTypeBookRequest,
+			fmt.Sprintf("%s wants to exchange %s for your %s", partyName(ex.RequesterName), bundleTitles(ex.OfferedBooks), bundleTitles(ex.RequestedBooks)))
+	}
+	return id, true, nil
+}
+
+func (s *ExchangeService) checkRules(userID int, bookIDs, offeredBookIDs []int) error {
+	if s.Rules == nil {
+		return nil
+	}
+	return s.Rules.Check(userID, bookIDs, offeredBookIDs)
+}
+
+func (s *ExchangeService) List(userID int) ([]models.BookExchangeRequest, error) {
+	requests, err := s.Repo.GetForUser(userID)
+	for i := range requests {
+		s.setExpiry(&requests[i])
+	}
+	return requests, err
+}
+
+// setExpiry fills in when a pending request expires
+func (s *ExchangeService) setExpiry(ex *models.BookExchangeRequest) {
+	if ex.Status != models.ExchangeStatusPending || s.RequestTTL <= 0 {
+		return
+	}
+	if updated, err := time.Parse(time.RFC3339, ex.UpdatedAt); err == nil {
+		ex.ExpiresAt = updated.Add(s.RequestTTL).UTC().Format(time.RFC3339)
+	}
+}
+
+// Get returns an exchange with its history to one of its parties
+func (s *ExchangeService) Get(userID, exchangeID int) (models.BookExchangeRequest, error) {
+	ex, err := s.load(userID, exchangeID)
+	if err != nil {
+		return ex, err
+	}
+	if ex.Offers, err = s.Repo.GetOffers(exchangeID); err != nil {
+		return ex, err
+	}
+	ex.History, err = s.Repo.GetEvents(exchangeID)
+	return ex, err
+}
+
+func (s *ExchangeService) load(userID, exchangeID int) (models.BookExchangeRequest, error) {
+	ex, err := s.Repo.GetByID(exchangeID)
+	if err == sql.ErrNoRows {
+		return ex, ErrExchangeNotFound
+	}
+	if err != nil {
+		return ex, err
+	}
+	if ex.OwnerID != userID && ex.RequesterID != userID {
+		return ex, ErrNotExchangeParty
+	}
+	ex.IsIncoming = ex.OwnerID == userID
+	s.setExpiry(&ex)
+	return ex, nil
+}
+
+func limitExchangeText(text string) string {
+	text = strings.TrimSpace(text)
+	if r := []rune(text); len(r) > maxExchangeReasonLength {
+		text = string(r[:maxExchangeReasonLength])
+	}
+	return text
+}
+
+// Act applies an action of one party to an exchange and notifies the other party.
+// Actions the current status does not allow return ErrInvalidTransition.
+func (s *ExchangeService) Act(userID, exchangeID int, action string, req models.ExchangeActionRequest) (models.BookExchangeRequest, error) {
+	reason := limitExchangeText(req.Reason)
+
+	ex, err := s.load(userID, exchangeID)
+	if err != nil {
+		return ex, err
+	}
+	isOwner := ex.OwnerID == userID
+	other, name := ex.RequesterID, partyName(ex.OwnerName)
+	if !isOwner {
+		other, name = ex.OwnerID, partyName(ex.RequesterName)
+	}
+
+	var closed []int
+	completed := false
+	switch action {
+	case models.ExchangeActionAccept:
+		// The offer on the table binds once the party that did not propose it accepts
+		if ex.Status == models.ExchangeStatusPending && ex.LastOfferBy == userID {
+			return ex, ErrOwnOffer
+		}
+		closed, err = s.Repo.Accept(exchangeID, userID, req.OfferID)
+	case models.ExchangeActionDecline:
+		if !isOwner {
+			return ex, ErrExchangeForbidden
+		}
+		err = s.Repo.Decline(exchangeID, userID, reason)
+	case models.ExchangeActionCancel:
+		// A pending request is the requester's to withdraw; the owner declines it instead
+		if isOwner && ex.Status == models.ExchangeStatusPending {
+			return ex, ErrExchangeForbidden
+		}
+		err = s.Repo.Cancel(exchangeID, userID, reason)
+	case models.ExchangeActionConfirm:
+		completed, err = s.Repo.ConfirmCompletion(exchangeID, userID, isOwner)
+	case models.ExchangeActionDispute:
+		if reason == "" {
+			return ex, ErrDisputeReasonRequired
+		}
+		err = s.Repo.Dispute(exchangeID, userID, reason)
+	case models.ExchangeActionNoShow:
+		err = s.Repo.ReportNoShow(exchangeID, userID, other, time.Now().UTC().Format(time.RFC3339), reason)
+		if errors.Is(err, repositories.ErrMeetupNotStarted) {
+			return ex, ErrMeetupNotStarted
+		}
+	default:
+		return ex, ErrUnknownExchangeAction
+	}
+	if errors.Is(err, repositories.ErrExchangeTransition) || errors.Is(err, repositories.ErrBookStatusConflict) {
+		return ex, ErrInvalidTransition
+	}
+	if err != nil {
+		return ex, err
+	}
+
+	switch action {
+	case models.ExchangeActionAccept:
+		s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeBookAccepted,
+			fmt.Sprintf("%s accepted the exchange of %s for %s", name, bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks)))
+		s.notifyClosed(closed, userID)
+	case models.ExchangeActionDecline:
+		s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchange,
+			fmt.Sprintf("%s declined your exchange request for %s", name, bundleTitles(ex.RequestedBooks)))
+	case models.ExchangeActionCancel:
+		s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchange,
+			fmt.Sprintf("%s cancelled the exchange of %s for %s", name, bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks)))
+	case models.ExchangeActionConfirm:
+		if completed {
+			message := fmt.Sprintf("The exchange of %s for %s is complete", bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks))
+			s.NotifService.Notify(s.Notifier, other, 0, models.NotificationTypeExchange, message)
+			s.NotifService.Notify(s.Notifier, userID, 0, models.NotificationTypeExchange, message)
+		} else {
+			s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchange,
+				fmt.Sprintf("%s confirmed the exchange of %s for %s; confirm it too to complete it", name, bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks)))
+		}
+	case models.ExchangeActionDispute:
+		s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchange,
+			fmt.Sprintf("%s opened a dispute on the exchange of %s for %s", name, bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks)))
+	case models.ExchangeActionNoShow:
+		s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchange,
+			fmt.Sprintf("%s reported that you missed the meetup; the exchange of %s for %s was cancelled", name, bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks)))
+	}
+
+	return s.Get(userID, exchangeID)
+}
+
+// Counter puts a counter-offer on a pending exchange: another bundle of the requester's
+// library. Either party may counter; the other party is notified.
+func (s *ExchangeService) Counter(userID, exchangeID int, req models.ExchangeOfferRequest) (models.BookExchangeRequest, error) {
+	ex, err := s.load(userID, exchangeID)
+	if err != nil {
+		return ex, err
+	}
+	offered := BundleIDs(req.OfferedBookID, req.OfferedBookIDs)
+	if err := validateBundle(offered); err != nil {
+		return ex, err
+	}
+	for _, book := range ex.RequestedBooks {
+		for _, id := range offered {
+			if id == book.ID {
+				return ex, ErrInvalidBundle
+			}
+		}
+	}
+
+	// The owner may propose any book of the requester, the requester has to meet the rules
+	if userID == ex.RequesterID {
+		requested := make([]int, len(ex.RequestedBooks))
+		for i, book := range ex.RequestedBooks {
+			requested[i] = book.ID
+		}
+		missing, err := s.Repo.MissingBook(offered)
+		if err != nil {
+			return ex, err
+		}
+		if missing != 0 {
+			return ex, ErrInvalidOffer
+		}
+		if err := s.checkRules(userID, requested, offered); err != nil {
+			return ex, err
+		}
+	}
+
+	_, err = s.Repo.Counter(exchangeID, userID, offered, limitExchangeText(req.Message))
+	switch {
+	case errors.Is(err, repositories.ErrExchangeTransition):
+		return ex, ErrInvalidTransition
+	case errors.Is(err, repositories.ErrOfferBookInvalid):
+		return ex, ErrInvalidOffer
+	case errors.Is(err, repositories.ErrOfferDuplicate):
+		return ex, ErrOfferTaken
+	case err != nil:
+		return ex, err
+	}
+
+	updated, err := s.Get(userID, exchangeID)
+	if err != nil {
+		return updated, err
+	}
+	other, name := ex.RequesterID, partyName(ex.OwnerName)
+	if ex.RequesterID == userID {
+		other, name = ex.OwnerID, partyName(ex.RequesterName)
+	}
+	s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchangeOffer,
+		fmt.Sprintf("%s proposed %s for %s", name, bundleTitles(updated.OfferedBooks), bundleTitles(updated.RequestedBooks)))
+	return updated, nil
+}
+
+// Disputes returns the disputed exchanges waiting for a moderator
+func (s *ExchangeService) Disputes() ([]models.BookExchangeRequest, error) {
+	return s.Repo.GetDisputed()
+}
+
+// ResolveDispute ends a disputed exchange on a moderator's decision and notifies both
+// parties. Cancelling lists the books again; completing hands them over.
+func (s *ExchangeService) ResolveDispute(moderatorID, exchangeID int, req models.DisputeResolutionRequest) (models.BookExchangeRequest, error) {
+	var ex models.BookExchangeRequest
+	if req.Outcome != models.DisputeOutcomeCancel && req.Outcome != models.DisputeOutcomeComplete {
+		return ex, ErrInvalidDisputeOutcome
+	}
+	ex, err := s.Repo.GetByID(exchangeID)
+	if err == sql.ErrNoRows {
+		return ex, ErrExchangeNotFound
+	}
+	if err != nil {
+		return ex, err
+	}
+
+	err = s.Repo.ResolveDispute(exchangeID, moderatorID, req.Outcome, limitExchangeText(req.Note))
+	if errors.Is(err, repositories.ErrExchangeTransition) || errors.Is(err, repositories.ErrBookStatusConflict) {
+		return ex, ErrInvalidTransition
+	}
+	if err != nil {
+		return ex, err
+	}
+
+	outcome := "cancelled; the books are listed again"
+	if req.Outcome == models.DisputeOutcomeComplete {
+		outcome = "completed"
+	}
+	message := fmt.Sprintf("A moderator resolved the dispute on the exchange of %s for %s: it was %s",
+		bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks), outcome)
+	s.NotifService.Notify(s.Notifier, ex.OwnerID, 0, models.NotificationTypeExchange, message)
+	s.NotifService.Notify(s.Notifier, ex.RequesterID, 0, models.NotificationTypeExchange, message)
+
+	return s.Get(ex.OwnerID, exchangeID)
+}
+
+// notifyClosed tells the parties of requests closed by an accepted exchange, except the
+// user who accepted it
+func (s *ExchangeService) notifyClosed(ids []int, actorID int) {
+	for _, id := range ids {
+		ex, err := s.Repo.GetByID(id)
+		if err != nil {
+			fmt.Println("Error loading closed exchange:", err)
+			continue
+		}
+		message := fmt.Sprintf("The exchange request of %s for %s was %s: one of the books is reserved for another exchange",
+			bundleTitles(ex.OfferedBooks), bundleTitles(ex.RequestedBooks), ex.Status)
+		for _, to := range []int{ex.RequesterID, ex.OwnerID} {
+			if to != actorID {
+				s.NotifService.Notify(s.Notifier, to, 0, models.NotificationTypeExchange, message)
+			}
+		}
+	}
+}
+
+func partyName(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return "Someone"
+	}
+	return strings.TrimSpace(name)
+}
+
+// CancelUnavailable cancels the pending requests with a book that left the market,
+// was deleted or changed hands, and notifies both parties
+func (s *ExchangeService) CancelUnavailable() error {
+	ids, err := s.Repo.CancelUnavailable("a book is no longer available")
+	for _, id := range ids {
+		if ex, err := s.Repo.GetByID(id); err == nil {
+			message := fmt.Sprintf("The exchange request of %s for %s was cancelled: one of the books is no longer available",
+				bundleTitles(ex.OfferedBooks), bundleTitles(ex.RequestedBooks))
+			s.NotifService.Notify(s.Notifier, ex.RequesterID, 0, models.NotificationTypeExchange, message)
+			s.NotifService.Notify(s.Notifier, ex.OwnerID, 0, models.NotificationTypeExchange, message)
+		}
+	}
+	return err
+}
+
+// ExpireStale expires the pending requests without activity for RequestTTL and
+// notifies both parties
+func (s *ExchangeService) ExpireStale(now time.Time) error {
+	if s.RequestTTL <= 0 {
+		return nil
+	}
+	days := int(s.RequestTTL.Hours() / 24)
+	note := fmt.Sprintf("no activity for %s", s.RequestTTL)
+	ids, err := s.Repo.ExpirePending(now.Add(-s.RequestTTL).UTC().Format(sqlTimeLayout), note)
+	for _, id := range ids {
+		if ex, err := s.Repo.GetByID(id); err == nil {
+			message := fmt.Sprintf("The exchange request of %s for %s expired without an answer", bundleTitles(ex.OfferedBooks), bundleTitles(ex.RequestedBooks))
+			if days > 0 {
+				message = fmt.Sprintf("The exchange request of %s for %s expired after %d days without an answer",
+					bundleTitles(ex.OfferedBooks), bundleTitles(ex.RequestedBooks), days)
+			}
+			s.NotifService.Notify(s.Notifier, ex.RequesterID, 0, models.NotificationTypeExchange, message)
+			s.NotifService.Notify(s.Notifier, ex.OwnerID, 0, models.NotificationTypeExchange, message)
+		}
+	}
+	return err
+}
+
+// Run cleans up pending requests every interval until the process exits
+func (s *ExchangeService) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := s.CancelUnavailable(); err != nil {
+			fmt.Println("Error cancelling unavailable exchange requests:", err)
+		}
+		if err := s.ExpireStale(now); err != nil {
+			fmt.Println("Error expiring exchange requests:", err)
+		}
+	}
+}
+
+// newHandoffCode returns a random one-time handoff code
+func newHandoffCode() (string, error) {
+	buf := make([]byte, handoffCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	// The alphabet has 32 characters, so every byte maps without bias
+	for i, b := range buf {
+		buf[i] = handoffCodeAlphabet[int(b)%len(handoffCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// handoffPayload is what the QR code of a handoff code holds
+func handoffPayload(exchangeID int, code string) string {
+	return fmt.Sprintf("ktabnet:handoff:%d:%s", exchangeID, code)
+}
+
+// normalizeHandoffCode reads a typed code or a scanned QR payload of an exchange
+func normalizeHandoffCode(exchangeID int, input string) string {
+	input = strings.TrimSpace(input)
+	if prefix := handoffPayload(exchangeID, ""); strings.HasPrefix(input, prefix) {
+		input = strings.TrimPrefix(input, prefix)
+	}
+	input = strings.NewReplacer(" ", "", "-", "").Replace(input)
+	return strings.ToUpper(input)
+}
+
+// handoffHolders returns the holder of the user's own code and of the other party's
+func handoffHolders(ex models.BookExchangeRequest, userID int) (string, string) {
+	if ex.OwnerID == userID {
+		return models.HandoffHolderOwner, models.HandoffHolderRequester
+	}
+	return models.HandoffHolderRequester, models.HandoffHolderOwner
+}
+
+// Handoff returns a party's handoff code once the meetup is scheduled, creating the codes
+// of both parties on first use. A code already verified is not shown again.
+func (s *ExchangeService) Handoff(userID, exchangeID int) (models.ExchangeHandoff, error) {
+	ex, err := s.load(userID, exchangeID)
+	if err != nil {
+		return models.ExchangeHandoff{}, err
+	}
+	handoff := models.ExchangeHandoff{ExchangeID: exchangeID, Completed: ex.Status == models.ExchangeStatusCompleted}
+	if handoff.Completed {
+		handoff.CodeVerified, handoff.OtherVerified = true, true
+		return handoff, nil
+	}
+
+	codes, err := s.Repo.GetHandoffCodes(exchangeID, newHandoffCode)
+	if errors.Is(err, repositories.ErrExchangeTransition) {
+		return handoff, ErrInvalidTransition
+	}
+	if err != nil {
+		return handoff, err
+	}
+	mine, theirs := handoffHolders(ex, userID)
+	handoff.CodeVerified = codes[mine].Verified
+	handoff.OtherVerified = codes[theirs].Verified
+	if !handoff.CodeVerified {
+		handoff.Code = codes[mine].Code
+		handoff.QRPayload = handoffPayload(exchangeID, handoff.Code)
+		handoff.QRURL = fmt.Sprintf("/api/exchange-requests/%d/handoff/qr.png", exchangeID)
+	}
+	return handoff, nil
+}
+
+// HandoffQR renders a party's handoff code as a PNG QR code
+func (s *ExchangeService) HandoffQR(userID, exchangeID int) ([]byte, error) {
+	handoff, err := s.Handoff(userID, exchangeID)
+	if err != nil {
+		return nil, err
+	}
+	if handoff.Code == "" {
+		return nil, ErrHandoffVerified
+	}
+	return qrcode.Encode(handoff.QRPayload, qrcode.Medium, handoffQRSize)
+}
+
+// VerifyHandoff checks the other party's handoff code entered by userID and notifies
+// them. Once both codes are verified the exchange completes.
+func (s *ExchangeService) VerifyHandoff(userID, exchangeID int, input string) (models.BookExchangeRequest, error) {
+	ex, err := s.load(userID, exchangeID)
+	if err != nil {
+		return ex, err
+	}
+	replacement, err := newHandoffCode()
+	if err != nil {
+		return ex, err
+	}
+	_, theirs := handoffHolders(ex, userID)
+	completed, err := s.Repo.VerifyHandoff(exchangeID, userID, theirs, normalizeHandoffCode(exchangeID, input), maxHandoffAttempts, replacement)
+
+	other, name := ex.RequesterID, partyName(ex.OwnerName)
+	if ex.RequesterID == userID {
+		other, name = ex.OwnerID, partyName(ex.RequesterName)
+	}
+	switch {
+	case errors.Is(err, repositories.ErrHandoffCode):
+		return ex, ErrWrongHandoffCode
+	case errors.Is(err, repositories.ErrHandoffCodeReplaced):
+		s.NotifService.Notify(s.Notifier, other, 0, models.NotificationTypeExchange,
+			fmt.Sprintf("Your handoff code for the exchange of %s for %s was replaced after too many wrong attempts",
+				bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks)))
+		return ex, ErrHandoffCodeReplaced
+	case errors.Is(err, repositories.ErrHandoffVerified):
+		return ex, ErrHandoffVerified
+	case errors.Is(err, repositories.ErrExchangeTransition), errors.Is(err, repositories.ErrBookStatusConflict):
+		return ex, ErrInvalidTransition
+	case err != nil:
+		return ex, err
+	}
+
+	if completed {
+		message := fmt.Sprintf("Both handoff codes were verified: the exchange of %s for %s is complete",
+			bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks))
+		s.NotifService.Notify(s.Notifier, other, 0, models.NotificationTypeExchange, message)
+		s.NotifService.Notify(s.Notifier, userID, 0, models.NotificationTypeExchange, message)
+	} else {
+		s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchange,
+			fmt.Sprintf("%s verified your handoff code for the exchange of %s for %s; enter theirs to complete it",
+				name, bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks)))
+	}
+	return s.Get(userID, exchangeID)
+}
+
+// Ratings returns a party's view of the ratings of an exchange
+func (s *ExchangeService) Ratings(userID, exchangeID int) (models.ExchangeRatings, error) {
+	ex, err := s.load(userID, exchangeID)
+	if err != nil {
+		return models.ExchangeRatings{}, err
+	}
+	return s.exchangeRatings(userID, ex)
+}
+
+func (s *ExchangeService) exchangeRatings(userID int, ex models.BookExchangeRequest) (models.ExchangeRatings, error) {
+	view := models.ExchangeRatings{ExchangeID: ex.ID}
+	ratings, err := s.RatingRepo.GetForExchange(ex.ID)
+	if err != nil {
+		return view, err
+	}
+	for i := range ratings {
+		if ratings[i].RaterID == userID {
+			view.Given = &ratings[i]
+		} else {
+			view.Received = &ratings[i]
+		}
+	}
+	view.CanRate = ex.Status == models.ExchangeStatusCompleted && view.Given == nil
+	return view, nil
+}
+
+// Rate saves the rating a party gives the other party of a completed exchange, once per
+// side, and notifies them
+func (s *ExchangeService) Rate(userID, exchangeID int, req models.RatingRequest) (models.ExchangeRatings, error) {
+	ex, err := s.load(userID, exchangeID)
+	if err != nil {
+		return models.ExchangeRatings{}, err
+	}
+	if req.Stars < models.MinRatingStars || req.Stars > models.MaxRatingStars {
+		return models.ExchangeRatings{}, ErrInvalidRating
+	}
+	comment := strings.TrimSpace(req.Comment)
+	if r := []rune(comment); len(r) > maxRatingCommentLength {
+		comment = string(r[:maxRatingCommentLength])
+	}
+
+	other, name := ex.RequesterID, partyName(ex.OwnerName)
+	if ex.RequesterID == userID {
+		other, name = ex.OwnerID, partyName(ex.RequesterName)
+	}
+	_, err = s.RatingRepo.Create(exchangeID, userID, other, req.Stars, comment)
+	switch {
+	case errors.Is(err, repositories.ErrExchangeTransition):
+		return models.ExchangeRatings{}, ErrRatingNotAllowed
+	case errors.Is(err, repositories.ErrAlreadyRated):
+		return models.ExchangeRatings{}, ErrAlreadyRated
+	case err != nil:
+		return models.ExchangeRatings{}, err
+	}
+
+	s.NotifService.Notify(s.Notifier, other, userID, models.NotificationTypeExchange,
+		fmt.Sprintf("%s rated your exchange of %s for %s %d/5", name, bundleTitles(ex.RequestedBooks), bundleTitles(ex.OfferedBooks), req.Stars))
+	return s.exchangeRatings(userID, ex)
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+)
+
+var (
+	ErrClaimNotFound       = errors.New("giveaway claim not found")
+	ErrNotClaimParty       = errors.New("you are not part of this giveaway claim")
+	ErrClaimForbidden      = errors.New("your side of the giveaway cannot do this")
+	ErrInvalidClaimChange  = errors.New("the claim status does not allow this")
+	ErrUnknownClaimAction  = errors.New("unknown giveaway action")
+	ErrClaimLimitReached   = errors.New("the monthly giveaway claim limit is reached")
+	ErrGiveawayBookMissing = errors.New("book not found")
+	ErrNotGiveawayOwner    = errors.New("only the owner can see the queue of a giveaway")
+	ErrOwnGiveaway         = errors.New("cannot claim your own book")
+	ErrNotGiveaway         = errors.New("book is not listed as a giveaway")
+	ErrGiveawayUnavailable = errors.New("the giveaway is no longer available")
+)
+
+type GiveawayService struct {
+	Repo         *repositories.GiveawayRepository
+	Books        *repositories.BookRepository
+	NotifService *NotificationService
+	Notifier     Notifier
+	// MonthlyClaims is how many giveaways one user may be chosen for per calendar month
+	MonthlyClaims int
+}
+
+func NewGiveawayService(repo *repositories.GiveawayRepository, books *repositories.BookRepository, notifService *NotificationService, notifier Notifier, monthlyClaims int) *GiveawayService {
+	return &GiveawayService{Repo: repo, Books: books, NotifService: notifService, Notifier: notifier, MonthlyClaims: monthlyClaims}
+}
+
+// monthStart returns the start of the current UTC month in SQLite's timestamp format
+func monthStart() string {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).Format(sqlTimeLayout)
+}
+
+// withEligibility flags whether the owner may still choose a claim's requester; a claim
+// already chosen or completed counts as eligible
+func (s *GiveawayService) withEligibility(claim models.GiveawayClaim) models.GiveawayClaim {
+	claim.Eligible = claim.Status == models.ClaimStatusChosen || claim.Status == models.ClaimStatusCompleted ||
+		claim.ClaimsThisMonth < s.MonthlyClaims
+	return claim
+}
+
+// Request queues a claim for a giveaway listing and lets the owner know. A requester at
+// the monthly limit is turned away. On a first-come listing the claim is chosen right
+// away when nobody holds the book. It reports false when the claim was already open.
+func (s *GiveawayService) Request(userID int, req models.GiveawayRequest) (models.GiveawayClaim, bool, error) {
+	n, err := s.Repo.CountChosenSince(userID, monthStart())
+	if err != nil {
+		return models.GiveawayClaim{}, false, err
+	}
+	if n >= s.MonthlyClaims {
+		return models.GiveawayClaim{}, false, ErrClaimLimitReached
+	}
+
+	id, created, err := s.Repo.Create(models.GiveawayClaim{
+		BookID:      req.BookID,
+		RequesterID: userID,
+		Message:     limitExchangeText(req.Message),
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		return models.GiveawayClaim{}, false, ErrGiveawayBookMissing
+	case errors.Is(err, repositories.ErrOwnGiveaway):
+		return models.GiveawayClaim{}, false, ErrOwnGiveaway
+	case errors.Is(err, repositories.ErrNotGiveaway):
+		return models.GiveawayClaim{}, false, ErrNotGiveaway
+	case errors.Is(err, repositories.ErrGiveawayUnavailable):
+		return models.GiveawayClaim{}, false, ErrGiveawayUnavailable
+	case err != nil:
+		return models.GiveawayClaim{}, false, err
+	}
+
+	claim, err := s.Get(userID, id)
+	if err != nil || !created {
+		return claim, created, err
+	}
+	s.NotifService.Notify(s.Notifier, claim.OwnerID, userID, models.NotificationTypeGiveawayClaim,
+		fmt.Sprintf("%s would like your giveaway \"%s\"", partyName(claim.RequesterName), claim.BookTitle))
+	s.pickNext(claim.BookID)
+	claim, err = s.Get(userID, id)
+	return claim, true, err
+}
+
+func (s *GiveawayService) List(userID int) ([]models.GiveawayClaim, error) {
+	claims, err := s.Repo.GetForUser(userID, monthStart())
+	for i := range claims {
+		claims[i] = s.withEligibility(claims[i])
+	}
+	return claims, err
+}
+
+// Queue returns the open claims of a giveaway listing, oldest first, to its owner
+func (s *GiveawayService) Queue(userID, bookID int) ([]models.GiveawayClaim, error) {
+	book, err := s.Books.GetBookByID(bookID)
+	if err == sql.ErrNoRows {
+		return nil, ErrGiveawayBookMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	if book.OwnerID != userID {
+		return nil, ErrNotGiveawayOwner
+	}
+	claims, err := s.Repo.GetQueue(bookID, monthStart())
+	for i := range claims {
+		claims[i] = s.withEligibility(claims[i])
+		claims[i].IsIncoming = true
+	}
+	return claims, err
+}
+
+// Get returns a claim to its requester or the book owner
+func (s *GiveawayService) Get(userID, claimID int) (models.GiveawayClaim, error) {
+	claim, err := s.Repo.GetByID(claimID, monthStart())
+	if err == sql.ErrNoRows {
+		return claim, ErrClaimNotFound
+	}
+	if err != nil {
+		return claim, err
+	}
+	if claim.OwnerID != userID && claim.RequesterID != userID {
+		return claim, ErrNotClaimParty
+	}
+	claim.IsIncoming = claim.OwnerID == userID
+	return s.withEligibility(claim), nil
+}
+
+// Act applies an action to a claim and notifies the other party. The owner chooses,
+// declines, releases and completes; the requester cancels.
+func (s *GiveawayService) Act(userID, claimID int, action string) (models.GiveawayClaim, error) {
+	claim, err := s.Get(userID, claimID)
+	if err != nil {
+		return claim, err
+	}
+	isOwner := claim.OwnerID == userID
+	switch action {
+	case models.ClaimActionChoose, models.ClaimActionDecline, models.ClaimActionRelease, models.ClaimActionComplete:
+		if !isOwner {
+			return claim, ErrClaimForbidden
+		}
+	case models.ClaimActionCancel:
+		if isOwner {
+			return claim, ErrClaimForbidden
+		}
+	default:
+		return claim, ErrUnknownClaimAction
+	}
+
+	var declined []int
+	switch action {
+	case models.ClaimActionChoose:
+		err = s.Repo.Choose(claimID, userID, monthStart(), s.MonthlyClaims)
+	case models.ClaimActionDecline:
+		err = s.Repo.Decline(claimID)
+	case models.ClaimActionRelease:
+		err = s.Repo.Release(claimID, userID)
+	case models.ClaimActionComplete:
+		declined, err = s.Repo.Complete(claimID, userID)
+	case models.ClaimActionCancel:
+		err = s.Repo.Cancel(claimID, userID)
+	}
+	if errors.Is(err, repositories.ErrClaimLimit) {
+		return claim, ErrClaimLimitReached
+	}
+	if errors.Is(err, repositories.ErrClaimTransition) || errors.Is(err, repositories.ErrBookStatusConflict) {
+		return claim, ErrInvalidClaimChange
+	}
+	if err != nil {
+		return claim, err
+	}
+
+	owner := partyName(claim.OwnerName)
+	switch action {
+	case models.ClaimActionChoose:
+		s.NotifService.Notify(s.Notifier, claim.RequesterID, userID, models.NotificationTypeGiveaway,
+			fmt.Sprintf("%s chose you to receive the giveaway \"%s\"", owner, claim.BookTitle))
+	case models.ClaimActionDecline:
+		s.NotifService.Notify(s.Notifier, claim.RequesterID, userID, models.NotificationTypeGiveaway,
+			fmt.Sprintf("%s declined your claim for the giveaway \"%s\"", owner, claim.BookTitle))
+	case models.ClaimActionRelease:
+		s.NotifService.Notify(s.Notifier, claim.RequesterID, userID, models.NotificationTypeGiveaway,
+			fmt.Sprintf("%s released the giveaway \"%s\" for someone else", owner, claim.BookTitle))
+		s.pickNext(claim.BookID)
+	case models.ClaimActionComplete:
+		s.NotifService.Notify(s.Notifier, claim.RequesterID, userID, models.NotificationTypeGiveaway,
+			fmt.Sprintf("%s gave you \"%s\". It is now in your library", owner, claim.BookTitle))
+		for _, id := range declined {
+			if other, err := s.Repo.GetByID(id, monthStart()); err == nil {
+				s.NotifService.Notify(s.Notifier, other.RequesterID, 0, models.NotificationTypeGiveaway,
+					fmt.Sprintf("The giveaway \"%s\" went to someone else", other.BookTitle))
+			}
+		}
+	case models.ClaimActionCancel:
+		s.NotifService.Notify(s.Notifier, claim.OwnerID, userID, models.NotificationTypeGiveaway,
+			fmt.Sprintf("%s withdrew the claim for your giveaway \"%s\"", partyName(claim.RequesterName), claim.BookTitle))
+		s.pickNext(claim.BookID)
+	}
+
+	return s.Get(userID, claimID)
+}
+
+// pickNext chooses the first eligible requester of a first-come giveaway that is listed
+func (s *GiveawayService) pickNext(bookID int) {
+	book, err := s.Books.GetBookByID(bookID)
+	if err != nil || book.ListingType != models.ListingTypeGiveaway || book.GiveawayPick != models.GiveawayPickFirst ||
+		book.Status != models.BookStatusListed {
+		return
+	}
+	id, err := s.Repo.NextEligible(bookID, monthStart(), s.MonthlyClaims)
+	if err != nil || id == 0 {
+		if err != nil {
+			fmt.Println("Error picking giveaway recipient:", err)
+		}
+		return
+	}
+	if err := s.Repo.Choose(id, 0, monthStart(), s.MonthlyClaims); err != nil {
+		fmt.Println("Error choosing giveaway recipient:", err)
+		return
+	}
+	if claim, err := s.Repo.GetByID(id, monthStart()); err == nil {
+		s.NotifService.Notify(s.Notifier, claim.RequesterID, 0, models.NotificationTypeGiveaway,
+			fmt.Sprintf("You are first in line for the giveaway \"%s\". Arrange the handover with %s", claim.BookTitle, partyName(claim.OwnerName)))
+		s.NotifService.Notify(s.Notifier, claim.OwnerID, 0, models.NotificationTypeGiveaway,
+			fmt.Sprintf("%s was chosen to receive your giveaway \"%s\"", partyName(claim.RequesterName), claim.BookTitle))
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+
+	"ktabnet/storage"
+	"ktabnet/utils"
+)
+
+// ImageService is the shared upload pipeline for book photos, avatars and post images
+type ImageService struct {
+	Store    storage.BlobStore
+	MaxBytes int64
+}
+
+func NewImageService(store storage.BlobStore, maxBytes int64) *ImageService {
+	return &ImageService{Store: store, MaxBytes: maxBytes}
+}
+
+// SaveUpload runs an uploaded form file through the pipeline, see Save
+func (s *ImageService) SaveUpload(fh *multipart.FileHeader, dir string) (string, error) {
+	if fh.Size > s.MaxBytes {
+		return "", utils.ErrImageTooLarge
+	}
+	file, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return s.Save(file, dir)
+}
+
+// Save validates an image, strips its metadata and stores every size variant
+// under content-hashed keys in <dir>/. It returns the public URL of the
+// full-size variant; the others are found with utils.ImageVariantURL.
+func (s *ImageService) Save(r io.Reader, dir string) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, s.MaxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > s.MaxBytes {
+		return "", utils.ErrImageTooLarge
+	}
+
+	img, err := utils.ProcessImage(data)
+	if err != nil {
+		return "", err
+	}
+
+	for _, v := range utils.ImageVariants {
+		key := dir + "/" + img.Filename(v.Suffix)
+		// The same content always produces the same blobs, so an existing one is reused
+		if ok, err := s.Store.Exists(key); err == nil && ok {
+			continue
+		}
+		if err := s.Store.Put(key, img.Variants[v.Suffix], storage.ContentType(key)); err != nil {
+			return "", fmt.Errorf("saving image: %w", err)
+		}
+	}
+	return s.Store.URL(dir + "/" + img.Filename("")), nil
+}
+
+// Remove deletes a stored image and its size variants. Callers must make sure
+// nothing else references the URL, since identical uploads share their blobs.
+// URLs the store does not own are ignored.
+func (s *ImageService) Remove(url string) error {
+	key, ok := s.Store.KeyFromURL(url)
+	if !ok {
+		return nil
+	}
+
+	var firstErr error
+	for _, v := range utils.ImageVariants {
+		// Older uploads were stored without variants; deleting a missing key is a no-op
+		if err := s.Store.Delete(utils.ImageVariantURL(key, v.Suffix)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+)
+
+var (
+	ErrLoanNotFound        = errors.New("loan not found")
+	ErrNotLoanParty        = errors.New("you are not part of this loan")
+	ErrLoanForbidden       = errors.New("your side of the loan cannot do this")
+	ErrInvalidLoanChange   = errors.New("the loan status does not allow this")
+	ErrUnknownLoanAction   = errors.New("unknown loan action")
+	ErrInvalidLoanDates    = errors.New("a loan needs a start date from today and a due date after it, at most 90 days later (YYYY-MM-DD)")
+	ErrLoanBookNotFound    = errors.New("book not found")
+	ErrOwnLoanBook         = errors.New("you cannot borrow your own book")
+	ErrNotForLending       = errors.New("the book is not listed for lending")
+	ErrLoanBookUnavailable = errors.New("the book is not available to borrow")
+)
+
+const (
+	loanDateLayout   = "2006-01-02"
+	maxLoanDays      = 90
+	loanReminderDays = 2
+)
+
+type LoanService struct {
+	Repo         *repositories.LoanRepository
+	NotifService *NotificationService
+	Notifier     Notifier
+}
+
+func NewLoanService(repo *repositories.LoanRepository, notifService *NotificationService, notifier Notifier) *LoanService {
+	return &LoanService{Repo: repo, NotifService: notifService, Notifier: notifier}
+}
+
+func loanToday() string {
+	return time.Now().UTC().Format(loanDateLayout)
+}
+
+// validateLoanDates checks that start is today or later and due falls after start
+// within maxLoanDays
+func validateLoanDates(start, due string) error {
+	startDate, err := time.Parse(loanDateLayout, start)
+	if err != nil {
+		return ErrInvalidLoanDates
+	}
+	dueDate, err := time.Parse(loanDateLayout, due)
+	if err != nil {
+		return ErrInvalidLoanDates
+	}
+	if start < loanToday() || !dueDate.After(startDate) || dueDate.Sub(startDate) > maxLoanDays*24*time.Hour {
+		return ErrInvalidLoanDates
+	}
+	return nil
+}
+
+// Request asks to borrow a lend listing and lets the owner know. It reports false when
+// the borrower already had a pending request for the book.
+func (s *LoanService) Request(userID int, req models.LoanRequest) (models.BookLoan, bool, error) {
+	req.StartDate = strings.TrimSpace(req.StartDate)
+	req.DueDate = strings.TrimSpace(req.DueDate)
+	if err := validateLoanDates(req.StartDate, req.DueDate); err != nil {
+		return models.BookLoan{}, false, err
+	}
+
+	id, created, err := s.Repo.Create(models.BookLoan{
+		BookID:     req.BookID,
+		BorrowerID: userID,
+		StartDate:  req.StartDate,
+		DueDate:    req.DueDate,
+		Message:    limitExchangeText(req.Message),
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		return models.BookLoan{}, false, ErrLoanBookNotFound
+	case errors.Is(err, repositories.ErrOwnLoanBook):
+		return models.BookLoan{}, false, ErrOwnLoanBook
+	case errors.Is(err, repositories.ErrNotForLending):
+		return models.BookLoan{}, false, ErrNotForLending
+	case errors.Is(err, repositories.ErrLoanBookUnavailable):
+		return models.BookLoan{}, false, ErrLoanBookUnavailable
+	case err != nil:
+		return models.BookLoan{}, false, err
+	}
+
+	loan, err := s.Get(userID, id)
+	if err != nil {
+		return loan, created, err
+	}
+	if created {
+		s.NotifService.Notify(s.Notifier, loan.OwnerID, userID, models.NotificationTypeLoanRequest,
+			fmt.Sprintf("%s wants to borrow your \"%s\" from %s to %s", partyName(loan.BorrowerName), loan.BookTitle, loan.StartDate, loan.DueDate))
+	}
+	return loan, created, nil
+}
+
+func (s *LoanService) List(userID int) ([]models.BookLoan, error) {
+	return s.Repo.GetForUser(userID, loanToday())
+}
+
+// Get returns a loan to its owner or borrower
+func (s *LoanService) Get(userID, loanID int) (models.BookLoan, error) {
+	loan, err := s.Repo.GetByID(loanID, loanToday())
+	if err == sql.ErrNoRows {
+		return loan, ErrLoanNotFound
+	}
+	if err != nil {
+		return loan, err
+	}
+	if loan.OwnerID != userID && loan.BorrowerID != userID {
+		return loan, ErrNotLoanParty
+	}
+	loan.IsIncoming = loan.OwnerID == userID
+	return loan, nil
+}
+
+// Act applies an action to a loan and notifies the other party. The owner approves,
+// declines and marks the book returned; the borrower cancels a pending or approved loan,
+// and the owner may call off an approved one before it starts.
+func (s *LoanService) Act(userID, loanID int, action string) (models.BookLoan, error) {
+	loan, err := s.Get(userID, loanID)
+	if err != nil {
+		return loan, err
+	}
+	isOwner := loan.OwnerID == userID
+
+	var declined []int
+	switch action {
+	case models.LoanActionApprove:
+		if !isOwner {
+			return loan, ErrLoanForbidden
+		}
+		declined, err = s.Repo.Approve(loanID, userID, loanToday())
+	case models.LoanActionDecline:
+		if !isOwner {
+			return loan, ErrLoanForbidden
+		}
+		err = s.Repo.Decline(loanID)
+	case models.LoanActionCancel:
+		if isOwner && loan.Status != models.LoanStatusApproved {
+			return loan, ErrLoanForbidden
+		}
+		err = s.Repo.Cancel(loanID, userID)
+	case models.LoanActionReturn:
+		if !isOwner {
+			return loan, ErrLoanForbidden
+		}
+		err = s.Repo.MarkReturned(loanID, userID)
+	default:
+		return loan, ErrUnknownLoanAction
+	}
+	if errors.Is(err, repositories.ErrLoanTransition) || errors.Is(err, repositories.ErrBookStatusConflict) {
+		return loan, ErrInvalidLoanChange
+	}
+	if err != nil {
+		return loan, err
+	}
+
+	switch action {
+	case models.LoanActionApprove:
+		message := fmt.Sprintf("%s approved your request to borrow \"%s\"; it is due back on %s", partyName(loan.OwnerName), loan.BookTitle, loan.DueDate)
+		if loan.StartDate > loanToday() {
+			message = fmt.Sprintf("%s approved your request to borrow \"%s\" from %s; it is due back on %s", partyName(loan.OwnerName), loan.BookTitle, loan.StartDate, loan.DueDate)
+		}
+		s.NotifService.Notify(s.Notifier, loan.BorrowerID, userID, models.NotificationTypeLoanUpdate, message)
+		for _, id := range declined {
+			if other, err := s.Repo.GetByID(id, loanToday()); err == nil {
+				s.NotifService.Notify(s.Notifier, other.BorrowerID, 0, models.NotificationTypeLoanUpdate,
+					fmt.Sprintf("Your request to borrow \"%s\" was declined: the book is lent to someone else", other.BookTitle))
+			}
+		}
+	case models.LoanActionDecline:
+		s.NotifService.Notify(s.Notifier, loan.BorrowerID, userID, models.NotificationTypeLoanUpdate,
+			fmt.Sprintf("%s declined your request to borrow \"%s\"", partyName(loan.OwnerName), loan.BookTitle))
+	case models.LoanActionCancel:
+		if isOwner {
+			s.NotifService.Notify(s.Notifier, loan.BorrowerID, userID, models.NotificationTypeLoanUpdate,
+				fmt.Sprintf("%s called off lending you \"%s\"", partyName(loan.OwnerName), loan.BookTitle))
+		} else {
+			s.NotifService.Notify(s.Notifier, loan.OwnerID, userID, models.NotificationTypeLoanUpdate,
+				fmt.Sprintf("%s withdrew the request to borrow \"%s\"", partyName(loan.BorrowerName), loan.BookTitle))
+		}
+	case models.LoanActionReturn:
+		s.NotifService.Notify(s.Notifier, loan.BorrowerID, userID, models.NotificationTypeLoanUpdate,
+			fmt.Sprintf("%s marked \"%s\" as returned. Thanks for giving it back!", partyName(loan.OwnerName), loan.BookTitle))
+	}
+
+	return s.Get(userID, loanID)
+}
+
+// RunReminders starts the approved loans that reached their start date, reminds
+// borrowers of loans due within the next loanReminderDays and sends a daily overdue
+// notice to the borrower and the owner of each loan past its due date
+func (s *LoanService) RunReminders(now time.Time) error {
+	now = now.UTC()
+	day := now.Format(loanDateLayout)
+	at := now.Format(sqlTimeLayout)
+
+	started, err := s.Repo.StartDue(day)
+	if err != nil {
+		return err
+	}
+	for _, loan := range started {
+		s.NotifService.Notify(s.Notifier, loan.BorrowerID, 0, models.NotificationTypeLoanUpdate,
+			fmt.Sprintf("Your loan of \"%s\" from %s starts today; it is due back on %s", loan.BookTitle, partyName(loan.OwnerName), loan.DueDate))
+		s.NotifService.Notify(s.Notifier, loan.OwnerID, 0, models.NotificationTypeLoanUpdate,
+			fmt.Sprintf("The loan of \"%s\" to %s starts today", loan.BookTitle, partyName(loan.BorrowerName)))
+	}
+
+	due, err := s.Repo.GetDueForReminder(day, now.AddDate(0, 0, loanReminderDays).Format(loanDateLayout))
+	if err != nil {
+		return err
+	}
+	for _, loan := range due {
+		when := "on " + loan.DueDate
+		if loan.DueDate == day {
+			when = "today"
+		}
+		s.NotifService.Notify(s.Notifier, loan.BorrowerID, 0, models.NotificationTypeLoanReminder,
+			fmt.Sprintf("Reminder: \"%s\" is due back to %s %s", loan.BookTitle, partyName(loan.OwnerName), when))
+		if err := s.Repo.MarkReminded(loan.ID, at); err != nil {
+			fmt.Printf("Error updating loan %d: %v\n", loan.ID, err)
+		}
+	}
+
+	overdue, err := s.Repo.GetOverdue(day, day+" 00:00:00")
+	if err != nil {
+		return err
+	}
+	for _, loan := range overdue {
+		s.NotifService.Notify(s.Notifier, loan.BorrowerID, 0, models.NotificationTypeLoanOverdue,
+			fmt.Sprintf("\"%s\" was due back to %s on %s. Please return it", loan.BookTitle, partyName(loan.OwnerName), loan.DueDate))
+		s.NotifService.Notify(s.Notifier, loan.OwnerID, 0, models.NotificationTypeLoanOverdue,
+			fmt.Sprintf("\"%s\" lent to %s was due back on %s", loan.BookTitle, partyName(loan.BorrowerName), loan.DueDate))
+		if err := s.Repo.MarkOverdueNotified(loan.ID, at); err != nil {
+			fmt.Printf("Error updating loan %d: %v\n", loan.ID, err)
+		}
+	}
+	return nil
+}
+
+// Run checks loan due dates every interval until the process exits
+func (s *LoanService) Run(interval time.Duration) {
+	if err := s.RunReminders(time.Now()); err != nil {
+		fmt.Println("Error running loan reminders:", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := s.RunReminders(now); err != nil {
+			fmt.Println("Error running loan reminders:", err)
+		}
+	}
+}
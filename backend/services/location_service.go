@@ -0,0 +1,107 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+	"ktabnet/utils"
+)
+
+var (
+	ErrUnknownCity     = errors.New("unknown city")
+	ErrNoLocation      = errors.New("set a location or city on your profile to search near you")
+	ErrInvalidLocation = errors.New("latitude must be between -90 and 90 and longitude between -180 and 180")
+)
+
+// NearMe is the value of the near parameter that stands for the viewer's own location
+const NearMe = "me"
+
+type LocationService struct {
+	Repo *repositories.LocationRepository
+}
+
+func NewLocationService(repo *repositories.LocationRepository) *LocationService {
+	return &LocationService{Repo: repo}
+}
+
+func (s *LocationService) GetCities() ([]models.City, error) {
+	return s.Repo.GetCities()
+}
+
+// FindCity resolves a city name or its French or Arabic label, ignoring case and accents
+func (s *LocationService) FindCity(name string) (models.City, error) {
+	if strings.TrimSpace(name) == "" {
+		return models.City{}, ErrUnknownCity
+	}
+	city, err := s.Repo.FindCity(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return city, ErrUnknownCity
+	}
+	return city, err
+}
+
+// CanonicalCity returns the stored spelling of a city name, e.g. "sale" gives "Salé"
+func (s *LocationService) CanonicalCity(name string) (string, error) {
+	city, err := s.FindCity(name)
+	if err != nil {
+		return "", err
+	}
+	return city.Name, nil
+}
+
+// ResolveNear turns a near parameter into a position: a city name gives its
+// coordinates and "me" the viewer's origin (see UserOrigin). Empty gives nil.
+func (s *LocationService) ResolveNear(userID int, near string) (*models.GeoPoint, error) {
+	near = strings.TrimSpace(near)
+	switch {
+	case near == "":
+		return nil, nil
+	case strings.EqualFold(near, NearMe):
+		origin, err := s.UserOrigin(userID)
+		if err == nil && origin == nil {
+			err = ErrNoLocation
+		}
+		return origin, err
+	}
+	city, err := s.FindCity(near)
+	if err != nil {
+		return nil, err
+	}
+	return &models.GeoPoint{Latitude: city.Latitude, Longitude: city.Longitude}, nil
+}
+
+// UserOrigin is the profile location of a user, falling back to their profile
+// city; nil when neither is known
+func (s *LocationService) UserOrigin(userID int) (*models.GeoPoint, error) {
+	return s.Repo.GetUserOrigin(userID)
+}
+
+func (s *LocationService) GetUserLocation(userID int) (*models.GeoPoint, error) {
+	return s.Repo.GetUserLocation(userID)
+}
+
+// SetUserLocation parses and stores a profile location, rounded so that only an
+// approximate position is kept. Both values empty clears it.
+func (s *LocationService) SetUserLocation(userID int, latitude, longitude string) error {
+	latitude, longitude = strings.TrimSpace(latitude), strings.TrimSpace(longitude)
+	if latitude == "" && longitude == "" {
+		return s.Repo.SetUserLocation(userID, nil)
+	}
+
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return ErrInvalidLocation
+	}
+	lng, err := strconv.ParseFloat(longitude, 64)
+	if err != nil || lng < -180 || lng > 180 {
+		return ErrInvalidLocation
+	}
+	return s.Repo.SetUserLocation(userID, &models.GeoPoint{
+		Latitude:  utils.ApproximateCoordinate(lat),
+		Longitude: utils.ApproximateCoordinate(lng),
+	})
+}
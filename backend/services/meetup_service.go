@@ -0,0 +1,356 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+)
+
+var (
+	ErrMeetupNotFound      = errors.New("meetup not found")
+	ErrNotMeetupParty      = errors.New("you are not part of this meetup's exchange")
+	ErrInvalidMeetupChange = errors.New("the exchange or meetup status does not allow this")
+	ErrUnknownMeetupAction = errors.New("unknown meetup action")
+	ErrMeetupExists        = errors.New("the exchange already has a meetup; reschedule it instead")
+	ErrOwnMeetupProposal   = errors.New("the other party has to accept your proposal")
+	ErrInvalidMeetupTime   = errors.New("starts_at must be an RFC 3339 time within the next 60 days")
+	ErrSpotNotFound        = errors.New("meetup spot not found or retired")
+	ErrInvalidSpot         = errors.New("invalid meetup spot")
+	ErrDuplicateSpot       = errors.New("the city already has a spot with this name")
+	ErrFeedNotFound        = errors.New("calendar feed not found")
+)
+
+const (
+	// maxMeetupAhead is how far ahead a meetup may be planned
+	maxMeetupAhead       = 60 * 24 * time.Hour
+	maxSpotNameLength    = 100
+	maxSpotAddressLength = 200
+)
+
+// meetupZone is the zone meetup times are written in for notifications
+var meetupZone = loadMeetupZone()
+
+func loadMeetupZone() *time.Location {
+	if loc, err := time.LoadLocation("Africa/Casablanca"); err == nil {
+		return loc
+	}
+	return time.FixedZone("+01", 3600)
+}
+
+type MeetupService struct {
+	Repo         *repositories.MeetupRepository
+	Exchanges    *repositories.ExchangeRepository
+	Locations    *LocationService
+	NotifService *NotificationService
+	Notifier     Notifier
+}
+
+func NewMeetupService(repo *repositories.MeetupRepository, exchanges *repositories.ExchangeRepository, locations *LocationService, notifService *NotificationService, notifier Notifier) *MeetupService {
+	return &MeetupService{Repo: repo, Exchanges: exchanges, Locations: locations, NotifService: notifService, Notifier: notifier}
+}
+
+// parseMeetupTime reads a proposed start time, which must lie ahead within maxMeetupAhead,
+// and returns it in UTC
+func parseMeetupTime(value string) (string, error) {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	now := time.Now()
+	if err != nil || !t.After(now) || t.After(now.Add(maxMeetupAhead)) {
+		return "", ErrInvalidMeetupTime
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// withEnd fills in when a meetup ends
+func withEnd(m models.ExchangeMeetup) models.ExchangeMeetup {
+	if t, err := time.Parse(time.RFC3339, m.StartsAt); err == nil {
+		m.EndsAt = t.Add(meetupLength).UTC().Format(time.RFC3339)
+	}
+	return m
+}
+
+// meetupWhen describes a meetup's time and place for notifications
+func meetupWhen(m models.ExchangeMeetup) string {
+	when := m.StartsAt
+	if t, err := time.Parse(time.RFC3339, m.StartsAt); err == nil {
+		when = t.In(meetupZone).Format("Mon 2 Jan at 15:04")
+	}
+	return fmt.Sprintf("%s, %s, on %s", m.Spot.Name, m.Spot.City, when)
+}
+
+// Spots lists the active spots of a city, or of every city when city is empty
+func (s *MeetupService) Spots(city string) ([]models.MeetupSpot, error) {
+	if city = strings.TrimSpace(city); city != "" {
+		canonical, err := s.Locations.CanonicalCity(city)
+		if err != nil {
+			return nil, err
+		}
+		city = canonical
+	}
+	return s.Repo.GetSpots(city, false)
+}
+
+// List returns the upcoming meetups of a user's ongoing exchanges, soonest first; a
+// meetup stays listed while it is under way
+func (s *MeetupService) List(userID int) ([]models.ExchangeMeetup, error) {
+	from := time.Now().Add(-meetupLength).UTC().Format(time.RFC3339)
+	meetups, err := s.Repo.GetUpcoming(userID, from)
+	for i := range meetups {
+		meetups[i] = withEnd(meetups[i])
+	}
+	return meetups, err
+}
+
+// Get returns a meetup to one of the parties of its exchange
+func (s *MeetupService) Get(userID, meetupID int) (models.ExchangeMeetup, error) {
+	m, err := s.Repo.GetByID(meetupID)
+	if err == sql.ErrNoRows {
+		return m, ErrMeetupNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	if m.OwnerID != userID && m.RequesterID != userID {
+		return m, ErrNotMeetupParty
+	}
+	return withEnd(m), nil
+}
+
+// Propose suggests a time and spot to hand over the books of an accepted exchange and
+// lets the other party know
+func (s *MeetupService) Propose(userID int, req models.MeetupRequest) (models.ExchangeMeetup, error) {
+	ex, err := s.Exchanges.GetByID(req.ExchangeID)
+	if err == sql.ErrNoRows {
+		return models.ExchangeMeetup{}, ErrExchangeNotFound
+	}
+	if err != nil {
+		return models.ExchangeMeetup{}, err
+	}
+	if ex.OwnerID != userID && ex.RequesterID != userID {
+		return models.ExchangeMeetup{}, ErrNotExchangeParty
+	}
+	startsAt, err := parseMeetupTime(req.StartsAt)
+	if err != nil {
+		return models.ExchangeMeetup{}, err
+	}
+
+	id, err := s.Repo.Create(req.ExchangeID, userID, req.SpotID, startsAt, limitExchangeText(req.Note))
+	if err != nil {
+		return models.ExchangeMeetup{}, meetupError(err)
+	}
+	m, err := s.Get(userID, id)
+	if err != nil {
+		return m, err
+	}
+	s.notifyOther(m, userID, fmt.Sprintf("%s proposed to meet at %s to exchange %s for %s. Accept or reschedule it",
+		s.actorName(m, userID), meetupWhen(m), bundleTitles(m.RequestedBooks), bundleTitles(m.OfferedBooks)))
+	return m, nil
+}
+
+// Act applies a party's answer to a meetup: accepting the other party's proposal, or
+// rescheduling it with a new time and spot that the other party then answers
+func (s *MeetupService) Act(userID, meetupID int, action string, req models.MeetupRequest) (models.ExchangeMeetup, error) {
+	m, err := s.Get(userID, meetupID)
+	if err != nil {
+		return m, err
+	}
+
+	switch action {
+	case models.MeetupActionAccept:
+		if m.ProposedBy == userID && m.Status == models.MeetupStatusProposed {
+			return m, ErrOwnMeetupProposal
+		}
+		err = s.Repo.Accept(meetupID, userID)
+	case models.MeetupActionReschedule:
+		startsAt, parseErr := parseMeetupTime(req.StartsAt)
+		if parseErr != nil {
+			return m, parseErr
+		}
+		spotID := req.SpotID
+		if spotID == 0 {
+			spotID = m.Spot.ID
+		}
+		err = s.Repo.Reschedule(meetupID, userID, spotID, startsAt, limitExchangeText(req.Note))
+	default:
+		return m, ErrUnknownMeetupAction
+	}
+	if err != nil {
+		return m, meetupError(err)
+	}
+
+	updated, err := s.Get(userID, meetupID)
+	if err != nil {
+		return updated, err
+	}
+	name := s.actorName(updated, userID)
+	switch action {
+	case models.MeetupActionAccept:
+		s.notifyOther(updated, userID, fmt.Sprintf("%s accepted to meet at %s", name, meetupWhen(updated)))
+	case models.MeetupActionReschedule:
+		s.notifyOther(updated, userID, fmt.Sprintf("%s proposed a new meetup at %s. Accept or reschedule it", name, meetupWhen(updated)))
+	}
+	return updated, nil
+}
+
+// meetupError maps repository errors of meetup changes to service errors
+func meetupError(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrMeetupExists):
+		return ErrMeetupExists
+	case errors.Is(err, repositories.ErrSpotUnavailable):
+		return ErrSpotNotFound
+	case errors.Is(err, repositories.ErrMeetupTransition), errors.Is(err, repositories.ErrExchangeTransition),
+		errors.Is(err, repositories.ErrBookStatusConflict):
+		return ErrInvalidMeetupChange
+	case err == sql.ErrNoRows:
+		return ErrMeetupNotFound
+	}
+	return err
+}
+
+// Calendar renders one meetup as an .ics document for one of its parties
+func (s *MeetupService) Calendar(userID, meetupID int) (string, error) {
+	m, err := s.Get(userID, meetupID)
+	if err != nil {
+		return "", err
+	}
+	return meetupCalendar("Book exchange meetup", userID, []models.ExchangeMeetup{m}), nil
+}
+
+// FeedToken returns the secret token of a user's calendar feed, creating it on first
+// use. rotate replaces it, which stops the previous feed URL from working.
+func (s *MeetupService) FeedToken(userID int, rotate bool) (string, error) {
+	if !rotate {
+		token, err := s.Repo.GetFeedToken(userID)
+		if err != sql.ErrNoRows {
+			return token, err
+		}
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	return token, s.Repo.SetFeedToken(userID, token)
+}
+
+// Feed renders the upcoming meetups of the owner of a feed token as an .ics document
+func (s *MeetupService) Feed(token string) (string, error) {
+	userID, err := s.Repo.GetFeedUser(token)
+	if err == sql.ErrNoRows {
+		return "", ErrFeedNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	meetups, err := s.List(userID)
+	if err != nil {
+		return "", err
+	}
+	return meetupCalendar("Book exchange meetups", userID, meetups), nil
+}
+
+// AllSpots lists every spot, retired ones included, for admins
+func (s *MeetupService) AllSpots() ([]models.MeetupSpot, error) {
+	return s.Repo.GetSpots("", true)
+}
+
+// buildSpot validates an admin spot request onto spot
+func (s *MeetupService) buildSpot(spot models.MeetupSpot, req models.MeetupSpotRequest) (models.MeetupSpot, error) {
+	city, err := s.Locations.CanonicalCity(req.City)
+	if err != nil {
+		return spot, fmt.Errorf("%w: %v", ErrInvalidSpot, err)
+	}
+	spot.City = city
+	spot.Name = strings.TrimSpace(req.Name)
+	spot.Address = strings.TrimSpace(req.Address)
+	if spot.Name == "" || len([]rune(spot.Name)) > maxSpotNameLength {
+		return spot, fmt.Errorf("%w: a name of at most %d characters is required", ErrInvalidSpot, maxSpotNameLength)
+	}
+	if len([]rune(spot.Address)) > maxSpotAddressLength {
+		return spot, fmt.Errorf("%w: the address is longer than %d characters", ErrInvalidSpot, maxSpotAddressLength)
+	}
+	if (req.Latitude == nil) != (req.Longitude == nil) {
+		return spot, fmt.Errorf("%w: give both a latitude and a longitude, or neither", ErrInvalidSpot)
+	}
+	if req.Latitude != nil && (*req.Latitude < -90 || *req.Latitude > 90 || *req.Longitude < -180 || *req.Longitude > 180) {
+		return spot, fmt.Errorf("%w: %v", ErrInvalidSpot, ErrInvalidLocation)
+	}
+	spot.Latitude, spot.Longitude = req.Latitude, req.Longitude
+	if req.Active != nil {
+		spot.Active = *req.Active
+	}
+
+	dup, err := s.Repo.SpotExists(spot.City, spot.Name, spot.ID)
+	if err == nil && dup {
+		err = ErrDuplicateSpot
+	}
+	return spot, err
+}
+
+// CreateSpot adds a curated spot
+func (s *MeetupService) CreateSpot(req models.MeetupSpotRequest) (models.MeetupSpot, error) {
+	spot, err := s.buildSpot(models.MeetupSpot{Active: true}, req)
+	if err != nil {
+		return spot, err
+	}
+	id, err := s.Repo.CreateSpot(spot)
+	if err != nil {
+		return spot, err
+	}
+	return s.Repo.GetSpot(id)
+}
+
+// UpdateSpot replaces the details of a spot; meetups already planned there keep it
+func (s *MeetupService) UpdateSpot(id int, req models.MeetupSpotRequest) (models.MeetupSpot, error) {
+	existing, err := s.Repo.GetSpot(id)
+	if err == sql.ErrNoRows {
+		return existing, ErrSpotNotFound
+	}
+	if err != nil {
+		return existing, err
+	}
+	spot, err := s.buildSpot(existing, req)
+	if err != nil {
+		return spot, err
+	}
+	if err := s.Repo.UpdateSpot(spot); err != nil {
+		return spot, err
+	}
+	return s.Repo.GetSpot(id)
+}
+
+// RetireSpot stops a spot from being offered for new meetups
+func (s *MeetupService) RetireSpot(id int) error {
+	spot, err := s.Repo.GetSpot(id)
+	if err == sql.ErrNoRows {
+		return ErrSpotNotFound
+	}
+	if err != nil {
+		return err
+	}
+	spot.Active = false
+	return s.Repo.UpdateSpot(spot)
+}
+
+func (s *MeetupService) actorName(m models.ExchangeMeetup, userID int) string {
+	if userID == m.OwnerID {
+		return partyName(m.OwnerName)
+	}
+	return partyName(m.RequesterName)
+}
+
+// notifyOther notifies the party of a meetup's exchange other than actorID
+func (s *MeetupService) notifyOther(m models.ExchangeMeetup, actorID int, message string) {
+	other := m.OwnerID
+	if actorID == m.OwnerID {
+		other = m.RequesterID
+	}
+	s.NotifService.Notify(s.Notifier, other, actorID, models.NotificationTypeMeetup, message)
+}
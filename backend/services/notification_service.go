@@ -1,6 +1,9 @@
 package services
 
 import (
+	"fmt"
+	"time"
+
 	"ktabnet/models"
 	"ktabnet/repositories"
 )
@@ -36,6 +39,30 @@ func (s *NotificationService) CreateNotification(req models.CreateNotificationRe
 	return s.Repo.CreateNotification(req)
 }
 
+// Notify saves a notification for toID and delivers it in real time through notifier;
+// senderID 0 is the system. A nil service or notifier skips that step.
+func (s *NotificationService) Notify(notifier Notifier, toID, senderID int, notifType, message string) {
+	if s != nil {
+		err := s.CreateNotification(models.CreateNotificationRequest{
+			UserID:   toID,
+			SenderID: senderID,
+			Type:     notifType,
+			Message:  message,
+		})
+		if err != nil {
+			fmt.Println("Error saving "+notifType+" notification:", err)
+		}
+	}
+	if notifier != nil {
+		notifier.SendNotification(models.Notification{
+			SenderID:  senderID,
+			Type:      notifType,
+			Message:   message,
+			CreatedAt: time.Now().Format(time.RFC3339),
+		}, toID)
+	}
+}
+
 // GetUnreadNotificationCount returns the count of unseen notifications
 func (s *NotificationService) GetUnreadNotificationCount(userID int) (int, error) {
 	return s.Repo.GetUnreadNotificationCount(userID)
@@ -5,8 +5,12 @@ import (
 	"ktabnet/repositories"
 )
 
+// receivedRatingsLimit caps the ratings listed on a profile
+const receivedRatingsLimit = 50
+
 type ProfileService struct {
 	ProfileRepo repositories.SqliteProfileRepo
+	Ratings     *repositories.RatingRepository
 }
 
 func NewProfileService(repo repositories.SqliteProfileRepo) *ProfileService {
@@ -32,6 +36,12 @@ func (s *ProfileService) GetUserProfile(requesterID, targetID int) (*models.Prof
 		}
 	}
 
+	if s.Ratings != nil {
+		if user.Reputation, err = s.Ratings.GetReputation(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
 	if user.IsPrivate && !user.IsOwner && !user.IsFollowed {
 		user.FirstName = ""
 		user.LastName = ""
@@ -43,6 +53,14 @@ func (s *ProfileService) GetUserProfile(requesterID, targetID int) (*models.Prof
 	return user, nil
 }
 
+// ReceivedRatings returns the latest exchange ratings a user received
+func (s *ProfileService) ReceivedRatings(userID int) ([]models.ExchangeRating, error) {
+	if _, err := s.ProfileRepo.FindByID(userID); err != nil {
+		return nil, err
+	}
+	return s.Ratings.GetReceived(userID, receivedRatingsLimit)
+}
+
 func (us *ProfileService) SearchUsers(query string) ([]models.SearchResult, error) {
 	if query == "" {
 		return nil, nil
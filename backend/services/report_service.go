@@ -77,7 +77,11 @@ func (s *ReportService) SetUserRole(userID int, role string) error {
 }
 
 func (s *ReportService) DeleteBook(bookID int) error {
-	return s.Repo.DeleteBook(bookID)
+	err := s.Repo.DeleteBook(bookID)
+	if errors.Is(err, repositories.ErrBookStatusConflict) || errors.Is(err, repositories.ErrBookOnLoan) {
+		return ErrBookNotDeletable
+	}
+	return err
 }
 
 func (s *ReportService) GetAllUsers() ([]models.UserAdmin, error) {
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+)
+
+var (
+	ErrSavedSearchNotFound = errors.New("saved search not found")
+	ErrInvalidSavedSearch  = errors.New("invalid saved search")
+)
+
+// savedSearchFilterKeys are the feed parameters a saved search may store
+var savedSearchFilterKeys = map[string]bool{
+	"genre":         true,
+	"condition":     true,
+	"min_condition": true,
+	"city":          true,
+	"author":        true,
+	"listing_type":  true,
+	"near":          true,
+	"radius_km":     true,
+	"min_rating":    true,
+	"language":      true,
+	"format":        true,
+	"publisher":     true,
+	"series":        true,
+	"year_from":     true,
+	"year_to":       true,
+}
+
+// digestLimit caps how many new listings a digest counts
+const digestLimit = 50
+
+const sqlTimeLayout = "2006-01-02 15:04:05"
+
+type SavedSearchService struct {
+	Repo         *repositories.SavedSearchRepository
+	Books        *BookService
+	NotifService *NotificationService
+	Notifier     Notifier
+}
+
+func NewSavedSearchService(repo *repositories.SavedSearchRepository, books *BookService, notifService *NotificationService, notifier Notifier) *SavedSearchService {
+	return &SavedSearchService{Repo: repo, Books: books, NotifService: notifService, Notifier: notifier}
+}
+
+// buildSavedSearch validates a request, including the filters that depend on
+// stored data such as near, and encodes its filters as a feed query string
+func (s *SavedSearchService) buildSavedSearch(userID int, req models.SavedSearchRequest) (models.SavedSearch, error) {
+	search, err := buildSavedSearchQuery(req)
+	if err != nil {
+		return search, err
+	}
+	filter, _ := ParseFeedFilter(mustParseQuery(search.Query))
+	if err := s.Books.ValidateFeedFilter(userID, filter); err != nil {
+		if errors.Is(err, ErrUnknownCity) || errors.Is(err, ErrNoLocation) || errors.Is(err, ErrUnknownTerm) {
+			return search, fmt.Errorf("%w: %v", ErrInvalidSavedSearch, err)
+		}
+		return search, err
+	}
+	return search, nil
+}
+
+// buildSavedSearchQuery validates a request and encodes its filters as a feed query string
+func buildSavedSearchQuery(req models.SavedSearchRequest) (models.SavedSearch, error) {
+	s := models.SavedSearch{
+		Name:      strings.TrimSpace(req.Name),
+		Frequency: req.Frequency,
+	}
+	if s.Name == "" {
+		return s, fmt.Errorf("%w: name is required", ErrInvalidSavedSearch)
+	}
+	switch s.Frequency {
+	case "":
+		s.Frequency = models.SavedSearchDaily
+	case models.SavedSearchHourly, models.SavedSearchDaily:
+	default:
+		return s, fmt.Errorf("%w: frequency must be hourly or daily", ErrInvalidSavedSearch)
+	}
+
+	values := url.Values{}
+	for key, value := range req.Filters {
+		if !savedSearchFilterKeys[key] {
+			return s, fmt.Errorf("%w: unsupported filter %q", ErrInvalidSavedSearch, key)
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			values.Set(key, value)
+		}
+	}
+	if _, err := ParseFeedFilter(values); err != nil {
+		return s, fmt.Errorf("%w: %v", ErrInvalidSavedSearch, err)
+	}
+	s.Query = values.Encode()
+	return s, nil
+}
+
+// withFilters decodes the stored query into the Filters map exposed by the API
+func withFilters(s models.SavedSearch) models.SavedSearch {
+	s.Filters = map[string]string{}
+	values, _ := url.ParseQuery(s.Query)
+	for key := range values {
+		s.Filters[key] = values.Get(key)
+	}
+	return s
+}
+
+func (s *SavedSearchService) List(userID int) ([]models.SavedSearch, error) {
+	searches, err := s.Repo.GetByUser(userID)
+	for i := range searches {
+		searches[i] = withFilters(searches[i])
+	}
+	return searches, err
+}
+
+// Get returns a saved search of userID; other users' searches are reported as not found
+func (s *SavedSearchService) Get(userID, id int) (models.SavedSearch, error) {
+	search, err := s.Repo.GetByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && search.UserID != userID) {
+		return models.SavedSearch{}, ErrSavedSearchNotFound
+	}
+	return withFilters(search), err
+}
+
+// Create saves a search; only listings created afterwards are reported in digests
+func (s *SavedSearchService) Create(userID int, req models.SavedSearchRequest) (models.SavedSearch, error) {
+	search, err := s.buildSavedSearch(userID, req)
+	if err != nil {
+		return search, err
+	}
+	search.UserID = userID
+	if search.LastBookID, err = s.Books.Repo.MaxBookID(); err != nil {
+		return search, err
+	}
+	search.LastRunAt = time.Now().UTC().Format(sqlTimeLayout)
+
+	id, err := s.Repo.Create(search)
+	if err != nil {
+		return search, err
+	}
+	return s.Get(userID, id)
+}
+
+func (s *SavedSearchService) Update(userID, id int, req models.SavedSearchRequest) (models.SavedSearch, error) {
+	if _, err := s.Get(userID, id); err != nil {
+		return models.SavedSearch{}, err
+	}
+	search, err := s.buildSavedSearch(userID, req)
+	if err != nil {
+		return search, err
+	}
+	search.ID = id
+	if err := s.Repo.Update(search); err != nil {
+		return search, err
+	}
+	return s.Get(userID, id)
+}
+
+func (s *SavedSearchService) Delete(userID, id int) error {
+	if _, err := s.Get(userID, id); err != nil {
+		return err
+	}
+	return s.Repo.Delete(id)
+}
+
+// Results runs a saved search against the current feed
+func (s *SavedSearchService) Results(userID, id int, cursor string, limit int) (models.BookFeedPage, error) {
+	search, err := s.Get(userID, id)
+	if err != nil {
+		return models.BookFeedPage{}, err
+	}
+	filter, err := ParseFeedFilter(mustParseQuery(search.Query))
+	if err != nil {
+		return models.BookFeedPage{}, err
+	}
+	filter.Cursor = cursor
+	filter.Limit = limit
+	return s.Books.GetFeedBooksWithOwner(userID, filter)
+}
+
+func mustParseQuery(query string) url.Values {
+	values, _ := url.ParseQuery(query)
+	return values
+}
+
+// RunDigests sends one notification per due saved search that has new matching
+// listings since its last digest, then moves the search's watermark forward
+func (s *SavedSearchService) RunDigests(now time.Time) error {
+	runAt := now.UTC().Format(sqlTimeLayout)
+	due, err := s.Repo.GetDue(runAt)
+	if err != nil {
+		return err
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	maxID, err := s.Books.Repo.MaxBookID()
+	if err != nil {
+		return err
+	}
+
+	for _, search := range due {
+		if search.LastBookID < maxID {
+			if err := s.sendDigest(search, maxID); err != nil {
+				fmt.Printf("Error running saved search %d: %v\n", search.ID, err)
+				continue
+			}
+		}
+		if err := s.Repo.MarkRun(search.ID, maxID, runAt); err != nil {
+			fmt.Printf("Error updating saved search %d: %v\n", search.ID, err)
+		}
+	}
+	return nil
+}
+
+// sendDigest notifies the owner of search about listings with IDs in (LastBookID, upToID]
+func (s *SavedSearchService) sendDigest(search models.SavedSearch, upToID int) error {
+	filter, err := ParseFeedFilter(mustParseQuery(search.Query))
+	if err != nil {
+		return err
+	}
+	filter.AfterID = search.LastBookID
+	filter.UpToID = upToID
+	filter.Limit = digestLimit
+
+	page, err := s.Books.GetFeedBooksWithOwner(search.UserID, filter)
+	if err != nil {
+		return err
+	}
+	if len(page.Books) == 0 {
+		return nil
+	}
+
+	count := fmt.Sprintf("%d new listings match", len(page.Books))
+	if page.NextCursor != "" {
+		count = fmt.Sprintf("%d+ new listings match", digestLimit)
+	} else if len(page.Books) == 1 {
+		count = "1 new listing matches"
+	}
+	s.NotifService.Notify(s.Notifier, search.UserID, 0, models.NotificationTypeSearchDigest,
+		fmt.Sprintf("%s your saved search \"%s\"", count, search.Name))
+	return nil
+}
+
+// Run checks for due digests every interval until the process exits
+func (s *SavedSearchService) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := s.RunDigests(now); err != nil {
+			fmt.Println("Error running saved search digests:", err)
+		}
+	}
+}
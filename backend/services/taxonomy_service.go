@@ -0,0 +1,232 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+)
+
+var (
+	ErrUnknownTaxonomyKind = errors.New("unknown taxonomy kind")
+	ErrTermNotFound        = errors.New("taxonomy term not found")
+	ErrInvalidTerm         = errors.New("invalid taxonomy term")
+	ErrDuplicateTerm       = errors.New("a term with this name already exists")
+	ErrTermInUse           = errors.New("term is still in use")
+	// ErrUnknownTerm is returned when a listing or filter uses a genre, condition,
+	// language or format missing from the taxonomy
+	ErrUnknownTerm = errors.New("unknown value")
+)
+
+type TaxonomyService struct {
+	Repo      *repositories.TaxonomyRepository
+	Locations *LocationService
+}
+
+func NewTaxonomyService(repo *repositories.TaxonomyRepository, locations *LocationService) *TaxonomyService {
+	return &TaxonomyService{Repo: repo, Locations: locations}
+}
+
+func validTaxonomyKind(kind string) bool {
+	switch kind {
+	case models.TaxonomyGenres, models.TaxonomyConditions, models.TaxonomyCities,
+		models.TaxonomyLanguages, models.TaxonomyFormats:
+		return true
+	}
+	return false
+}
+
+// GetTaxonomy returns every genre, condition, city, language and format with their labels
+func (s *TaxonomyService) GetTaxonomy() (models.Taxonomy, error) {
+	var t models.Taxonomy
+	var err error
+	if t.Genres, err = s.Repo.List(models.TaxonomyGenres); err != nil {
+		return t, err
+	}
+	if t.Conditions, err = s.Repo.List(models.TaxonomyConditions); err != nil {
+		return t, err
+	}
+	if t.Cities, err = s.Repo.List(models.TaxonomyCities); err != nil {
+		return t, err
+	}
+	if t.Languages, err = s.Repo.List(models.TaxonomyLanguages); err != nil {
+		return t, err
+	}
+	t.Formats, err = s.Repo.List(models.TaxonomyFormats)
+	return t, err
+}
+
+func (s *TaxonomyService) List(kind string) ([]models.TaxonomyTerm, error) {
+	if !validTaxonomyKind(kind) {
+		return nil, ErrUnknownTaxonomyKind
+	}
+	return s.Repo.List(kind)
+}
+
+func (s *TaxonomyService) Get(kind string, id int) (models.TaxonomyTerm, error) {
+	if !validTaxonomyKind(kind) {
+		return models.TaxonomyTerm{}, ErrUnknownTaxonomyKind
+	}
+	term, err := s.Repo.GetByID(kind, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return term, ErrTermNotFound
+	}
+	return term, err
+}
+
+// buildTerm validates a request; the English label defaults to the name
+func buildTerm(kind string, req models.TaxonomyTermRequest) (models.TaxonomyTerm, error) {
+	term := models.TaxonomyTerm{
+		Name: strings.TrimSpace(req.Name),
+		Labels: models.TaxonomyLabels{
+			Ar: strings.TrimSpace(req.Labels.Ar),
+			Fr: strings.TrimSpace(req.Labels.Fr),
+			En: strings.TrimSpace(req.Labels.En),
+		},
+		SortOrder: req.SortOrder,
+	}
+	if term.Labels.En == "" {
+		term.Labels.En = term.Name
+	}
+
+	if kind == models.TaxonomyCities {
+		if req.Latitude == nil || req.Longitude == nil {
+			return term, fmt.Errorf("%w: a city needs a latitude and a longitude", ErrInvalidTerm)
+		}
+		if *req.Latitude < -90 || *req.Latitude > 90 || *req.Longitude < -180 || *req.Longitude > 180 {
+			return term, fmt.Errorf("%w: %v", ErrInvalidTerm, ErrInvalidLocation)
+		}
+		term.Latitude, term.Longitude = req.Latitude, req.Longitude
+	}
+	return term, nil
+}
+
+// exists reports whether a term with this name is already defined; cities are
+// compared ignoring accents too
+func (s *TaxonomyService) exists(kind, name string) (bool, error) {
+	var err error
+	if kind == models.TaxonomyCities {
+		_, err = s.Locations.FindCity(name)
+		if errors.Is(err, ErrUnknownCity) {
+			return false, nil
+		}
+	} else {
+		_, err = s.Repo.FindByName(kind, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+	}
+	return err == nil, err
+}
+
+func (s *TaxonomyService) Create(kind string, req models.TaxonomyTermRequest) (models.TaxonomyTerm, error) {
+	if !validTaxonomyKind(kind) {
+		return models.TaxonomyTerm{}, ErrUnknownTaxonomyKind
+	}
+	term, err := buildTerm(kind, req)
+	if err != nil {
+		return term, err
+	}
+	if term.Name == "" {
+		return term, fmt.Errorf("%w: name is required", ErrInvalidTerm)
+	}
+	if dup, err := s.exists(kind, term.Name); err != nil || dup {
+		if dup {
+			err = ErrDuplicateTerm
+		}
+		return term, err
+	}
+
+	id, err := s.Repo.Create(kind, term)
+	if err != nil {
+		return term, err
+	}
+	return s.Repo.GetByID(kind, id)
+}
+
+// Update changes the labels, order and (for cities) coordinates of a term; its name is kept
+func (s *TaxonomyService) Update(kind string, id int, req models.TaxonomyTermRequest) (models.TaxonomyTerm, error) {
+	existing, err := s.Get(kind, id)
+	if err != nil {
+		return existing, err
+	}
+	req.Name = existing.Name
+	term, err := buildTerm(kind, req)
+	if err != nil {
+		return term, err
+	}
+	term.ID = id
+	if err := s.Repo.Update(kind, term); err != nil {
+		return term, err
+	}
+	return s.Repo.GetByID(kind, id)
+}
+
+// Delete removes a term that nothing references anymore
+func (s *TaxonomyService) Delete(kind string, id int) error {
+	term, err := s.Get(kind, id)
+	if err != nil {
+		return err
+	}
+	inUse, err := s.Repo.InUse(kind, term.Name)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrTermInUse
+	}
+	return s.Repo.Delete(kind, id)
+}
+
+// CanonicalName returns the stored name of a term, matched ignoring case
+func (s *TaxonomyService) CanonicalName(kind, name string) (string, error) {
+	term, err := s.Repo.FindByName(kind, strings.TrimSpace(name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", s.unknownTermError(kind, name)
+	}
+	return term.Name, err
+}
+
+// unknownTermError names the accepted values, e.g. `unknown value: genre "Poetry" (...)`
+func (s *TaxonomyService) unknownTermError(kind, name string) error {
+	field := map[string]string{
+		models.TaxonomyGenres:     "genre",
+		models.TaxonomyConditions: "condition",
+		models.TaxonomyLanguages:  "language",
+		models.TaxonomyFormats:    "format",
+	}[kind]
+	names := []string{}
+	if terms, err := s.Repo.List(kind); err == nil {
+		for _, t := range terms {
+			names = append(names, t.Name)
+		}
+	}
+	return fmt.Errorf("%w: %s %q must be one of %s", ErrUnknownTerm, field, name, strings.Join(names, ", "))
+}
+
+// NormalizeBook checks the genre, condition, city and, when given, the language and
+// format of a listing against the taxonomy and replaces them with their stored spelling
+func (s *TaxonomyService) NormalizeBook(book *models.Book) error {
+	var err error
+	if book.Genre, err = s.CanonicalName(models.TaxonomyGenres, book.Genre); err != nil {
+		return err
+	}
+	if book.Condition, err = s.CanonicalName(models.TaxonomyConditions, book.Condition); err != nil {
+		return err
+	}
+	if strings.TrimSpace(book.Language) != "" {
+		if book.Language, err = s.CanonicalName(models.TaxonomyLanguages, book.Language); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(book.Format) != "" {
+		if book.Format, err = s.CanonicalName(models.TaxonomyFormats, book.Format); err != nil {
+			return err
+		}
+	}
+	book.City, err = s.Locations.CanonicalCity(book.City)
+	return err
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+)
+
+var (
+	ErrTradeCycleNotFound  = errors.New("trade cycle not found")
+	ErrNotCycleParticipant = errors.New("you are not part of this trade cycle")
+	ErrInvalidCycleChange  = errors.New("the trade cycle status does not allow this")
+	ErrUnknownCycleAction  = errors.New("unknown trade cycle action")
+	ErrCycleUnavailable    = errors.New("a book of the trade cycle is no longer available; the cycle was cancelled")
+)
+
+type TradeCycleService struct {
+	Repo         *repositories.TradeCycleRepository
+	NotifService *NotificationService
+	Notifier     Notifier
+}
+
+func NewTradeCycleService(repo *repositories.TradeCycleRepository, notifService *NotificationService, notifier Notifier) *TradeCycleService {
+	return &TradeCycleService{Repo: repo, NotifService: notifService, Notifier: notifier}
+}
+
+// RunMatching cancels the proposed cycles whose books left the market, then proposes
+// the new cycles found in the wants graph to their participants. It returns the ids of
+// the new cycles.
+func (s *TradeCycleService) RunMatching() ([]int, error) {
+	stale, err := s.Repo.CancelStale()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range stale {
+		s.notifyOthers(id, 0, "A trade cycle was cancelled: one of its books is no longer available")
+	}
+
+	wants, err := s.Repo.GetWants()
+	if err != nil {
+		return nil, err
+	}
+	known, err := s.Repo.GetSignatures()
+	if err != nil {
+		return nil, err
+	}
+
+	var created []int
+	for _, legs := range FindTradeCycles(wants, minTradeCycle, maxTradeCycle, known) {
+		id, err := s.Repo.Create(legs[0].City, TradeCycleSignature(legs), legs)
+		if err != nil {
+			fmt.Println("Error saving trade cycle:", err)
+			continue
+		}
+		created = append(created, id)
+		s.notifyProposed(id)
+	}
+	return created, nil
+}
+
+// Run looks for trade cycles every interval until the process exits
+func (s *TradeCycleService) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := s.RunMatching(); err != nil {
+			fmt.Println("Error matching trade cycles:", err)
+		}
+	}
+}
+
+func (s *TradeCycleService) List(userID int) ([]models.TradeCycle, error) {
+	return s.Repo.GetForUser(userID)
+}
+
+// Get returns a cycle to one of its participants
+func (s *TradeCycleService) Get(userID, cycleID int) (models.TradeCycle, error) {
+	c, err := s.Repo.GetByID(cycleID)
+	if err == sql.ErrNoRows {
+		return c, ErrTradeCycleNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+	for _, leg := range c.Legs {
+		if leg.ReceiverID == userID {
+			return c, nil
+		}
+	}
+	return c, ErrNotCycleParticipant
+}
+
+// Act applies a participant's answer to a cycle and lets the other participants know.
+// Accepting is only possible while the cycle is proposed, confirming a received book
+// only while it is active; a decline ends either.
+func (s *TradeCycleService) Act(userID, cycleID int, action string) (models.TradeCycle, error) {
+	c, err := s.Get(userID, cycleID)
+	if err != nil {
+		return c, err
+	}
+	name := "Someone"
+	for _, leg := range c.Legs {
+		if leg.ReceiverID == userID {
+			name = partyName(leg.ReceiverName)
+		}
+	}
+
+	done := false
+	switch action {
+	case models.TradeCycleActionAccept:
+		done, err = s.Repo.Accept(cycleID, userID)
+	case models.TradeCycleActionDecline:
+		err = s.Repo.Decline(cycleID, userID)
+	case models.TradeCycleActionConfirm:
+		done, err = s.Repo.Confirm(cycleID, userID)
+	default:
+		return c, ErrUnknownCycleAction
+	}
+	if errors.Is(err, repositories.ErrTradeCycleUnavailable) {
+		s.notifyOthers(cycleID, 0, fmt.Sprintf("The %d-way trade cycle was cancelled: one of its books is no longer available", len(c.Legs)))
+		return c, ErrCycleUnavailable
+	}
+	if errors.Is(err, repositories.ErrTradeCycleTransition) || errors.Is(err, repositories.ErrBookStatusConflict) {
+		return c, ErrInvalidCycleChange
+	}
+	if err != nil {
+		return c, err
+	}
+
+	switch action {
+	case models.TradeCycleActionAccept:
+		if done {
+			s.notifyOthers(cycleID, 0, fmt.Sprintf("Everyone accepted the %d-way trade cycle. The books are reserved; arrange the hand-overs", len(c.Legs)))
+		} else {
+			s.notifyOthers(cycleID, userID, fmt.Sprintf("%s accepted the %d-way trade cycle", name, len(c.Legs)))
+		}
+	case models.TradeCycleActionDecline:
+		s.notifyOthers(cycleID, userID, fmt.Sprintf("%s declined the %d-way trade cycle, so it will not go ahead", name, len(c.Legs)))
+	case models.TradeCycleActionConfirm:
+		if done {
+			s.notifyOthers(cycleID, 0, fmt.Sprintf("The %d-way trade cycle is complete", len(c.Legs)))
+		} else {
+			s.notifyOthers(cycleID, userID, fmt.Sprintf("%s received their book in the %d-way trade cycle", name, len(c.Legs)))
+		}
+	}
+
+	return s.Get(userID, cycleID)
+}
+
+// notifyProposed tells every participant of a new cycle what they would give and receive
+func (s *TradeCycleService) notifyProposed(cycleID int) {
+	c, err := s.Repo.GetByID(cycleID)
+	if err != nil {
+		fmt.Println("Error loading trade cycle:", err)
+		return
+	}
+	for _, receive := range c.Legs {
+		for _, give := range c.Legs {
+			if give.GiverID != receive.ReceiverID {
+				continue
+			}
+			s.NotifService.Notify(s.Notifier, receive.ReceiverID, 0, models.NotificationTypeTradeCycle,
+				fmt.Sprintf("A %d-way trade in %s was found: you would receive \"%s\" from %s and give \"%s\" to %s. It goes ahead once everyone accepts",
+					len(c.Legs), c.City, receive.BookTitle, partyName(receive.GiverName), give.BookTitle, partyName(give.ReceiverName)))
+		}
+	}
+}
+
+// notifyOthers notifies the participants of a cycle other than actorID; actorID 0
+// reaches everyone and sends as the system
+func (s *TradeCycleService) notifyOthers(cycleID, actorID int, message string) {
+	c, err := s.Repo.GetByID(cycleID)
+	if err != nil {
+		fmt.Println("Error loading trade cycle:", err)
+		return
+	}
+	for _, leg := range c.Legs {
+		if leg.ReceiverID != actorID {
+			s.NotifService.Notify(s.Notifier, leg.ReceiverID, actorID, models.NotificationTypeTradeCycle, message)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ktabnet/models"
+)
+
+const (
+	minTradeCycle = 3
+	maxTradeCycle = 5
+)
+
+// tradeEdge is the book a wanter would receive from an owner; between two users the
+// lowest book id stands for all their wants
+type tradeEdge struct {
+	to     int
+	bookID int
+}
+
+// FindTradeCycles finds circular trades in the wants graph: cycles of minSize to maxSize
+// users of one city where every user receives a book of the next one. Each cycle is
+// returned as its legs, legs[i] being a want of legs[i].WanterID on a book of
+// legs[i].OwnerID == legs[i+1].WanterID, starting at the lowest user id.
+//
+// Cycles are picked greedily so that no user takes part in two: shorter cycles first,
+// then by city and user ids. Cycles whose signature is in skip are left out. The result
+// depends only on the wants, not on their order.
+func FindTradeCycles(wants []models.TradeWant, minSize, maxSize int, skip map[string]bool) [][]models.TradeWant {
+	byCity := map[string]map[int]map[int]int{} // city -> wanter -> owner -> lowest book id
+	for _, w := range wants {
+		if w.WanterID == w.OwnerID {
+			continue
+		}
+		graph := byCity[w.City]
+		if graph == nil {
+			graph = map[int]map[int]int{}
+			byCity[w.City] = graph
+		}
+		if graph[w.WanterID] == nil {
+			graph[w.WanterID] = map[int]int{}
+		}
+		if book, ok := graph[w.WanterID][w.OwnerID]; !ok || w.BookID < book {
+			graph[w.WanterID][w.OwnerID] = w.BookID
+		}
+	}
+
+	cities := make([]string, 0, len(byCity))
+	for city := range byCity {
+		cities = append(cities, city)
+	}
+	sort.Strings(cities)
+
+	var candidates [][]models.TradeWant
+	for _, city := range cities {
+		candidates = append(candidates, cityCycles(city, byCity[city], minSize, maxSize)...)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		if a[0].City != b[0].City {
+			return a[0].City < b[0].City
+		}
+		for k := range a {
+			if a[k].WanterID != b[k].WanterID {
+				return a[k].WanterID < b[k].WanterID
+			}
+		}
+		return false
+	})
+
+	used := map[int]bool{}
+	var picked [][]models.TradeWant
+	for _, cycle := range candidates {
+		if skip[TradeCycleSignature(cycle)] {
+			continue
+		}
+		free := true
+		for _, leg := range cycle {
+			if used[leg.WanterID] {
+				free = false
+				break
+			}
+		}
+		if !free {
+			continue
+		}
+		for _, leg := range cycle {
+			used[leg.WanterID] = true
+		}
+		picked = append(picked, cycle)
+	}
+	return picked
+}
+
+// cityCycles lists the simple cycles of one city's graph. Every cycle is found once,
+// from its lowest user id, by only walking through higher ids.
+func cityCycles(city string, graph map[int]map[int]int, minSize, maxSize int) [][]models.TradeWant {
+	adjacency := map[int][]tradeEdge{}
+	var users []int
+	for wanter, owners := range graph {
+		users = append(users, wanter)
+		for owner, book := range owners {
+			adjacency[wanter] = append(adjacency[wanter], tradeEdge{to: owner, bookID: book})
+		}
+		sort.Slice(adjacency[wanter], func(i, j int) bool { return adjacency[wanter][i].to < adjacency[wanter][j].to })
+	}
+	sort.Ints(users)
+
+	var cycles [][]models.TradeWant
+	for _, start := range users {
+		var path []models.TradeWant
+		onPath := map[int]bool{start: true}
+		var walk func(user int)
+		walk = func(user int) {
+			for _, edge := range adjacency[user] {
+				leg := models.TradeWant{WanterID: user, BookID: edge.bookID, OwnerID: edge.to, City: city}
+				if edge.to == start {
+					if len(path)+1 >= minSize {
+						cycle := append(append([]models.TradeWant{}, path...), leg)
+						cycles = append(cycles, cycle)
+					}
+					continue
+				}
+				if edge.to < start || onPath[edge.to] || len(path)+1 >= maxSize {
+					continue
+				}
+				onPath[edge.to] = true
+				path = append(path, leg)
+				walk(edge.to)
+				path = path[:len(path)-1]
+				onPath[edge.to] = false
+			}
+		}
+		walk(start)
+	}
+	return cycles
+}
+
+// TradeCycleSignature identifies a cycle by its receivers and books, e.g. "2:14,5:9,7:3"
+func TradeCycleSignature(legs []models.TradeWant) string {
+	parts := make([]string, len(legs))
+	for i, leg := range legs {
+		parts[i] = fmt.Sprintf("%d:%d", leg.WanterID, leg.BookID)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"ktabnet/models"
+)
+
+// tw is a want of wanter on book, owned by owner in city
+func tw(city string, wanter, owner, book int) models.TradeWant {
+	return models.TradeWant{WanterID: wanter, OwnerID: owner, BookID: book, City: city}
+}
+
+// ring makes users[i] want a book of users[i+1], the last one wanting from the first;
+// user u's book is u*10+1
+func ring(city string, users ...int) []models.TradeWant {
+	wants := make([]models.TradeWant, len(users))
+	for i, u := range users {
+		owner := users[(i+1)%len(users)]
+		wants[i] = tw(city, u, owner, owner*10+1)
+	}
+	return wants
+}
+
+func joinWants(groups ...[]models.TradeWant) []models.TradeWant {
+	var wants []models.TradeWant
+	for _, g := range groups {
+		wants = append(wants, g...)
+	}
+	return wants
+}
+
+func cycleSignatures(cycles [][]models.TradeWant) []string {
+	signatures := []string{}
+	for _, c := range cycles {
+		signatures = append(signatures, TradeCycleSignature(c))
+	}
+	return signatures
+}
+
+func TestFindTradeCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		wants []models.TradeWant
+		skip  map[string]bool
+		want  []string
+	}{
+		{
+			name:  "two-way swap is left to exchange requests",
+			wants: ring("Rabat", 1, 2),
+			want:  []string{},
+		},
+		{
+			name:  "three-way cycle",
+			wants: ring("Rabat", 1, 2, 3),
+			want:  []string{"1:21,2:31,3:11"},
+		},
+		{
+			name:  "starts at the lowest user id",
+			wants: ring("Rabat", 7, 3, 5),
+			want:  []string{"3:51,5:71,7:31"},
+		},
+		{
+			name:  "five-way cycle",
+			wants: ring("Rabat", 1, 2, 3, 4, 5),
+			want:  []string{"1:21,2:31,3:41,4:51,5:11"},
+		},
+		{
+			name:  "six-way cycle is too long",
+			wants: ring("Rabat", 1, 2, 3, 4, 5, 6),
+			want:  []string{},
+		},
+		{
+			name:  "lowest book id stands for a pair of users",
+			wants: append(ring("Rabat", 1, 2, 3), tw("Rabat", 1, 2, 29), tw("Rabat", 1, 2, 20)),
+			want:  []string{"1:20,2:31,3:11"},
+		},
+		{
+			name:  "own books are ignored",
+			wants: append(ring("Rabat", 1, 2, 3), tw("Rabat", 1, 1, 11)),
+			want:  []string{"1:21,2:31,3:11"},
+		},
+		{
+			name: "cities never mix",
+			wants: []models.TradeWant{
+				tw("Rabat", 1, 2, 21), tw("Rabat", 2, 3, 31), tw("Casablanca", 3, 1, 11),
+			},
+			want: []string{},
+		},
+		{
+			name:  "one cycle per city, by city name",
+			wants: joinWants(ring("Rabat", 1, 2, 3), ring("Casablanca", 4, 5, 6)),
+			want:  []string{"4:51,5:61,6:41", "1:21,2:31,3:11"},
+		},
+		{
+			name: "shorter cycle wins a shared user",
+			wants: joinWants(ring("Rabat", 1, 4, 5, 6), []models.TradeWant{
+				tw("Rabat", 1, 2, 21), tw("Rabat", 2, 3, 31), tw("Rabat", 3, 1, 12),
+			}),
+			want: []string{"1:21,2:31,3:12"},
+		},
+		{
+			name:  "same size: lower user ids win a shared user",
+			wants: joinWants(ring("Rabat", 3, 5, 6), ring("Rabat", 2, 3, 4)),
+			want:  []string{"2:31,3:41,4:21"},
+		},
+		{
+			name:  "disjoint cycles are all picked",
+			wants: joinWants(ring("Rabat", 4, 5, 6, 7), ring("Rabat", 1, 2, 3)),
+			want:  []string{"1:21,2:31,3:11", "4:51,5:61,6:71,7:41"},
+		},
+		{
+			name:  "skipped cycle is left out",
+			wants: ring("Rabat", 1, 2, 3),
+			skip:  map[string]bool{"1:21,2:31,3:11": true},
+			want:  []string{},
+		},
+		{
+			name: "skipped cycle frees its users for the next one",
+			wants: joinWants(ring("Rabat", 1, 4, 5, 6), []models.TradeWant{
+				tw("Rabat", 1, 2, 21), tw("Rabat", 2, 3, 31), tw("Rabat", 3, 1, 12),
+			}),
+			skip: map[string]bool{"1:21,2:31,3:12": true},
+			want: []string{"1:41,4:51,5:61,6:11"},
+		},
+		{
+			name:  "unrelated signature skips nothing",
+			wants: ring("Rabat", 1, 2, 3),
+			skip:  map[string]bool{"1:21,2:31,3:12": true},
+			want:  []string{"1:21,2:31,3:11"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cycleSignatures(FindTradeCycles(tt.wants, minTradeCycle, maxTradeCycle, tt.skip))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			// The result must not depend on the order of the wants
+			for seed := int64(1); seed <= 5; seed++ {
+				shuffled := append([]models.TradeWant{}, tt.wants...)
+				rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+					shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+				})
+				got := cycleSignatures(FindTradeCycles(shuffled, minTradeCycle, maxTradeCycle, tt.skip))
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("shuffled with seed %d: got %v, want %v", seed, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCityCycles(t *testing.T) {
+	// complete makes every user of n want a book of every other one
+	complete := func(n int) map[int]map[int]int {
+		graph := map[int]map[int]int{}
+		for u := 1; u <= n; u++ {
+			graph[u] = map[int]int{}
+			for v := 1; v <= n; v++ {
+				if u != v {
+					graph[u][v] = v*10 + 1
+				}
+			}
+		}
+		return graph
+	}
+
+	tests := []struct {
+		name             string
+		n                int
+		minSize, maxSize int
+		want             map[int]int // cycle size -> count
+	}{
+		// A complete graph of n users has n!/(n-k)!/k directed cycles of size k
+		{name: "three users", n: 3, minSize: 3, maxSize: 5, want: map[int]int{3: 2}},
+		{name: "four users", n: 4, minSize: 3, maxSize: 5, want: map[int]int{3: 8, 4: 6}},
+		{name: "four users with swaps", n: 4, minSize: 2, maxSize: 5, want: map[int]int{2: 6, 3: 8, 4: 6}},
+		{name: "six users up to five", n: 6, minSize: 3, maxSize: 5, want: map[int]int{3: 40, 4: 90, 5: 144}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cycles := cityCycles("Rabat", complete(tt.n), tt.minSize, tt.maxSize)
+			got := map[int]int{}
+			seen := map[string]bool{}
+			for _, c := range cycles {
+				got[len(c)]++
+				signature := TradeCycleSignature(c)
+				if seen[signature] {
+					t.Fatalf("cycle %s found twice", signature)
+				}
+				seen[signature] = true
+				for i, leg := range c {
+					if leg.WanterID < c[0].WanterID {
+						t.Fatalf("cycle %s does not start at its lowest user", signature)
+					}
+					if next := c[(i+1)%len(c)]; leg.OwnerID != next.WanterID || leg.City != "Rabat" {
+						t.Fatalf("cycle %s is broken at leg %d", signature, i)
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("cycles by size = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
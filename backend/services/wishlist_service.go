@@ -0,0 +1,133 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ktabnet/models"
+	"ktabnet/repositories"
+	"ktabnet/utils"
+)
+
+var (
+	ErrWishlistNotFound = errors.New("wishlist entry not found")
+	ErrInvalidWish      = errors.New("a wish needs an ISBN or both a title and an author")
+)
+
+// Notifier delivers notifications in real time; *hub.Hub implements it
+type Notifier interface {
+	SendNotification(notification models.Notification, toID int)
+}
+
+type WishlistService struct {
+	Repo         *repositories.WishlistRepository
+	NotifService *NotificationService
+	Notifier     Notifier
+}
+
+func NewWishlistService(repo *repositories.WishlistRepository, notifService *NotificationService, notifier Notifier) *WishlistService {
+	return &WishlistService{Repo: repo, NotifService: notifService, Notifier: notifier}
+}
+
+// buildWish validates a request and turns it into a wish with a canonical ISBN-13
+// and trimmed, de-duplicated cities
+func buildWish(req models.WishlistRequest) (models.Wishlist, error) {
+	w := models.Wishlist{
+		Title:  strings.TrimSpace(req.Title),
+		Author: strings.TrimSpace(req.Author),
+		Cities: []string{},
+	}
+	if isbn := strings.TrimSpace(req.ISBN); isbn != "" {
+		canonical, err := utils.NormalizeISBN(isbn)
+		if err != nil {
+			return w, err
+		}
+		w.ISBN = canonical
+	}
+	if w.ISBN == "" && (w.Title == "" || w.Author == "") {
+		return w, ErrInvalidWish
+	}
+
+	seen := map[string]bool{}
+	for _, city := range req.Cities {
+		city = strings.TrimSpace(city)
+		if city == "" || seen[strings.ToLower(city)] {
+			continue
+		}
+		seen[strings.ToLower(city)] = true
+		w.Cities = append(w.Cities, city)
+	}
+	return w, nil
+}
+
+func (s *WishlistService) List(userID int) ([]models.Wishlist, error) {
+	return s.Repo.GetByUser(userID)
+}
+
+// Get returns a wish of userID; other users' wishes are reported as not found
+func (s *WishlistService) Get(userID, id int) (models.Wishlist, error) {
+	w, err := s.Repo.GetByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && w.UserID != userID) {
+		return models.Wishlist{}, ErrWishlistNotFound
+	}
+	return w, err
+}
+
+func (s *WishlistService) Create(userID int, req models.WishlistRequest) (models.Wishlist, error) {
+	w, err := buildWish(req)
+	if err != nil {
+		return w, err
+	}
+	w.UserID = userID
+	id, err := s.Repo.Create(w)
+	if err != nil {
+		return w, err
+	}
+	return s.Repo.GetByID(id)
+}
+
+func (s *WishlistService) Update(userID, id int, req models.WishlistRequest) (models.Wishlist, error) {
+	if _, err := s.Get(userID, id); err != nil {
+		return models.Wishlist{}, err
+	}
+	w, err := buildWish(req)
+	if err != nil {
+		return w, err
+	}
+	w.ID = id
+	w.UserID = userID
+	if err := s.Repo.Update(w); err != nil {
+		return w, err
+	}
+	return s.Repo.GetByID(id)
+}
+
+func (s *WishlistService) Delete(userID, id int) error {
+	if _, err := s.Get(userID, id); err != nil {
+		return err
+	}
+	return s.Repo.Delete(id)
+}
+
+// NotifyMatches tells every user whose wishlist a new listing satisfies, once per
+// user, with a stored notification and a real-time one
+func (s *WishlistService) NotifyMatches(book models.Book) {
+	wishes, err := s.Repo.FindMatches(book)
+	if err != nil {
+		fmt.Println("Error matching wishlists:", err)
+		return
+	}
+
+	notified := map[int]bool{}
+	for _, w := range wishes {
+		if notified[w.UserID] {
+			continue
+		}
+		notified[w.UserID] = true
+
+		s.NotifService.Notify(s.Notifier, w.UserID, book.OwnerID, models.NotificationTypeWishlistMatch,
+			fmt.Sprintf("\"%s\" by %s from your wishlist is now available in %s", book.Title, book.Author, book.City))
+	}
+}
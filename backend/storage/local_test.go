@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoreRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "uploads")
+	store := NewLocalStore(dir, "/uploads/")
+
+	for _, key := range []string{
+		"",
+		"/",
+		"..",
+		"../secret.txt",
+		"books/../../secret.txt",
+		"books/..",
+		"..\\secret.txt",
+		"books/%2e%2e/../secret.txt",
+	} {
+		if err := store.Put(key, []byte("x"), "text/plain"); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if _, err := store.Get(key); err == nil {
+			t.Errorf("Get(%q) succeeded", key)
+		}
+		if _, err := store.Exists(key); err == nil {
+			t.Errorf("Exists(%q) succeeded", key)
+		}
+		if err := store.Delete(key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "uploads" {
+			t.Errorf("%s was written outside the store", e.Name())
+		}
+	}
+}
+
+func TestLocalStoreAbsoluteKeysStayInside(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir, "/uploads/")
+	if err := store.Put("/books/a.jpg", []byte("x"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "books", "a.jpg")); err != nil {
+		t.Fatalf("blob not stored under the store directory: %v", err)
+	}
+}
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "/uploads/")
+	if err := store.Put("books/a.jpg", []byte("photo"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("avatars/b.png", []byte("face"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Get("books/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "photo" {
+		t.Fatalf("Get = %q", data)
+	}
+
+	keys, err := store.List("books/")
+	if err != nil || strings.Join(keys, ",") != "books/a.jpg" {
+		t.Fatalf("List(books/) = %v, %v", keys, err)
+	}
+
+	if err := store.Delete("books/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("books/a.jpg"); err != ErrNotFound {
+		t.Fatalf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete("books/a.jpg"); err != nil {
+		t.Fatalf("deleting a missing key: %v", err)
+	}
+}
+
+func TestLocalStoreKeyFromURL(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "/uploads/")
+	tests := []struct {
+		url  string
+		key  string
+		want bool
+	}{
+		{"/uploads/books/a.jpg", "books/a.jpg", true},
+		{"https://ktabnet.example/uploads/books/a.jpg", "books/a.jpg", true},
+		{"/uploads/../ktabnet.db", "", false},
+		{"/uploads/", "", false},
+		{"/static/a.jpg", "", false},
+	}
+	for _, tt := range tests {
+		key, ok := store.KeyFromURL(tt.url)
+		if ok != tt.want || key != tt.key {
+			t.Errorf("KeyFromURL(%q) = %q, %v; want %q, %v", tt.url, key, ok, tt.key, tt.want)
+		}
+	}
+}
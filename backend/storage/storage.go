@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"ktabnet/utils"
+)
+
+// ErrNotFound is returned when a key does not exist in a store
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps uploaded media. Keys are slash-separated paths such as
+// "books/ab12.jpg"; URL gives the public address clients load them from.
+type BlobStore interface {
+	Put(key string, data []byte, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Exists(key string) (bool, error)
+	Delete(key string) error
+	// List returns every key starting with prefix
+	List(prefix string) ([]string, error)
+	URL(key string) string
+	// KeyFromURL maps a public URL produced by this store back to its key
+	KeyFromURL(url string) (string, bool)
+}
+
+// NewFromConfig builds the store selected by STORAGE_BACKEND ("local" or "s3")
+func NewFromConfig() (BlobStore, error) {
+	cfg := utils.GetStorageConfig()
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocalStore(utils.GetUploadPath(""), "/uploads/"), nil
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, errors.New("S3 storage needs S3_ENDPOINT and S3_BUCKET")
+		}
+		return NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PublicURL), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// Copy copies every blob under prefix from src to dst, skipping keys dst already has.
+// It returns the number of blobs copied.
+func Copy(src, dst BlobStore, prefix string) (int, error) {
+	keys, err := src.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for _, key := range keys {
+		if ok, err := dst.Exists(key); err != nil {
+			return copied, err
+		} else if ok {
+			continue
+		}
+
+		r, err := src.Get(key)
+		if err != nil {
+			return copied, err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return copied, err
+		}
+
+		if err := dst.Put(key, data, ContentType(key)); err != nil {
+			return copied, fmt.Errorf("copying %s: %w", key, err)
+		}
+		copied++
+	}
+	return copied, nil
+}
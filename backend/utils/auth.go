@@ -1,8 +1,14 @@
 package utils
 
+import "strings"
+
 func PrepareAvatarURL(path string) string {
 	if path == "" {
 		return ""
 	}
+	// Avatars kept in an external blob store already have an absolute URL
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
 	return "http://localhost:8080/" + path
-}
\ No newline at end of file
+}
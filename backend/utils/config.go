@@ -3,6 +3,8 @@ package utils
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
 var DataDir string
@@ -30,3 +32,107 @@ func GetUploadPath(subpath string) string {
 func GetUploadURL(filename string) string {
 	return "/uploads/" + filename
 }
+
+// GetCatalogFile returns the JSON file imported into the local book catalog at startup, if any
+func GetCatalogFile() string {
+	return os.Getenv("BOOK_CATALOG_FILE")
+}
+
+// GetMetadataProviderURL returns the base URL of the remote ISBN metadata API
+// (Google Books compatible), e.g. BOOK_METADATA_URL=https://www.googleapis.com/books/v1.
+// Remote lookups are opt-in: when it is unset, empty or "off", ISBNs are only looked
+// up in the local catalog and no request leaves the server.
+func GetMetadataProviderURL() string {
+	if v := os.Getenv("BOOK_METADATA_URL"); v != "off" {
+		return v
+	}
+	return ""
+}
+
+// GetMaxUploadBytes returns the size limit of a single uploaded image
+// (UPLOAD_MAX_BYTES, 10 MB by default)
+func GetMaxUploadBytes() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 10 << 20
+}
+
+// StorageConfig selects where uploads are kept
+type StorageConfig struct {
+	Backend     string // "local" (default) or "s3"
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PublicURL string
+}
+
+// GetStorageConfig reads the upload storage settings from the environment
+func GetStorageConfig() StorageConfig {
+	return StorageConfig{
+		Backend:     os.Getenv("STORAGE_BACKEND"),
+		S3Endpoint:  os.Getenv("S3_ENDPOINT"),
+		S3Region:    os.Getenv("S3_REGION"),
+		S3Bucket:    os.Getenv("S3_BUCKET"),
+		S3AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		S3SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		S3PublicURL: os.Getenv("S3_PUBLIC_URL"),
+	}
+}
+
+// GetSavedSearchInterval returns how often due saved search digests are checked
+// (SAVED_SEARCH_CHECK_INTERVAL as a Go duration, 5 minutes by default)
+func GetSavedSearchInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("SAVED_SEARCH_CHECK_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// GetLoanCheckInterval returns how often loan due dates are checked for reminders and
+// overdue notices (LOAN_CHECK_INTERVAL as a Go duration, 1 hour by default)
+func GetLoanCheckInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("LOAN_CHECK_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
+
+// GetGiveawayMonthlyClaims returns how many giveaway listings one user may be chosen for
+// per calendar month (GIVEAWAY_MONTHLY_CLAIMS, 3 by default)
+func GetGiveawayMonthlyClaims() int {
+	if n, err := strconv.Atoi(os.Getenv("GIVEAWAY_MONTHLY_CLAIMS")); err == nil && n > 0 {
+		return n
+	}
+	return 3
+}
+
+// GetTradeCycleInterval returns how often the matching engine looks for circular trades
+// (TRADE_CYCLE_INTERVAL as a Go duration, 1 hour by default)
+func GetTradeCycleInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("TRADE_CYCLE_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
+
+// GetExchangeRequestTTL returns how long a pending exchange request lives without
+// activity (EXCHANGE_REQUEST_TTL as a Go duration, 14 days by default; 0 disables expiry)
+func GetExchangeRequestTTL() time.Duration {
+	v := os.Getenv("EXCHANGE_REQUEST_TTL")
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return d
+	}
+	return 14 * 24 * time.Hour
+}
+
+// GetExchangeCleanupInterval returns how often pending exchange requests are checked for
+// expiry and unavailable books (EXCHANGE_CLEANUP_INTERVAL as a Go duration, 1 hour by default)
+func GetExchangeCleanupInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("EXCHANGE_CLEANUP_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return time.Hour
+}
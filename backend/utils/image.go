@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strings"
+)
+
+var (
+	ErrUnsupportedImage = errors.New("unsupported image format: expected JPEG, PNG or GIF")
+	ErrImageTooLarge    = errors.New("image is too large")
+)
+
+// maxImagePixels caps the decoded size so a small file cannot expand into a huge bitmap
+const maxImagePixels = 40_000_000
+
+// ImageVariant is one stored size of an uploaded image. The full-size variant has
+// an empty Suffix so its URL is the one saved in the database.
+type ImageVariant struct {
+	Suffix  string
+	MaxSide int
+}
+
+// ImageVariants lists the sizes generated for every upload, largest first
+var ImageVariants = []ImageVariant{
+	{Suffix: "", MaxSide: 1600},
+	{Suffix: "medium", MaxSide: 800},
+	{Suffix: "thumb", MaxSide: 200},
+}
+
+// ProcessedImage holds the re-encoded variants of an upload, keyed by variant suffix
+type ProcessedImage struct {
+	Hash     string
+	Ext      string
+	Variants map[string][]byte
+}
+
+// Filename returns the content-hashed file name of a variant
+func (p ProcessedImage) Filename(suffix string) string {
+	if suffix == "" {
+		return p.Hash + p.Ext
+	}
+	return p.Hash + "_" + suffix + p.Ext
+}
+
+// SniffImageType detects the image format from its magic bytes. It returns
+// "jpeg", "png", "gif" or "" when the data is not an image ProcessImage can decode.
+func SniffImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	}
+	return ""
+}
+
+// ProcessImage validates an uploaded image and re-encodes it into every variant.
+// Decoding and re-encoding drops all metadata (EXIF, GPS, comments); the EXIF
+// orientation of JPEGs is applied to the pixels first so photos stay upright.
+// JPEGs are stored as JPEG, PNGs and GIFs (first frame) as PNG.
+func ProcessImage(data []byte) (ProcessedImage, error) {
+	format := SniffImageType(data)
+	var decode func([]byte) (image.Image, error)
+	ext := ".png"
+	switch format {
+	case "jpeg":
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+		ext = ".jpg"
+	case "png":
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+	case "gif":
+		decode = func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }
+	default:
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return ProcessedImage{}, ErrImageTooLarge
+	}
+
+	img, err := decode(data)
+	if err != nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	sum := sha256.Sum256(data)
+	out := ProcessedImage{
+		Hash:     hex.EncodeToString(sum[:16]),
+		Ext:      ext,
+		Variants: make(map[string][]byte, len(ImageVariants)),
+	}
+	for _, v := range ImageVariants {
+		var buf bytes.Buffer
+		resized := resizeToFit(img, v.MaxSide)
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+		out.Variants[v.Suffix] = buf.Bytes()
+	}
+	return out, nil
+}
+
+// ImageVariantURL returns the URL of another variant of a stored image,
+// e.g. ("/uploads/books/ab12.jpg", "thumb") -> "/uploads/books/ab12_thumb.jpg"
+func ImageVariantURL(url, suffix string) string {
+	if suffix == "" {
+		return url
+	}
+	ext := path.Ext(url)
+	return strings.TrimSuffix(url, ext) + "_" + suffix + ext
+}
+
+// resizeToFit scales img down so its longest side is at most maxSide, averaging
+// the source pixels covered by each destination pixel. Smaller images are copied as is.
+func resizeToFit(img image.Image, maxSide int) image.Image {
+	src := toNRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= maxSide && h <= maxSide {
+		return src
+	}
+
+	dw, dh := maxSide, h*maxSide/w
+	if h > w {
+		dw, dh = w*maxSide/h, maxSide
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, (y+1)*h/dh
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, (x+1)*w/dw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				off := sy*src.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					pa := uint64(src.Pix[off+3])
+					r += uint64(src.Pix[off]) * pa
+					g += uint64(src.Pix[off+1]) * pa
+					b += uint64(src.Pix[off+2]) * pa
+					a += pa
+					n++
+					off += 4
+				}
+			}
+			px := color.NRGBA{}
+			if a > 0 {
+				px = color.NRGBA{R: uint8(r / a), G: uint8(g / a), B: uint8(b / a), A: uint8(a / n)}
+			}
+			dst.SetNRGBA(x, y, px)
+		}
+	}
+	return dst
+}
+
+// toNRGBA copies img into a zero-origin NRGBA bitmap
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	if n, ok := img.(*image.NRGBA); ok && b.Min == (image.Point{}) {
+		return n
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag (1-8) of a JPEG, or 1 when absent
+func jpegOrientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan / end of image
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag (0x0112) in the first IFD of a TIFF block
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < count; e++ {
+		off := ifd + 2 + e*12
+		if off+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[off:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[off+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates/flips img so that EXIF orientation o becomes 1
+func applyOrientation(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	src := toNRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetNRGBA(dx, dy, src.NRGBAAt(x, y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestSniffImageType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00}, "jpeg"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "png"},
+		{"gif87a", []byte("GIF87a..."), "gif"},
+		{"gif89a", []byte("GIF89a..."), "gif"},
+		{"empty", nil, ""},
+		{"truncated jpeg", []byte{0xFF, 0xD8}, ""},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), ""},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), ""},
+		{"text", []byte("hello"), ""},
+	}
+	for _, tt := range tests {
+		if got := SniffImageType(tt.data); got != tt.want {
+			t.Errorf("%s: SniffImageType = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// halves returns a w x h image whose left half is red and right half blue
+func halves(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{B: 255, A: 255}
+			if x < w/2 {
+				c = color.NRGBA{R: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// withExif inserts an APP1 EXIF segment carrying orientation o, followed by a
+// comment segment, right after the SOI marker of a JPEG
+func withExif(t *testing.T, jpg []byte, o uint16) []byte {
+	t.Helper()
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)      // one IFD entry
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112) // orientation
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)      // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, o)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0) // padding and next IFD offset
+	tiff = append(tiff, "GPS 33.97N 6.85W"...)
+
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(seg)+2))
+	app1 = append(app1, seg...)
+
+	comment := []byte("taken at home")
+	com := []byte{0xFF, 0xFE}
+	com = binary.BigEndian.AppendUint16(com, uint16(len(comment)+2))
+	com = append(com, comment...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app1...)
+	out = append(out, com...)
+	return append(out, jpg[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func TestProcessImageOrientation(t *testing.T) {
+	const w, h = 64, 32
+	tests := []struct {
+		orientation  uint16
+		wantW, wantH int
+		redAt        image.Point // a pixel that must end up red
+		blueAt       image.Point // a pixel that must end up blue
+	}{
+		{1, w, h, image.Pt(8, 16), image.Pt(56, 16)},
+		{3, w, h, image.Pt(56, 16), image.Pt(8, 16)},
+		{6, h, w, image.Pt(16, 8), image.Pt(16, 56)},
+		{8, h, w, image.Pt(16, 56), image.Pt(16, 8)},
+	}
+	src := encodeJPEG(t, halves(w, h))
+	for _, tt := range tests {
+		data := withExif(t, src, tt.orientation)
+		if got := jpegOrientation(data); got != int(tt.orientation) {
+			t.Fatalf("jpegOrientation = %d, want %d", got, tt.orientation)
+		}
+
+		out, err := ProcessImage(data)
+		if err != nil {
+			t.Fatalf("orientation %d: %v", tt.orientation, err)
+		}
+		if out.Ext != ".jpg" {
+			t.Errorf("orientation %d: Ext = %q, want .jpg", tt.orientation, out.Ext)
+		}
+		full := out.Variants[""]
+		img, err := jpeg.Decode(bytes.NewReader(full))
+		if err != nil {
+			t.Fatalf("orientation %d: decode result: %v", tt.orientation, err)
+		}
+		if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+		if !isRed(img.At(tt.redAt.X, tt.redAt.Y)) {
+			t.Errorf("orientation %d: pixel %v is not red", tt.orientation, tt.redAt)
+		}
+		if isRed(img.At(tt.blueAt.X, tt.blueAt.Y)) {
+			t.Errorf("orientation %d: pixel %v is red", tt.orientation, tt.blueAt)
+		}
+		if got := jpegOrientation(full); got != 1 {
+			t.Errorf("orientation %d: result still carries orientation %d", tt.orientation, got)
+		}
+	}
+}
+
+func TestProcessImageStripsMetadata(t *testing.T) {
+	data := withExif(t, encodeJPEG(t, halves(64, 32)), 1)
+	out, err := ProcessImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Variants) != len(ImageVariants) {
+		t.Fatalf("got %d variants, want %d", len(out.Variants), len(ImageVariants))
+	}
+	for suffix, b := range out.Variants {
+		for _, secret := range []string{"Exif", "GPS 33.97N", "taken at home"} {
+			if bytes.Contains(b, []byte(secret)) {
+				t.Errorf("variant %q still contains %q", suffix, secret)
+			}
+		}
+	}
+}
+
+func TestProcessImageFormats(t *testing.T) {
+	var pngBuf, gifBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, halves(8, 8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifBuf, halves(8, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"png": pngBuf.Bytes(), "gif": gifBuf.Bytes()} {
+		out, err := ProcessImage(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if out.Ext != ".png" || SniffImageType(out.Variants[""]) != "png" {
+			t.Errorf("%s: stored as %q, want PNG", name, out.Ext)
+		}
+	}
+
+	if _, err := ProcessImage([]byte("not an image")); err != ErrUnsupportedImage {
+		t.Errorf("text: err = %v, want ErrUnsupportedImage", err)
+	}
+	if _, err := ProcessImage([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10}); err != ErrUnsupportedImage {
+		t.Errorf("truncated jpeg: err = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestProcessImageResizesVariants(t *testing.T) {
+	out, err := ProcessImage(encodeJPEG(t, halves(2000, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range ImageVariants {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(out.Variants[v.Suffix]))
+		if err != nil {
+			t.Fatalf("variant %q: %v", v.Suffix, err)
+		}
+		if cfg.Width != v.MaxSide || cfg.Height != v.MaxSide/2 {
+			t.Errorf("variant %q: size = %dx%d, want %dx%d", v.Suffix, cfg.Width, cfg.Height, v.MaxSide, v.MaxSide/2)
+		}
+	}
+}
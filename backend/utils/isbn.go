@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidISBN is returned for values that are not a well-formed ISBN
+var ErrInvalidISBN = errors.New("invalid ISBN")
+
+// CleanISBN strips hyphens and spaces from an ISBN and uppercases the X check digit
+func CleanISBN(isbn string) string {
+	isbn = strings.ToUpper(strings.TrimSpace(isbn))
+	return strings.NewReplacer("-", "", " ", "").Replace(isbn)
+}
+
+// NormalizeISBN checks the checksum of an ISBN-10 or ISBN-13 (hyphens and spaces
+// allowed) and returns it in canonical ISBN-13 form, e.g. "2-07-054127-4" becomes
+// "9782070541270".
+func NormalizeISBN(isbn string) (string, error) {
+	isbn = CleanISBN(isbn)
+	switch {
+	case len(isbn) == 10 && validISBN10(isbn):
+		return isbn10To13(isbn), nil
+	case len(isbn) == 13 && validISBN13(isbn):
+		return isbn, nil
+	}
+	return "", ErrInvalidISBN
+}
+
+// validISBN10 checks a cleaned ISBN-10: sum of digit*(10-position) must be divisible by 11
+func validISBN10(isbn string) bool {
+	sum := 0
+	for i, c := range isbn {
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c == 'X' && i == 9:
+			d = 10
+		default:
+			return false
+		}
+		sum += d * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// validISBN13 checks a cleaned ISBN-13: digits weighted 1,3,1,3... must sum to a multiple of 10
+func validISBN13(isbn string) bool {
+	if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+		return false
+	}
+	sum := 0
+	for i, c := range isbn {
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// isbn10To13 prefixes a valid ISBN-10 with 978 and recomputes the check digit
+func isbn10To13(isbn string) string {
+	body := "978" + isbn[:9]
+	sum := 0
+	for i, c := range body {
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return body + string(rune('0'+(10-sum%10)%10))
+}
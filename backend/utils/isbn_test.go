@@ -0,0 +1,53 @@
+package utils
+
+import "testing"
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // empty when the ISBN is invalid
+	}{
+		// ISBN-10 becomes ISBN-13 with a recomputed check digit
+		{"2070541274", "9782070541270"},
+		{"2-07-054127-4", "9782070541270"},
+		{" 2 07 054127 4 ", "9782070541270"},
+		{"0-306-40615-2", "9780306406157"},
+
+		// X check digit, in either case
+		{"080442957X", "9780804429573"},
+		{"0-8044-2957-x", "9780804429573"},
+
+		// ISBN-13, with the 978 and 979 prefixes
+		{"9782070612758", "9782070612758"},
+		{"978-2-07-061275-8", "9782070612758"},
+		{"979-10-323-0569-0", "9791032305690"},
+		{"979 10 90636 07 1", "9791090636071"},
+
+		// Bad checksums
+		{"2070541275", ""},
+		{"0804429570", ""},
+		{"9782070612757", ""},
+		{"979-10-323-0569-1", ""},
+
+		// Malformed
+		{"", ""},
+		{"123", ""},
+		{"X804429570", ""},    // X is only allowed as the last ISBN-10 digit
+		{"978207061275X", ""}, // ISBN-13 has no X check digit
+		{"1234567890128", ""}, // valid EAN-13 but not a book prefix
+		{"978-2-07-06127-58-0", ""},
+		{"2O70541274", ""}, // letter O, not zero
+	}
+	for _, tt := range tests {
+		got, err := NormalizeISBN(tt.in)
+		if tt.want == "" {
+			if err != ErrInvalidISBN {
+				t.Errorf("NormalizeISBN(%q) = %q, %v; want ErrInvalidISBN", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeISBN(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// foldedRunes maps accented Latin letters and Arabic letter variants to the
+// form they are searched under. Keys are lowercase since input is lowered first.
+var foldedRunes = map[rune]string{
+	// French and other Latin accents
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'ř': "r",
+	'ś': "s", 'š': "s", 'ş': "s",
+	'ť': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+	'æ': "ae", 'œ': "oe", 'ß': "ss",
+
+	// Arabic: alef with hamza or madda, and wasla, become a bare alef
+	'أ': "ا", 'إ': "ا", 'آ': "ا", 'ٱ': "ا", 'ٲ': "ا", 'ٳ': "ا",
+	// Hamza carried on waw or yeh, alef maqsura and Persian yeh/kaf
+	'ؤ': "و", 'ئ': "ي", 'ى': "ي", 'ی': "ي", 'ک': "ك",
+	// Teh marbuta is written and typed as heh interchangeably
+	'ة': "ه",
+}
+
+// isStrippedMark reports whether r is a mark that search ignores: Latin combining
+// accents, Arabic harakat, Quranic annotation signs and the tatweel (kashida).
+func isStrippedMark(r rune) bool {
+	switch {
+	case r >= 0x0300 && r <= 0x036F: // combining diacritical marks
+		return true
+	case r >= 0x0610 && r <= 0x061A: // Arabic honorific signs
+		return true
+	case r >= 0x064B && r <= 0x065F: // Arabic harakat (fatha, damma, kasra, shadda, sukun, ...)
+		return true
+	case r == 0x0670: // superscript alef
+		return true
+	case r >= 0x06D6 && r <= 0x06ED: // Quranic annotation signs
+		return true
+	case r == 0x0640: // tatweel
+		return true
+	}
+	return false
+}
+
+// NormalizeText folds text into the form used by search, both when indexing and
+// when querying: lowercase, Latin accents removed, Arabic diacritics and tatweel
+// stripped, Arabic letter variants unified and Arabic-Indic digits turned into ASCII.
+func NormalizeText(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range strings.ToLower(s) {
+		if isStrippedMark(r) {
+			continue
+		}
+		if folded, ok := foldedRunes[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		switch {
+		case r >= '٠' && r <= '٩':
+			r = '0' + (r - '٠')
+		case r >= '۰' && r <= '۹':
+			r = '0' + (r - '۰')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SearchTerms splits a query into normalized words. Anything that is not a
+// letter or a digit separates words.
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(NormalizeText(query), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// HighlightSnippet returns an excerpt of at most window words of text, centered on
+// the first word containing a part that starts with one of the normalized terms.
+// Every matching word is wrapped in <mark> tags and the rest is HTML-escaped.
+// It reports false when nothing matches.
+func HighlightSnippet(text string, terms []string, window int) (string, bool) {
+	words := strings.Fields(text)
+	first := -1
+	marked := make([]bool, len(words))
+	for i, word := range words {
+		for _, part := range SearchTerms(word) {
+			for _, term := range terms {
+				if strings.HasPrefix(part, term) {
+					marked[i] = true
+				}
+			}
+		}
+		if marked[i] && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	start := first - window/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + window
+	if end > len(words) {
+		end = len(words)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if i > start {
+			b.WriteByte(' ')
+		}
+		if marked[i] {
+			b.WriteString("<mark>" + html.EscapeString(words[i]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(words[i]))
+		}
+	}
+	if end < len(words) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
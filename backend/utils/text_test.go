@@ -0,0 +1,63 @@
+package utils
+
+import "testing"
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"lowercase", "Le Petit PRINCE", "le petit prince"},
+
+		// Latin accents, precomposed and as combining marks
+		{"precomposed accents", "Céline À Noël", "celine a noel"},
+		{"combining accents", "Céline À Noël", "celine a noel"},
+		{"ligatures", "Œuvres Straße", "oeuvres strasse"},
+		{"cedilla", "Français", "francais"},
+
+		// Arabic letter variants
+		{"alef with hamza above", "أحمد", "احمد"},
+		{"alef with hamza below", "إسلام", "اسلام"},
+		{"alef with madda", "آمال", "امال"},
+		{"alef wasla", "ٱلكتاب", "الكتاب"},
+		{"teh marbuta", "مدرسة", "مدرسه"},
+		{"alef maqsura", "مستشفى", "مستشفي"},
+		{"hamza on waw and yeh", "مؤلف رئيس", "مولف رييس"},
+		{"persian yeh and kaf", "کتابی", "كتابي"},
+
+		// Arabic marks that search ignores
+		{"tatweel", "كتــــاب", "كتاب"},
+		{"harakat", "كِتَابٌ", "كتاب"},
+		{"shadda and sukun", "مُؤَلِّفْ", "مولف"},
+		{"superscript alef", "هٰذا", "هذا"},
+
+		// Digits
+		{"arabic-indic digits", "٢٠٢٤", "2024"},
+		{"persian digits", "۱۹۸۴", "1984"},
+
+		{"mixed scripts", "Éditions الشُّروق ١٩٦٨", "editions الشروق 1968"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeText(tt.in); got != tt.want {
+			t.Errorf("%s: NormalizeText(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTextMatchesVariants(t *testing.T) {
+	// Spellings of the same word must fold to one search key
+	for _, group := range [][]string{
+		{"Éléphant", "Éléphant", "elephant", "ÉLÉPHANT"},
+		{"القاهرة", "القاهره", "القاهـــرة", "القَاهِرَة"},
+		{"أسامة", "اسامه", "إسامة", "أُسَامَة"},
+	} {
+		want := NormalizeText(group[0])
+		for _, s := range group[1:] {
+			if got := NormalizeText(s); got != want {
+				t.Errorf("NormalizeText(%q) = %q, want %q like %q", s, got, want, group[0])
+			}
+		}
+	}
+}
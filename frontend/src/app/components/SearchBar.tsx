@@ -49,7 +49,7 @@ export function SearchBar() {
         authFetch(apiUrl(`/api/search?query=${encodeURIComponent(searchQuery)}`))
       ]);
 
-      const books = booksRes.ok ? await booksRes.json() : [];
+      const books = booksRes.ok ? (await booksRes.json()).results : [];
       const users = usersRes.ok ? await usersRes.json() : [];
 
       setResults({
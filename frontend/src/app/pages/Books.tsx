@@ -45,7 +45,7 @@ export function Books() {
       const res = await authFetch(apiUrl('/api/books'));
       if (res.ok) {
         const data = await res.json();
-        const mappedBooks = (data || []).map((book: any) => ({
+        const mappedBooks = (data?.books || []).map((book: any) => ({
           ...book,
           id: String(book.id),
           imageUrl: book.images?.[0] || '/placeholder-book.png',
@@ -23,7 +23,7 @@ export function HomePage() {
           return;
         }
         const data = await res.json();
-        const mapped = (data || []).map((book: any) => ({
+        const mapped = (data?.books || []).map((book: any) => ({
           ...book,
           id: String(book.id),
           imageUrl: book.images?.[0] || '/placeholder-book.png',
@@ -66,32 +66,38 @@ export function UserProfile() {
 
         // Fetch user's books
         try {
-          const booksRes = await authFetch(apiUrl(`/api/books`));
-          if (booksRes.ok) {
-            const allBooks = await booksRes.json();
-            // Filter books by this user and map to correct format
-            const filteredBooks = (allBooks || [])
-              .filter((book: any) => book.owner_id === parseInt(id))
-              .map((book: any) => ({
-                ...book,
-                id: String(book.id),
-                imageUrl: book.images?.[0] || '/placeholder-book.png',
-                description: book.description || '',
-                available: book.available ?? true,
-                owner: {
-                  id: book.owner_id,
-                  first_name: book.owner_first_name || '',
-                  last_name: book.owner_last_name || '',
-                  name: `${book.owner_first_name || ''} ${book.owner_last_name || ''}`.trim() || 'Unknown User',
-                  avatar: book.owner_avatar || '/default-avatar.png',
-                  city: book.owner_city || 'Unknown',
-                  booksListed: 0,
-                  booksExchanged: 0,
-                },
-                location: book.owner_city || book.city || 'Unknown Location',
-              }));
-            setUserBooks(filteredBooks);
-          }
+          // Follow the feed cursor through every page of this owner's listings
+          const allBooks: any[] = [];
+          let cursor = '';
+          do {
+            const params = new URLSearchParams({ owner_id: String(id), limit: '100' });
+            if (cursor) params.set('cursor', cursor);
+            const booksRes = await authFetch(apiUrl(`/api/books?${params}`));
+            if (!booksRes.ok) break;
+            const page = await booksRes.json();
+            allBooks.push(...(page.books || []));
+            cursor = page.next_cursor || '';
+          } while (cursor);
+          const mappedBooks = allBooks
+            .map((book: any) => ({
+              ...book,
+              id: String(book.id),
+              imageUrl: book.images?.[0] || '/placeholder-book.png',
+              description: book.description || '',
+              available: book.available ?? true,
+              owner: {
+                id: book.owner_id,
+                first_name: book.owner_first_name || '',
+                last_name: book.owner_last_name || '',
+                name: `${book.owner_first_name || ''} ${book.owner_last_name || ''}`.trim() || 'Unknown User',
+                avatar: book.owner_avatar || '/default-avatar.png',
+                city: book.owner_city || 'Unknown',
+                booksListed: 0,
+                booksExchanged: 0,
+              },
+              location: book.owner_city || book.city || 'Unknown Location',
+            }));
+          setUserBooks(mappedBooks);
         } catch {
           setUserBooks([]);
         }